@@ -5,6 +5,58 @@ export interface Contact {
   name: string;
   number: string;
   isGroup: boolean;
+  about?: string;
+  businessName?: string;
+  verified?: boolean;
+  savedName?: string;
+  pushName?: string;
+}
+
+export interface ContactDetail extends Contact {
+  avatarUrl?: string;
+  blocked?: boolean;
+  participants?: { id: string; admin?: boolean }[];
+  groupRefreshedAt?: number;
+}
+
+export interface GroupMember {
+  id: string;
+  name?: string;
+  number?: string;
+  isAdmin: boolean;
+  isSuperAdmin: boolean;
+}
+
+export interface GroupInfo {
+  id: string;
+  name: string;
+  topic?: string;
+  createdAt?: number;
+  owner?: string;
+  participants: GroupMember[];
+}
+
+export interface CreateGroupResponse {
+  success: boolean;
+  chatId: string;
+  name: string;
+  notAdded: string[];
+}
+
+export interface ParticipantChangeResult {
+  id: string;
+  success: boolean;
+  code?: number;
+  error?: string;
+}
+
+export interface ProfilePicture {
+  id: string;
+  url: string;
+  pictureId?: string;
+  preview: boolean;
+  updatedAt: number;
+  cached: boolean;
 }
 
 export interface Message {
@@ -14,15 +66,49 @@ export interface Message {
   timestamp: number;
   from: string;
   senderName?: string;
+  senderNumber?: string;
   hasMedia: boolean;
   mediaData?: string;
-  mediaType?: "image" | "video" | "audio" | "sticker" | "document" | "unknown";
+  mediaType?:
+    | "image"
+    | "video"
+    | "audio"
+    | "sticker"
+    | "document"
+    | "interactive"
+    | "live_location"
+    | "unknown";
+  source?: "bridge" | "phone" | "device";
+  editedAt?: number;
+  forwarded?: boolean;
+  forwardingScore?: number;
+  receivedAt?: number;
+  mediaStatus?: "pending" | "downloaded" | "failed" | "skipped";
+  quotedMessageId?: string;
+  quotedPreview?: string;
+  pinned?: boolean;
+  starred?: boolean;
+  revoked?: boolean; // deleted for everyone
+  // how far a message we sent has got; unset on incoming messages
+  status?: "sent" | "delivered" | "read";
 }
 
 export interface MessagesResponse {
   messages: Message[];
   fromCache: boolean;
   empty?: boolean;
+  clamped?: boolean;
+  limit?: number;
+  // set when reading failed partway through; messages is then cut short
+  error?: string;
+  code?: string;
+}
+
+export interface MessageCounts {
+  count: number;
+  unreadCount: number;
+  oldestTs?: number;
+  newestTs?: number;
 }
 
 export interface Chat {
@@ -32,23 +118,69 @@ export interface Chat {
   lastMessage?: string;
   lastMessageTimestamp?: number;
   isGroup: boolean;
+  leftAt?: number; // set once we have left the group
+  chatType: ChatType;
+  archived?: boolean;
+  mutedUntil?: number; // unix seconds the mute ends, or -1 until unmuted
+}
+
+// Why history may be empty, with what to do about it
+export interface HistoryDiagnosis {
+  status: ConnectionStatus;
+  phoneReachable: boolean | null; // null: not asked yet, or still waiting
+  historySyncsSinceConnect: number;
+  lastHistorySyncAt?: number;
+  chats: number;
+  messages: number;
+  ok: boolean;
+  guidance: string[];
+}
+
+// Chats whose name, archived state or mute changed in a resync
+export interface AppStateResync {
+  collections: string[];
+  errors?: Record<string, string>; // collections that failed to fetch, with why
+  names: string[];
+  archived: string[];
+  muted: string[];
 }
 
+export type ChatType =
+  | "individual"
+  | "group"
+  | "community"
+  | "announcement"
+  | "broadcast";
+
 export type ConnectionStatus =
   | "disconnected"
   | "connecting"
   | "qr"
   | "authenticated"
-  | "ready";
+  | "ready"
+  | "failed"
+  | "stuck";
 
 export interface StatusResponse {
   status: ConnectionStatus;
   ready: boolean;
+  // Recent-message sync run on connect (WAPP_SYNC_ON_CONNECT*)
+  connectSync?: { enabled: boolean; chats: number; count: number };
+  // Paired device and account; unset before pairing
+  deviceJid?: string;
+  phone?: string;
+  pushName?: string;
+  chats?: number;
+  messages?: number;
+  lastSyncAt?: number; // unix seconds
+  deepSyncRunning?: boolean;
 }
 
 export interface QRResponse {
   qr: string | null;
   message?: string;
+  seq?: number; // goes up each time WhatsApp rotates the code
+  expiresAt?: number; // unix seconds when this code is replaced
 }
 
 export interface SearchResult {
@@ -64,6 +196,11 @@ export interface SearchResult {
   chatJid: string;
 }
 
+export interface StarredMessage extends Message {
+  chatName: string;
+  chatJid: string;
+}
+
 class WhatsAppAPI {
   private baseUrl: string;
 
@@ -116,16 +253,199 @@ class WhatsAppAPI {
     return this.fetch<QRResponse>("/qr");
   }
 
+  // Unpairs the bridge so it shows a new QR code; stored messages are kept
+  async resetSession(): Promise<{ success: boolean; message: string }> {
+    return this.fetch<{ success: boolean; message: string }>(
+      "/session/reset?confirm=true",
+      { method: "POST" },
+    );
+  }
+
+  // Long-poll: resolves when a new code arrives or pairing succeeds, or with
+  // the unchanged state after timeoutMs
+  async waitForQR(timeoutMs: number = 25000): Promise<QRResponse> {
+    return this.fetch<QRResponse>(`/qr?wait=true&timeoutMs=${timeoutMs}`);
+  }
+
   async getContacts(): Promise<Contact[]> {
     const response = await this.fetch<{ contacts: Contact[] }>("/contacts");
     return response.contacts;
   }
 
-  async getChats(): Promise<Chat[]> {
-    const response = await this.fetch<{ chats: Chat[] }>("/chats");
+  async getContact(chatId: string): Promise<ContactDetail> {
+    return this.fetch<ContactDetail>(`/contacts/${encodeURIComponent(chatId)}`);
+  }
+
+  // Live group metadata, fetched from WhatsApp
+  async getGroupInfo(chatId: string): Promise<GroupInfo> {
+    return this.fetch<GroupInfo>(`/groups/${encodeURIComponent(chatId)}`);
+  }
+
+  // Create a group with the given members; notAdded lists those WhatsApp refused
+  async createGroup(
+    name: string,
+    participants: string[],
+  ): Promise<CreateGroupResponse> {
+    return this.fetch<CreateGroupResponse>("/groups", {
+      method: "POST",
+      body: JSON.stringify({ name, participants }),
+    });
+  }
+
+  // Leave a group; its history stays, marked with leftAt
+  async leaveGroup(chatId: string): Promise<{ success: boolean }> {
+    return this.fetch<{ success: boolean }>(
+      `/groups/${encodeURIComponent(chatId)}/leave`,
+      { method: "POST" },
+    );
+  }
+
+  async setArchived(
+    chatId: string,
+    archived: boolean,
+  ): Promise<{ success: boolean; archived: boolean }> {
+    const action = archived ? "archive" : "unarchive";
+    return this.fetch<{ success: boolean; archived: boolean }>(
+      `/chats/${encodeURIComponent(chatId)}/${action}`,
+      { method: "POST" },
+    );
+  }
+
+  // Mute for duration seconds or until a unix time; with neither, until
+  // unmuted
+  async muteChat(
+    chatId: string,
+    opts: { duration?: number; until?: number } = {},
+  ): Promise<{ success: boolean; muted: boolean; mutedUntil: number }> {
+    return this.fetch<{ success: boolean; muted: boolean; mutedUntil: number }>(
+      `/chats/${encodeURIComponent(chatId)}/mute`,
+      { method: "POST", body: JSON.stringify(opts) },
+    );
+  }
+
+  async unmuteChat(
+    chatId: string,
+  ): Promise<{ success: boolean; muted: boolean; mutedUntil: number }> {
+    return this.fetch<{ success: boolean; muted: boolean; mutedUntil: number }>(
+      `/chats/${encodeURIComponent(chatId)}/unmute`,
+      { method: "POST" },
+    );
+  }
+
+  // Pins are kept by the bridge only; WhatsApp does not see them
+  async setPinned(
+    chatId: string,
+    messageId: string,
+    pinned: boolean,
+  ): Promise<{ success: boolean; pinned: boolean; localOnly: boolean }> {
+    const path = `/chats/${encodeURIComponent(chatId)}/pin`;
+    return this.fetch<{ success: boolean; pinned: boolean; localOnly: boolean }>(
+      pinned ? path : `${path}?messageId=${encodeURIComponent(messageId)}`,
+      pinned
+        ? { method: "POST", body: JSON.stringify({ messageId }) }
+        : { method: "DELETE" },
+    );
+  }
+
+  async getPinnedMessages(chatId: string): Promise<{ messages: Message[] }> {
+    return this.fetch<{ messages: Message[] }>(
+      `/chats/${encodeURIComponent(chatId)}/pins`,
+    );
+  }
+
+  // Starred messages are bookmarks in the bridge only; they survive
+  // deleting or clearing their chat
+  async setStarred(
+    messageId: string,
+    starred: boolean,
+  ): Promise<{ success: boolean; starred: boolean }> {
+    return this.fetch<{ success: boolean; starred: boolean }>(
+      `/messages/${encodeURIComponent(messageId)}/${starred ? "star" : "unstar"}`,
+      { method: "POST" },
+    );
+  }
+
+  async getStarred(limit = 50): Promise<{ messages: StarredMessage[] }> {
+    return this.fetch<{ messages: StarredMessage[] }>(`/starred?limit=${limit}`);
+  }
+
+  // probe asks the phone for recent messages first, to check it answers
+  async diagnoseHistory(probe = false): Promise<HistoryDiagnosis> {
+    return this.fetch<HistoryDiagnosis>(
+      `/diagnostics/history${probe ? "?probe=true" : ""}`,
+    );
+  }
+
+  // Fetch contact names and chat settings from the phone again; lists the
+  // chats whose name, archived state or mute changed
+  async resyncAppState(): Promise<AppStateResync> {
+    return this.fetch<AppStateResync>("/resync-appstate", { method: "POST" });
+  }
+
+  // Add, remove, promote or demote group members (needs admin rights); one
+  // result per member, as WhatsApp can refuse some and accept others
+  async updateGroupParticipants(
+    chatId: string,
+    action: "add" | "remove" | "promote" | "demote",
+    participants: string[],
+  ): Promise<ParticipantChangeResult[]> {
+    let path = `/groups/${encodeURIComponent(chatId)}/participants`;
+    if (action === "promote" || action === "demote") path += `/${action}`;
+    const response = await this.fetch<{ results: ParticipantChangeResult[] }>(
+      path,
+      {
+        method: action === "remove" ? "DELETE" : "POST",
+        body: JSON.stringify({ participants }),
+      },
+    );
+    return response.results;
+  }
+
+  // Profile picture URL, cached by the bridge; rejects if there is none
+  async getAvatar(
+    chatId: string,
+    preview: boolean = true,
+  ): Promise<ProfilePicture> {
+    return this.fetch<ProfilePicture>(
+      `/contacts/${encodeURIComponent(chatId)}/avatar?preview=${preview}`,
+    );
+  }
+
+  async getChats(
+    filter: {
+      unreadOnly?: boolean;
+      type?: "group" | "individual";
+      includeArchived?: boolean;
+    } = {},
+  ): Promise<Chat[]> {
+    const params = new URLSearchParams();
+    if (filter.unreadOnly) params.set("unreadOnly", "true");
+    if (filter.type) params.set("type", filter.type);
+    if (filter.includeArchived) params.set("includeArchived", "true");
+    const query = params.toString();
+    const response = await this.fetch<{ chats: Chat[] }>(
+      query ? `/chats?${query}` : "/chats",
+    );
     return response.chats;
   }
 
+  async getChatsPage(
+    limit: number,
+    offset: number = 0,
+  ): Promise<{ chats: Chat[]; hasMore: boolean }> {
+    return this.fetch<{ chats: Chat[]; hasMore: boolean }>(
+      `/chats?limit=${limit}&offset=${offset}`,
+    );
+  }
+
+  // Contacts' status updates, newest first (needs WAPP_STORE_STATUS_UPDATES)
+  async getStatusUpdates(limit: number = 50): Promise<Message[]> {
+    const response = await this.fetch<{ updates: Message[] }>(
+      `/status-updates?limit=${limit}`,
+    );
+    return response.updates;
+  }
+
   async markRead(chatId: string): Promise<void> {
     await this.fetch<{ success: boolean }>(
       `/mark-read/${encodeURIComponent(chatId)}`,
@@ -153,6 +473,30 @@ class WhatsAppAPI {
     return this.fetch<MessagesResponse>(url);
   }
 
+  // Full-text search within one chat, newest first
+  async searchInChat(
+    chatId: string,
+    query: string,
+    limit: number = 50,
+  ): Promise<Message[]> {
+    const response = await this.fetch<MessagesResponse>(
+      `/chats/${encodeURIComponent(chatId)}/messages?q=${encodeURIComponent(query)}&limit=${limit}`,
+    );
+    return response.messages;
+  }
+
+  // Cached messages with only the given fields, e.g. ["id", "body", "timestamp"]
+  async getMessagesCompact<K extends keyof Message>(
+    chatId: string,
+    fields: K[],
+    limit: number = 50,
+  ): Promise<Pick<Message, K>[]> {
+    const response = await this.fetch<{ messages: Pick<Message, K>[] }>(
+      `/chats/${encodeURIComponent(chatId)}/messages?limit=${limit}&fields=${fields.join(",")}`,
+    );
+    return response.messages;
+  }
+
   // Force fresh fetch (slow, but up-to-date)
   async getMessagesRefresh(
     chatId: string,
@@ -163,6 +507,13 @@ class WhatsAppAPI {
     );
   }
 
+  // Message count and timestamp range only, without fetching messages
+  async getMessageCounts(chatId: string): Promise<MessageCounts> {
+    return this.fetch<MessageCounts>(
+      `/chats/${encodeURIComponent(chatId)}/messages?count=true`,
+    );
+  }
+
   async sendMessage(
     chatId: string,
     message: string,
@@ -174,6 +525,27 @@ class WhatsAppAPI {
     });
   }
 
+  async editMessage(
+    messageId: string,
+    newText: string,
+  ): Promise<{ success: boolean; messageId?: string }> {
+    return this.fetch<{ success: boolean; messageId?: string }>(
+      "/edit-message",
+      {
+        method: "POST",
+        body: JSON.stringify({ messageId, newText }),
+      },
+    );
+  }
+
+  // Delete for everyone: your own messages, or any in a group you admin
+  async deleteMessage(messageId: string): Promise<{ success: boolean }> {
+    return this.fetch<{ success: boolean }>(
+      `/messages/${encodeURIComponent(messageId)}`,
+      { method: "DELETE" },
+    );
+  }
+
   async downloadMedia(
     messageId: string,
   ): Promise<{ data: string; mimetype: string }> {
@@ -183,14 +555,49 @@ class WhatsAppAPI {
     });
   }
 
+  // mimetype overrides the type the bridge detects from the data;
+  // asDocument sends the image uncompressed, as a file named fileName
   async sendImage(
     chatId: string,
     base64: string,
     caption?: string,
+    mimetype?: string,
+    options?: { asDocument?: boolean; fileName?: string },
   ): Promise<{ success: boolean; messageId?: string }> {
     return this.fetch<{ success: boolean; messageId?: string }>("/send-image", {
       method: "POST",
-      body: JSON.stringify({ chatId, base64, caption }),
+      body: JSON.stringify({ chatId, base64, caption, mimetype, ...options }),
+    });
+  }
+
+  async sendVideo(
+    chatId: string,
+    base64: string,
+    caption?: string,
+    gifPlayback?: boolean,
+    mimetype?: string,
+  ): Promise<{ success: boolean; messageId?: string }> {
+    return this.fetch<{ success: boolean; messageId?: string }>("/send-video", {
+      method: "POST",
+      body: JSON.stringify({ chatId, base64, caption, gifPlayback, mimetype }),
+    });
+  }
+
+  // ptt sends a voice note (Ogg Opus); waveform is base64 of up to 64
+  // samples, 0-100
+  async sendAudio(
+    chatId: string,
+    base64: string,
+    options?: {
+      ptt?: boolean;
+      seconds?: number;
+      waveform?: string;
+      mimetype?: string;
+    },
+  ): Promise<{ success: boolean; messageId?: string }> {
+    return this.fetch<{ success: boolean; messageId?: string }>("/send-audio", {
+      method: "POST",
+      body: JSON.stringify({ chatId, base64, ...options }),
     });
   }
 
@@ -206,14 +613,39 @@ class WhatsAppAPI {
     }
   }
 
+  // Look a sender's name up now and store it on their messages
+  async resolveSender(
+    senderJid: string,
+    chatId?: string,
+  ): Promise<{ senderJid: string; name: string; updated: number }> {
+    return this.fetch<{ senderJid: string; name: string; updated: number }>(
+      "/resolve-sender",
+      {
+        method: "POST",
+        body: JSON.stringify({ senderJid, chatId }),
+      },
+    );
+  }
+
   async searchMessages(
     query: string,
     limit: number = 50,
+    filters: {
+      after?: number;
+      before?: number;
+      chatId?: string;
+      sender?: string;
+    } = {},
   ): Promise<SearchResult[]> {
+    let url = `/search?q=${encodeURIComponent(query)}&limit=${limit}`;
+    if (filters.after) url += `&after=${filters.after}`;
+    if (filters.before) url += `&before=${filters.before}`;
+    if (filters.chatId) url += `&chatId=${encodeURIComponent(filters.chatId)}`;
+    if (filters.sender) url += `&sender=${encodeURIComponent(filters.sender)}`;
     const response = await this.fetch<{
       results: SearchResult[];
       count: number;
-    }>(`/search?q=${encodeURIComponent(query)}&limit=${limit}`);
+    }>(url);
     return response.results;
   }
 
@@ -226,6 +658,27 @@ class WhatsAppAPI {
       body: JSON.stringify({ messageId, emoji }),
     });
   }
+
+  // React to the newest message in a chat without knowing its ID
+  async reactToLastMessage(
+    chatId: string,
+    emoji: string,
+  ): Promise<{ success: boolean; messageId: string }> {
+    return this.fetch<{ success: boolean; messageId: string }>("/react-last", {
+      method: "POST",
+      body: JSON.stringify({ chatId, emoji }),
+    });
+  }
+
+  async forwardMessage(
+    sourceMessageId: string,
+    targetChatId: string,
+  ): Promise<{ success: boolean; messageId: string }> {
+    return this.fetch<{ success: boolean; messageId: string }>("/forward", {
+      method: "POST",
+      body: JSON.stringify({ sourceMessageId, targetChatId }),
+    });
+  }
 }
 
 export const api = new WhatsAppAPI();
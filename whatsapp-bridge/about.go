@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// aboutUserBatch is how many users one GetUserInfo query asks about.
+const aboutUserBatch = 50
+
+// refreshAbout fetches the about text of users jids (internal format) from
+// WhatsApp and stores it, returning how many were stored. Group descriptions
+// come with their info, stored by refreshGroups; group JIDs are skipped. A
+// batch that can't be fetched is logged and marked refreshed all the same,
+// so it doesn't head every later batch, and the rest carry on; the first
+// such error is returned at the end.
+func refreshAbout(ctx context.Context, api waAPI, store *AppStore, jids []string) (int, error) {
+	var users []types.JID
+	for _, s := range jids {
+		jid, err := types.ParseJID(s)
+		if err != nil || jid.Server == types.GroupServer {
+			log.Printf("Skipping about refresh for %s: not a user JID", s)
+			continue
+		}
+		users = append(users, jid)
+	}
+
+	stored := 0
+	var firstErr error
+	for start := 0; start < len(users); start += aboutUserBatch {
+		batch := users[start:min(start+aboutUserBatch, len(users))]
+		infos, err := api.GetUserInfo(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return stored, fmt.Errorf("get user info: %w", err)
+			}
+			log.Printf("Error fetching about of %d users: %v", len(batch), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("get user info: %w", err)
+			}
+			for _, jid := range batch {
+				if err := store.MarkAboutRefreshed(jid.String()); err != nil {
+					return stored, err
+				}
+			}
+			continue
+		}
+		for _, jid := range batch {
+			// Users who hide their about, or aren't on WhatsApp, come back
+			// blank; storing that still marks them as refreshed
+			if err := store.SetContactAbout(jid.String(), infos[jid].Status); err != nil {
+				return stored, err
+			}
+			stored++
+		}
+	}
+	return stored, firstErr
+}
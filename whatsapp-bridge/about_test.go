@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestRefreshAbout(t *testing.T) {
+	store := newTestStore(t)
+	fake := newFakeWA()
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	bob := types.NewJID("10000000002", types.DefaultUserServer)
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	fake.userInfo[alice] = types.UserInfo{Status: "Hey there! I am using WhatsApp."}
+	fake.groupInfo[group] = &types.GroupInfo{GroupTopic: types.GroupTopic{Topic: "Weekend plans"}}
+
+	jids := []string{alice.String(), bob.String(), group.String()}
+	n, err := refreshAbout(context.Background(), fake, store, jids)
+	if err != nil {
+		t.Fatalf("refreshAbout: %v", err)
+	}
+	// The group is left to the group refresh; bob hides his about but is
+	// still stored
+	if n != 2 {
+		t.Errorf("stored %d, want 2", n)
+	}
+
+	want := map[string]string{
+		alice.String(): "Hey there! I am using WhatsApp.",
+		bob.String():   "",
+	}
+	for jid, about := range want {
+		got, err := store.GetContactAbout(jid)
+		if err != nil || got != about {
+			t.Errorf("about of %s = %q, %v; want %q", jid, got, err, about)
+		}
+	}
+	if _, err := store.GetContactAbout(group.String()); err == nil {
+		t.Error("group got an about from refreshAbout")
+	}
+}
+
+func TestRefreshAbout_FailedBatchIsStamped(t *testing.T) {
+	store := newTestStore(t)
+	fake := newFakeWA()
+	fake.userErr = errors.New("rate limited")
+	alice := "10000000001@s.whatsapp.net"
+	store.UpsertChat(alice, "Alice", false, nil, nil)
+	store.SetContactAbout(alice, "busy")
+	store.db.Exec(`UPDATE contacts SET about_updated_at = 0`)
+
+	n, err := refreshAbout(context.Background(), fake, store, []string{alice})
+	if err == nil || n != 0 {
+		t.Errorf("refreshAbout = %d, %v; want 0 and the error", n, err)
+	}
+	if stale, _ := store.GetStaleAboutJIDs(time.Now().Add(-time.Minute).Unix(), 10); len(stale) != 0 {
+		t.Errorf("stale after a failed refresh = %v, want none until the next interval", stale)
+	}
+	if about, _ := store.GetContactAbout(alice); about != "busy" {
+		t.Errorf("about = %q, want the stored one kept", about)
+	}
+}
+
+func TestGetStaleAboutJIDs(t *testing.T) {
+	store := newTestStore(t)
+	for _, jid := range []string{"10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "120363000000000001@g.us", "200000000000001@lid"} {
+		store.UpsertChat(jid, "", false, nil, nil)
+	}
+	store.SetContactAbout("10000000001@s.whatsapp.net", "busy")
+
+	// Only the never-refreshed user: LID chats can't be queried, and groups
+	// are left to the group refresh
+	got, err := store.GetStaleAboutJIDs(time.Now().Add(-time.Hour).Unix(), 10)
+	if err != nil {
+		t.Fatalf("GetStaleAboutJIDs: %v", err)
+	}
+	if len(got) != 1 || got[0] != "10000000002@s.whatsapp.net" {
+		t.Errorf("stale = %v, want the other user", got)
+	}
+
+	contacts, err := store.GetContacts()
+	if err != nil {
+		t.Fatalf("GetContacts: %v", err)
+	}
+	for _, c := range contacts {
+		if c.ID == "10000000001@c.us" && c.About != "busy" {
+			t.Errorf("contact about = %q, want %q", c.About, "busy")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// AppStateResync is the response of POST /resync-appstate: the app-state
+// collections fetched again, why any others could not be, and the chats
+// whose name, archived state or mute changed as a result.
+type AppStateResync struct {
+	Collections []string          `json:"collections"`
+	Errors      map[string]string `json:"errors,omitempty"` // by collection
+	Names       []string          `json:"names"`
+	Archived    []string          `json:"archived"`
+	Muted       []string          `json:"muted"`
+}
+
+// ResyncAppState fetches every app-state collection (contact names and chat
+// settings such as archives and mutes) from scratch, then applies the
+// result to the stored contacts and chats. It fixes settings that drifted
+// from the phone, e.g. after missed app-state patches. Pins are fetched too,
+// but not stored. A collection that fails to fetch is reported in the
+// result and the others are still applied; only if none could be fetched
+// is that an error.
+func (wc *WAClient) ResyncAppState(ctx context.Context) (*AppStateResync, error) {
+	if ownID, _ := wc.ownJIDs(); ownID == nil {
+		return nil, errNotPaired
+	}
+	before, _, err := wc.store.GetChatsPage(ChatQuery{IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AppStateResync{}
+	var firstErr error
+	for _, name := range appstate.AllPatchNames {
+		if err := wc.client.FetchAppState(ctx, name, true, false); err != nil {
+			log.Printf("Error fetching app state %s: %v", name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("fetch app state %s: %w", name, err)
+			}
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[string(name)] = err.Error()
+			continue
+		}
+		result.Collections = append(result.Collections, string(name))
+	}
+	if len(result.Collections) == 0 {
+		return nil, firstErr
+	}
+
+	wc.populateContacts()
+	settings := make(map[string]types.LocalChatSettings, len(before))
+	for _, chat := range before {
+		jid, err := types.ParseJID(toInternalJID(chat.ID))
+		if err != nil {
+			continue
+		}
+		s, err := wc.client.Store.ChatSettings.GetChatSettings(ctx, jid)
+		if err != nil {
+			log.Printf("Error getting settings of %s: %v", jid, err)
+			continue
+		}
+		settings[jid.String()] = s
+	}
+	applyChatSettings(wc.store, settings)
+
+	after, _, err := wc.store.GetChatsPage(ChatQuery{IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+	result.Names, result.Archived, result.Muted = diffChats(before, after)
+	log.Printf("App state resynced: %d names, %d archives and %d mutes changed",
+		len(result.Names), len(result.Archived), len(result.Muted))
+	return result, nil
+}
+
+// applyChatSettings stores the archived state and mute of each chat in
+// settings, keyed by internal JID. Chats the phone has no settings for are
+// left alone.
+func applyChatSettings(s *AppStore, settings map[string]types.LocalChatSettings) {
+	for chatJID, cs := range settings {
+		if !cs.Found {
+			continue
+		}
+		if err := s.SetChatArchived(chatJID, cs.Archived); err != nil {
+			log.Printf("Error setting archived of %s: %v", chatJID, err)
+		}
+		if err := s.SetChatMutedUntil(chatJID, mutedUntilUnix(cs.MutedUntil)); err != nil {
+			log.Printf("Error setting mute of %s: %v", chatJID, err)
+		}
+	}
+}
+
+// mutedUntilUnix converts whatsmeow's mute end, zero when unmuted, to a
+// muted_until.
+func mutedUntilUnix(t time.Time) int64 {
+	switch {
+	case t.IsZero():
+		return 0
+	case t.Unix() >= store.MutedForever.Unix():
+		return MutedForever
+	}
+	return t.Unix()
+}
+
+// diffChats returns the IDs of the chats in after whose name, archived state
+// or mute differs from before. Chats only in one of them are skipped.
+func diffChats(before, after []Chat) (names, archived, muted []string) {
+	old := make(map[string]Chat, len(before))
+	for _, c := range before {
+		old[c.ID] = c
+	}
+	names, archived, muted = []string{}, []string{}, []string{}
+	for _, c := range after {
+		o, ok := old[c.ID]
+		if !ok {
+			continue
+		}
+		if c.Name != o.Name {
+			names = append(names, c.ID)
+		}
+		if c.Archived != o.Archived {
+			archived = append(archived, c.ID)
+		}
+		if c.MutedUntil != o.MutedUntil {
+			muted = append(muted, c.ID)
+		}
+	}
+	return names, archived, muted
+}
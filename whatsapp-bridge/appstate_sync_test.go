@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestApplyChatSettings(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob, team := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "120363000000000001@g.us"
+	s.UpsertChat(alice, "Alice", false, nil, nil)
+	s.UpsertChat(bob, "Bob", false, nil, nil)
+	s.UpsertChat(team, "Team", true, nil, nil)
+	s.SetChatArchived(alice, true)
+	s.SetChatMutedUntil(bob, MutedForever)
+	s.SetChatMutedUntil(team, MutedForever)
+
+	before, _, _ := s.GetChatsPage(ChatQuery{IncludeArchived: true})
+	end := time.Now().Add(time.Hour).Truncate(time.Second)
+	applyChatSettings(s, map[string]types.LocalChatSettings{
+		alice: {Found: true},                  // unarchived on the phone
+		bob:   {Found: true, MutedUntil: end}, // mute shortened
+		team:  {},                             // nothing known: left alone
+	})
+	after, _, _ := s.GetChatsPage(ChatQuery{IncludeArchived: true})
+
+	names, archived, muted := diffChats(before, after)
+	if len(names) != 0 || !reflect.DeepEqual(archived, []string{"10000000001@c.us"}) || !reflect.DeepEqual(muted, []string{"10000000002@c.us"}) {
+		t.Errorf("changed names=%v archived=%v muted=%v", names, archived, muted)
+	}
+	for _, c := range after {
+		if c.ID == "10000000002@c.us" && c.MutedUntil != end.Unix() {
+			t.Errorf("bob mutedUntil = %d, want %d", c.MutedUntil, end.Unix())
+		}
+	}
+}
+
+func TestMutedUntilUnix(t *testing.T) {
+	end := time.Unix(1800000000, 0)
+	for in, want := range map[time.Time]int64{
+		{}:                 0,
+		end:                1800000000,
+		store.MutedForever: MutedForever,
+	} {
+		if got := mutedUntilUnix(in); got != want {
+			t.Errorf("mutedUntilUnix(%v) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -45,7 +45,8 @@ func authMiddleware(next http.Handler) http.Handler {
 		// TODO [HIGH][SECURITY]: /ui bypasses auth and exposes a full chat explorer.
 		// Any local process can access it without an API key. Consider requiring
 		// auth for /ui and passing the key via a query param or session cookie.
-		if r.URL.Path == "/health" || r.URL.Path == "/ui" {
+		switch r.URL.Path {
+		case "/health", "/livez", "/readyz", "/ui":
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -54,7 +55,7 @@ func authMiddleware(next http.Handler) http.Handler {
 		if key == "" || key != apiKey {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"Unauthorized: Invalid or missing API key"}`))
+			w.Write([]byte(`{"error":"Unauthorized: Invalid or missing API key","code":"` + ErrCodeUnauthorized + `"}`))
 			return
 		}
 
@@ -14,13 +14,15 @@ func TestAuthMiddleware_HealthBypass(t *testing.T) {
 
 	handler := authMiddleware(inner)
 
-	// /health should bypass auth
-	req := httptest.NewRequest("GET", "/health", nil)
-	rec := httptest.NewRecorder()
-	handler.ServeHTTP(rec, req)
-
-	if rec.Code != http.StatusOK {
-		t.Errorf("GET /health without API key: status = %d, want %d", rec.Code, http.StatusOK)
+	// /health and the probes should bypass auth
+	for _, path := range []string{"/health", "/livez", "/readyz"} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s without API key: status = %d, want %d", path, rec.Code, http.StatusOK)
+		}
 	}
 }
 
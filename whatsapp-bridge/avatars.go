@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// avatarCacheTTL is how long a cached profile picture lookup is served
+// before WhatsApp is asked again. Picture URLs are signed and stop working
+// after a while, so this stays well under their lifetime.
+const avatarCacheTTL = 6 * time.Hour
+
+// profilePicture returns jid's profile picture, full size or preview, from
+// the cache while it is fresh and from WhatsApp otherwise, unless refresh
+// forces a lookup. A chat without a picture, or whose owner only shows it to
+// their contacts, has an empty URL; that is cached too.
+func profilePicture(ctx context.Context, api waAPI, store *AppStore, jid types.JID, preview, refresh bool) (*ProfilePic, bool, error) {
+	if !refresh {
+		cached, err := store.GetProfilePic(jid.String(), preview)
+		if err == nil && time.Since(time.Unix(cached.UpdatedAt, 0)) < avatarCacheTTL {
+			return cached, true, nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error reading cached profile picture of %s: %v", jid, err)
+		}
+	}
+
+	var url, id string
+	info, err := api.GetProfilePictureInfo(ctx, jid, &whatsmeow.GetProfilePictureParams{Preview: preview})
+	switch {
+	case err == nil && info != nil:
+		url, id = info.URL, info.ID
+	case err == nil, errors.Is(err, whatsmeow.ErrProfilePictureNotSet), errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized):
+	default:
+		return nil, false, err
+	}
+	if err := store.SetProfilePic(jid.String(), preview, url, id); err != nil {
+		log.Printf("Error caching profile picture of %s: %v", jid, err)
+	}
+	return &ProfilePic{URL: url, ID: id, UpdatedAt: time.Now().Unix()}, false, nil
+}
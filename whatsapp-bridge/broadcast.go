@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+)
+
+// Event types pushed to /events subscribers.
+const (
+	EventChatRead = "chatRead"
+	EventMessage  = "message"  // a message arrived or was edited; Message is its stored form
+	EventReceipt  = "receipt"  // messages we sent were delivered, read or played
+	EventPushName = "pushName" // a contact changed their push name
+	EventStatus   = "status"   // the connection status changed; on a new QR code FetchQR is set
+)
+
+// Event is a real-time notification delivered to /events subscribers.
+type Event struct {
+	Type       string   `json:"type"`
+	ChatID     string   `json:"chatId,omitempty"`
+	Message    *Message `json:"message,omitempty"`
+	MessageIDs []string `json:"messageIds,omitempty"`
+	Receipt    string   `json:"receipt,omitempty"` // delivered, read or played
+	ContactID  string   `json:"contactId,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	Status     string   `json:"status,omitempty"`  // a ConnectionStatus
+	FetchQR    bool     `json:"fetchQr,omitempty"` // a new code is waiting at GET /qr
+	// A new QR code's raw data (for terminals to render; GET /qr has it as
+	// an image), sequence number and expiry, as in QRResponse
+	QRCode      string `json:"qrCode,omitempty"`
+	QRSeq       int    `json:"qrSeq,omitempty"`
+	QRExpiresAt int64  `json:"qrExpiresAt,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may lag behind before
+// further events are dropped for it.
+const subscriberBuffer = 64
+
+// Broadcaster fans events out to a dynamic set of subscribers. Publishing
+// never blocks: a subscriber whose buffer is full misses the event.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewBroadcaster returns an empty Broadcaster.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscriber. The returned function removes the
+// subscription and closes the channel; it is safe to call more than once.
+func (b *Broadcaster) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers evt to every current subscriber. A nil Broadcaster is a
+// no-op so code paths without a live client (tests) need no special casing.
+func (b *Broadcaster) Publish(evt Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+			// Subscriber is too slow; drop rather than stall event handling
+		}
+	}
+}
+
+// SubscriberCount returns the number of active subscribers.
+func (b *Broadcaster) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroadcaster_PublishAndUnsubscribe(t *testing.T) {
+	b := NewBroadcaster()
+	ch, unsubscribe := b.Subscribe()
+
+	b.Publish(Event{Type: EventChatRead, ChatID: "10000000001@c.us"})
+	select {
+	case evt := <-ch:
+		if evt.Type != EventChatRead || evt.ChatID != "10000000001@c.us" {
+			t.Errorf("event = %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	unsubscribe()
+	unsubscribe() // idempotent
+	if n := b.SubscriberCount(); n != 0 {
+		t.Errorf("subscribers after unsubscribe = %d, want 0", n)
+	}
+	// Publishing with no subscribers must not panic
+	b.Publish(Event{Type: EventChatRead})
+}
+
+func TestBroadcaster_SlowSubscriberDoesNotBlock(t *testing.T) {
+	b := NewBroadcaster()
+	_, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer*2; i++ {
+			b.Publish(Event{Type: EventChatRead})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+}
+
+func TestBroadcaster_NilIsNoop(t *testing.T) {
+	var b *Broadcaster
+	b.Publish(Event{Type: EventChatRead})
+}
+
+func TestHandleEvents_StreamsEvents(t *testing.T) {
+	srv := &Server{wc: &WAClient{events: NewBroadcaster()}}
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleEvents))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Wait for the handler to subscribe before publishing
+	deadline := time.Now().Add(time.Second)
+	for srv.wc.events.SubscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.wc.events.Publish(Event{Type: EventChatRead, ChatID: "10000000001@c.us"})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "retry: 3000" {
+		t.Errorf("retry line = %q", lines[0])
+	}
+	if lines[1] != "event: chatRead" {
+		t.Errorf("event line = %q", lines[1])
+	}
+	if !strings.Contains(lines[2], `"chatId":"10000000001@c.us"`) {
+		t.Errorf("data line = %q", lines[2])
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,26 +14,99 @@ import (
 	"github.com/skip2/go-qrcode"
 	_ "github.com/mattn/go-sqlite3"
 	"go.mau.fi/whatsmeow"
-	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
-	waLog "go.mau.fi/whatsmeow/util/log"
 )
 
 // WAClient manages the whatsmeow client lifecycle including connection,
 // QR code authentication, and reconnection.
 type WAClient struct {
-	client       *whatsmeow.Client
-	status       ConnectionStatus
-	qrCode       *string
-	mu           sync.RWMutex
-	store        *AppStore
-	handlerOnce  sync.Once
-	reconnecting sync.Mutex // prevents concurrent reconnect goroutines
+	client        *whatsmeow.Client
+	wa            waAPI // client behind waAPI, for the lookups tests fake
+	status        ConnectionStatus
+	qrCode        *string
+	// qrSeq counts the QR codes shown and qrExpiresAt is when the current
+	// one is rotated out; both guarded by mu
+	qrSeq         int
+	qrExpiresAt   time.Time
+	mu            sync.RWMutex
+	store         *AppStore
+	handlerOnce   sync.Once
+	reconnecting  sync.Mutex // prevents concurrent reconnect goroutines
+	historySyncs  *historySyncQueue
+	// serializes group sender-name backfills
+	senderBackfillMu sync.Mutex
+	events        *Broadcaster
+
+	// Message counters for the current connection, guarded by mu.
+	msgReceived   int64
+	lastMessageAt time.Time
+	history       historyStats
+
+	// Consecutive reconnect attempts since the last successful connection,
+	// guarded by mu. 0 for maxReconnectAttempts means never give up.
+	reconnectAttempts    int
+	maxReconnectAttempts int
+
+	syncDelays  syncDelays
+	connectSync ConnectSync
+
+	// When pairing succeeded (StatusAuthenticated), and how long to wait
+	// from then for the connection to become ready; 0 waits forever. The
+	// ready-timeout counters are guarded by mu.
+	authenticatedAt    time.Time
+	readyTimeout       time.Duration
+	readyTimeouts      int
+	lastReadyTimeoutAt time.Time
+
+	// ownID and ownLID are the paired account's JIDs, cached on connect and
+	// guarded by mu; see ownJIDs.
+	ownID  *types.JID
+	ownLID types.JID
+
+	// mirrorPhoneDeletes applies chats deleted or cleared on the phone to
+	// the local store too.
+	mirrorPhoneDeletes bool
+
+	// storeStatusUpdates keeps messages posted to the status feed
+	// (status@broadcast) rather than dropping them on arrival.
+	storeStatusUpdates bool
+
+	// webhook receives new incoming messages; nil if none is configured.
+	webhook *Webhook
+
+	// autoDownload caches incoming media as it arrives; nil unless enabled.
+	autoDownload *mediaAutoDownloader
+
+	// counts caches the store counts GetStatus reports; see storeCounts.
+	countsMu sync.Mutex
+	counts   storeCounts
+}
+
+// errNotPaired is returned by ResetSession and ResyncAppState when there is
+// no session.
+var errNotPaired = errors.New("not paired")
+
+// Reconnect backoff: the pause before the first attempt, doubled after each
+// failure up to the cap, so a long outage is ridden out without hammering
+// WhatsApp.
+const (
+	reconnectBaseDelay = 5 * time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+)
+
+// reconnectDelay is the pause before reconnect attempt n, counting from 1.
+func reconnectDelay(attempt int) time.Duration {
+	d := reconnectBaseDelay
+	for i := 1; i < attempt && d < reconnectMaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, reconnectMaxDelay)
 }
 
 // NewWAClient initialises a WAClient backed by a SQLite session store at
 // ~/.whatsapp-raycast/whatsmeow.db and the provided application data store.
-func NewWAClient(appStore *AppStore) (*WAClient, error) {
+// resetSession discards the stored session first, so the device pairs anew.
+func NewWAClient(appStore *AppStore, resetSession bool) (*WAClient, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("get home dir: %w", err)
@@ -44,28 +118,32 @@ func NewWAClient(appStore *AppStore) (*WAClient, error) {
 	}
 
 	dbPath := filepath.Join(dir, "whatsmeow.db")
-	container, err := sqlstore.New(
-		context.Background(),
-		"sqlite3",
-		"file:"+dbPath+"?_foreign_keys=on&_busy_timeout=5000",
-		waLog.Noop,
-	)
+	opts := loadSQLiteOptions("")
+	log.Printf("Session database: %s", opts)
+	_, device, err := openSession(dbPath, opts.params(), resetSession, newWALogger("Database", envWADBLogLevel, "OFF"))
 	if err != nil {
-		return nil, fmt.Errorf("open session store: %w", err)
+		return nil, err
 	}
 
-	device, err := container.GetFirstDevice(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("get first device: %w", err)
+	client := whatsmeow.NewClient(device, newWALogger("WA", envWALogLevel, "INFO"))
+
+	wc := &WAClient{
+		client:               client,
+		wa:                   liveWAAPI{client},
+		status:               StatusDisconnected,
+		store:                appStore,
+		events:               NewBroadcaster(),
+		maxReconnectAttempts: envInt(envReconnectMaxAttempts, 0),
+		syncDelays:           loadSyncDelays(),
+		connectSync:          loadConnectSync(),
+		readyTimeout:         envDuration(envReadyTimeout, defaultReadyTimeout),
+		mirrorPhoneDeletes:   envBool(envMirrorPhoneDeletes, true),
+		storeStatusUpdates:   envBool(envStoreStatusUpdates, false),
+		webhook:              newWebhook(loadWebhookURL()),
 	}
-
-	client := whatsmeow.NewClient(device, waLog.Stdout("WA", "INFO", true))
-
-	return &WAClient{
-		client: client,
-		status: StatusDisconnected,
-		store:  appStore,
-	}, nil
+	wc.historySyncs = newHistorySyncQueue(wc.handleHistorySync)
+	wc.autoDownload = loadMediaAutoDownloader(wc.wa, appStore)
+	return wc, nil
 }
 
 // Connect starts the WhatsApp connection. If the device is not yet paired it
@@ -91,19 +169,18 @@ func (wc *WAClient) Connect() error {
 			for evt := range qrChan {
 				switch evt.Event {
 				case "code":
-					code := evt.Code
-					wc.mu.Lock()
-					wc.qrCode = &code
-					wc.status = StatusQR
-					wc.mu.Unlock()
-					log.Printf("QR code received, scan to authenticate")
+					seq := wc.setQRCode(evt.Code, evt.Timeout)
+					log.Printf("QR code %d received, scan to authenticate", seq)
 
 				case "success":
+					now := time.Now()
 					wc.mu.Lock()
 					wc.qrCode = nil
-					wc.status = StatusAuthenticated
+					wc.changeStatusLocked(StatusAuthenticated)
+					wc.authenticatedAt = now
 					wc.mu.Unlock()
 					log.Printf("QR authentication successful")
+					wc.watchReady(now)
 
 				case "timeout":
 					log.Printf("QR code timed out, attempting reconnect")
@@ -133,14 +210,58 @@ func (wc *WAClient) Disconnect() {
 	wc.setStatus(StatusDisconnected)
 }
 
+// cacheOwnJIDs remembers the paired account's JIDs from the session store.
+func (wc *WAClient) cacheOwnJIDs() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.ownID, wc.ownLID = wc.client.Store.ID, wc.client.Store.LID
+}
+
+// ownJIDs returns the paired account's JID, with its device, and its LID
+// (empty if unknown), as cached on connect. Before the first connect it
+// reads them from the session store; the JID is nil if not paired.
+func (wc *WAClient) ownJIDs() (*types.JID, types.JID) {
+	wc.mu.RLock()
+	id, lid := wc.ownID, wc.ownLID
+	wc.mu.RUnlock()
+	if id == nil && wc.client != nil {
+		return wc.client.Store.ID, wc.client.Store.LID
+	}
+	return id, lid
+}
+
+// isOwnJID reports whether jid is the paired account, by number or LID, on
+// any of its devices.
+func (wc *WAClient) isOwnJID(jid types.JID) bool {
+	ownID, ownLID := wc.ownJIDs()
+	return isOwnJID(jid, ownID, ownLID)
+}
+
+// Status returns the connection status alone, without GetStatus's
+// database reads.
+func (wc *WAClient) Status() ConnectionStatus {
+	wc.mu.RLock()
+	defer wc.mu.RUnlock()
+	return wc.status
+}
+
 // GetStatus returns the current connection status including offline gap info.
 func (wc *WAClient) GetStatus() StatusResponse {
+	// Before taking wc.mu, which ownJIDs takes too
+	resp := StatusResponse{}
+	if id, _ := wc.ownJIDs(); id != nil {
+		resp.DeviceJID, resp.Phone = id.String(), id.User
+		if wc.client != nil {
+			resp.PushName = wc.client.Store.PushName
+		}
+	}
+	wc.addStoreStatus(&resp)
+
 	wc.mu.RLock()
 	defer wc.mu.RUnlock()
-	resp := StatusResponse{
-		Status: wc.status,
-		Ready:  wc.status == StatusReady,
-	}
+	resp.Status = wc.status
+	resp.Ready = wc.status == StatusReady
+	resp.ConnectSync = wc.connectSync
 	if ts, err := wc.store.GetSyncState("last_connected_at"); err == nil {
 		var v int64
 		if _, err := fmt.Sscanf(ts, "%d", &v); err == nil {
@@ -157,9 +278,84 @@ func (wc *WAClient) GetStatus() StatusResponse {
 		gap := *resp.LastConnectedAt - *resp.LastDisconnectedAt
 		resp.OfflineGapSecs = &gap
 	}
+	resp.ReconnectAttempts = wc.reconnectAttempts
+	resp.MessagesReceived = wc.msgReceived
+	if !wc.lastMessageAt.IsZero() {
+		ts := wc.lastMessageAt.Unix()
+		resp.LastMessageAt = &ts
+	}
+	resp.ReadyTimeouts = wc.readyTimeouts
+	if !wc.lastReadyTimeoutAt.IsZero() {
+		ts := wc.lastReadyTimeoutAt.Unix()
+		resp.LastReadyTimeoutAt = &ts
+	}
 	return resp
 }
 
+// addStoreStatus fills in the chat and message counts, last sync and deep
+// sync state of resp. Counts that fail are left at zero.
+func (wc *WAClient) addStoreStatus(resp *StatusResponse) {
+	c := wc.storeCounts(time.Now())
+	resp.Chats, resp.Messages, resp.LastSyncAt = c.chats, c.messages, c.lastSyncAt
+	deepSyncProgress.mu.Lock()
+	resp.DeepSyncRunning = deepSyncProgress.Running
+	deepSyncProgress.mu.Unlock()
+}
+
+// storeCountsTTL is how long GetStatus reuses the store's counts. Counting
+// messages scans the whole table, and clients poll /status.
+const storeCountsTTL = 5 * time.Second
+
+// storeCounts are the store totals GetStatus reports, as of at.
+type storeCounts struct {
+	at              time.Time
+	chats, messages int
+	lastSyncAt      *int64
+}
+
+// storeCounts returns the store's counts, reading them again if the cached
+// ones are older than storeCountsTTL.
+func (wc *WAClient) storeCounts(now time.Time) storeCounts {
+	wc.countsMu.Lock()
+	defer wc.countsMu.Unlock()
+	if !wc.counts.at.IsZero() && now.Sub(wc.counts.at) < storeCountsTTL {
+		return wc.counts
+	}
+	c := storeCounts{at: now}
+	var err error
+	if c.chats, err = wc.store.GetTotalChatCount(); err != nil {
+		log.Printf("Error counting chats for status: %v", err)
+	}
+	if c.messages, err = wc.store.GetTotalMessageCount(); err != nil {
+		log.Printf("Error counting messages for status: %v", err)
+	}
+	if c.lastSyncAt, err = wc.store.GetLastChatSyncAt(); err != nil {
+		log.Printf("Error getting last sync for status: %v", err)
+	}
+	wc.counts = c
+	return c
+}
+
+// recordMessageReceived bumps the per-connection message counter and stamps
+// the time of the most recent message. A stale timestamp on an otherwise
+// ready connection is a strong hint that events have stopped flowing.
+func (wc *WAClient) recordMessageReceived() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.msgReceived++
+	wc.lastMessageAt = time.Now()
+}
+
+// resetMessageStats clears the per-connection message and history sync
+// counters.
+func (wc *WAClient) resetMessageStats() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.msgReceived = 0
+	wc.lastMessageAt = time.Time{}
+	wc.history = historyStats{}
+}
+
 // GetQR returns a QR response. When a QR code is available the response
 // contains a data-URL PNG image; otherwise a human-readable status message.
 func (wc *WAClient) GetQR() QRResponse {
@@ -173,7 +369,7 @@ func (wc *WAClient) GetQR() QRResponse {
 			return QRResponse{Message: &msg}
 		}
 		dataURL := "data:image/png;base64," + png
-		return QRResponse{QR: &dataURL}
+		return QRResponse{QR: &dataURL, Seq: wc.qrSeq, ExpiresAt: wc.qrExpiresAt.Unix()}
 	}
 
 	var msg string
@@ -184,20 +380,80 @@ func (wc *WAClient) GetQR() QRResponse {
 		msg = "Connecting..."
 	case StatusAuthenticated:
 		msg = "Authenticated, waiting for ready state"
+	case StatusStuck:
+		msg = "Authenticated but never became ready, reconnecting"
 	default:
 		msg = "No QR code available (status: " + string(wc.status) + ")"
 	}
 	return QRResponse{Message: &msg}
 }
 
+// setQRCode makes code, valid for timeout, the current QR code and sends it
+// to /events subscribers. Every code is news, not just the first: WhatsApp
+// rotates them until one is scanned. It returns the code's sequence number.
+func (wc *WAClient) setQRCode(code string, timeout time.Duration) int {
+	wc.mu.Lock()
+	wc.qrCode = &code
+	wc.qrSeq++
+	wc.qrExpiresAt = time.Now().Add(timeout)
+	wc.status = StatusQR
+	evt := Event{Type: EventStatus, Status: string(StatusQR), FetchQR: true,
+		QRCode: code, QRSeq: wc.qrSeq, QRExpiresAt: wc.qrExpiresAt.Unix()}
+	wc.mu.Unlock()
+	wc.events.Publish(evt)
+	return evt.QRSeq
+}
+
+// watchReady reconnects if the client paired at authenticatedAt is still not
+// ready after readyTimeout, rather than sitting in a stuck handshake.
+func (wc *WAClient) watchReady(authenticatedAt time.Time) {
+	if wc.readyTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(wc.readyTimeout, func() {
+		if !wc.markStuck(authenticatedAt) {
+			return
+		}
+		log.Printf("WARNING: paired %s ago but the connection never became ready, reconnecting", wc.readyTimeout)
+		wc.reconnect()
+	})
+}
+
+// markStuck moves a client still waiting to become ready since
+// authenticatedAt to StatusStuck and counts the timeout. It reports false if
+// the client has moved on, e.g. became ready or paired again.
+func (wc *WAClient) markStuck(authenticatedAt time.Time) bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.status != StatusAuthenticated || !wc.authenticatedAt.Equal(authenticatedAt) {
+		return false
+	}
+	wc.changeStatusLocked(StatusStuck)
+	wc.readyTimeouts++
+	wc.lastReadyTimeoutAt = time.Now()
+	return true
+}
+
 // setStatus safely updates the connection status.
 func (wc *WAClient) setStatus(s ConnectionStatus) {
 	wc.mu.Lock()
 	defer wc.mu.Unlock()
+	wc.changeStatusLocked(s)
+}
+
+// changeStatusLocked sets the connection status, telling /events
+// subscribers if it changed. wc.mu must be held.
+func (wc *WAClient) changeStatusLocked(s ConnectionStatus) {
+	if wc.status == s {
+		return
+	}
 	wc.status = s
+	wc.events.Publish(Event{Type: EventStatus, Status: string(s)})
 }
 
-// reconnect performs a single disconnect-sleep-connect cycle.
+// reconnect disconnects and retries Connect, backing off as reconnectDelay
+// says, until it succeeds. If a consecutive-attempt limit is set and hit, the
+// status becomes "failed" and only Reconnect (POST /reconnect) starts over.
 // The mutex prevents concurrent reconnects (e.g. StreamReplaced → Disconnect → Disconnected).
 func (wc *WAClient) reconnect() {
 	if !wc.reconnecting.TryLock() {
@@ -207,14 +463,82 @@ func (wc *WAClient) reconnect() {
 	defer wc.reconnecting.Unlock()
 
 	wc.client.Disconnect()
-	wc.setStatus(StatusDisconnected)
-	log.Printf("Reconnecting in 5 seconds...")
-	time.Sleep(5 * time.Second)
-	if err := wc.Connect(); err != nil {
+	for {
+		attempt, ok := wc.nextReconnectAttempt()
+		if !ok {
+			log.Printf("Giving up after %d consecutive reconnect attempts; POST /reconnect to retry", attempt)
+			return
+		}
+		wc.setStatus(StatusDisconnected)
+		delay := reconnectDelay(attempt)
+		log.Printf("Reconnecting in %s (attempt %d)...", delay, attempt)
+		time.Sleep(delay)
+		err := wc.Connect()
+		if err == nil {
+			return
+		}
 		log.Printf("Reconnect failed: %v", err)
 	}
 }
 
+// nextReconnectAttempt counts a reconnect attempt and returns its number.
+// Once the limit is used up it marks the client failed and returns ok=false.
+func (wc *WAClient) nextReconnectAttempt() (attempt int, ok bool) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.status == StatusFailed {
+		return wc.reconnectAttempts, false
+	}
+	if wc.maxReconnectAttempts > 0 && wc.reconnectAttempts >= wc.maxReconnectAttempts {
+		wc.changeStatusLocked(StatusFailed)
+		return wc.reconnectAttempts, false
+	}
+	wc.reconnectAttempts++
+	return wc.reconnectAttempts, true
+}
+
+// resetReconnectAttempts clears the attempt counter and any "failed" state.
+func (wc *WAClient) resetReconnectAttempts() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.reconnectAttempts = 0
+	if wc.status == StatusFailed {
+		wc.changeStatusLocked(StatusDisconnected)
+	}
+}
+
+// Reconnect manually starts a fresh reconnect cycle, e.g. after the bridge
+// gave up. It returns immediately; progress is visible via GetStatus.
+func (wc *WAClient) Reconnect() {
+	wc.resetReconnectAttempts()
+	go wc.reconnect()
+}
+
+// ResetSession unpairs the bridge: it logs the device out and deletes its
+// session from whatsmeow.db, then reconnects, which starts the QR flow to
+// pair again. app.db and its history, cached media included, are left
+// alone. If WhatsApp can't be told about the logout, e.g. because the
+// session is already broken, the session is deleted locally all the same.
+func (wc *WAClient) ResetSession(ctx context.Context) error {
+	if wc.client.Store.ID == nil {
+		return errNotPaired
+	}
+	if err := wc.client.Logout(ctx); err != nil {
+		log.Printf("Logout request failed (%v), deleting the session locally", err)
+		wc.client.Disconnect()
+		if err := wc.client.Store.Delete(ctx); err != nil {
+			return fmt.Errorf("delete session: %w", err)
+		}
+	}
+	log.Printf("Session reset; scan the QR code to pair again")
+	wc.mu.Lock()
+	wc.ownID, wc.ownLID = nil, types.EmptyJID
+	wc.mu.Unlock()
+	wc.setStatus(StatusDisconnected)
+	wc.Reconnect()
+	return nil
+}
+
 // RequestHistorySync sends an on-demand history sync request to the primary device.
 // It asks for `count` messages before the given anchor point. If the chat has no
 // messages yet, a dummy anchor at the current time is used.
@@ -235,6 +559,7 @@ func (wc *WAClient) RequestHistorySync(ctx context.Context, chatJID string, coun
 		if err != nil {
 			return fmt.Errorf("send history sync request (no anchor): %w", err)
 		}
+		wc.recordHistoryRequest()
 		log.Printf("Requested %d messages for %s (no existing messages, using now as anchor)", count, chatJID)
 		return nil
 	}
@@ -254,6 +579,7 @@ func (wc *WAClient) RequestHistorySync(ctx context.Context, chatJID string, coun
 	if err != nil {
 		return fmt.Errorf("send history sync request: %w", err)
 	}
+	wc.recordHistoryRequest()
 	log.Printf("Requested %d messages before oldest in %s (anchor: %s at %d)", count, chatJID, oldest.RawMsgID, oldest.Ts)
 	return nil
 }
@@ -275,6 +601,7 @@ func (wc *WAClient) RequestRecentMessages(ctx context.Context, chatJID string, c
 	if err != nil {
 		return fmt.Errorf("request recent messages: %w", err)
 	}
+	wc.recordHistoryRequest()
 	log.Printf("Requested %d recent messages for %s (now anchor)", count, chatJID)
 	return nil
 }
@@ -305,7 +632,9 @@ var deepSyncProgress = &DeepSyncProgress{}
 // DeepSync aggressively pulls all available history for every chat.
 // It loops each chat, requesting 50 messages at a time, until the count
 // stops growing (2 consecutive rounds with no change).
-func (wc *WAClient) DeepSync() {
+// Chats already synced back to the start of their history are skipped
+// unless force is set.
+func (wc *WAClient) DeepSync(force bool) {
 	deepSyncProgress.mu.Lock()
 	if deepSyncProgress.Running {
 		deepSyncProgress.mu.Unlock()
@@ -331,6 +660,19 @@ func (wc *WAClient) DeepSync() {
 		return
 	}
 
+	fullySynced := make(map[string]bool)
+	if !force {
+		states, err := wc.store.GetAllChatSyncStates()
+		if err != nil {
+			log.Printf("Deep sync: failed to load sync state, syncing every chat: %v", err)
+		}
+		for _, st := range states {
+			if st.FullySynced {
+				fullySynced[toInternalJID(st.ChatID)] = true
+			}
+		}
+	}
+
 	deepSyncProgress.mu.Lock()
 	deepSyncProgress.TotalChats = len(chatJIDs)
 	deepSyncProgress.mu.Unlock()
@@ -341,6 +683,19 @@ func (wc *WAClient) DeepSync() {
 		deepSyncProgress.ChatIndex = i + 1
 		deepSyncProgress.mu.Unlock()
 
+		if fullySynced[jid] {
+			count, _ := wc.store.GetMessageCount(jid)
+			deepSyncProgress.mu.Lock()
+			deepSyncProgress.Results = append(deepSyncProgress.Results, DeepSyncChatResult{
+				ChatJID: toAPIJIDString(jid),
+				Before:  count,
+				After:   count,
+				Status:  "skipped",
+			})
+			deepSyncProgress.mu.Unlock()
+			continue
+		}
+
 		beforeCount, _ := wc.store.GetMessageCount(jid)
 		staleRounds := 0
 		rounds := 0
@@ -359,7 +714,7 @@ func (wc *WAClient) DeepSync() {
 			rounds++
 
 			// Wait for messages to arrive
-			time.Sleep(10 * time.Second)
+			time.Sleep(wc.syncDelays.RoundWait)
 
 			currentCount, _ := wc.store.GetMessageCount(jid)
 			if currentCount == lastCount {
@@ -373,6 +728,12 @@ func (wc *WAClient) DeepSync() {
 
 		afterCount, _ := wc.store.GetMessageCount(jid)
 		newMsgs := afterCount - beforeCount
+		// An empty round doesn't mean the start was reached: the phone often
+		// ignores requests (whatsmeow #654). Only its end-of-history marker,
+		// seen in processConversation, marks the chat fully synced.
+		if err := wc.store.RecordChatSync(jid, false); err != nil {
+			log.Printf("Deep sync: error recording sync state for %s: %v", jid, err)
+		}
 		status := "complete"
 		if rounds >= 30 {
 			status = "max_rounds"
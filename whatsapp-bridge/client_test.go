@@ -0,0 +1,193 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestMessageStats_RecordAndReset(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), status: StatusReady}
+
+	status := wc.GetStatus()
+	if status.MessagesReceived != 0 || status.LastMessageAt != nil {
+		t.Fatalf("fresh client: got count=%d lastMessageAt=%v, want 0/nil", status.MessagesReceived, status.LastMessageAt)
+	}
+
+	wc.recordMessageReceived()
+	wc.recordMessageReceived()
+
+	status = wc.GetStatus()
+	if status.MessagesReceived != 2 {
+		t.Errorf("MessagesReceived = %d, want 2", status.MessagesReceived)
+	}
+	if status.LastMessageAt == nil {
+		t.Error("LastMessageAt should be set after a message")
+	}
+
+	wc.resetMessageStats()
+	status = wc.GetStatus()
+	if status.MessagesReceived != 0 || status.LastMessageAt != nil {
+		t.Errorf("after reset: got count=%d lastMessageAt=%v, want 0/nil", status.MessagesReceived, status.LastMessageAt)
+	}
+}
+
+func TestGetStatus_AccountAndStore(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), status: StatusReady}
+	status := wc.GetStatus()
+	if status.DeviceJID != "" || status.Chats != 0 || status.Messages != 0 || status.LastSyncAt != nil {
+		t.Fatalf("unpaired, empty: %+v", status)
+	}
+
+	own := types.NewADJID("19999999999", 0, 12)
+	wc.ownID = &own
+	alice := "10000000001@s.whatsapp.net"
+	wc.store.UpsertChat(alice, "Alice", false, nil, nil)
+	wc.store.UpsertChat("10000000002@s.whatsapp.net", "Bob", false, nil, nil)
+	wc.store.UpsertMessage("false_10000000001@c.us_M1", alice, alice, "", false, "hi", 100, false, nil, nil)
+	wc.store.RecordChatSync(alice, false)
+
+	// Counts are cached for a while; expire them
+	if status = wc.GetStatus(); status.Chats != 0 {
+		t.Errorf("chats = %d, want the cached count", status.Chats)
+	}
+	wc.counts.at = time.Now().Add(-storeCountsTTL)
+	status = wc.GetStatus()
+	if status.DeviceJID != "19999999999:12@s.whatsapp.net" || status.Phone != "19999999999" ||
+		status.Chats != 2 || status.Messages != 1 || status.LastSyncAt == nil || status.DeepSyncRunning {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestNextReconnectAttempt_GivesUpAtLimit(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), status: StatusDisconnected, maxReconnectAttempts: 3}
+
+	for want := 1; want <= 3; want++ {
+		attempt, ok := wc.nextReconnectAttempt()
+		if !ok || attempt != want {
+			t.Fatalf("attempt %d: got (%d, %v)", want, attempt, ok)
+		}
+	}
+	if _, ok := wc.nextReconnectAttempt(); ok {
+		t.Fatal("expected give-up after 3 attempts")
+	}
+	status := wc.GetStatus()
+	if status.Status != StatusFailed || status.ReconnectAttempts != 3 {
+		t.Errorf("status = %s attempts = %d, want failed/3", status.Status, status.ReconnectAttempts)
+	}
+	// Stays failed until reset
+	if _, ok := wc.nextReconnectAttempt(); ok {
+		t.Error("failed client should not attempt again")
+	}
+
+	wc.resetReconnectAttempts()
+	if wc.GetStatus().Status != StatusDisconnected {
+		t.Errorf("after reset: status = %s, want disconnected", wc.GetStatus().Status)
+	}
+	if attempt, ok := wc.nextReconnectAttempt(); !ok || attempt != 1 {
+		t.Errorf("after reset: got (%d, %v), want (1, true)", attempt, ok)
+	}
+}
+
+func TestNextReconnectAttempt_Unlimited(t *testing.T) {
+	wc := &WAClient{status: StatusDisconnected}
+	for i := 0; i < 100; i++ {
+		if _, ok := wc.nextReconnectAttempt(); !ok {
+			t.Fatalf("gave up after %d attempts with no limit", i)
+		}
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 5 * time.Minute}, // 320s, capped
+		{1000, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := reconnectDelay(tt.attempt); got != tt.want {
+			t.Errorf("reconnectDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestMarkStuck(t *testing.T) {
+	paired := time.Unix(1700000000, 0)
+	wc := &WAClient{store: newTestStore(t), status: StatusAuthenticated, authenticatedAt: paired}
+
+	// A timer from an earlier pairing doesn't count
+	if wc.markStuck(paired.Add(-time.Minute)) {
+		t.Error("stale timer marked the client stuck")
+	}
+	if !wc.markStuck(paired) {
+		t.Fatal("authenticated client not marked stuck")
+	}
+	status := wc.GetStatus()
+	if status.Status != StatusStuck || status.ReadyTimeouts != 1 || status.LastReadyTimeoutAt == nil {
+		t.Errorf("status = %+v, want stuck with one timeout", status)
+	}
+
+	// Once ready, the timer is a no-op
+	wc.setStatus(StatusReady)
+	if wc.markStuck(paired) {
+		t.Error("ready client marked stuck")
+	}
+}
+
+func TestSetStatus_PublishesChanges(t *testing.T) {
+	wc := &WAClient{status: StatusDisconnected, events: NewBroadcaster()}
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+
+	wc.setStatus(StatusConnecting)
+	wc.setStatus(StatusConnecting) // unchanged: no event
+	wc.setStatus(StatusReady)
+
+	for _, want := range []ConnectionStatus{StatusConnecting, StatusReady} {
+		select {
+		case evt := <-ch:
+			if evt.Type != EventStatus || evt.Status != string(want) {
+				t.Errorf("event = %+v, want status %s", evt, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %s", want)
+		}
+	}
+	select {
+	case evt := <-ch:
+		t.Errorf("unexpected event %+v", evt)
+	default:
+	}
+}
+
+func TestSetQRCode(t *testing.T) {
+	wc := &WAClient{status: StatusConnecting, events: NewBroadcaster()}
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+
+	for i, code := range []string{"2@first", "2@second"} {
+		if seq := wc.setQRCode(code, time.Minute); seq != i+1 {
+			t.Errorf("seq of %s = %d, want %d", code, seq, i+1)
+		}
+		select {
+		case evt := <-ch:
+			if evt.Type != EventStatus || !evt.FetchQR || evt.QRCode != code || evt.QRSeq != i+1 ||
+				evt.QRExpiresAt < time.Now().Add(59*time.Second).Unix() {
+				t.Errorf("event = %+v", evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %s", code)
+		}
+	}
+
+	resp := wc.GetQR()
+	if resp.QR == nil || resp.Seq != 2 || resp.ExpiresAt == 0 {
+		t.Errorf("GetQR = %+v, want the second code", resp)
+	}
+}
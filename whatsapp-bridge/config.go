@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // WAPP_TIMEZONE must resolve on hosts without a zone database
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Environment variables read at startup. All are optional.
+//
+//	WAPP_WA_LOG_LEVEL            whatsmeow client log level: DEBUG, INFO, WARN, ERROR or OFF (default INFO)
+//	WAPP_WA_DB_LOG_LEVEL         whatsmeow session-store log level, same values (default OFF)
+//	WAPP_DEBUG_API               set to true to enable debugging endpoints such as GET /messages/{id}/raw
+//	WAPP_RECONNECT_MAX_ATTEMPTS  consecutive failed reconnects before giving up with status "failed"
+//	                             until POST /reconnect; 0 retries forever, backing off from 5s to
+//	                             5m between attempts (default 0)
+//	WAPP_SYNC_REQUEST_DELAY      pause between per-chat history requests, as a Go duration (default 200ms)
+//	WAPP_SYNC_ROUND_WAIT         deep sync wait for each round's messages to arrive (default 10s)
+//	WAPP_SYNC_STARTUP_DELAY      wait after connecting before syncing recent chats (default 2s)
+//	WAPP_SYNC_ON_CONNECT         set to false to skip requesting recent messages for the most recent
+//	                             chats each time the offline sync completes (default true)
+//	WAPP_SYNC_ON_CONNECT_CHATS   how many of the most recent chats that sync covers; 0 skips it (default 5)
+//	WAPP_SYNC_ON_CONNECT_COUNT   messages it requests per chat (default 50, at most 500)
+//	WAPP_MESSAGES_LIMIT_DEFAULT  messages returned when a request omits limit (default 50)
+//	WAPP_MESSAGES_LIMIT_MAX      largest messages limit served; bigger requests are clamped
+//	                             (default and hard ceiling 1000; page with before= for more)
+//	WAPP_INCLUDE_LID_CHATS       set to true to list chats keyed by a LID (@lid) rather than a number
+//	WAPP_INCLUDE_BROADCAST_CHATS set to true to list broadcast lists (the status feed stays hidden)
+//	WAPP_REQUIRE_CONFIRM         confirmation for irreversible endpoints (permanent delete, clear,
+//	                             delete for everyone): off, flag (confirm=true) or token
+//	                             (GET /confirm-token) (default off)
+//	WAPP_TRASH_RETENTION_DAYS    days a trashed chat is kept before it is deleted for good;
+//	                             0 keeps it until deleted by hand (default 30)
+//	WAPP_ABOUT_REFRESH_INTERVAL  how old a contact's stored about text may get before it is fetched
+//	                             again, as a Go duration; 0 disables the refresh (default 24h)
+//	WAPP_READY_TIMEOUT           how long after pairing to wait for the connection to become ready
+//	                             before reconnecting, as a Go duration; 0 waits forever (default 60s)
+//	WAPP_MIRROR_PHONE_DELETES    set to false to keep the history of chats deleted or cleared on the
+//	                             phone instead of deleting it here too (default true)
+//	WAPP_GROUP_REFRESH_INTERVAL  how often each group's name, participants and description are
+//	                             refetched, as a Go duration; 0 disables the refresh (default 6h)
+//	WAPP_DB_JOURNAL_MODE         SQLite journal mode for both databases: WAL, DELETE, TRUNCATE or
+//	                             PERSIST. Use DELETE or TRUNCATE on network filesystems, where WAL
+//	                             breaks (default WAL for app.db, SQLite's DELETE for whatsmeow.db)
+//	WAPP_DB_BUSY_TIMEOUT         how long a write waits for a locked database before failing with
+//	                             "database is locked", as a Go duration (default 5s)
+//	WAPP_STORE_STATUS_UPDATES    set to true to store contacts' status updates (stories), served by
+//	                             GET /status-updates, instead of dropping them (default false)
+//	WAPP_WEBHOOK_URL             http(s) URL each new incoming message is POSTed to as JSON; if unset,
+//	                             read from ~/.whatsapp-raycast/webhook-url (default none)
+//	WAPP_AUTO_DOWNLOAD_MEDIA     set to true to download incoming media to ~/.whatsapp-raycast/media
+//	                             as it arrives, so POST /download-media needn't fetch it (default false)
+//	WAPP_AUTO_DOWNLOAD_MAX_MB    largest media file auto-downloaded, in MB (default 16)
+//	WAPP_AUTO_DOWNLOAD_INTERVAL  pause between auto-downloads, as a Go duration (default 1s)
+//	WAPP_MEDIA_CACHE_MAX_MB      size the media cache is trimmed to, oldest files first, in MB;
+//	                             0 is unlimited (default 2048)
+//	WAPP_MEDIA_CACHE_MAX_AGE     how long cached media is kept, as a Go duration; 0 keeps it until
+//	                             the size cap or its message's deletion drops it (default 0)
+//	WAPP_MAX_CONCURRENT_UPLOADS  media sends (/send-image, /send-video, /send-audio) uploading at once;
+//	                             up to 4 more per slot wait, beyond that they get a 503. 0 is
+//	                             unlimited (default 2)
+//	WAPP_TIMEZONE                IANA zone, such as Europe/Berlin, for log timestamps and the /ui
+//	                             times and date separators (default the server's local zone for
+//	                             logs and the browser's for /ui)
+//
+// The sync defaults suit most accounts. On an account that has been rate
+// limited or is new, slow down to around 1s / 20s / 10s; on a long-standing
+// account, halving them is usually tolerated.
+const (
+	envWALogLevel           = "WAPP_WA_LOG_LEVEL"
+	envWADBLogLevel         = "WAPP_WA_DB_LOG_LEVEL"
+	envDebugAPI             = "WAPP_DEBUG_API"
+	envReconnectMaxAttempts = "WAPP_RECONNECT_MAX_ATTEMPTS"
+	envSyncRequestDelay     = "WAPP_SYNC_REQUEST_DELAY"
+	envSyncRoundWait        = "WAPP_SYNC_ROUND_WAIT"
+	envSyncStartupDelay     = "WAPP_SYNC_STARTUP_DELAY"
+	envSyncOnConnect        = "WAPP_SYNC_ON_CONNECT"
+	envSyncOnConnectChats   = "WAPP_SYNC_ON_CONNECT_CHATS"
+	envSyncOnConnectCount   = "WAPP_SYNC_ON_CONNECT_COUNT"
+	envMessagesLimitDefault = "WAPP_MESSAGES_LIMIT_DEFAULT"
+	envMessagesLimitMax     = "WAPP_MESSAGES_LIMIT_MAX"
+	envIncludeLIDChats      = "WAPP_INCLUDE_LID_CHATS"
+	envIncludeBroadcasts    = "WAPP_INCLUDE_BROADCAST_CHATS"
+	envTrashRetentionDays   = "WAPP_TRASH_RETENTION_DAYS"
+	envRequireConfirm       = "WAPP_REQUIRE_CONFIRM"
+	envAboutRefresh         = "WAPP_ABOUT_REFRESH_INTERVAL"
+	envGroupRefresh         = "WAPP_GROUP_REFRESH_INTERVAL"
+	envMirrorPhoneDeletes   = "WAPP_MIRROR_PHONE_DELETES"
+	envReadyTimeout         = "WAPP_READY_TIMEOUT"
+	envTimezone             = "WAPP_TIMEZONE"
+	envStoreStatusUpdates   = "WAPP_STORE_STATUS_UPDATES"
+	envDBJournalMode        = "WAPP_DB_JOURNAL_MODE"
+	envDBBusyTimeout        = "WAPP_DB_BUSY_TIMEOUT"
+	envWebhookURL           = "WAPP_WEBHOOK_URL"
+	envAutoDownloadMedia    = "WAPP_AUTO_DOWNLOAD_MEDIA"
+	envAutoDownloadMaxMB    = "WAPP_AUTO_DOWNLOAD_MAX_MB"
+	envAutoDownloadInterval = "WAPP_AUTO_DOWNLOAD_INTERVAL"
+	envMediaCacheMaxMB      = "WAPP_MEDIA_CACHE_MAX_MB"
+	envMediaCacheMaxAge     = "WAPP_MEDIA_CACHE_MAX_AGE"
+	envMaxConcurrentUploads = "WAPP_MAX_CONCURRENT_UPLOADS"
+)
+
+// defaultTrashRetentionDays is how long a trashed chat is kept by default.
+const defaultTrashRetentionDays = 30
+
+// defaultAboutRefresh is how long stored about text is trusted by default.
+const defaultAboutRefresh = 24 * time.Hour
+
+// defaultReadyTimeout is how long a freshly paired client may take to become
+// ready by default.
+const defaultReadyTimeout = 60 * time.Second
+
+// defaultGroupRefresh is how often group names and rosters are refetched by
+// default.
+const defaultGroupRefresh = 6 * time.Hour
+
+// syncDelays are the pauses history sync operations take between requests to
+// the phone. Longer pauses lower the risk of rate limiting or bans.
+type syncDelays struct {
+	Request   time.Duration // between per-chat sync requests
+	RoundWait time.Duration // deep sync: wait for a round's messages to arrive
+	Startup   time.Duration // before syncing recent chats after connecting
+}
+
+var defaultSyncDelays = syncDelays{
+	Request:   200 * time.Millisecond,
+	RoundWait: 10 * time.Second,
+	Startup:   2 * time.Second,
+}
+
+// loadSyncDelays returns defaultSyncDelays with any environment overrides.
+func loadSyncDelays() syncDelays {
+	return syncDelays{
+		Request:   envDuration(envSyncRequestDelay, defaultSyncDelays.Request),
+		RoundWait: envDuration(envSyncRoundWait, defaultSyncDelays.RoundWait),
+		Startup:   envDuration(envSyncStartupDelay, defaultSyncDelays.Startup),
+	}
+}
+
+// ConnectSync is the recent-message sync run each time the offline sync
+// completes after connecting: Count messages requested for each of the Chats
+// most recent chats. It is reported by GET /status.
+type ConnectSync struct {
+	Enabled bool `json:"enabled"`
+	Chats   int  `json:"chats"`
+	Count   int  `json:"count"`
+}
+
+var defaultConnectSync = ConnectSync{Enabled: true, Chats: 5, Count: 50}
+
+// loadConnectSync returns defaultConnectSync with any environment overrides.
+// Zero chats disables it; Count is capped like a /sync-history request's.
+func loadConnectSync() ConnectSync {
+	c := ConnectSync{
+		Enabled: envBool(envSyncOnConnect, defaultConnectSync.Enabled),
+		Chats:   envInt(envSyncOnConnectChats, defaultConnectSync.Chats),
+		Count:   envInt(envSyncOnConnectCount, defaultConnectSync.Count),
+	}
+	if c.Count < 1 || c.Count > defaultResultLimits.SyncCountMax {
+		log.Printf("Invalid %s=%d, using %d", envSyncOnConnectCount, c.Count, defaultConnectSync.Count)
+		c.Count = defaultConnectSync.Count
+	}
+	if c.Chats == 0 {
+		c.Enabled = false
+	}
+	return c
+}
+
+// resultLimits bounds how much a single request can ask for. Each Default
+// applies when the request omits the value; anything above Max is clamped.
+type resultLimits struct {
+	MessagesDefault, MessagesMax   int // GET /chats/{chatId}/messages limit
+	SearchDefault, SearchMax       int // GET /search limit
+	PerChatDefault, PerChatMax     int // GET /search?groupBy=chat perChat
+	SyncCountDefault, SyncCountMax int // messages requested per chat by /sync-history and /sync-all
+}
+
+var defaultResultLimits = resultLimits{
+	MessagesDefault:  50,
+	MessagesMax:      maxMessagesPerQuery,
+	SearchDefault:    50,
+	SearchMax:        500,
+	PerChatDefault:   3,
+	PerChatMax:       20,
+	SyncCountDefault: 50,
+	SyncCountMax:     500,
+}
+
+// loadResultLimits returns defaultResultLimits with the message limits
+// overridden from the environment. The max can only be lowered, and a
+// default above the max is lowered to it.
+func loadResultLimits() resultLimits {
+	l := defaultResultLimits
+	l.MessagesDefault = envInt(envMessagesLimitDefault, l.MessagesDefault)
+	l.MessagesMax = envInt(envMessagesLimitMax, l.MessagesMax)
+	if l.MessagesMax < 1 || l.MessagesMax > maxMessagesPerQuery {
+		l.MessagesMax = defaultResultLimits.MessagesMax
+	}
+	if l.MessagesDefault < 1 || l.MessagesDefault > l.MessagesMax {
+		l.MessagesDefault = min(defaultResultLimits.MessagesDefault, l.MessagesMax)
+	}
+	return l
+}
+
+// chatFilter selects which chats the list endpoints (contacts, chats, search,
+// sync state) and deep sync see. The zero value hides LID chats and all
+// broadcast JIDs. Trashed chats are always hidden.
+type chatFilter struct {
+	IncludeLID       bool
+	IncludeBroadcast bool
+}
+
+// loadChatFilter reads the chat filter from the environment.
+func loadChatFilter() chatFilter {
+	return chatFilter{
+		IncludeLID:       envBool(envIncludeLIDChats, false),
+		IncludeBroadcast: envBool(envIncludeBroadcasts, false),
+	}
+}
+
+// defaultDBBusyTimeout is how long a write waits on a locked database by
+// default.
+const defaultDBBusyTimeout = 5 * time.Second
+
+// sqliteOptions are the connection settings for a SQLite database.
+type sqliteOptions struct {
+	JournalMode string // "" leaves SQLite's default, DELETE
+	BusyTimeout time.Duration
+}
+
+// loadSQLiteOptions reads the database settings from the environment.
+// defaultJournal applies when WAPP_DB_JOURNAL_MODE is unset or invalid.
+func loadSQLiteOptions(defaultJournal string) sqliteOptions {
+	o := sqliteOptions{
+		JournalMode: defaultJournal,
+		BusyTimeout: envDuration(envDBBusyTimeout, defaultDBBusyTimeout),
+	}
+	switch mode := strings.ToUpper(envString(envDBJournalMode, "")); mode {
+	case "":
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST":
+		o.JournalMode = mode
+	default:
+		log.Printf("Invalid %s=%q (want WAL, DELETE, TRUNCATE or PERSIST), using the default", envDBJournalMode, mode)
+	}
+	return o
+}
+
+// params returns the options as go-sqlite3 DSN parameters.
+func (o sqliteOptions) params() string {
+	p := fmt.Sprintf("_busy_timeout=%d", o.BusyTimeout.Milliseconds())
+	if o.JournalMode != "" {
+		p = "_journal_mode=" + o.JournalMode + "&" + p
+	}
+	return p
+}
+
+// String describes the options for the startup log.
+func (o sqliteOptions) String() string {
+	mode := o.JournalMode
+	if mode == "" {
+		mode = "DELETE"
+	}
+	return fmt.Sprintf("journal_mode=%s busy_timeout=%s", mode, o.BusyTimeout)
+}
+
+// loadTimezone reads WAPP_TIMEZONE, returning the zone and its name. Unset or
+// invalid, it returns time.Local and "".
+func loadTimezone() (*time.Location, string) {
+	name := envString(envTimezone, "")
+	if name == "" {
+		return time.Local, ""
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using local time: %v", envTimezone, name, err)
+		return time.Local, ""
+	}
+	return loc, loc.String()
+}
+
+// loadConfirmMode reads WAPP_REQUIRE_CONFIRM, falling back to off.
+func loadConfirmMode() string {
+	switch mode := envString(envRequireConfirm, confirmOff); mode {
+	case confirmOff, confirmFlag, confirmToken:
+		return mode
+	default:
+		log.Printf("Invalid %s=%q, using %s", envRequireConfirm, mode, confirmOff)
+		return confirmOff
+	}
+}
+
+// envString returns the trimmed value of an environment variable, or def if
+// it is unset or blank.
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool parses a boolean environment variable (1/0, true/false, ...),
+// returning def if it is unset or not a valid boolean.
+func envBool(key string, def bool) bool {
+	raw := envString(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %t", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// envInt parses a non-negative integer environment variable, returning def if
+// it is unset or invalid.
+func envInt(key string, def int) int {
+	raw := envString(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		log.Printf("Invalid %s=%q, using %d", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// envDuration parses a non-negative Go duration ("500ms", "2s") from the
+// environment, returning def if it is unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	raw := envString(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v < 0 {
+		log.Printf("Invalid %s=%q, using %s", key, raw, def)
+		return def
+	}
+	return v
+}
+
+// parseLogLevel normalizes a log level name. It returns the whatsmeow level
+// string, whether logging is enabled at all, and whether the value was valid.
+func parseLogLevel(v string) (level string, enabled, ok bool) {
+	switch level := strings.ToUpper(strings.TrimSpace(v)); level {
+	case "DEBUG", "INFO", "WARN", "ERROR":
+		return level, true, true
+	case "WARNING":
+		return "WARN", true, true
+	case "OFF", "NONE", "SILENT":
+		return "", false, true
+	default:
+		return "", false, false
+	}
+}
+
+// newWALogger builds a whatsmeow logger whose level comes from envKey,
+// falling back to def when the variable is unset or invalid.
+func newWALogger(module, envKey, def string) waLog.Logger {
+	raw := envString(envKey, def)
+	level, enabled, ok := parseLogLevel(raw)
+	if !ok {
+		log.Printf("Invalid %s=%q, using %s", envKey, raw, def)
+		level, enabled, _ = parseLogLevel(def)
+	}
+	if !enabled {
+		return waLog.Noop
+	}
+	return waLog.Stdout(module, level, true)
+}
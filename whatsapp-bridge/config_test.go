@@ -0,0 +1,210 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantLevel   string
+		wantEnabled bool
+		wantOK      bool
+	}{
+		{"DEBUG", "DEBUG", true, true},
+		{"info", "INFO", true, true},
+		{" warn ", "WARN", true, true},
+		{"warning", "WARN", true, true},
+		{"ERROR", "ERROR", true, true},
+		{"off", "", false, true},
+		{"none", "", false, true},
+		{"verbose", "", false, false},
+		{"", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			level, enabled, ok := parseLogLevel(tt.input)
+			if level != tt.wantLevel || enabled != tt.wantEnabled || ok != tt.wantOK {
+				t.Errorf("parseLogLevel(%q) = (%q, %v, %v), want (%q, %v, %v)",
+					tt.input, level, enabled, ok, tt.wantLevel, tt.wantEnabled, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEnvString(t *testing.T) {
+	t.Setenv("WAPP_TEST_VALUE", "  set  ")
+	if got := envString("WAPP_TEST_VALUE", "def"); got != "set" {
+		t.Errorf("envString = %q, want %q", got, "set")
+	}
+	t.Setenv("WAPP_TEST_VALUE", "")
+	if got := envString("WAPP_TEST_VALUE", "def"); got != "def" {
+		t.Errorf("envString blank = %q, want %q", got, "def")
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	tests := []struct {
+		value string
+		def   bool
+		want  bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{"true", false, true},
+		{"1", false, true},
+		{"FALSE", true, false},
+		{"yes please", true, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("WAPP_TEST_BOOL", tt.value)
+		if got := envBool("WAPP_TEST_BOOL", tt.def); got != tt.want {
+			t.Errorf("envBool(%q, %v) = %v, want %v", tt.value, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestEnvInt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 10},
+		{"3", 3},
+		{" 0 ", 0},
+		{"-1", 10},
+		{"lots", 10},
+	}
+	for _, tt := range tests {
+		t.Setenv("WAPP_TEST_INT", tt.value)
+		if got := envInt("WAPP_TEST_INT", 10); got != tt.want {
+			t.Errorf("envInt(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSyncDelays(t *testing.T) {
+	t.Setenv(envSyncRequestDelay, "1s")
+	t.Setenv(envSyncRoundWait, "-5s")
+	t.Setenv(envSyncStartupDelay, "soon")
+
+	got := loadSyncDelays()
+	if got.Request != time.Second {
+		t.Errorf("Request = %s, want 1s", got.Request)
+	}
+	// Invalid overrides fall back to the defaults
+	if got.RoundWait != defaultSyncDelays.RoundWait || got.Startup != defaultSyncDelays.Startup {
+		t.Errorf("got %+v, want defaults for RoundWait and Startup", got)
+	}
+}
+
+func TestLoadConnectSync(t *testing.T) {
+	tests := []struct {
+		name                  string
+		enabled, chats, count string
+		want                  ConnectSync
+	}{
+		{"unset", "", "", "", defaultConnectSync},
+		{"overrides", "", "10", "20", ConnectSync{Enabled: true, Chats: 10, Count: 20}},
+		{"disabled", "false", "", "", ConnectSync{Chats: 5, Count: 50}},
+		{"no chats", "", "0", "", ConnectSync{Count: 50}},
+		{"count above max", "", "", "5000", defaultConnectSync},
+		{"zero count", "", "", "0", defaultConnectSync},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envSyncOnConnect, tt.enabled)
+			t.Setenv(envSyncOnConnectChats, tt.chats)
+			t.Setenv(envSyncOnConnectCount, tt.count)
+			if got := loadConnectSync(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadChatFilter(t *testing.T) {
+	if got := loadChatFilter(); got != (chatFilter{}) {
+		t.Errorf("unset: got %+v, want zero value", got)
+	}
+	t.Setenv(envIncludeLIDChats, "true")
+	t.Setenv(envIncludeBroadcasts, "maybe")
+	if got := loadChatFilter(); !got.IncludeLID || got.IncludeBroadcast {
+		t.Errorf("got %+v, want only IncludeLID", got)
+	}
+}
+
+func TestLoadResultLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		def, max    string
+		wantDefault int
+		wantMax     int
+	}{
+		{"unset", "", "", 50, 1000},
+		{"overrides", "20", "200", 20, 200},
+		{"default above max", "500", "100", 50, 100},
+		{"tiny max", "", "10", 10, 10},
+		{"zero max", "", "0", 50, 1000},
+		{"max above hard ceiling", "", "5000", 50, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envMessagesLimitDefault, tt.def)
+			t.Setenv(envMessagesLimitMax, tt.max)
+			l := loadResultLimits()
+			if l.MessagesDefault != tt.wantDefault || l.MessagesMax != tt.wantMax {
+				t.Errorf("got default=%d max=%d, want %d/%d", l.MessagesDefault, l.MessagesMax, tt.wantDefault, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestLoadTimezone(t *testing.T) {
+	tests := []struct {
+		value    string
+		wantName string
+	}{
+		{"", ""},
+		{"Asia/Tokyo", "Asia/Tokyo"},
+		{" UTC ", "UTC"},
+		{"Mars/Olympus_Mons", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(envTimezone, tt.value)
+			loc, name := loadTimezone()
+			if name != tt.wantName {
+				t.Errorf("name = %q, want %q", name, tt.wantName)
+			}
+			if tt.wantName == "" && loc != time.Local {
+				t.Errorf("loc = %v, want time.Local", loc)
+			}
+		})
+	}
+}
+
+func TestLoadSQLiteOptions(t *testing.T) {
+	tests := []struct {
+		name, journal, timeout string
+		defaultJournal         string
+		wantParams             string
+	}{
+		{"defaults", "", "", "WAL", "_journal_mode=WAL&_busy_timeout=5000"},
+		{"SQLite's default journal", "", "", "", "_busy_timeout=5000"},
+		{"overrides", "truncate", "30s", "WAL", "_journal_mode=TRUNCATE&_busy_timeout=30000"},
+		{"invalid journal", "MEMORY", "", "WAL", "_journal_mode=WAL&_busy_timeout=5000"},
+		{"invalid timeout", "", "-1s", "WAL", "_journal_mode=WAL&_busy_timeout=5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envDBJournalMode, tt.journal)
+			t.Setenv(envDBBusyTimeout, tt.timeout)
+			if got := loadSQLiteOptions(tt.defaultJournal).params(); got != tt.wantParams {
+				t.Errorf("params = %q, want %q", got, tt.wantParams)
+			}
+		})
+	}
+}
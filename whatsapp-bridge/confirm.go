@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Confirmation modes for destructive endpoints (WAPP_REQUIRE_CONFIRM).
+const (
+	confirmOff   = "off"   // no confirmation needed
+	confirmFlag  = "flag"  // confirm=true in the query or body
+	confirmToken = "token" // a one-time token from GET /confirm-token in X-Confirm-Token
+)
+
+// confirmTokenTTL is how long a confirmation token stays valid.
+const confirmTokenTTL = 60 * time.Second
+
+// confirmGate guards irreversible endpoints (permanent chat delete, clear,
+// delete for everyone) against accidental calls. A nil gate lets everything through.
+type confirmGate struct {
+	mode string
+
+	mu     sync.Mutex
+	tokens map[string]time.Time // token -> expiry
+}
+
+func newConfirmGate(mode string) *confirmGate {
+	return &confirmGate{mode: mode, tokens: make(map[string]time.Time)}
+}
+
+// issue returns a new single-use token and its expiry.
+func (g *confirmGate) issue() (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(confirmTokenTTL)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for t, exp := range g.tokens {
+		if now.After(exp) {
+			delete(g.tokens, t)
+		}
+	}
+	g.tokens[token] = expires
+	return token, expires, nil
+}
+
+// redeem consumes token, reporting whether it was issued and is unexpired.
+func (g *confirmGate) redeem(token string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	exp, ok := g.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(g.tokens, token)
+	return time.Now().Before(exp)
+}
+
+// require is check for endpoints that need confirming whatever the mode:
+// with confirmation off they still need the confirm flag.
+func (g *confirmGate) require(w http.ResponseWriter, r *http.Request, bodyConfirm bool) bool {
+	if g == nil || g.mode == confirmOff {
+		g = &confirmGate{mode: confirmFlag}
+	}
+	return g.check(w, r, bodyConfirm)
+}
+
+// check reports whether r is confirmed under the gate's mode. bodyConfirm is
+// the request body's confirm field, for endpoints that take a body. If not
+// confirmed it writes a 428 and returns false.
+func (g *confirmGate) check(w http.ResponseWriter, r *http.Request, bodyConfirm bool) bool {
+	if g == nil {
+		return true
+	}
+	switch g.mode {
+	case confirmFlag:
+		if q, _ := strconv.ParseBool(r.URL.Query().Get("confirm")); q || bodyConfirm {
+			return true
+		}
+		writeError(w, http.StatusPreconditionRequired, ErrCodeConfirmRequired, "this action is irreversible: repeat it with confirm=true")
+		return false
+	case confirmToken:
+		if token := r.Header.Get("X-Confirm-Token"); token != "" && g.redeem(token) {
+			return true
+		}
+		writeError(w, http.StatusPreconditionRequired, ErrCodeConfirmRequired, "this action is irreversible: get a token from GET /confirm-token and send it in X-Confirm-Token")
+		return false
+	default:
+		return true
+	}
+}
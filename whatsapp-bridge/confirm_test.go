@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfirmGate_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		gate        *confirmGate
+		url         string
+		bodyConfirm bool
+		want        bool
+	}{
+		{"nil gate", nil, "/x", false, true},
+		{"off", newConfirmGate(confirmOff), "/x", false, true},
+		{"flag missing", newConfirmGate(confirmFlag), "/x", false, false},
+		{"flag in query", newConfirmGate(confirmFlag), "/x?confirm=true", false, true},
+		{"flag in body", newConfirmGate(confirmFlag), "/x", true, true},
+		{"token missing", newConfirmGate(confirmToken), "/x?confirm=true", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			got := tt.gate.check(rec, httptest.NewRequest("DELETE", tt.url, nil), tt.bodyConfirm)
+			if got != tt.want {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+			if !got && rec.Code != http.StatusPreconditionRequired {
+				t.Errorf("status = %d, want 428", rec.Code)
+			}
+		})
+	}
+}
+
+func TestConfirmGate_Require(t *testing.T) {
+	tests := []struct {
+		name string
+		gate *confirmGate
+		url  string
+		want bool
+	}{
+		{"nil gate", nil, "/x", false},
+		{"nil gate confirmed", nil, "/x?confirm=true", true},
+		{"off", newConfirmGate(confirmOff), "/x", false},
+		{"off confirmed", newConfirmGate(confirmOff), "/x?confirm=true", true},
+		{"token missing", newConfirmGate(confirmToken), "/x?confirm=true", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if got := tt.gate.require(rec, httptest.NewRequest("POST", tt.url, nil), false); got != tt.want {
+				t.Errorf("require() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmGate_Token(t *testing.T) {
+	g := newConfirmGate(confirmToken)
+	token, expires, err := g.issue()
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if time.Until(expires) > confirmTokenTTL {
+		t.Errorf("expires in %s, want at most %s", time.Until(expires), confirmTokenTTL)
+	}
+
+	req := httptest.NewRequest("DELETE", "/x", nil)
+	req.Header.Set("X-Confirm-Token", token)
+	if !g.check(httptest.NewRecorder(), req, false) {
+		t.Fatal("fresh token rejected")
+	}
+	// Single use
+	if g.check(httptest.NewRecorder(), req, false) {
+		t.Error("token accepted twice")
+	}
+
+	expired, _, _ := g.issue()
+	g.tokens[expired] = time.Now().Add(-time.Second)
+	req.Header.Set("X-Confirm-Token", expired)
+	if g.check(httptest.NewRecorder(), req, false) {
+		t.Error("expired token accepted")
+	}
+}
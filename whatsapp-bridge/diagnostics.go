@@ -0,0 +1,114 @@
+package main
+
+import (
+	"time"
+)
+
+// phoneAnswerWait is how long the phone gets to answer an on-demand history
+// request before it counts as unreachable. It usually answers within seconds
+// when WhatsApp is open on it.
+const phoneAnswerWait = 30 * time.Second
+
+// historyStats is what the client has seen of history sync since it last
+// connected.
+type historyStats struct {
+	Syncs       int       // history sync events received
+	LastSyncAt  time.Time // when the latest arrived
+	RequestedAt time.Time // when the latest on-demand request was sent
+	AnsweredAt  time.Time // when the latest on-demand history sync arrived
+}
+
+// recordHistorySync counts a history sync event; onDemand marks the phone's
+// answer to an on-demand request.
+func (wc *WAClient) recordHistorySync(onDemand bool) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.history.Syncs++
+	wc.history.LastSyncAt = time.Now()
+	if onDemand {
+		wc.history.AnsweredAt = wc.history.LastSyncAt
+	}
+}
+
+// recordHistoryRequest notes that an on-demand history request was sent.
+func (wc *WAClient) recordHistoryRequest() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.history.RequestedAt = time.Now()
+}
+
+// historyStats returns a copy of the history sync stats.
+func (wc *WAClient) historyStats() historyStats {
+	wc.mu.RLock()
+	defer wc.mu.RUnlock()
+	return wc.history
+}
+
+// phoneReachable reports whether the phone answers on-demand history
+// requests: true once it has answered the latest, false if that has gone
+// unanswered for phoneAnswerWait, and nil while there is nothing to tell.
+func (h historyStats) phoneReachable(now time.Time) *bool {
+	var reachable bool
+	switch {
+	case h.RequestedAt.IsZero() && h.AnsweredAt.IsZero():
+		return nil
+	case !h.AnsweredAt.Before(h.RequestedAt):
+		reachable = true
+	case now.Sub(h.RequestedAt) < phoneAnswerWait:
+		return nil
+	}
+	return &reachable
+}
+
+// HistoryDiagnosis is the response of GET /diagnostics/history: why the
+// stored history may be empty or thin, with what to do about it.
+type HistoryDiagnosis struct {
+	Status ConnectionStatus `json:"status"`
+	// PhoneReachable is whether the phone answers history requests; null
+	// when none has been sent, or the latest is still pending.
+	PhoneReachable    *bool  `json:"phoneReachable"`
+	HistorySyncs      int    `json:"historySyncsSinceConnect"`
+	LastHistorySyncAt *int64 `json:"lastHistorySyncAt,omitempty"`
+	Chats             int    `json:"chats"`
+	Messages          int    `json:"messages"`
+	// OK is set when nothing looks wrong; Guidance then says so.
+	OK       bool     `json:"ok"`
+	Guidance []string `json:"guidance"`
+}
+
+// diagnoseHistory fills in d's OK and Guidance from its other fields. The
+// usual causes, in the order they are checked: the bridge isn't paired or
+// connected; the phone is offline, so it answers nothing (history sync comes
+// from the phone, not WhatsApp's servers); the initial sync after pairing
+// hasn't arrived yet; or chats arrived without messages, which is the phone
+// ignoring on-demand requests, as it often does (whatsmeow #654).
+func diagnoseHistory(d *HistoryDiagnosis) {
+	var guidance []string
+	switch d.Status {
+	case StatusReady:
+	case StatusQR:
+		guidance = append(guidance, "The bridge is not paired: fetch GET /qr and scan the code with WhatsApp on your phone (Settings > Linked devices).")
+	case StatusDisconnected:
+		guidance = append(guidance, "The bridge is disconnected and reconnects on its own: wait a minute and check again, or POST /reconnect. If this device was logged out on the phone, fetch GET /qr and pair again.")
+	case StatusFailed:
+		guidance = append(guidance, "The bridge gave up reconnecting: check the network, then POST /reconnect.")
+	default:
+		guidance = append(guidance, "The bridge is still connecting ("+string(d.Status)+"); wait a minute and check again.")
+	}
+
+	if d.PhoneReachable != nil && !*d.PhoneReachable {
+		guidance = append(guidance, "Your phone did not answer a history request. Open WhatsApp on your phone and keep it online and unlocked; history only comes from the phone.")
+	}
+	if d.Status == StatusReady && d.HistorySyncs == 0 && d.Messages == 0 {
+		guidance = append(guidance, "No history has arrived since connecting. After pairing the phone sends it within a few minutes while WhatsApp is open on it; if nothing arrives, POST /session/reset and pair again.")
+	}
+	if d.Chats > 0 && d.Messages == 0 {
+		guidance = append(guidance, "Chats are listed but hold no messages: the phone often ignores on-demand history requests. With WhatsApp open on the phone, open a few chats there, or run POST /deep-sync.")
+	}
+
+	d.OK = len(guidance) == 0
+	if d.OK {
+		guidance = append(guidance, "History is arriving; nothing to do.")
+	}
+	d.Guidance = guidance
+}
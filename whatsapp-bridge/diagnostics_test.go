@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhoneReachable(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		stats historyStats
+		want  string
+	}{
+		{"nothing asked", historyStats{}, "unknown"},
+		{"answered", historyStats{RequestedAt: now.Add(-time.Minute), AnsweredAt: now.Add(-50 * time.Second)}, "true"},
+		{"pending", historyStats{RequestedAt: now.Add(-5 * time.Second)}, "unknown"},
+		{"unanswered", historyStats{RequestedAt: now.Add(-time.Minute)}, "false"},
+		{"old answer, new request unanswered", historyStats{RequestedAt: now.Add(-time.Minute), AnsweredAt: now.Add(-time.Hour)}, "false"},
+		{"answered unasked", historyStats{AnsweredAt: now.Add(-time.Hour)}, "true"},
+	}
+	for _, tt := range tests {
+		got := "unknown"
+		if r := tt.stats.phoneReachable(now); r != nil {
+			got = map[bool]string{true: "true", false: "false"}[*r]
+		}
+		if got != tt.want {
+			t.Errorf("%s: phoneReachable = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiagnoseHistory(t *testing.T) {
+	unreachable := false
+	tests := []struct {
+		name   string
+		d      HistoryDiagnosis
+		wantOK bool
+		want   []string // a phrase of each expected piece of guidance
+	}{
+		{"healthy", HistoryDiagnosis{Status: StatusReady, HistorySyncs: 3, Chats: 10, Messages: 500}, true, []string{"nothing to do"}},
+		{"not paired", HistoryDiagnosis{Status: StatusQR}, false, []string{"GET /qr"}},
+		{"disconnected", HistoryDiagnosis{Status: StatusDisconnected}, false, []string{"POST /reconnect"}},
+		{"phone offline", HistoryDiagnosis{Status: StatusReady, PhoneReachable: &unreachable, HistorySyncs: 1, Chats: 10, Messages: 500}, false, []string{"Open WhatsApp on your phone"}},
+		{"nothing arrived", HistoryDiagnosis{Status: StatusReady}, false, []string{"No history has arrived"}},
+		{"empty chats", HistoryDiagnosis{Status: StatusReady, HistorySyncs: 1, Chats: 10}, false, []string{"hold no messages"}},
+		{"offline with empty chats", HistoryDiagnosis{Status: StatusReady, PhoneReachable: &unreachable, HistorySyncs: 1, Chats: 10}, false,
+			[]string{"did not answer", "hold no messages"}},
+	}
+	for _, tt := range tests {
+		d := tt.d
+		diagnoseHistory(&d)
+		if d.OK != tt.wantOK || len(d.Guidance) != len(tt.want) {
+			t.Errorf("%s: ok = %v, guidance = %q", tt.name, d.OK, d.Guidance)
+			continue
+		}
+		for i, phrase := range tt.want {
+			if !strings.Contains(d.Guidance[i], phrase) {
+				t.Errorf("%s: guidance[%d] = %q, want it to mention %q", tt.name, i, d.Guidance[i], phrase)
+			}
+		}
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	waHistorySync "go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
 	waWeb "go.mau.fi/whatsmeow/proto/waWeb"
 	"google.golang.org/protobuf/proto"
 )
@@ -20,7 +24,9 @@ func (wc *WAClient) handleEvent(evt interface{}) {
 	switch evt.(type) {
 	case *events.Connected, *events.Disconnected, *events.StreamReplaced,
 		*events.HistorySync, *events.Message, *events.PushName, *events.Receipt,
-		*events.OfflineSyncPreview, *events.OfflineSyncCompleted:
+		*events.OfflineSyncPreview, *events.OfflineSyncCompleted,
+		*events.DeleteChat, *events.ClearChat, *events.GroupInfo, *events.JoinedGroup,
+		*events.BusinessName, *events.Archive, *events.Mute:
 		// Known types — handled below
 	default:
 		log.Printf("EVENT: unhandled type %T", evt)
@@ -28,7 +34,10 @@ func (wc *WAClient) handleEvent(evt interface{}) {
 
 	switch v := evt.(type) {
 	case *events.Connected:
+		wc.cacheOwnJIDs()
 		wc.setStatus(StatusReady)
+		wc.resetMessageStats()
+		wc.resetReconnectAttempts()
 		log.Printf("WhatsApp connected and ready")
 		// Log gap since last connection for diagnostics
 		if gap, err := wc.store.GetOfflineGap(); err == nil && gap > 0 {
@@ -41,9 +50,10 @@ func (wc *WAClient) handleEvent(evt interface{}) {
 		if err := wc.store.ResetAllUnread(); err != nil {
 			log.Printf("Error resetting unread counts: %v", err)
 		}
+		wc.ensureSelfChat()
 		go wc.populateContacts()
 		go wc.populateGroupNames()
-		go wc.backfillGroupSenderNames()
+		go wc.backfillGroupSenderNames("", nil)
 
 	case *events.Disconnected:
 		wc.setStatus(StatusDisconnected)
@@ -57,83 +67,258 @@ func (wc *WAClient) handleEvent(evt interface{}) {
 		go wc.reconnect()
 
 	case *events.HistorySync:
-		wc.handleHistorySync(v)
+		wc.dispatchHistorySync(v)
 
 	case *events.Message:
+		wc.recordMessageReceived()
 		wc.handleMessage(v)
 
 	case *events.PushName:
 		wc.handlePushName(v)
 
+	case *events.BusinessName:
+		updateBusinessName(context.Background(), wc.wa, wc.store, v)
+
 	case *events.Receipt:
 		wc.handleReceipt(v)
 
+	case *events.DeleteChat:
+		wc.handleChatRemovedOnPhone(v.JID, v.Timestamp, true)
+
+	case *events.ClearChat:
+		wc.handleChatRemovedOnPhone(v.JID, v.Timestamp, false)
+
+	// Participants joining, leaving or changing number may name senders the
+	// backfill could not
+	case *events.GroupInfo:
+		wc.handleGroupRename(v)
+		wc.handleGroupMembership(v)
+		go wc.backfillGroupSenderNames(v.JID.String(), nil)
+
+	case *events.JoinedGroup:
+		wc.handleJoinedGroup(v)
+		go wc.backfillGroupSenderNames(v.JID.String(), &v.GroupInfo)
+
+	case *events.Archive:
+		wc.handleArchive(v)
+
+	case *events.Mute:
+		wc.handleMute(v)
+
 	case *events.OfflineSyncPreview:
 		log.Printf("Offline sync preview: total=%d messages=%d notifications=%d receipts=%d appdata=%d",
 			v.Total, v.Messages, v.Notifications, v.Receipts, v.AppDataChanges)
 
 	case *events.OfflineSyncCompleted:
+		if !wc.connectSync.Enabled {
+			log.Printf("Offline sync completed")
+			break
+		}
 		log.Printf("Offline sync completed, requesting recent messages for active chats")
 		go wc.syncRecentChats()
 	}
 }
 
+// History sync tuning. Initial full syncs can carry thousands of
+// conversations; processing them in chunks with a short pause lets other
+// writers (live messages, receipts) get at the database in between.
+const (
+	historySyncChunkSize      = 50
+	historySyncChunkPause     = 20 * time.Millisecond
+	largeHistorySyncThreshold = 500
+)
+
+// dispatchHistorySync queues a history sync event for handleHistorySync. It
+// never waits: imports can take minutes, and the whatsmeow event loop must
+// keep delivering messages and receipts meanwhile.
+func (wc *WAClient) dispatchHistorySync(evt *events.HistorySync) {
+	wc.recordHistorySync(evt.Data.GetSyncType() == waHistorySync.HistorySync_ON_DEMAND)
+	if n := len(evt.Data.GetConversations()); n >= largeHistorySyncThreshold {
+		log.Printf("History sync: large payload (%d conversations) queued", n)
+	}
+	wc.historySyncs.Add(evt)
+}
+
+// historySyncQueue runs history syncs through process one at a time, in
+// arrival order, on a worker goroutine. The worker is started by Add when
+// there is work and exits once the queue is empty, so syncs never interleave
+// and Add never blocks.
+type historySyncQueue struct {
+	process func(*events.HistorySync)
+
+	mu      sync.Mutex
+	pending []*events.HistorySync
+	running bool
+}
+
+func newHistorySyncQueue(process func(*events.HistorySync)) *historySyncQueue {
+	return &historySyncQueue{process: process}
+}
+
+// Add queues evt, starting the worker if it isn't running.
+func (q *historySyncQueue) Add(evt *events.HistorySync) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, evt)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *historySyncQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		evt := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		q.process(evt)
+	}
+}
+
 // handleHistorySync processes a history sync event, persisting conversations,
-// messages, and contacts into the application store.
+// messages, and contacts into the application store. Only the
+// historySyncQueue worker calls it.
 func (wc *WAClient) handleHistorySync(evt *events.HistorySync) {
 	conversations := evt.Data.GetConversations()
 	log.Printf("History sync: %d conversations", len(conversations))
 
-	for _, conv := range conversations {
-		chatJID := conv.GetID()
-		chatName := conv.GetDisplayName()
-		unread := conv.GetUnreadCount()
-		isGroup := strings.HasSuffix(chatJID, "@g.us")
+	start := time.Now()
+	for i, conv := range conversations {
+		wc.processConversation(conv)
 
-		var lastMsgBody *string
-		var lastMsgTs *int64
+		if (i+1)%historySyncChunkSize == 0 && i+1 < len(conversations) {
+			log.Printf("History sync: processed %d/%d conversations", i+1, len(conversations))
+			time.Sleep(historySyncChunkPause)
+		}
+	}
+	if len(conversations) >= largeHistorySyncThreshold {
+		log.Printf("History sync: finished %d conversations in %s", len(conversations), time.Since(start).Round(time.Millisecond))
+	}
+}
 
-		historyMessages := conv.GetMessages()
-		for _, hsMsg := range historyMessages {
-			webMsg := hsMsg.GetMessage()
-			if webMsg == nil {
-				continue
-			}
+// processConversation persists a single history sync conversation: its
+// messages, the chat summary, unread count, and (for direct chats) the contact.
+func (wc *WAClient) processConversation(conv *waHistorySync.Conversation) {
+	chatJID := conv.GetID()
+	if chatJID == types.StatusBroadcastJID.String() && !wc.storeStatusUpdates {
+		return
+	}
+	chatName := conv.GetDisplayName()
+	unread := conv.GetUnreadCount()
+	isGroup := strings.HasSuffix(chatJID, "@g.us")
+	if wc.isSelfChat(chatJID) {
+		chatName, unread = selfChatName, 0
+	}
 
-			wc.processWebMessage(webMsg, chatJID, isGroup)
+	var lastMsgBody *string
+	var lastMsgTs *int64
 
-			// Track the latest message for the chat summary
-			ts := int64(webMsg.GetMessageTimestamp())
-			if lastMsgTs == nil || ts > *lastMsgTs {
-				e2eMsg := webMsg.GetMessage()
-				body := extractMessageBody(e2eMsg)
-				if body != "" {
-					lastMsgBody = &body
-				}
-				lastMsgTs = &ts
+	// Write the conversation's messages in one transaction rather than one
+	// commit per message; fall back to individual writes if that fails.
+	var upsert messageUpserter = wc.store
+	batch, err := wc.store.BeginMessageBatch()
+	if err != nil {
+		log.Printf("Error starting message batch for %s: %v", chatJID, err)
+	} else {
+		defer batch.Rollback()
+		upsert = batch
+	}
+
+	historyMessages := conv.GetMessages()
+	for _, hsMsg := range historyMessages {
+		webMsg := hsMsg.GetMessage()
+		if webMsg == nil {
+			continue
+		}
+
+		wc.processWebMessage(upsert, webMsg, chatJID, isGroup)
+
+		// Track the latest message for the chat summary
+		ts := int64(webMsg.GetMessageTimestamp())
+		if lastMsgTs == nil || ts > *lastMsgTs {
+			e2eMsg := webMsg.GetMessage()
+			body := extractMessageBody(e2eMsg)
+			if body != "" {
+				lastMsgBody = &body
 			}
+			lastMsgTs = &ts
 		}
+	}
+	if batch != nil {
+		if err := batch.Commit(); err != nil {
+			log.Printf("Error committing messages for %s: %v", chatJID, err)
+		}
+	}
+
+	if err := wc.store.UpsertChat(chatJID, chatName, isGroup, lastMsgBody, lastMsgTs); err != nil {
+		log.Printf("Error upserting chat %s: %v", chatJID, err)
+	}
 
-		if err := wc.store.UpsertChat(chatJID, chatName, isGroup, lastMsgBody, lastMsgTs); err != nil {
-			log.Printf("Error upserting chat %s: %v", chatJID, err)
+	if err := wc.store.SetUnread(chatJID, int(unread)); err != nil {
+		log.Printf("Error setting unread for %s: %v", chatJID, err)
+	}
+	if conv.Archived != nil {
+		if err := wc.store.SetChatArchived(chatJID, conv.GetArchived()); err != nil {
+			log.Printf("Error setting archived of %s: %v", chatJID, err)
 		}
+	}
+	if isGroup {
+		chatType := groupChatType(conv.GetIsParentGroup(), conv.GetIsDefaultSubgroup())
+		if err := wc.store.SetChatType(chatJID, chatType); err != nil {
+			log.Printf("Error setting type of %s: %v", chatJID, err)
+		}
+	}
+
+	// Broadcast lists carry their recipients as participants; keep them so
+	// messages to the list can be delivered (see handleSend)
+	if isBroadcastList(chatJID) && len(conv.GetParticipant()) > 0 {
+		members := make([]string, 0, len(conv.GetParticipant()))
+		for _, p := range conv.GetParticipant() {
+			members = append(members, p.GetUserJID())
+		}
+		if err := wc.store.SetBroadcastListMembers(chatJID, members); err != nil {
+			log.Printf("Error storing broadcast list members for %s: %v", chatJID, err)
+		}
+	}
 
-		if err := wc.store.SetUnread(chatJID, int(unread)); err != nil {
-			log.Printf("Error setting unread for %s: %v", chatJID, err)
+	if len(historyMessages) > 0 {
+		// The phone flags the chunk that reaches the start of the chat's history
+		fully := conv.GetEndOfHistoryTransfer() &&
+			conv.GetEndOfHistoryTransferType() == waHistorySync.Conversation_COMPLETE_AND_NO_MORE_MESSAGE_REMAIN_ON_PRIMARY
+		if err := wc.store.RecordChatSync(chatJID, fully); err != nil {
+			log.Printf("Error recording sync state for %s: %v", chatJID, err)
 		}
+	}
 
-		// Upsert contact for non-group chats (always, even if name is empty)
-		if !isGroup {
-			number := extractNumber(chatJID)
-			if err := wc.store.UpsertContact(chatJID, chatName, "", number, false); err != nil {
-				log.Printf("Error upserting contact %s: %v", chatJID, err)
-			}
+	// Upsert contact for non-group chats (always, even if name is empty)
+	if !isGroup {
+		number := extractNumber(chatJID)
+		if err := wc.store.UpsertContact(chatJID, chatName, "", number, false); err != nil {
+			log.Printf("Error upserting contact %s: %v", chatJID, err)
 		}
 	}
 }
 
-// processWebMessage extracts data from a WebMessageInfo and persists it.
-func (wc *WAClient) processWebMessage(webMsg *waWeb.WebMessageInfo, chatJID string, isGroup bool) {
+// messageUpserter is satisfied by both AppStore and MessageBatch.
+type messageUpserter interface {
+	UpsertMessage(id, chatJID, senderJID, senderName string, fromMe bool, body string, timestamp int64, hasMedia bool, mediaType *string, rawProto []byte) error
+	EditMessage(id, body string, editedAt int64) (bool, error)
+	SetMessageForwarded(id string, score int) error
+	SetMessageQuote(id, quotedID, preview string) error
+	SetLiveLocation(chatJID, senderJID string, loc LiveLocation) error
+	SetDeliveryStatus(messageIDs []string, status string) (int64, error)
+}
+
+// processWebMessage extracts data from a WebMessageInfo and persists it
+// through upsert.
+func (wc *WAClient) processWebMessage(upsert messageUpserter, webMsg *waWeb.WebMessageInfo, chatJID string, isGroup bool) {
 	key := webMsg.GetKey()
 	if key == nil {
 		return
@@ -146,12 +331,24 @@ func (wc *WAClient) processWebMessage(webMsg *waWeb.WebMessageInfo, chatJID stri
 	pushName := webMsg.GetPushName()
 	e2eMsg := webMsg.GetMessage()
 
+	if targetID, content, editedAt, ok := messageEdit(e2eMsg); ok {
+		if editedAt == 0 {
+			editedAt = ts
+		}
+		ownID, _ := wc.ownJIDs()
+		senderJID := determineSenderJID(key, fromMe, ownID, chatJID, isGroup)
+		targetFormattedID := formatMessageID(fromMe, toAPIJIDString(remoteJID), targetID)
+		applyEdit(upsert, targetFormattedID, chatJID, senderJID, pushName, fromMe, content, editedAt)
+		return
+	}
+
 	body := extractMessageBody(e2eMsg)
 	mediaType := getMediaType(e2eMsg)
-	hasMedia := mediaType != nil
+	hasMedia := hasMediaContent(e2eMsg)
 
+	// Keep the proto for downloadable media and for interactive messages
 	var rawProto []byte
-	if hasMedia && e2eMsg != nil {
+	if mediaType != nil && e2eMsg != nil {
 		var err error
 		rawProto, err = proto.Marshal(e2eMsg)
 		if err != nil {
@@ -161,7 +358,8 @@ func (wc *WAClient) processWebMessage(webMsg *waWeb.WebMessageInfo, chatJID stri
 	}
 
 	// Determine sender JID
-	senderJID := determineSenderJID(key, fromMe, wc.client.Store.ID, chatJID, isGroup)
+	ownID, ownLID := wc.ownJIDs()
+	senderJID := determineSenderJID(key, fromMe, ownID, chatJID, isGroup)
 
 	// Resolve sender name for group messages
 	senderName := pushName
@@ -176,7 +374,7 @@ func (wc *WAClient) processWebMessage(webMsg *waWeb.WebMessageInfo, chatJID stri
 	// Build the formatted message ID
 	formattedID := formatMessageID(fromMe, toAPIJIDString(remoteJID), rawMsgID)
 
-	if err := wc.store.UpsertMessage(
+	if err := upsert.UpsertMessage(
 		formattedID,
 		chatJID,
 		senderJID,
@@ -190,6 +388,27 @@ func (wc *WAClient) processWebMessage(webMsg *waWeb.WebMessageInfo, chatJID stri
 	); err != nil {
 		log.Printf("Error upserting message %s: %v", formattedID, err)
 	}
+	recordForwarding(upsert, formattedID, e2eMsg)
+	recordQuote(upsert, formattedID, toAPIJIDString(remoteJID), e2eMsg, ownID, ownLID)
+	recordLiveLocation(upsert, formattedID, chatJID, senderJID, ts, e2eMsg)
+	if status := webDeliveryStatus(webMsg.GetStatus()); fromMe && status != "" {
+		if _, err := upsert.SetDeliveryStatus([]string{formattedID}, status); err != nil {
+			log.Printf("Error storing delivery status of %s: %v", formattedID, err)
+		}
+	}
+}
+
+// webDeliveryStatus maps the status history sync gives a message we sent to
+// the delivery status receipts would have recorded, or "" if there is
+// nothing past sent to record.
+func webDeliveryStatus(status waWeb.WebMessageInfo_Status) string {
+	switch status {
+	case waWeb.WebMessageInfo_DELIVERY_ACK:
+		return DeliveryStatusDelivered
+	case waWeb.WebMessageInfo_READ, waWeb.WebMessageInfo_PLAYED:
+		return DeliveryStatusRead
+	}
+	return ""
 }
 
 // determineSenderJID resolves the sender JID from a message key.
@@ -211,23 +430,114 @@ func determineSenderJID(key *waCommon.MessageKey, fromMe bool, ownID *types.JID,
 	return ""
 }
 
+// selfChatName is the name the "Message yourself" chat is listed under.
+const selfChatName = "You"
+
+// isSelfChat reports whether chatJID is the "Message yourself" chat, the
+// direct chat with our own number (or, on newer accounts, our own LID).
+// Everything in it is fromMe, so it never counts as unread.
+func isSelfChat(chatJID string, ownID *types.JID, ownLID types.JID) bool {
+	jid, err := types.ParseJID(chatJID)
+	if err != nil {
+		return false
+	}
+	return isOwnJID(jid, ownID, ownLID)
+}
+
+// isOwnJID reports whether jid is the account ownID (nil before pairing) is
+// a device of, or has ownLID as its LID.
+func isOwnJID(jid types.JID, ownID *types.JID, ownLID types.JID) bool {
+	if ownID == nil {
+		return false
+	}
+	switch jid.Server {
+	case types.DefaultUserServer:
+		return jid.User == ownID.User
+	case types.HiddenUserServer:
+		return !ownLID.IsEmpty() && jid.User == ownLID.User
+	}
+	return false
+}
+
+func (wc *WAClient) isSelfChat(chatJID string) bool {
+	ownID, ownLID := wc.ownJIDs()
+	return isSelfChat(chatJID, ownID, ownLID)
+}
+
+// ensureSelfChat adds the "Message yourself" chat to the chat list under
+// selfChatName, so it can be found even before it has any messages.
+func (wc *WAClient) ensureSelfChat() {
+	ownID, _ := wc.ownJIDs()
+	if ownID == nil {
+		return
+	}
+	chatJID := ownID.ToNonAD().String()
+	if err := wc.store.UpsertChat(chatJID, selfChatName, false, nil, nil); err != nil {
+		log.Printf("Error upserting self chat %s: %v", chatJID, err)
+	}
+}
+
 // handleReceipt processes read receipts. When the user reads messages on
 // another device (phone), WhatsApp sends a "read-self" receipt that we use
-// to clear the unread count.
+// to clear the unread count and notify /events subscribers. Receipts for
+// messages we sent are passed on to subscribers.
 func (wc *WAClient) handleReceipt(evt *events.Receipt) {
-	if evt.Type == events.ReceiptTypeReadSelf {
+	switch evt.Type {
+	case types.ReceiptTypeReadSelf:
 		chatJID := evt.Chat.String()
 		if err := wc.store.MarkRead(chatJID); err != nil {
 			log.Printf("Error marking read from receipt for %s: %v", chatJID, err)
+			return
+		}
+		wc.events.Publish(Event{Type: EventChatRead, ChatID: toAPIJID(evt.Chat)})
+
+	case types.ReceiptTypeDelivered, types.ReceiptTypeRead, types.ReceiptTypePlayed:
+		receipt := string(evt.Type)
+		status := DeliveryStatusRead // played media has been seen too
+		if evt.Type == types.ReceiptTypeDelivered {
+			receipt = "delivered"
+			status = DeliveryStatusDelivered
+		}
+		chatID := toAPIJID(evt.Chat)
+		ids := make([]string, len(evt.MessageIDs))
+		for i, id := range evt.MessageIDs {
+			ids[i] = formatMessageID(true, chatID, id)
+		}
+		if _, err := wc.store.SetDeliveryStatus(ids, status); err != nil {
+			log.Printf("Error storing %s receipt: %v", receipt, err)
 		}
+		wc.events.Publish(Event{Type: EventReceipt, ChatID: chatID, MessageIDs: ids, Receipt: receipt})
 	}
 }
 
+// publishMessage sends the stored form of a message to /events subscribers.
+func (wc *WAClient) publishMessage(chatJID, rawMsgID string) {
+	if wc.events == nil || wc.events.SubscriberCount() == 0 {
+		return
+	}
+	msg, err := wc.store.GetMessageByRawID(chatJID, rawMsgID)
+	if err != nil {
+		log.Printf("Error loading message %s for subscribers: %v", rawMsgID, err)
+		return
+	}
+	wc.events.Publish(Event{Type: EventMessage, ChatID: toAPIJIDString(chatJID), Message: msg})
+}
+
 // resolveSenderName attempts to find a better display name for a sender JID.
 // It checks the whatsmeow contact store, app DB, and group participants.
 func (wc *WAClient) resolveSenderName(senderJID types.JID, pushName string, chatJID ...string) string {
+	// Our own messages are from "You", whatever our contact card says
+	if wc.isOwnJID(senderJID) {
+		return selfChatName
+	}
+	return resolveSenderName(context.Background(), wc.wa, wc.store, senderJID, pushName, chatJID...)
+}
+
+// resolveSenderName is WAClient.resolveSenderName over any waAPI, so that
+// POST /resolve-sender can run it too.
+func resolveSenderName(ctx context.Context, api waAPI, store *AppStore, senderJID types.JID, pushName string, chatJID ...string) string {
 	// Try to get the contact name from whatsmeow's store
-	contact, err := wc.client.Store.Contacts.GetContact(context.Background(), senderJID)
+	contact, err := api.GetContact(ctx, senderJID)
 	if err == nil {
 		if contact.FullName != "" {
 			return contact.FullName
@@ -244,7 +554,7 @@ func (wc *WAClient) resolveSenderName(senderJID types.JID, pushName string, chat
 	}
 
 	// Try our app DB contacts table as fallback
-	name, err := wc.store.GetContactName(senderJID.String())
+	name, err := store.GetContactName(senderJID.String())
 	if err == nil && name != "" {
 		return name
 	}
@@ -252,11 +562,11 @@ func (wc *WAClient) resolveSenderName(senderJID types.JID, pushName string, chat
 	// For LID JIDs in group chats, try to resolve via group participant info
 	if senderJID.Server == "lid" && len(chatJID) > 0 && strings.HasSuffix(chatJID[0], "@g.us") {
 		groupJID := parseAPIJID(toAPIJIDString(chatJID[0]))
-		if info, err := wc.client.GetGroupInfo(context.Background(), groupJID); err == nil {
+		if info, err := api.GetGroupInfo(ctx, groupJID); err == nil {
 			for _, p := range info.Participants {
 				if p.LID == senderJID || p.JID == senderJID {
 					// Found the participant — look up their contact name
-					pContact, err := wc.client.Store.Contacts.GetContact(context.Background(), p.JID)
+					pContact, err := api.GetContact(ctx, p.JID)
 					if err == nil && pContact.FullName != "" {
 						return pContact.FullName
 					}
@@ -264,7 +574,7 @@ func (wc *WAClient) resolveSenderName(senderJID types.JID, pushName string, chat
 						return pContact.PushName
 					}
 					// Try app DB
-					if n, err := wc.store.GetContactName(p.JID.String()); err == nil && n != "" {
+					if n, err := store.GetContactName(p.JID.String()); err == nil && n != "" {
 						return n
 					}
 					// Fall back to phone number
@@ -278,9 +588,128 @@ func (wc *WAClient) resolveSenderName(senderJID types.JID, pushName string, chat
 	return pushName
 }
 
+// messageSource reports which of your devices sent a message, using the
+// sender's device number: 0 is the primary phone, own is this bridge's
+// session, and anything else is another linked device. It returns "" for
+// messages you did not send.
+func messageSource(info types.MessageInfo, own *types.JID) string {
+	if !info.IsFromMe {
+		return ""
+	}
+	switch {
+	case own != nil && info.Sender.Device == own.Device:
+		return MessageSourceBridge
+	case info.Sender.Device == 0:
+		return MessageSourcePhone
+	default:
+		return MessageSourceDevice
+	}
+}
+
+// messageEdit reports whether msg edits an earlier message, returning that
+// message's raw ID, its new content and the edit time in unix seconds (0 if
+// the edit does not carry one).
+func messageEdit(msg *waE2E.Message) (targetID string, content *waE2E.Message, editedAt int64, ok bool) {
+	pm := msg.GetProtocolMessage()
+	if pm.GetType() != waE2E.ProtocolMessage_MESSAGE_EDIT || pm.GetEditedMessage() == nil {
+		return "", nil, 0, false
+	}
+	return pm.GetKey().GetID(), pm.GetEditedMessage(), pm.GetTimestampMS() / 1000, true
+}
+
+// applyEdit replaces the body of message id with content's. The message keeps
+// its original timestamp so an edit never moves it within the chat. If the
+// original was never stored, the edited version is stored at the edit time.
+func applyEdit(upsert messageUpserter, id, chatJID, senderJID, senderName string, fromMe bool, content *waE2E.Message, editedAt int64) {
+	body := extractMessageBody(content)
+	found, err := upsert.EditMessage(id, body, editedAt)
+	if err != nil {
+		log.Printf("Error editing message %s: %v", id, err)
+		return
+	}
+	if found {
+		return
+	}
+	if err := upsert.UpsertMessage(id, chatJID, senderJID, senderName, fromMe, body, editedAt, false, nil, nil); err != nil {
+		log.Printf("Error upserting edited message %s: %v", id, err)
+		return
+	}
+	if _, err := upsert.EditMessage(id, body, editedAt); err != nil {
+		log.Printf("Error editing message %s: %v", id, err)
+	}
+}
+
+// recordForwarding flags the stored message id as forwarded if msg says so.
+func recordForwarding(upsert messageUpserter, id string, msg *waE2E.Message) {
+	forwarded, score := forwardingInfo(msg)
+	if !forwarded {
+		return
+	}
+	if err := upsert.SetMessageForwarded(id, score); err != nil {
+		log.Printf("Error flagging forwarded message %s: %v", id, err)
+	}
+}
+
+// quotedPreviewLength is how much of a quoted message's text is kept with
+// the reply, in bytes.
+const quotedPreviewLength = 100
+
+// recordQuote stores which message msg replies to, if it is a reply.
+// apiChatJID is the chat as it appears in the reply's own ID; the quoted
+// message is taken to be in the same chat unless the reply names another.
+func recordQuote(upsert messageUpserter, id, apiChatJID string, msg *waE2E.Message, ownID *types.JID, ownLID types.JID) {
+	ci := messageContextInfo(msg)
+	if ci.GetStanzaID() == "" {
+		return
+	}
+	if remote := ci.GetRemoteJID(); remote != "" {
+		apiChatJID = toAPIJIDString(remote)
+	}
+	// The quoted sender is us if it is our own number or LID
+	fromMe := isSelfChat(ci.GetParticipant(), ownID, ownLID)
+	quotedID := formatMessageID(fromMe, apiChatJID, ci.GetStanzaID())
+
+	quoted := ci.GetQuotedMessage()
+	preview := truncate(extractMessageBody(quoted), quotedPreviewLength)
+	if mediaType := getMediaType(quoted); preview == "" && mediaType != nil {
+		preview = "[" + *mediaType + "]"
+	}
+	if err := upsert.SetMessageQuote(id, quotedID, preview); err != nil {
+		log.Printf("Error storing quote of message %s: %v", id, err)
+	}
+}
+
+// recordLiveLocation stores msg's position for the live-location share id,
+// if msg is a live location. ts is the update's time, and the share's start
+// time when it is new.
+func recordLiveLocation(upsert messageUpserter, id, chatJID, senderJID string, ts int64, msg *waE2E.Message) {
+	live := msg.GetLiveLocationMessage()
+	if live == nil {
+		return
+	}
+	loc := LiveLocation{
+		MessageID:      id,
+		Latitude:       live.GetDegreesLatitude(),
+		Longitude:      live.GetDegreesLongitude(),
+		AccuracyMeters: int(live.GetAccuracyInMeters()),
+		SpeedMps:       float64(live.GetSpeedInMps()),
+		Heading:        int(live.GetDegreesClockwiseFromMagneticNorth()),
+		Caption:        live.GetCaption(),
+		Sequence:       live.GetSequenceNumber(),
+		StartedAt:      ts,
+		UpdatedAt:      ts,
+	}
+	if err := upsert.SetLiveLocation(chatJID, senderJID, loc); err != nil {
+		log.Printf("Error storing live location %s: %v", id, err)
+	}
+}
+
 // handleMessage processes a real-time incoming or outgoing message.
 func (wc *WAClient) handleMessage(evt *events.Message) {
 	info := evt.Info
+	if info.Chat == types.StatusBroadcastJID && !wc.storeStatusUpdates {
+		return
+	}
 	chatJID := info.Chat.String()       // internal format for DB
 	senderJID := info.Sender.String()   // internal format for DB
 	fromMe := info.IsFromMe
@@ -291,12 +720,51 @@ func (wc *WAClient) handleMessage(evt *events.Message) {
 	senderName := wc.resolveSenderName(info.Sender, info.PushName, chatJID)
 
 	e2eMsg := evt.Message
+
+	// Edits update the original message in place; they are not new messages
+	// and so leave the chat preview and unread count alone.
+	if targetID, content, editedAt, ok := messageEdit(e2eMsg); ok {
+		if editedAt == 0 {
+			editedAt = ts
+		}
+		targetFormattedID := formatMessageID(fromMe, toAPIJIDString(chatJID), targetID)
+		applyEdit(wc.store, targetFormattedID, chatJID, senderJID, senderName, fromMe, content, editedAt)
+		// The edited message may be the one the chat list previews
+		if _, err := wc.store.ReconcileChatPreviews(chatJID); err != nil {
+			log.Printf("Error reconciling preview for %s: %v", chatJID, err)
+		}
+		log.Printf("Message %s in %s edited: %s", targetFormattedID, chatJID, truncate(extractMessageBody(content), 50))
+		wc.publishMessage(chatJID, targetID)
+		return
+	}
+
+	// Pins from the phone or other members mark the pinned message
+	if pin := e2eMsg.GetPinInChatMessage(); pin != nil {
+		applyPin(wc.store, chatJID, pin)
+		return
+	}
+
+	formattedID := formatMessageID(fromMe, toAPIJIDString(chatJID), rawMsgID)
+
+	// Live-location updates carry the ID of the share they belong to, and
+	// move it rather than arriving as new messages
+	if e2eMsg.GetLiveLocationMessage() != nil {
+		known, err := wc.store.HasLiveLocation(formattedID)
+		if err != nil {
+			log.Printf("Error looking up live location %s: %v", formattedID, err)
+		}
+		if known {
+			recordLiveLocation(wc.store, formattedID, chatJID, senderJID, ts, e2eMsg)
+			return
+		}
+	}
 	body := extractMessageBody(e2eMsg)
 	mediaType := getMediaType(e2eMsg)
-	hasMedia := mediaType != nil
+	hasMedia := hasMediaContent(e2eMsg)
 
+	// Keep the proto for downloadable media and for interactive messages
 	var rawProto []byte
-	if hasMedia && e2eMsg != nil {
+	if mediaType != nil && e2eMsg != nil {
 		var err error
 		rawProto, err = proto.Marshal(e2eMsg)
 		if err != nil {
@@ -305,8 +773,6 @@ func (wc *WAClient) handleMessage(evt *events.Message) {
 		}
 	}
 
-	formattedID := formatMessageID(fromMe, toAPIJIDString(chatJID), rawMsgID)
-
 	if err := wc.store.UpsertMessage(
 		formattedID,
 		chatJID,
@@ -321,13 +787,41 @@ func (wc *WAClient) handleMessage(evt *events.Message) {
 	); err != nil {
 		log.Printf("Error upserting message %s: %v", formattedID, err)
 	}
+	recordForwarding(wc.store, formattedID, e2eMsg)
+	ownID, ownLID := wc.ownJIDs()
+	recordQuote(wc.store, formattedID, toAPIJIDString(chatJID), e2eMsg, ownID, ownLID)
+	recordLiveLocation(wc.store, formattedID, chatJID, senderJID, ts, e2eMsg)
+	wc.autoDownload.Enqueue(formattedID, e2eMsg)
+	if source := messageSource(info, ownID); source != "" {
+		if err := wc.store.SetMessageSource(formattedID, source); err != nil {
+			log.Printf("Error storing source for message %s: %v", formattedID, err)
+		}
+	}
+
+	// A new message brings a trashed chat back, as WhatsApp does for deleted chats
+	if restored, err := wc.store.RestoreChat(chatJID); err != nil {
+		log.Printf("Error restoring chat %s: %v", chatJID, err)
+	} else if restored {
+		log.Printf("Restored trashed chat %s on new message", chatJID)
+	}
 
 	// Ensure the chat exists
 	isGroup := strings.HasSuffix(chatJID, "@g.us")
+	selfChat := wc.isSelfChat(chatJID)
+	chatName := ""
+	if selfChat {
+		chatName = selfChatName
+	}
 	bodyPreview := truncate(body, 100)
-	if err := wc.store.UpsertChat(chatJID, "", isGroup, &bodyPreview, &ts); err != nil {
+	if err := wc.store.UpsertChat(chatJID, chatName, isGroup, &bodyPreview, &ts); err != nil {
 		log.Printf("Error upserting chat %s: %v", chatJID, err)
 	}
+	// Someone writing to an archived chat brings it back, as on the phone
+	if !fromMe {
+		if err := wc.store.SetChatArchived(chatJID, false); err != nil {
+			log.Printf("Error unarchiving %s: %v", chatJID, err)
+		}
+	}
 
 	// Update the chat last message
 	if body != "" {
@@ -336,14 +830,180 @@ func (wc *WAClient) handleMessage(evt *events.Message) {
 		}
 	}
 
-	// Increment unread for incoming messages
-	if !fromMe {
+	// A muted chat stores incoming messages quietly: no unread, no
+	// notifications. Our own messages still reach other clients.
+	muted, err := wc.store.IsChatMuted(chatJID, time.Now().Unix())
+	if err != nil {
+		log.Printf("Error checking mute of %s: %v", chatJID, err)
+	}
+
+	// Increment unread for incoming messages; our own, from any device, and
+	// notes to self never count
+	if !fromMe && !selfChat && !muted && !wc.isOwnJID(info.Sender) {
 		if err := wc.store.IncrementUnread(chatJID); err != nil {
 			log.Printf("Error incrementing unread for %s: %v", chatJID, err)
 		}
 	}
 
 	log.Printf("Message %s in %s: %s", formattedID, chatJID, truncate(body, 50))
+	if muted && !fromMe {
+		return
+	}
+	wc.publishMessage(chatJID, rawMsgID)
+	if !fromMe {
+		wc.webhook.Deliver(WebhookPayload{
+			MessageID:  formattedID,
+			ChatID:     toAPIJIDString(chatJID),
+			Sender:     toAPIJIDString(senderJID),
+			SenderName: senderName,
+			Body:       body,
+			Timestamp:  ts,
+			HasMedia:   hasMedia,
+			MediaType:  mediaType,
+		})
+	}
+}
+
+// applyPin pins or unpins the stored message pin refers to in chatJID.
+// WhatsApp pins expire after a chosen time; the stored pin does not.
+func applyPin(store *AppStore, chatJID string, pin *waE2E.PinInChatMessage) {
+	target, err := store.GetMessageByRawID(chatJID, pin.GetKey().GetID())
+	if err != nil {
+		log.Printf("Error finding pinned message %s in %s: %v", pin.GetKey().GetID(), chatJID, err)
+		return
+	}
+	pinned := pin.GetType() == waE2E.PinInChatMessage_PIN_FOR_ALL
+	if err := store.SetMessagePinned(target.ID, pinned); err != nil {
+		log.Printf("Error pinning message %s: %v", target.ID, err)
+	}
+}
+
+// handleJoinedGroup lists a group we were added to, or created elsewhere,
+// straight away, and clears its left state if we are back in it.
+func (wc *WAClient) handleJoinedGroup(evt *events.JoinedGroup) {
+	chatJID := evt.JID.String()
+	if err := wc.store.UpsertChat(chatJID, evt.Name, true, nil, nil); err != nil {
+		log.Printf("Error storing joined group %s: %v", chatJID, err)
+		return
+	}
+	if err := wc.store.SetChatType(chatJID, groupChatType(evt.IsParent, evt.IsDefaultSubGroup)); err != nil {
+		log.Printf("Error setting type of %s: %v", chatJID, err)
+	}
+	if err := wc.store.SetGroupLeft(chatJID, false); err != nil {
+		log.Printf("Error clearing left state of %s: %v", chatJID, err)
+	}
+}
+
+// handleArchive mirrors a chat archived or unarchived on another device.
+func (wc *WAClient) handleArchive(evt *events.Archive) {
+	chatJID := evt.JID.String()
+	archived := evt.Action.GetArchived()
+	if err := wc.store.SetChatArchived(chatJID, archived); err != nil {
+		log.Printf("Error setting archived of %s: %v", chatJID, err)
+	}
+}
+
+// handleMute mirrors a chat muted or unmuted on another device.
+func (wc *WAClient) handleMute(evt *events.Mute) {
+	chatJID := evt.JID.String()
+	if err := wc.store.SetChatMutedUntil(chatJID, muteEnd(evt.Action)); err != nil {
+		log.Printf("Error setting mute of %s: %v", chatJID, err)
+	}
+}
+
+// muteEnd converts a mute action to a muted_until: 0 when unmuted, else the
+// unix second the mute ends or MutedForever. WhatsApp gives the end in
+// milliseconds, and -1 for a mute with no end.
+func muteEnd(action *waSyncAction.MuteAction) int64 {
+	if !action.GetMuted() {
+		return 0
+	}
+	end := action.GetMuteEndTimestamp()
+	if end <= 0 {
+		return MutedForever
+	}
+	return end / 1000
+}
+
+// handleGroupRename renames a group's chat as soon as its subject changes,
+// rather than at the next group refresh. whatsmeow delivers subject changes
+// as group notifications, not as messages in the chat.
+func (wc *WAClient) handleGroupRename(evt *events.GroupInfo) {
+	if evt.Name == nil || evt.Name.Name == "" {
+		return
+	}
+	chatJID := evt.JID.String()
+	if err := wc.store.UpsertChat(chatJID, evt.Name.Name, true, nil, nil); err != nil {
+		log.Printf("Error renaming group %s: %v", chatJID, err)
+		return
+	}
+	log.Printf("Group %s renamed to %q", chatJID, evt.Name.Name)
+}
+
+// handleGroupMembership marks a group left when we leave it on another
+// device or are removed from it, and no longer left when we are added back.
+func (wc *WAClient) handleGroupMembership(evt *events.GroupInfo) {
+	chatJID := evt.JID.String()
+	for _, jid := range evt.Leave {
+		if wc.isOwnJID(jid) {
+			if err := wc.store.SetGroupLeft(chatJID, true); err != nil {
+				log.Printf("Error marking %s as left: %v", chatJID, err)
+			}
+			return
+		}
+	}
+	for _, jid := range evt.Join {
+		if wc.isOwnJID(jid) {
+			if err := wc.store.SetGroupLeft(chatJID, false); err != nil {
+				log.Printf("Error clearing left state of %s: %v", chatJID, err)
+			}
+			return
+		}
+	}
+}
+
+// handleChatRemovedOnPhone mirrors a chat deleted (chatDeleted) or cleared on
+// another device, unless WAPP_MIRROR_PHONE_DELETES is off. Only messages up
+// to the action's time go: an app-state full sync replays old deletions, and
+// a chat that has had messages since keeps them, and so isn't deleted. An
+// action without a time is ignored, as there is no telling which messages
+// it covered.
+func (wc *WAClient) handleChatRemovedOnPhone(jid types.JID, at time.Time, chatDeleted bool) {
+	if !wc.mirrorPhoneDeletes {
+		return
+	}
+	chatJID := jid.String()
+	action := "cleared"
+	if chatDeleted {
+		action = "deleted"
+	}
+	if at.IsZero() {
+		log.Printf("Chat %s %s on phone at no stated time, keeping its messages", chatJID, action)
+		return
+	}
+
+	deleted, remaining, err := wc.store.DeleteMessagesUpTo(chatJID, at.Unix())
+	if err != nil {
+		log.Printf("Error mirroring chat %s %s on phone: %v", chatJID, action, err)
+		return
+	}
+
+	switch {
+	case remaining > 0:
+		if _, err := wc.store.ReconcileChatPreviews(chatJID); err != nil {
+			log.Printf("Error reconciling preview for %s: %v", chatJID, err)
+		}
+	case chatDeleted:
+		err = wc.store.DeleteChat(chatJID)
+	default:
+		// Nothing left: also resets the preview, unread count and sync state
+		_, err = wc.store.ClearMessages(chatJID)
+	}
+	if err != nil {
+		log.Printf("Error mirroring chat %s %s on phone: %v", chatJID, action, err)
+		return
+	}
+	log.Printf("Chat %s %s on phone: removed %d messages, kept %d newer or starred", chatJID, action, deleted, remaining)
 }
 
 // handlePushName updates the push name for a contact.
@@ -358,6 +1018,37 @@ func (wc *WAClient) handlePushName(evt *events.PushName) {
 		log.Printf("Error updating push name for %s: %v", jid, err)
 	}
 	log.Printf("Push name updated: %s -> %s", jid, name)
+	wc.events.Publish(Event{Type: EventPushName, ContactID: toAPIJID(evt.JID), Name: name})
+}
+
+// updateBusinessName stores a business's new verified name and renames its
+// messages. The name only shows where no saved contact name takes
+// precedence, as resolveSenderName decides.
+func updateBusinessName(ctx context.Context, api waAPI, store *AppStore, evt *events.BusinessName) {
+	if evt.NewBusinessName == "" {
+		return
+	}
+	// The business may be known both by LID and by number
+	jids := []types.JID{evt.JID.ToNonAD()}
+	if evt.Message != nil && !evt.Message.SenderAlt.IsEmpty() {
+		jids = append(jids, evt.Message.SenderAlt.ToNonAD())
+	}
+	for _, jid := range jids {
+		if err := store.SetBusinessName(jid.String(), evt.NewBusinessName); err != nil {
+			log.Printf("Error storing business name of %s: %v", jid, err)
+			continue
+		}
+		name := resolveSenderName(ctx, api, store, jid, "")
+		if name == "" {
+			continue
+		}
+		if n, err := store.SetSenderName(jid.String(), "", name); err != nil {
+			log.Printf("Error renaming messages of %s: %v", jid, err)
+		} else if n > 0 {
+			log.Printf("Renamed %d messages of %s to %q", n, jid, name)
+		}
+	}
+	log.Printf("Business name of %s changed: %q -> %q", evt.JID, evt.OldBusinessName, evt.NewBusinessName)
 }
 
 // populateContacts reads whatsmeow's internal contact store and upserts into our DB.
@@ -384,6 +1075,11 @@ func (wc *WAClient) populateContacts() {
 		if err := wc.store.UpsertContact(jid.String(), name, pushName, number, false); err != nil {
 			log.Printf("Error upserting contact %s: %v", jid, err)
 		}
+		if info.BusinessName != "" {
+			if err := wc.store.SetBusinessName(jid.String(), info.BusinessName); err != nil {
+				log.Printf("Error storing business name of %s: %v", jid, err)
+			}
+		}
 		count++
 	}
 	log.Printf("Populated %d contacts from whatsmeow store", count)
@@ -420,89 +1116,44 @@ func (wc *WAClient) populateGroupNames() {
 	log.Printf("Populated %d group names", count)
 }
 
-// backfillGroupSenderNames resolves LID sender names in group messages.
-// Runs once on connect to fix existing messages with empty sender names.
-func (wc *WAClient) backfillGroupSenderNames() {
-	// Find distinct LID senders with empty names in group chats
-	rows, err := wc.store.db.Query(`
-		SELECT DISTINCT m.sender_jid, m.chat_jid
-		FROM messages m
-		WHERE m.sender_jid LIKE '%@lid'
-			AND (m.sender_name = '' OR m.sender_name IS NULL)
-			AND m.chat_jid LIKE '%@g.us'
-		LIMIT 100
-	`)
-	if err != nil {
-		log.Printf("backfillGroupSenderNames: query error: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	type lidChat struct{ lid, chat string }
-	var pairs []lidChat
-	for rows.Next() {
-		var lid, chat string
-		rows.Scan(&lid, &chat)
-		pairs = append(pairs, lidChat{lid, chat})
-	}
-
-	if len(pairs) == 0 {
-		return
-	}
-
-	// Cache group info to avoid redundant lookups
-	groupCache := map[string]map[string]string{} // chatJID -> lidJID -> name
-	updated := 0
-
-	for _, p := range pairs {
-		if _, ok := groupCache[p.chat]; !ok {
-			groupJID := parseAPIJID(toAPIJIDString(p.chat))
-			info, err := wc.client.GetGroupInfo(context.Background(), groupJID)
-			if err != nil {
-				groupCache[p.chat] = map[string]string{}
-				continue
-			}
-			m := map[string]string{}
-			for _, participant := range info.Participants {
-				lidStr := participant.LID.String()
-				// Try to resolve name
-				name := ""
-				if c, err := wc.client.Store.Contacts.GetContact(context.Background(), participant.JID); err == nil {
-					if c.FullName != "" {
-						name = c.FullName
-					} else if c.PushName != "" {
-						name = c.PushName
-					}
-				}
-				if name == "" {
-					if n, err := wc.store.GetContactName(participant.JID.String()); err == nil && n != "" {
-						name = n
-					}
-				}
-				if name == "" {
-					name = participant.JID.User // phone number as last resort
-				}
-				m[lidStr] = name
+// backfillGroupSenderNames names the LID senders of group messages stored
+// without a name from the groups' stored rosters; see
+// backfillGroupSenderNames. It runs on connect for every group, and for one
+// group (groupJID) when its participants become known or change. info is
+// that group's info if already fetched; if not, it is fetched, as the
+// stored roster is out of date. Either way it is stored. Runs are serialized.
+func (wc *WAClient) backfillGroupSenderNames(groupJID string, info *types.GroupInfo) {
+	wc.senderBackfillMu.Lock()
+	defer wc.senderBackfillMu.Unlock()
+
+	ctx := context.Background()
+	if groupJID != "" {
+		if info == nil {
+			var err error
+			if info, err = wc.wa.GetGroupInfo(ctx, parseAPIJID(toAPIJIDString(groupJID))); err != nil {
+				log.Printf("Error fetching group info for %s: %v", groupJID, err)
+				return
 			}
-			groupCache[p.chat] = m
 		}
-
-		if name, ok := groupCache[p.chat][p.lid]; ok && name != "" {
-			wc.store.db.Exec(`UPDATE messages SET sender_name = ? WHERE sender_jid = ? AND chat_jid = ? AND (sender_name = '' OR sender_name IS NULL)`,
-				name, p.lid, p.chat)
-			updated++
+		if err := storeGroupInfo(wc.store, groupJID, info); err != nil {
+			log.Printf("Error storing group info for %s: %v", groupJID, err)
 		}
 	}
-	if updated > 0 {
-		log.Printf("Backfilled %d group sender names from %d groups", updated, len(groupCache))
+	n, err := backfillGroupSenderNames(ctx, wc.wa, wc.store, groupJID, info, groupRefreshSpacing)
+	if err != nil {
+		log.Printf("Error backfilling group sender names: %v", err)
+	}
+	if n > 0 {
+		log.Printf("Backfilled %d group sender names", n)
 	}
 }
 
-// syncRecentChats requests recent messages for the top chats on connect.
+// syncRecentChats requests recent messages for the top chats on connect, as
+// many as wc.connectSync says.
 // This backfills messages that were missed while the bridge was offline.
 func (wc *WAClient) syncRecentChats() {
 	// Wait a moment for the connection to stabilize
-	time.Sleep(2 * time.Second)
+	time.Sleep(wc.syncDelays.Startup)
 
 	chats, err := wc.store.GetChats()
 	if err != nil {
@@ -510,12 +1161,9 @@ func (wc *WAClient) syncRecentChats() {
 		return
 	}
 
-	// Sync the 5 most recent chats (already sorted by last_msg_ts desc).
+	// Sync the most recent chats (already sorted by last_msg_ts desc).
 	// On-demand sync is best-effort — phone often ignores requests (whatsmeow #654).
-	limit := 5
-	if len(chats) < limit {
-		limit = len(chats)
-	}
+	limit := min(wc.connectSync.Chats, len(chats))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -523,13 +1171,13 @@ func (wc *WAClient) syncRecentChats() {
 	synced := 0
 	for i := 0; i < limit; i++ {
 		internalJID := toInternalJID(chats[i].ID)
-		if err := wc.RequestRecentMessages(ctx, internalJID, 50); err != nil {
+		if err := wc.RequestRecentMessages(ctx, internalJID, wc.connectSync.Count); err != nil {
 			log.Printf("syncRecentChats: error requesting %s: %v", chats[i].ID, err)
 			continue
 		}
 		synced++
 		// Small delay between requests to avoid rate limiting
-		time.Sleep(200 * time.Millisecond)
+		time.Sleep(wc.syncDelays.Request)
 	}
 	log.Printf("syncRecentChats: requested recent messages for %d chats", synced)
 }
@@ -0,0 +1,796 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	waCommon "go.mau.fi/whatsmeow/proto/waCommon"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	waHistorySync "go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
+	waWeb "go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandleReceipt_ReadSelfClearsUnreadAndPublishes(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster()}
+	chatJID := "10000000001@s.whatsapp.net"
+	wc.store.UpsertChat(chatJID, "Alice", false, nil, nil)
+	wc.store.IncrementUnread(chatJID)
+
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+
+	wc.handleReceipt(&events.Receipt{
+		MessageSource: types.MessageSource{Chat: types.NewJID("10000000001", types.DefaultUserServer)},
+		Type:          events.ReceiptTypeReadSelf,
+	})
+
+	chats, _ := wc.store.GetChats()
+	if chats[0].UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0", chats[0].UnreadCount)
+	}
+	select {
+	case evt := <-ch:
+		if evt.Type != EventChatRead || evt.ChatID != "10000000001@c.us" {
+			t.Errorf("event = %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("chatRead event not published")
+	}
+}
+
+func TestHandleReceipt_PublishesDelivery(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster()}
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	wc.store.UpsertMessage("true_10000000001@c.us_M1", alice.String(), "19999999999@s.whatsapp.net", "", true, "hi", 100, false, nil, nil)
+	for _, typ := range []types.ReceiptType{types.ReceiptTypeDelivered, types.ReceiptTypeRead, types.ReceiptTypeRetry} {
+		wc.handleReceipt(&events.Receipt{
+			MessageSource: types.MessageSource{Chat: alice, Sender: alice},
+			MessageIDs:    []types.MessageID{"M1", "M2"},
+			Type:          typ,
+		})
+	}
+
+	for _, want := range []string{"delivered", "read"} {
+		select {
+		case evt := <-ch:
+			if evt.Type != EventReceipt || evt.Receipt != want || evt.ChatID != "10000000001@c.us" ||
+				len(evt.MessageIDs) != 2 || evt.MessageIDs[0] != "true_10000000001@c.us_M1" {
+				t.Errorf("event = %+v, want %s receipt", evt, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s receipt not published", want)
+		}
+	}
+	select {
+	case evt := <-ch:
+		t.Errorf("unexpected event %+v for a retry receipt", evt)
+	default:
+	}
+	if msgs, _ := wc.store.GetMessages(alice.String(), 10, 0); len(msgs) != 1 || msgs[0].Status != DeliveryStatusRead {
+		t.Errorf("stored messages = %+v, want M1 read", msgs)
+	}
+}
+
+func TestPublishMessage(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster()}
+	chatJID := "10000000001@s.whatsapp.net"
+	wc.store.UpsertMessage("false_10000000001@c.us_M1", chatJID, chatJID, "Alice", false, "hi", 100, false, nil, nil)
+
+	// Nobody listening: nothing to do
+	wc.publishMessage(chatJID, "M1")
+
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+	wc.publishMessage(chatJID, "M1")
+	select {
+	case evt := <-ch:
+		if evt.Type != EventMessage || evt.ChatID != "10000000001@c.us" || evt.Message == nil || evt.Message.Body != "hi" {
+			t.Errorf("event = %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message event not published")
+	}
+}
+
+func TestIsSelfChat(t *testing.T) {
+	ownID := types.NewADJID("10000000001", 0, 5)
+	ownLID := types.NewJID("200000000000001", types.HiddenUserServer)
+
+	tests := []struct {
+		name    string
+		chatJID string
+		ownID   *types.JID
+		ownLID  types.JID
+		want    bool
+	}{
+		{"own number", "10000000001@s.whatsapp.net", &ownID, ownLID, true},
+		{"own LID", "200000000000001@lid", &ownID, ownLID, true},
+		{"other contact", "10000000002@s.whatsapp.net", &ownID, ownLID, false},
+		{"group with same digits", "10000000001@g.us", &ownID, ownLID, false},
+		{"LID unknown", "200000000000001@lid", &ownID, types.EmptyJID, false},
+		{"not paired", "10000000001@s.whatsapp.net", nil, types.EmptyJID, false},
+		{"malformed", "not a jid@", &ownID, ownLID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelfChat(tt.chatJID, tt.ownID, tt.ownLID); got != tt.want {
+				t.Errorf("isSelfChat(%q) = %v, want %v", tt.chatJID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOwnJID(t *testing.T) {
+	ownID := types.NewADJID("10000000001", 0, 5)
+	ownLID := types.NewJID("200000000000001", types.HiddenUserServer)
+	wc := &WAClient{ownID: &ownID, ownLID: ownLID}
+
+	for jid, want := range map[types.JID]bool{
+		types.NewADJID("10000000001", 0, 0): true,
+		ownLID:                              true,
+		types.NewJID("10000000002", types.DefaultUserServer):    false,
+		types.NewJID("10000000001", types.GroupServer):          false,
+		types.NewJID("200000000000002", types.HiddenUserServer): false,
+	} {
+		if got := wc.isOwnJID(jid); got != want {
+			t.Errorf("isOwnJID(%s) = %v, want %v", jid, got, want)
+		}
+	}
+	if (&WAClient{}).isOwnJID(ownID) {
+		t.Error("isOwnJID before pairing = true, want false")
+	}
+}
+
+func TestHandleMessage_FromMe(t *testing.T) {
+	own := types.NewADJID("10000000001", 0, 5)
+	alice := types.NewJID("10000000002", types.DefaultUserServer)
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster(), ownID: &own}
+
+	for i, id := range []string{"OWN1", "OWN2"} {
+		wc.handleMessage(&events.Message{
+			Info: types.MessageInfo{
+				MessageSource: types.MessageSource{Chat: alice, Sender: own, IsFromMe: true},
+				ID:            id,
+				Timestamp:     time.Unix(int64(100+i), 0),
+			},
+			Message: &waE2E.Message{Conversation: proto.String("hi from my phone")},
+		})
+	}
+
+	chats, err := wc.store.GetChats()
+	if err != nil || len(chats) != 1 {
+		t.Fatalf("chats = %v, %v; want one", chats, err)
+	}
+	if chats[0].UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0 for our own messages", chats[0].UnreadCount)
+	}
+	msg, err := wc.store.GetMessageByRawID(alice.String(), "OWN1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.SenderName == nil || *msg.SenderName != selfChatName {
+		t.Errorf("sender name = %v, want %q", msg.SenderName, selfChatName)
+	}
+}
+
+func TestMessageSource(t *testing.T) {
+	own := types.JID{User: "10000000099", Server: types.DefaultUserServer, Device: 7}
+	tests := []struct {
+		name   string
+		fromMe bool
+		device uint16
+		own    *types.JID
+		want   string
+	}{
+		{"incoming", false, 0, &own, ""},
+		{"bridge", true, 7, &own, MessageSourceBridge},
+		{"phone", true, 0, &own, MessageSourcePhone},
+		{"other device", true, 3, &own, MessageSourceDevice},
+		{"not logged in", true, 7, nil, MessageSourceDevice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := types.MessageInfo{MessageSource: types.MessageSource{
+				IsFromMe: tt.fromMe,
+				Sender:   types.JID{User: "10000000099", Server: types.DefaultUserServer, Device: tt.device},
+			}}
+			if got := messageSource(info, tt.own); got != tt.want {
+				t.Errorf("messageSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageEdit(t *testing.T) {
+	edit := &waE2E.Message{ProtocolMessage: &waE2E.ProtocolMessage{
+		Type:          waE2E.ProtocolMessage_MESSAGE_EDIT.Enum(),
+		Key:           &waCommon.MessageKey{ID: proto.String("ORIG")},
+		EditedMessage: &waE2E.Message{Conversation: proto.String("fixed")},
+		TimestampMS:   proto.Int64(500_000),
+	}}
+	targetID, content, editedAt, ok := messageEdit(edit)
+	if !ok || targetID != "ORIG" || extractMessageBody(content) != "fixed" || editedAt != 500 {
+		t.Errorf("messageEdit() = %q, %v, %d, %v", targetID, content, editedAt, ok)
+	}
+
+	revoke := &waE2E.Message{ProtocolMessage: &waE2E.ProtocolMessage{Type: waE2E.ProtocolMessage_REVOKE.Enum()}}
+	for _, msg := range []*waE2E.Message{nil, {Conversation: proto.String("hi")}, revoke} {
+		if _, _, _, ok := messageEdit(msg); ok {
+			t.Errorf("messageEdit(%v) reported an edit", msg)
+		}
+	}
+}
+
+func TestApplyEdit(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	id := "false_10000000001@c.us_ORIG"
+	store.UpsertMessage(id, chatJID, chatJID, "Alice", false, "tpyo", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_NEXT", chatJID, chatJID, "Alice", false, "next", 200, false, nil, nil)
+
+	content := func(body string) *waE2E.Message { return &waE2E.Message{Conversation: proto.String(body)} }
+	applyEdit(store, id, chatJID, chatJID, "Alice", false, content("typo"), 300)
+	applyEdit(store, id, chatJID, chatJID, "Alice", false, content("stale"), 250) // delivered late
+	// An edit of a message that was never stored
+	applyEdit(store, "false_10000000001@c.us_GONE", chatJID, chatJID, "Alice", false, content("new text"), 400)
+
+	msgs, err := store.GetMessages(chatJID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	// Newest first: the inserted edit, then NEXT, then the original, which
+	// keeps its timestamp and so stays behind NEXT.
+	orig := msgs[2]
+	if orig.ID != id || orig.Body != "typo" || orig.Timestamp != 100 || orig.EditedAt == nil || *orig.EditedAt != 300 {
+		t.Errorf("edited message = %+v", orig)
+	}
+	if msgs[1].EditedAt != nil {
+		t.Errorf("unedited message has editedAt %d", *msgs[1].EditedAt)
+	}
+	gone := msgs[0]
+	if gone.Body != "new text" || gone.Timestamp != 400 || gone.EditedAt == nil || *gone.EditedAt != 400 {
+		t.Errorf("inserted edit = %+v", gone)
+	}
+}
+
+func TestRecordForwarding(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	fwdID := "false_10000000001@c.us_FWD"
+	plainID := "false_10000000001@c.us_PLAIN"
+	store.UpsertMessage(fwdID, chatJID, chatJID, "Alice", false, "look at this", 100, false, nil, nil)
+	store.UpsertMessage(plainID, chatJID, chatJID, "Alice", false, "hi", 200, false, nil, nil)
+
+	recordForwarding(store, fwdID, &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+		Text:        proto.String("look at this"),
+		ContextInfo: &waE2E.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(5)},
+	}})
+	recordForwarding(store, plainID, &waE2E.Message{Conversation: proto.String("hi")})
+
+	msgs, err := store.GetMessages(chatJID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if msgs[1].ID != fwdID || !msgs[1].Forwarded || msgs[1].ForwardingScore != 5 {
+		t.Errorf("forwarded message = %+v", msgs[1])
+	}
+	if msgs[0].Forwarded || msgs[0].ForwardingScore != 0 {
+		t.Errorf("plain message = %+v", msgs[0])
+	}
+}
+
+func TestRecordQuote(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	ownID := types.NewJID("10000000009", types.DefaultUserServer)
+	store.UpsertMessage("false_10000000001@c.us_R1", chatJID, chatJID, "Alice", false, "agreed", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_R2", chatJID, chatJID, "Alice", false, "nice", 200, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_PLAIN", chatJID, chatJID, "Alice", false, "hi", 300, false, nil, nil)
+
+	// A reply to one of our messages
+	recordQuote(store, "false_10000000001@c.us_R1", "10000000001@c.us", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+		Text: proto.String("agreed"),
+		ContextInfo: &waE2E.ContextInfo{
+			StanzaID:      proto.String("MINE"),
+			Participant:   proto.String("10000000009@s.whatsapp.net"),
+			QuotedMessage: &waE2E.Message{Conversation: proto.String("shall we meet at " + strings.Repeat("x", 200))},
+		},
+	}}, &ownID, types.EmptyJID)
+	// A reply to their photo
+	recordQuote(store, "false_10000000001@c.us_R2", "10000000001@c.us", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+		Text: proto.String("nice"),
+		ContextInfo: &waE2E.ContextInfo{
+			StanzaID:      proto.String("PHOTO"),
+			Participant:   proto.String(chatJID),
+			QuotedMessage: &waE2E.Message{ImageMessage: &waE2E.ImageMessage{}},
+		},
+	}}, &ownID, types.EmptyJID)
+	recordQuote(store, "false_10000000001@c.us_PLAIN", "10000000001@c.us", &waE2E.Message{Conversation: proto.String("hi")}, &ownID, types.EmptyJID)
+
+	msgs, err := store.GetMessages(chatJID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if m := msgs[2]; m.QuotedMessageID != "true_10000000001@c.us_MINE" || !strings.HasPrefix(m.QuotedPreview, "shall we meet at") || len(m.QuotedPreview) != quotedPreviewLength+3 {
+		t.Errorf("reply to own message = %+v", m)
+	}
+	if m := msgs[1]; m.QuotedMessageID != "false_10000000001@c.us_PHOTO" || m.QuotedPreview != "[image]" {
+		t.Errorf("reply to photo = %+v", m)
+	}
+	if m := msgs[0]; m.QuotedMessageID != "" || m.QuotedPreview != "" {
+		t.Errorf("plain message = %+v", m)
+	}
+}
+
+func TestRecordLiveLocation(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	id := "false_10000000001@c.us_LIVE"
+	live := func(lat, lng float64, seq int64, caption string) *waE2E.Message {
+		return &waE2E.Message{LiveLocationMessage: &waE2E.LiveLocationMessage{
+			DegreesLatitude:  proto.Float64(lat),
+			DegreesLongitude: proto.Float64(lng),
+			SequenceNumber:   proto.Int64(seq),
+			Caption:          proto.String(caption),
+		}}
+	}
+
+	recordLiveLocation(store, id, chatJID, chatJID, 1000, live(51.50, -0.12, 1, "On my way"))
+	recordLiveLocation(store, id, chatJID, chatJID, 1060, live(51.51, -0.11, 3, ""))
+	recordLiveLocation(store, id, chatJID, chatJID, 1030, live(51.49, -0.13, 2, "")) // delivered late
+	// Not a live location: ignored
+	recordLiveLocation(store, id, chatJID, chatJID, 1090, &waE2E.Message{Conversation: proto.String("hi")})
+
+	loc, err := store.GetLiveLocation(id)
+	if err != nil {
+		t.Fatalf("GetLiveLocation: %v", err)
+	}
+	if loc.Latitude != 51.51 || loc.Longitude != -0.11 || loc.Sequence != 3 || loc.Caption != "On my way" ||
+		loc.StartedAt != 1000 || loc.UpdatedAt != 1060 || loc.ChatID != "10000000001@c.us" {
+		t.Errorf("live location = %+v", loc)
+	}
+
+	if known, err := store.HasLiveLocation(id); err != nil || !known {
+		t.Errorf("HasLiveLocation = %v, %v; want true", known, err)
+	}
+
+	// The share goes with its message
+	if _, err := store.ClearMessages(chatJID); err != nil {
+		t.Fatal(err)
+	}
+	if known, _ := store.HasLiveLocation(id); known {
+		t.Error("live location outlived its cleared message")
+	}
+}
+
+func TestHandleMessage_LiveLocationShares(t *testing.T) {
+	own := types.NewJID("10000000009", types.DefaultUserServer)
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster(), ownID: &own}
+	share := func(id string, ts, seq int64, lat float64) {
+		wc.handleMessage(&events.Message{
+			Info: types.MessageInfo{
+				MessageSource: types.MessageSource{Chat: alice, Sender: own, IsFromMe: true},
+				ID:            id,
+				Timestamp:     time.Unix(ts, 0),
+			},
+			Message: &waE2E.Message{LiveLocationMessage: &waE2E.LiveLocationMessage{
+				DegreesLatitude: proto.Float64(lat), SequenceNumber: proto.Int64(seq),
+			}},
+		})
+	}
+
+	share("LIVE1", 100, 1, 51.50)
+	share("LIVE1", 160, 2, 51.51) // update of the first share
+	share("LIVE2", 200, 1, 48.85) // a second, separate share soon after
+
+	msgs, _ := wc.store.GetMessages(alice.String(), 10, 0)
+	if len(msgs) != 2 {
+		t.Fatalf("stored %d messages, want one per share", len(msgs))
+	}
+	first, _ := wc.store.GetLiveLocation("true_10000000001@c.us_LIVE1")
+	second, _ := wc.store.GetLiveLocation("true_10000000001@c.us_LIVE2")
+	if first == nil || first.Latitude != 51.51 || first.StartedAt != 100 {
+		t.Errorf("first share = %+v", first)
+	}
+	if second == nil || second.Latitude != 48.85 {
+		t.Errorf("second share = %+v", second)
+	}
+
+	if err := wc.store.DeleteChat(alice.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.store.GetLiveLocation("true_10000000001@c.us_LIVE1"); err == nil {
+		t.Error("live location outlived its deleted chat")
+	}
+}
+
+func TestDispatchHistorySync_SmallSyncDuringLargeDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	processed := make(chan int, 2)
+	wc := &WAClient{historySyncs: newHistorySyncQueue(func(evt *events.HistorySync) {
+		n := len(evt.Data.GetConversations())
+		if n >= largeHistorySyncThreshold {
+			<-release
+		}
+		processed <- n
+	})}
+
+	large := make([]*waHistorySync.Conversation, largeHistorySyncThreshold)
+	for i := range large {
+		large[i] = &waHistorySync.Conversation{ID: proto.String("10000000001@s.whatsapp.net")}
+	}
+	small := []*waHistorySync.Conversation{{ID: proto.String("10000000002@s.whatsapp.net")}}
+
+	done := make(chan struct{})
+	go func() {
+		wc.dispatchHistorySync(&events.HistorySync{Data: &waHistorySync.HistorySync{Conversations: large}})
+		wc.dispatchHistorySync(&events.HistorySync{Data: &waHistorySync.HistorySync{Conversations: small}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatching a small sync blocked behind the large one")
+	}
+
+	// Both run, in arrival order, once the large one can finish
+	close(release)
+	for _, want := range []int{largeHistorySyncThreshold, 1} {
+		select {
+		case n := <-processed:
+			if n != want {
+				t.Errorf("processed sync of %d conversations, want %d", n, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("sync of %d conversations never processed", want)
+		}
+	}
+}
+
+func TestHandleChatRemovedOnPhone(t *testing.T) {
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	setup := func(mirror bool) *WAClient {
+		wc := &WAClient{store: newTestStore(t), mirrorPhoneDeletes: mirror}
+		preview, ts := "later", int64(300)
+		wc.store.UpsertChat(alice.String(), "Alice", false, &preview, &ts)
+		wc.store.UpsertMessage("false_10000000001@c.us_M1", alice.String(), alice.String(), "", false, "early", 100, false, nil, nil)
+		wc.store.UpsertMessage("false_10000000001@c.us_M2", alice.String(), alice.String(), "", false, "later", 300, false, nil, nil)
+		return wc
+	}
+	chatExists := func(wc *WAClient) bool {
+		chats, _ := wc.store.GetChats()
+		return len(chats) == 1
+	}
+
+	tests := []struct {
+		name         string
+		mirror       bool
+		at           int64 // 0: no time given
+		chatDeleted  bool
+		wantChat     bool
+		wantMessages int
+	}{
+		{"delete", true, 400, true, false, 0},
+		{"replayed delete keeps newer messages", true, 200, true, true, 1},
+		{"clear", true, 400, false, true, 0},
+		{"mirroring off", false, 400, true, true, 2},
+		{"no time keeps everything", true, 0, true, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := setup(tt.mirror)
+			var at time.Time
+			if tt.at != 0 {
+				at = time.Unix(tt.at, 0)
+			}
+			wc.handleChatRemovedOnPhone(alice, at, tt.chatDeleted)
+
+			if got := chatExists(wc); got != tt.wantChat {
+				t.Errorf("chat exists = %v, want %v", got, tt.wantChat)
+			}
+			if n, _ := wc.store.GetMessageCount(alice.String()); n != tt.wantMessages {
+				t.Errorf("messages = %d, want %d", n, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestHandleMessage_StatusUpdates(t *testing.T) {
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	evt := &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: types.StatusBroadcastJID, Sender: alice},
+			ID:            "STATUS1",
+			Timestamp:     time.Unix(100, 0),
+		},
+		Message: &waE2E.Message{Conversation: proto.String("my story")},
+	}
+
+	// Dropped by default, before any lookups
+	wc := &WAClient{store: newTestStore(t)}
+	wc.handleMessage(evt)
+	if n, _ := wc.store.GetMessageCount(types.StatusBroadcastJID.String()); n != 0 {
+		t.Errorf("stored %d status updates, want none", n)
+	}
+}
+
+func TestHandleGroupRename(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t)}
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	wc.store.UpsertChat(group.String(), "Old name", true, nil, nil)
+
+	// Other group changes leave the name alone
+	wc.handleGroupRename(&events.GroupInfo{JID: group, Topic: &types.GroupTopic{Topic: "about"}})
+	if detail, _ := wc.store.GetContactDetail(group.String()); detail == nil || detail.Name != "Old name" {
+		t.Errorf("detail after topic change = %+v, want Old name", detail)
+	}
+
+	wc.handleGroupRename(&events.GroupInfo{JID: group, Name: &types.GroupName{Name: "New name"}})
+	if detail, _ := wc.store.GetContactDetail(group.String()); detail == nil || detail.Name != "New name" {
+		t.Errorf("detail after rename = %+v, want New name", detail)
+	}
+}
+
+func TestHandleJoinedGroup(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t)}
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	wc.store.UpsertChat(group.String(), "Announcements", true, nil, nil)
+	wc.store.SetGroupLeft(group.String(), true)
+
+	wc.handleJoinedGroup(&events.JoinedGroup{GroupInfo: types.GroupInfo{
+		JID:               group,
+		GroupName:         types.GroupName{Name: "Announcements"},
+		GroupIsDefaultSub: types.GroupIsDefaultSub{IsDefaultSubGroup: true},
+	}})
+	chats, _ := wc.store.GetChats()
+	if len(chats) != 1 || chats[0].Type != ChatTypeAnnouncement || chats[0].LeftAt != nil {
+		t.Errorf("chats = %+v, want the announcement group, no longer left", chats)
+	}
+}
+
+func TestHandleGroupMembership(t *testing.T) {
+	own := types.NewADJID("10000000001", 0, 5)
+	ownLID := types.NewJID("200000000000001", types.HiddenUserServer)
+	wc := &WAClient{store: newTestStore(t), ownID: &own, ownLID: ownLID}
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	wc.store.UpsertChat(group.String(), "Team", true, nil, nil)
+	leftAt := func() *int64 {
+		chats, _ := wc.store.GetChats()
+		return chats[0].LeftAt
+	}
+
+	wc.handleGroupMembership(&events.GroupInfo{JID: group, Leave: []types.JID{types.NewJID("10000000002", types.DefaultUserServer)}})
+	if leftAt() != nil {
+		t.Error("someone else leaving marked the group left")
+	}
+	wc.handleGroupMembership(&events.GroupInfo{JID: group, Leave: []types.JID{ownLID}})
+	if leftAt() == nil {
+		t.Error("leaving on another device did not mark the group left")
+	}
+	wc.handleGroupMembership(&events.GroupInfo{JID: group, Join: []types.JID{own.ToNonAD()}})
+	if leftAt() != nil {
+		t.Error("being added back left the group marked left")
+	}
+}
+
+func TestHandleArchive(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t)}
+	chat := types.NewJID("10000000001", types.DefaultUserServer)
+	wc.store.UpsertChat(chat.String(), "Alice", false, nil, nil)
+
+	wc.handleArchive(&events.Archive{JID: chat, Action: &waSyncAction.ArchiveChatAction{Archived: proto.Bool(true)}})
+	if chats, _ := wc.store.GetChats(); len(chats) != 1 || !chats[0].Archived {
+		t.Errorf("after archive on phone: chats = %+v", chats)
+	}
+	wc.handleArchive(&events.Archive{JID: chat, Action: &waSyncAction.ArchiveChatAction{Archived: proto.Bool(false)}})
+	if chats, _ := wc.store.GetChats(); len(chats) != 1 || chats[0].Archived {
+		t.Errorf("after unarchive on phone: chats = %+v", chats)
+	}
+}
+
+func TestArchived_HistoryAndIncomingMessage(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster(), wa: newFakeWA()}
+	alice := types.NewJID("10000000002", types.DefaultUserServer)
+	wc.processConversation(&waHistorySync.Conversation{ID: proto.String(alice.String()), Archived: proto.Bool(true)})
+	if chats, _ := wc.store.GetChats(); len(chats) != 1 || !chats[0].Archived {
+		t.Fatalf("after history sync: chats = %+v, want archived", chats)
+	}
+
+	wc.handleMessage(&events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: alice, Sender: alice},
+			ID:            "BACK",
+			Timestamp:     time.Unix(100, 0),
+		},
+		Message: &waE2E.Message{Conversation: proto.String("are you there?")},
+	})
+	if chats, _ := wc.store.GetChats(); len(chats) != 1 || chats[0].Archived {
+		t.Errorf("after an incoming message: chats = %+v, want unarchived", chats)
+	}
+}
+
+func TestProcessConversation_DeliveryStatus(t *testing.T) {
+	own := types.NewADJID("10000000001", 0, 5)
+	wc := &WAClient{store: newTestStore(t), ownID: &own}
+	alice := "10000000002@s.whatsapp.net"
+	sent := func(id string, status waWeb.WebMessageInfo_Status) *waHistorySync.HistorySyncMsg {
+		return &waHistorySync.HistorySyncMsg{Message: &waWeb.WebMessageInfo{
+			Key:              &waCommon.MessageKey{RemoteJID: proto.String(alice), FromMe: proto.Bool(true), ID: proto.String(id)},
+			Message:          &waE2E.Message{Conversation: proto.String("hi")},
+			MessageTimestamp: proto.Uint64(100),
+			Status:           status.Enum(),
+		}}
+	}
+	wc.processConversation(&waHistorySync.Conversation{ID: proto.String(alice), Messages: []*waHistorySync.HistorySyncMsg{
+		sent("ACKED", waWeb.WebMessageInfo_SERVER_ACK),
+		sent("DELIVERED", waWeb.WebMessageInfo_DELIVERY_ACK),
+		sent("READ", waWeb.WebMessageInfo_READ),
+		sent("PLAYED", waWeb.WebMessageInfo_PLAYED),
+	}})
+
+	for id, want := range map[string]string{
+		"ACKED":     DeliveryStatusSent,
+		"DELIVERED": DeliveryStatusDelivered,
+		"READ":      DeliveryStatusRead,
+		"PLAYED":    DeliveryStatusRead,
+	} {
+		msg, err := wc.store.GetMessageByRawID(alice, id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if msg.Status != want {
+			t.Errorf("%s: status = %q, want %q", id, msg.Status, want)
+		}
+	}
+}
+
+func TestApplyPin(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "120363000000000001@g.us"
+	store.UpsertMessage("true_120363000000000001@g.us_MINE", chatJID, "10000000001@s.whatsapp.net", "", true, "agenda", 100, false, nil, nil)
+
+	pin := func(typ waE2E.PinInChatMessage_Type) bool {
+		// Pinned by another member, so the key is not fromMe for them
+		applyPin(store, chatJID, &waE2E.PinInChatMessage{
+			Key:  &waCommon.MessageKey{RemoteJID: proto.String(chatJID), ID: proto.String("MINE"), FromMe: proto.Bool(false)},
+			Type: typ.Enum(),
+		})
+		msg, _ := store.GetMessageByRawID(chatJID, "MINE")
+		return msg.Pinned
+	}
+	if !pin(waE2E.PinInChatMessage_PIN_FOR_ALL) {
+		t.Error("message not pinned")
+	}
+	if pin(waE2E.PinInChatMessage_UNPIN_FOR_ALL) {
+		t.Error("message still pinned after unpin")
+	}
+}
+
+func TestHandleMute(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t)}
+	chat := types.NewJID("10000000001", types.DefaultUserServer)
+	wc.store.UpsertChat(chat.String(), "Alice", false, nil, nil)
+	end := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		action *waSyncAction.MuteAction
+		want   int64
+	}{
+		{"muted for an hour", &waSyncAction.MuteAction{Muted: proto.Bool(true), MuteEndTimestamp: proto.Int64(end * 1000)}, end},
+		{"muted forever", &waSyncAction.MuteAction{Muted: proto.Bool(true), MuteEndTimestamp: proto.Int64(-1)}, MutedForever},
+		{"unmuted", &waSyncAction.MuteAction{Muted: proto.Bool(false)}, 0},
+	}
+	for _, tt := range tests {
+		wc.handleMute(&events.Mute{JID: chat, Action: tt.action})
+		if chats, _ := wc.store.GetChats(); len(chats) != 1 || chats[0].MutedUntil != tt.want {
+			t.Errorf("%s: chats = %+v, want mutedUntil %d", tt.name, chats, tt.want)
+		}
+	}
+}
+
+func TestHandleMessage_Muted(t *testing.T) {
+	own := types.NewADJID("10000000001", 0, 5)
+	alice := types.NewJID("10000000002", types.DefaultUserServer)
+	wc := &WAClient{store: newTestStore(t), events: NewBroadcaster(), ownID: &own, wa: newFakeWA()}
+	wc.store.UpsertChat(alice.String(), "Alice", false, nil, nil)
+	wc.store.SetChatMutedUntil(alice.String(), MutedForever)
+
+	ch, unsubscribe := wc.events.Subscribe()
+	defer unsubscribe()
+	wc.handleMessage(&events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: alice, Sender: alice},
+			ID:            "QUIET",
+			Timestamp:     time.Unix(100, 0),
+			PushName:      "Alice",
+		},
+		Message: &waE2E.Message{Conversation: proto.String("shh")},
+	})
+
+	if _, err := wc.store.GetMessageByRawID(alice.String(), "QUIET"); err != nil {
+		t.Errorf("message in muted chat not stored: %v", err)
+	}
+	if chats, _ := wc.store.GetChats(); len(chats) != 1 || chats[0].UnreadCount != 0 {
+		t.Errorf("chats = %+v, want one with no unread", chats)
+	}
+	select {
+	case evt := <-ch:
+		t.Errorf("published %+v for a muted chat", evt)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Our own messages in a muted chat are still published
+	wc.handleMessage(&events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: alice, Sender: own, IsFromMe: true},
+			ID:            "REPLY",
+			Timestamp:     time.Unix(101, 0),
+		},
+		Message: &waE2E.Message{Conversation: proto.String("ok")},
+	})
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Error("own message in a muted chat not published")
+	}
+}
+
+func TestUpdateBusinessName(t *testing.T) {
+	store := newTestStore(t)
+	fake := newFakeWA()
+	shop := types.NewJID("10000000001", types.DefaultUserServer)
+	saved := types.NewJID("10000000002", types.DefaultUserServer)
+	store.UpsertChat(shop.String(), "", false, nil, nil)
+	store.UpsertMessage("false_120363000000000001@g.us_M1", "120363000000000001@g.us", shop.String(), "Old Shop", false, "hi", 100, false, nil, nil)
+	store.UpsertMessage("false_120363000000000001@g.us_M2", "120363000000000001@g.us", saved.String(), "Bob", false, "hi", 101, false, nil, nil)
+
+	// whatsmeow's contact store has the new name by the time the event fires
+	fake.contacts[shop] = types.ContactInfo{Found: true, BusinessName: "New Shop"}
+	fake.contacts[saved] = types.ContactInfo{Found: true, FullName: "Bob", BusinessName: "Bob's Bikes"}
+	// One through the event handler, which uses the client's waAPI
+	wc := &WAClient{store: store, wa: fake}
+	wc.handleEvent(&events.BusinessName{JID: shop, OldBusinessName: "Old Shop", NewBusinessName: "New Shop"})
+	updateBusinessName(context.Background(), fake, store, &events.BusinessName{JID: saved, NewBusinessName: "Bob's Bikes"})
+
+	contacts, err := store.GetContacts()
+	if err != nil || len(contacts) != 1 {
+		t.Fatalf("GetContacts = %+v, %v", contacts, err)
+	}
+	if c := contacts[0]; c.Name != "New Shop" || c.BusinessName != "New Shop" || !c.Verified {
+		t.Errorf("contact = %+v, want verified New Shop", c)
+	}
+	msgs, _ := store.GetMessages("120363000000000001@g.us", 10, 0)
+	names := map[string]string{}
+	for _, m := range msgs {
+		if m.SenderName != nil {
+			names[m.ID] = *m.SenderName
+		}
+	}
+	if names["false_120363000000000001@g.us_M1"] != "New Shop" {
+		t.Errorf("business sender name = %q, want New Shop", names["false_120363000000000001@g.us_M1"])
+	}
+	// A saved contact name still wins over the business name
+	if names["false_120363000000000001@g.us_M2"] != "Bob" {
+		t.Errorf("saved sender name = %q, want Bob", names["false_120363000000000001@g.us_M2"])
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// vcardEscaper escapes text values per RFC 6350 section 3.4.
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+// writeVCards writes contacts as vCard 3.0 entries, the version most
+// address books import.
+func writeVCards(w io.Writer, contacts []Contact) error {
+	for _, c := range contacts {
+		name := vcardEscaper.Replace(c.Name)
+		_, err := fmt.Fprintf(w, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:%s\r\nN:;%s;;;\r\nTEL;TYPE=CELL:%s\r\nEND:VCARD\r\n",
+			name, name, internationalNumber(c.Number))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeContactsCSV writes contacts as CSV with a name,number,whatsapp_id header.
+func writeContactsCSV(w io.Writer, contacts []Contact) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "number", "whatsapp_id"})
+	for _, c := range contacts {
+		cw.Write([]string{c.Name, internationalNumber(c.Number), c.ID})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// internationalNumber prefixes a bare digit string with "+".
+func internationalNumber(number string) string {
+	if number == "" || strings.HasPrefix(number, "+") {
+		return number
+	}
+	return "+" + number
+}
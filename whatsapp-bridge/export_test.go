@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteVCards(t *testing.T) {
+	var b strings.Builder
+	err := writeVCards(&b, []Contact{
+		{ID: "10000000001@c.us", Name: "Smith, Alice; Jr", Number: "10000000001"},
+	})
+	if err != nil {
+		t.Fatalf("writeVCards: %v", err)
+	}
+	want := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Smith\\, Alice\\; Jr\r\nN:;Smith\\, Alice\\; Jr;;;\r\n" +
+		"TEL;TYPE=CELL:+10000000001\r\nEND:VCARD\r\n"
+	if b.String() != want {
+		t.Errorf("vcard =\n%q\nwant\n%q", b.String(), want)
+	}
+}
+
+func TestWriteContactsCSV(t *testing.T) {
+	var b strings.Builder
+	err := writeContactsCSV(&b, []Contact{
+		{ID: "10000000001@c.us", Name: "Alice", Number: "10000000001"},
+		{ID: "10000000002@c.us", Name: `Bob "B", Jr`, Number: "+10000000002"},
+	})
+	if err != nil {
+		t.Fatalf("writeContactsCSV: %v", err)
+	}
+	want := "name,number,whatsapp_id\n" +
+		"Alice,+10000000001,10000000001@c.us\n" +
+		"\"Bob \"\"B\"\", Jr\",+10000000002,10000000002@c.us\n"
+	if b.String() != want {
+		t.Errorf("csv =\n%s\nwant\n%s", b.String(), want)
+	}
+}
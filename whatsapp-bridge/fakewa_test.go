@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// fakeWA is a waAPI that records calls instead of talking to WhatsApp.
+type fakeWA struct {
+	ownJID *types.JID
+
+	sent       []fakeSent
+	sendErr    error
+	sendBlocks bool // SendMessage waits for ctx cancellation
+	onWhatsApp map[string]types.JID
+	markedRead []types.MessageID
+	readErr    error // returned by MarkRead
+	userInfo   map[types.JID]types.UserInfo
+	userErr    error // returned by GetUserInfo
+	groupInfo  map[types.JID]*types.GroupInfo
+	contacts   map[types.JID]types.ContactInfo
+	avatars    map[types.JID]string // JID -> picture URL
+	avatarHits int                  // GetProfilePictureInfo calls
+	blocked    []types.JID
+	created    []whatsmeow.ReqCreateGroup
+	changes    []fakeParticipantChange
+	changeErrs map[types.JID]int // participant -> WhatsApp error code
+	left       []types.JID
+	appState   []appstate.PatchInfo
+}
+
+type fakeParticipantChange struct {
+	group        types.JID
+	participants []types.JID
+	action       whatsmeow.ParticipantChange
+}
+
+type fakeSent struct {
+	to  types.JID
+	msg *waE2E.Message
+}
+
+func newFakeWA() *fakeWA {
+	own := types.NewJID("19999999999", types.DefaultUserServer)
+	return &fakeWA{
+		ownJID:     &own,
+		onWhatsApp: map[string]types.JID{},
+		userInfo:   map[types.JID]types.UserInfo{},
+		groupInfo:  map[types.JID]*types.GroupInfo{},
+		contacts:   map[types.JID]types.ContactInfo{},
+		avatars:    map[types.JID]string{},
+		changeErrs: map[types.JID]int{},
+	}
+}
+
+func (f *fakeWA) SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if f.sendBlocks {
+		<-ctx.Done()
+		return whatsmeow.SendResponse{}, ctx.Err()
+	}
+	if f.sendErr != nil {
+		return whatsmeow.SendResponse{}, f.sendErr
+	}
+	f.sent = append(f.sent, fakeSent{to: to, msg: message})
+	return whatsmeow.SendResponse{ID: "FAKEID", Timestamp: time.Unix(1700000000, 0)}, nil
+}
+
+func (f *fakeWA) Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	return whatsmeow.UploadResponse{URL: "https://example.invalid/media", DirectPath: "/media", FileLength: uint64(len(plaintext))}, nil
+}
+
+func (f *fakeWA) IsOnWhatsApp(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	resp := make([]types.IsOnWhatsAppResponse, 0, len(phones))
+	for _, p := range phones {
+		jid, ok := f.onWhatsApp[p]
+		resp = append(resp, types.IsOnWhatsAppResponse{Query: p, JID: jid, IsIn: ok})
+	}
+	return resp, nil
+}
+
+func (f *fakeWA) MarkRead(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	if f.readErr != nil {
+		return f.readErr
+	}
+	f.markedRead = append(f.markedRead, ids...)
+	return nil
+}
+
+func (f *fakeWA) DownloadAny(ctx context.Context, msg *waE2E.Message) ([]byte, error) {
+	return []byte("media"), nil
+}
+
+func (f *fakeWA) GetUserInfo(ctx context.Context, jids []types.JID) (map[types.JID]types.UserInfo, error) {
+	if f.userErr != nil {
+		return nil, f.userErr
+	}
+	resp := make(map[types.JID]types.UserInfo, len(jids))
+	for _, jid := range jids {
+		if info, ok := f.userInfo[jid]; ok {
+			resp[jid] = info
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeWA) GetGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error) {
+	info, ok := f.groupInfo[jid]
+	if !ok {
+		return nil, whatsmeow.ErrGroupNotFound
+	}
+	return info, nil
+}
+
+func (f *fakeWA) GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	f.avatarHits++
+	url, ok := f.avatars[jid]
+	if !ok {
+		return nil, whatsmeow.ErrProfilePictureNotSet
+	}
+	return &types.ProfilePictureInfo{URL: url, ID: "pic-" + jid.User}, nil
+}
+
+func (f *fakeWA) GetBlocklist(ctx context.Context) (*types.Blocklist, error) {
+	return &types.Blocklist{JIDs: f.blocked}, nil
+}
+
+func (f *fakeWA) CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error) {
+	f.created = append(f.created, req)
+	jid := types.NewJID("120363000000000099", types.GroupServer)
+	info := &types.GroupInfo{JID: jid, GroupName: types.GroupName{Name: req.Name}}
+	for _, p := range append([]types.JID{*f.ownJID}, req.Participants...) {
+		info.Participants = append(info.Participants, types.GroupParticipant{JID: p, IsSuperAdmin: p == *f.ownJID})
+	}
+	f.groupInfo[jid] = info
+	return info, nil
+}
+
+func (f *fakeWA) SendAppState(ctx context.Context, patch appstate.PatchInfo) error {
+	f.appState = append(f.appState, patch)
+	return nil
+}
+
+func (f *fakeWA) LeaveGroup(ctx context.Context, jid types.JID) error {
+	if _, ok := f.groupInfo[jid]; !ok {
+		return whatsmeow.ErrGroupNotFound
+	}
+	f.left = append(f.left, jid)
+	return nil
+}
+
+func (f *fakeWA) UpdateGroupParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error) {
+	f.changes = append(f.changes, fakeParticipantChange{group: jid, participants: participantChanges, action: action})
+	resp := make([]types.GroupParticipant, 0, len(participantChanges))
+	for _, p := range participantChanges {
+		resp = append(resp, types.GroupParticipant{JID: p, Error: f.changeErrs[p]})
+	}
+	return resp, nil
+}
+
+func (f *fakeWA) GetContact(ctx context.Context, jid types.JID) (types.ContactInfo, error) {
+	return f.contacts[jid], nil
+}
+
+func (f *fakeWA) BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message {
+	// The real BuildEdit only builds a proto; it needs no connected client
+	return (*whatsmeow.Client)(nil).BuildEdit(chat, id, newContent)
+}
+
+func (f *fakeWA) BuildRevoke(chat, sender types.JID, id types.MessageID) *waE2E.Message {
+	return (*whatsmeow.Client)(nil).BuildRevoke(chat, sender, id)
+}
+
+func (f *fakeWA) OwnJID() *types.JID {
+	return f.ownJID
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// groupRefreshSpacing is the pause between GetGroupInfo calls during a
+// refresh, to stay clear of WhatsApp's rate limits.
+const groupRefreshSpacing = 2 * time.Second
+
+// refreshGroups fetches the name, participants and description of each group
+// in jids (internal format) and stores them, pausing spacing between calls. It
+// returns how many groups were refreshed. A group whose info can't be
+// fetched, such as one we have left, is logged and skipped.
+func refreshGroups(ctx context.Context, api waAPI, store *AppStore, jids []string, spacing time.Duration) (int, error) {
+	refreshed := 0
+	for i, s := range jids {
+		if i > 0 && spacing > 0 {
+			select {
+			case <-ctx.Done():
+				return refreshed, ctx.Err()
+			case <-time.After(spacing):
+			}
+		}
+		jid, err := types.ParseJID(s)
+		if err != nil || jid.Server != types.GroupServer {
+			log.Printf("Skipping group refresh for %s: not a group JID", s)
+			continue
+		}
+		info, err := api.GetGroupInfo(ctx, jid)
+		if err != nil {
+			if ctx.Err() != nil {
+				return refreshed, err
+			}
+			log.Printf("Error fetching group info for %s: %v", s, err)
+			// Stamp it anyway, or a group we can't fetch, say one we were
+			// removed from while offline, heads every batch from now on
+			if err := store.MarkGroupRefreshed(s); err != nil {
+				return refreshed, err
+			}
+			continue
+		}
+
+		if err := storeGroupInfo(store, s, info); err != nil {
+			return refreshed, err
+		}
+		if _, err := backfillGroupSenderNames(ctx, api, store, s, info, 0); err != nil {
+			log.Printf("Error backfilling sender names in %s: %v", s, err)
+		}
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+// storeGroupInfo stores the name, type, roster and description of group
+// groupJID from info.
+func storeGroupInfo(store *AppStore, groupJID string, info *types.GroupInfo) error {
+	participants := make([]GroupParticipant, 0, len(info.Participants))
+	for _, p := range info.Participants {
+		gp := GroupParticipant{ID: p.JID.String(), Admin: p.IsAdmin || p.IsSuperAdmin}
+		if !p.LID.IsEmpty() {
+			gp.LID = p.LID.String()
+		}
+		if !p.PhoneNumber.IsEmpty() {
+			gp.Phone = p.PhoneNumber.String()
+		}
+		participants = append(participants, gp)
+	}
+	chatType := groupChatType(info.IsParent, info.IsDefaultSubGroup)
+	if err := store.SetGroupInfo(groupJID, info.Name, chatType, participants); err != nil {
+		return err
+	}
+	return store.SetContactAbout(groupJID, info.Topic)
+}
+
+// groupSenderBatch is how many unnamed group senders
+// backfillGroupSenderNames reads at a time.
+const groupSenderBatch = 100
+
+// backfillGroupSenderNames names the LID senders of group messages stored
+// without a sender name, paging through all of them; a non-empty groupJID
+// limits it to that group, and info, if given, is its already-fetched info.
+// Named messages drop out of the query, and the paging cursor keeps senders
+// that can't be named from being read twice, so a run ends once every
+// sender has been tried; those are tried again on the next run. Senders are
+// named from each group's stored roster; only groups with none are fetched
+// from WhatsApp, spacing apart, and their roster stored. It returns how many
+// messages were named.
+func backfillGroupSenderNames(ctx context.Context, api waAPI, store *AppStore, groupJID string, info *types.GroupInfo, spacing time.Duration) (int, error) {
+	names := map[string]map[string]string{} // group -> LID -> name
+	if groupJID != "" && info != nil {
+		names[groupJID] = participantNames(ctx, api, store, info.Participants)
+	}
+
+	named := 0
+	fetched := false
+	var after groupSender
+	for {
+		senders, err := store.GetUnnamedGroupSenders(groupJID, after, groupSenderBatch)
+		if err != nil {
+			return named, err
+		}
+		for _, gs := range senders {
+			byLID, ok := names[gs.ChatJID]
+			if !ok {
+				wait := time.Duration(0)
+				if fetched {
+					wait = spacing
+				}
+				var didFetch bool
+				byLID, didFetch, err = rosterNames(ctx, api, store, gs.ChatJID, wait)
+				if err != nil {
+					return named, err
+				}
+				fetched = fetched || didFetch
+				names[gs.ChatJID] = byLID
+			}
+			name := byLID[gs.SenderJID]
+			if name == "" {
+				continue
+			}
+			n, err := store.FillSenderName(gs.SenderJID, gs.ChatJID, name)
+			if err != nil {
+				return named, err
+			}
+			named += int(n)
+		}
+		if len(senders) < groupSenderBatch {
+			return named, nil
+		}
+		after = senders[len(senders)-1]
+	}
+}
+
+// rosterNames returns participantNames for group groupJID's stored roster.
+// A group with none stored is fetched from WhatsApp after waiting wait, and
+// its info stored; fetched reports whether it was. A group that can't be
+// fetched, such as one we have left, gets no names.
+func rosterNames(ctx context.Context, api waAPI, store *AppStore, groupJID string, wait time.Duration) (names map[string]string, fetched bool, err error) {
+	roster, err := store.GetGroupRoster(groupJID)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(roster) > 0 {
+		return participantNames(ctx, api, store, rosterParticipants(roster)), false, nil
+	}
+
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	info, err := api.GetGroupInfo(ctx, parseAPIJID(toAPIJIDString(groupJID)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, true, err
+		}
+		log.Printf("Error fetching group info for %s: %v", groupJID, err)
+		return nil, true, nil
+	}
+	if err := storeGroupInfo(store, groupJID, info); err != nil {
+		log.Printf("Error storing group info for %s: %v", groupJID, err)
+	}
+	return participantNames(ctx, api, store, info.Participants), true, nil
+}
+
+// rosterParticipants converts a stored roster back to WhatsApp's form.
+func rosterParticipants(roster []GroupParticipant) []types.GroupParticipant {
+	parse := func(s string) types.JID {
+		jid, _ := types.ParseJID(s)
+		return jid
+	}
+	participants := make([]types.GroupParticipant, 0, len(roster))
+	for _, p := range roster {
+		var lid, phone types.JID
+		if p.LID != "" {
+			lid = parse(p.LID)
+		}
+		if p.Phone != "" {
+			phone = parse(p.Phone)
+		}
+		participants = append(participants, types.GroupParticipant{
+			JID:         parse(p.ID),
+			LID:         lid,
+			PhoneNumber: phone,
+			IsAdmin:     p.Admin,
+		})
+	}
+	return participants
+}
+
+// participantNames maps the LID of each of participants to a display name:
+// their contact name or push name, or failing those their number.
+// Participants whose number is hidden and who have no name are left out.
+func participantNames(ctx context.Context, api waAPI, store *AppStore, participants []types.GroupParticipant) map[string]string {
+	names := make(map[string]string, len(participants))
+	for _, p := range participants {
+		lid, phone := p.LID, p.PhoneNumber
+		if lid.IsEmpty() && p.JID.Server == types.HiddenUserServer {
+			lid = p.JID
+		}
+		if phone.IsEmpty() && p.JID.Server == types.DefaultUserServer {
+			phone = p.JID
+		}
+		if lid.IsEmpty() {
+			continue
+		}
+
+		name := ""
+		for _, jid := range []types.JID{phone, lid} {
+			if name != "" || jid.IsEmpty() {
+				continue
+			}
+			if c, err := api.GetContact(ctx, jid); err == nil {
+				if c.FullName != "" {
+					name = c.FullName
+				} else if c.PushName != "" {
+					name = c.PushName
+				}
+			}
+			if name == "" {
+				if n, err := store.GetContactName(jid.String()); err == nil {
+					name = n
+				}
+			}
+		}
+		if name == "" {
+			name = phone.User // phone number as last resort
+		}
+		if name != "" {
+			names[lid.String()] = name
+		}
+	}
+	return names
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestRefreshGroups(t *testing.T) {
+	store := newTestStore(t)
+	fake := newFakeWA()
+	renamed := types.NewJID("120363000000000001", types.GroupServer)
+	left := types.NewJID("120363000000000002", types.GroupServer)
+	store.UpsertChat(renamed.String(), "Old name", true, nil, nil)
+	store.UpsertChat(left.String(), "Left group", true, nil, nil)
+	store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	stale, err := store.GetStaleGroupJIDs(time.Now().Unix(), 10)
+	if err != nil || len(stale) != 2 {
+		t.Fatalf("stale before refresh = %v, %v; want both groups", stale, err)
+	}
+
+	fake.groupInfo[renamed] = &types.GroupInfo{
+		GroupName:   types.GroupName{Name: "New name"},
+		GroupTopic:  types.GroupTopic{Topic: "Weekend plans"},
+		GroupParent: types.GroupParent{IsParent: true},
+		Participants: []types.GroupParticipant{
+			{JID: types.NewJID("10000000001", types.DefaultUserServer), IsSuperAdmin: true},
+			{JID: types.NewJID("10000000002", types.DefaultUserServer)},
+		},
+	}
+	n, err := refreshGroups(context.Background(), fake, store, stale, 0)
+	if err != nil {
+		t.Fatalf("refreshGroups: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("refreshed %d, want 1", n)
+	}
+
+	detail, err := store.GetContactDetail(renamed.String())
+	if err != nil {
+		t.Fatalf("GetContactDetail: %v", err)
+	}
+	if detail.Name != "New name" || detail.GroupRefreshedAt == 0 || len(detail.Participants) != 2 ||
+		detail.Participants[0] != (GroupParticipant{ID: "10000000001@c.us", Admin: true}) {
+		t.Errorf("group detail = %+v", detail)
+	}
+	if about, _ := store.GetContactAbout(renamed.String()); about != "Weekend plans" {
+		t.Errorf("group about = %q, want its description", about)
+	}
+	chats, _ := store.GetChats()
+	for _, c := range chats {
+		want := map[string]string{"120363000000000001@g.us": ChatTypeCommunity, "120363000000000002@g.us": ChatTypeGroup, "10000000001@c.us": ChatTypeIndividual}[c.ID]
+		if c.Type != want {
+			t.Errorf("%s type = %q, want %q", c.ID, c.Type, want)
+		}
+	}
+
+	// The group we couldn't fetch waits for the next interval too
+	stale, err = store.GetStaleGroupJIDs(time.Now().Add(-time.Minute).Unix(), 10)
+	if err != nil || len(stale) != 0 {
+		t.Errorf("stale after refresh = %v, %v; want none", stale, err)
+	}
+	if detail, _ := store.GetContactDetail(left.String()); detail == nil || detail.Name != "Left group" {
+		t.Errorf("unfetched group detail = %+v, want name kept", detail)
+	}
+
+	// Deleting the chat drops its roster
+	if err := store.DeleteChat(renamed.String()); err != nil {
+		t.Fatalf("DeleteChat: %v", err)
+	}
+	if participants, err := store.GetGroupParticipants(renamed.String()); err != nil || len(participants) != 0 {
+		t.Errorf("participants after delete = %+v, %v; want none", participants, err)
+	}
+}
+
+func TestRefreshGroups_StopsOnCancel(t *testing.T) {
+	store := newTestStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	jids := []string{"120363000000000001@g.us", "120363000000000002@g.us"}
+	if _, err := refreshGroups(ctx, newFakeWA(), store, jids, time.Hour); err == nil {
+		t.Error("refreshGroups with a cancelled context returned no error")
+	}
+}
+
+func TestBackfillGroupSenderNames(t *testing.T) {
+	store := newTestStore(t)
+	fake := newFakeWA()
+	group := types.NewJID("120363000000000001", types.GroupServer)
+
+	// More unnamed senders than one batch; the last is not a participant
+	// any more and stays unnamed
+	senders := groupSenderBatch + 20
+	var participants []types.GroupParticipant
+	for i := 0; i < senders; i++ {
+		lid := types.NewJID(fmt.Sprintf("2000000000%05d", i), types.HiddenUserServer)
+		store.UpsertMessage(fmt.Sprintf("false_120363000000000001@g.us_M%d", i), group.String(), lid.String(), "", false, "hi", int64(i), false, nil, nil)
+		if i < senders-1 {
+			phone := types.NewJID(fmt.Sprintf("1000000%05d", i), types.DefaultUserServer)
+			participants = append(participants, types.GroupParticipant{JID: lid, LID: lid, PhoneNumber: phone})
+		}
+	}
+	fake.groupInfo[group] = &types.GroupInfo{JID: group, Participants: participants}
+	fake.contacts[types.NewJID("100000000000", types.DefaultUserServer)] = types.ContactInfo{Found: true, FullName: "Alice"}
+
+	n, err := backfillGroupSenderNames(context.Background(), fake, store, "", nil, 0)
+	if err != nil {
+		t.Fatalf("backfillGroupSenderNames: %v", err)
+	}
+	if n != senders-1 {
+		t.Errorf("named %d messages, want %d", n, senders-1)
+	}
+
+	left, _ := store.GetUnnamedGroupSenders("", groupSender{}, groupSenderBatch)
+	if len(left) != 1 || left[0].SenderJID != fmt.Sprintf("2000000000%05d@lid", senders-1) {
+		t.Errorf("unnamed after backfill = %+v, want only the departed sender", left)
+	}
+	msgs, _ := store.GetMessages(group.String(), senders, 0)
+	byID := map[string]string{}
+	for _, m := range msgs {
+		if m.SenderName != nil {
+			byID[m.ID] = *m.SenderName
+		}
+	}
+	if byID["false_120363000000000001@g.us_M0"] != "Alice" || byID["false_120363000000000001@g.us_M1"] != "100000000001" {
+		t.Errorf("names = %q, %q; want contact name, then number", byID["false_120363000000000001@g.us_M0"], byID["false_120363000000000001@g.us_M1"])
+	}
+
+	// A second run has nothing left it can name
+	if n, err := backfillGroupSenderNames(context.Background(), fake, store, group.String(), nil, 0); err != nil || n != 0 {
+		t.Errorf("second run named %d, %v; want 0", n, err)
+	}
+
+	// The fetched roster was stored, so later runs name senders without
+	// asking WhatsApp again
+	delete(fake.groupInfo, group)
+	store.UpsertMessage("false_120363000000000001@g.us_LATE", group.String(), "200000000000000@lid", "", false, "back", 1000, false, nil, nil)
+	if n, err := backfillGroupSenderNames(context.Background(), fake, store, group.String(), nil, time.Hour); err != nil || n != 1 {
+		t.Errorf("run from the stored roster named %d, %v; want 1", n, err)
+	}
+}
@@ -1,29 +1,47 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/proto/waCommon"
 	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
 // Server holds the WhatsApp client and database store, providing HTTP handlers
 // for every route the Raycast extension consumes.
 type Server struct {
-	wc    *WAClient
-	store *AppStore
+	wc      *WAClient
+	store   *AppStore
+	wa      waAPI
+	limits  resultLimits
+	confirm *confirmGate // nil: destructive endpoints need no confirmation
+	// timeZone is the IANA zone /ui shows dates in; "" uses the browser's
+	timeZone string
+	media    *mediaCache // nil: /download-media always fetches from WhatsApp
+	// sendLimiter rate-limits new messages; nil sends without limit
+	sendLimiter *sendLimiter
+	// uploads bounds concurrent media sends; nil runs them all at once
+	uploads *uploadGate
 }
 
 // ---------------------------------------------------------------------------
@@ -37,10 +55,152 @@ func writeJSON(w http.ResponseWriter, v interface{}) {
 	}
 }
 
-func writeError(w http.ResponseWriter, code int, msg string) {
+// Machine-readable error codes returned alongside the human-readable message
+// in every error body, so clients can branch without string-matching.
+const (
+	ErrCodeInvalidBody      = "invalid_body"
+	ErrCodeMissingField     = "missing_field"
+	ErrCodeInvalidJID       = "invalid_jid"
+	ErrCodeInvalidMessageID = "invalid_message_id"
+	ErrCodeInvalidBase64    = "invalid_base64"
+	ErrCodeInvalidParam     = "invalid_param"
+	ErrCodeMessageTooLong   = "message_too_long"
+	ErrCodeBodyTooLarge     = "body_too_large"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeNoMedia          = "no_media"
+	ErrCodeInvalidOption    = "invalid_option"
+	ErrCodeNotOnWhatsApp    = "not_on_whatsapp"
+	ErrCodeSyncInProgress   = "sync_in_progress"
+	ErrCodeConfirmRequired  = "confirm_required"
+	ErrCodeNotAdmin         = "not_admin"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeUploadsBusy      = "uploads_busy"
+	ErrCodeNotPaired        = "not_paired"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeWhatsApp         = "whatsapp_error"
+	ErrCodeTimeout          = "timeout"
+	ErrCodeClientClosed     = "client_closed"
+	ErrCodeInternal         = "internal_error"
+)
+
+// writeError writes a JSON error body of the form {"error": msg, "code": errCode}.
+func writeError(w http.ResponseWriter, status int, errCode, msg string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg, "code": errCode})
+}
+
+// Request body limits. Media bodies carry base64 (4/3 overhead) so they get a
+// much larger allowance than plain JSON; everything else is kept small.
+const (
+	maxSmallBodyBytes = 16 << 10  // 16KB — ID/flag-only bodies
+	maxJSONBodyBytes  = 256 << 10 // 256KB — text messages up to 64KB plus envelope
+	maxMediaBodyBytes = 32 << 20  // 32MB — base64-encoded media
+)
+
+// decodeJSONBody decodes the request body into v, reading at most limit bytes.
+// On failure it writes a 413 (body over limit) or 400 (malformed JSON) and
+// returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, limit int64, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge,
+				fmt.Sprintf("request body too large (max %d bytes)", maxErr.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("invalid body: %v", err))
+		return false
+	}
+	return true
+}
+
+// statusClientClosedRequest is the non-standard (nginx) status for a request
+// whose client disconnected before the response was ready.
+const statusClientClosedRequest = 499
+
+// maxRequestTimeout caps caller-supplied timeouts for WhatsApp calls.
+const maxRequestTimeout = 2 * time.Minute
+
+// maxMessageLen is the longest text /send and message edits accept, in
+// bytes: 64KB, WhatsApp's practical limit.
+const maxMessageLen = 65536
+
+// requestContext derives a context for a WhatsApp call from the incoming
+// request, so a client that gives up cancels the call. timeoutMs overrides def
+// when positive and is clamped to maxRequestTimeout.
+func requestContext(r *http.Request, timeoutMs int, def time.Duration) (context.Context, context.CancelFunc) {
+	timeout := def
+	if timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	if timeout > maxRequestTimeout {
+		timeout = maxRequestTimeout
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// writeWAError reports a failed WhatsApp call: 499 if the client went away,
+// 504 if the call timed out, 500 otherwise.
+func writeWAError(w http.ResponseWriter, r *http.Request, ctx context.Context, what string, err error) {
+	switch {
+	case r.Context().Err() != nil:
+		writeError(w, statusClientClosedRequest, ErrCodeClientClosed, fmt.Sprintf("%s: client closed request", what))
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, ErrCodeTimeout, fmt.Sprintf("%s: timed out: %v", what, err))
+	default:
+		writeError(w, http.StatusInternalServerError, ErrCodeWhatsApp, fmt.Sprintf("%s: %v", what, err))
+	}
+}
+
+// queryInt parses an integer query parameter, returning 0 if absent or invalid.
+func queryInt(r *http.Request, key string) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// unixTimeParam reads an optional unix-seconds query parameter, 0 if absent.
+// An invalid value gets a 400 and ok=false.
+func unixTimeParam(w http.ResponseWriter, r *http.Request, key string) (ts int64, ok bool) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return 0, true
+	}
+	ts, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ts < 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, key+" must be a unix timestamp")
+		return 0, false
+	}
+	return ts, true
+}
+
+// clampedInt reads a positive integer query parameter, using def when it is
+// missing or invalid and capping it at max.
+func clampedInt(r *http.Request, key string, def, max int) int {
+	v := queryInt(r, key)
+	if v <= 0 {
+		v = def
+	}
+	return min(v, max)
+}
+
+// checkMimetype reports whether mimetype, a client's override of the
+// detected media type, is a valid type of kind ("image", "video" or
+// "audio"), writing a 400 if it isn't. An empty mimetype passes.
+func checkMimetype(w http.ResponseWriter, mimetype, kind string) bool {
+	if mimetype == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(mimetype)
+	if err != nil || !strings.HasPrefix(mediaType, kind+"/") {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, fmt.Sprintf("mimetype must be %s/*", kind))
+		return false
+	}
+	return true
 }
 
 func stripDataURL(s string) string {
@@ -73,10 +233,50 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 // 3. GET /qr
 // ---------------------------------------------------------------------------
 
+// GET /qr?wait=true long-polls: it answers once a new code arrives or the
+// status changes (such as pairing succeeding), or after timeoutMs (default
+// qrWaitDefault, at most qrWaitMax) with the unchanged state. Clients fetch
+// once without wait, then wait for each change. A ready client answers at
+// once, as there is nothing to pair.
+const (
+	qrWaitDefault = 25 * time.Second
+	qrWaitMax     = 50 * time.Second
+)
+
 func (s *Server) handleQR(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("wait") == "true" {
+		s.waitForQRChange(r)
+	}
 	writeJSON(w, s.wc.GetQR())
 }
 
+// waitForQRChange blocks until the QR code or connection status changes, the
+// wait times out or the client goes away.
+func (s *Server) waitForQRChange(r *http.Request) {
+	ch, unsubscribe := s.wc.events.Subscribe()
+	defer unsubscribe()
+	if s.wc.Status() == StatusReady {
+		return
+	}
+
+	timeout := qrWaitDefault
+	if ms := queryInt(r, "timeoutMs"); ms > 0 {
+		timeout = min(time.Duration(ms)*time.Millisecond, qrWaitMax)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-ch:
+			if !ok || evt.Type == EventStatus {
+				return
+			}
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // 4. GET /contacts
 // ---------------------------------------------------------------------------
@@ -84,23 +284,54 @@ func (s *Server) handleQR(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleContacts(w http.ResponseWriter, r *http.Request) {
 	contacts, err := s.store.GetContacts()
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("get contacts: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get contacts: %v", err))
 		return
 	}
 	writeJSON(w, map[string]interface{}{"contacts": contacts})
 }
 
 // ---------------------------------------------------------------------------
-// 5. GET /chats
+// 5. GET /chats — every chat, or a page of them with limit and offset;
+// unreadOnly=true and type=group|individual narrow the list, and archived
+// chats are left out unless includeArchived=true
 // ---------------------------------------------------------------------------
 
+// Pages of GET /chats. Without limit or offset every chat is returned.
+const (
+	chatsPageDefault = 50
+	chatsPageMax     = 1000
+)
+
 func (s *Server) handleChats(w http.ResponseWriter, r *http.Request) {
-	chats, err := s.store.GetChats()
+	params := r.URL.Query()
+	q := ChatQuery{
+		UnreadOnly:      params.Get("unreadOnly") == "true",
+		Type:            params.Get("type"),
+		IncludeArchived: params.Get("includeArchived") == "true",
+	}
+	if q.Type != "" && q.Type != ChatTypeGroup && q.Type != ChatTypeIndividual {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "type must be group or individual")
+		return
+	}
+	paged := params.Has("limit") || params.Has("offset")
+	if paged {
+		if q.Offset = queryInt(r, "offset"); q.Offset < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "offset must not be negative")
+			return
+		}
+		q.Limit = clampedInt(r, "limit", chatsPageDefault, chatsPageMax)
+	}
+
+	chats, hasMore, err := s.store.GetChatsPage(q)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("get chats: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get chats: %v", err))
 		return
 	}
-	writeJSON(w, map[string]interface{}{"chats": chats})
+	resp := map[string]interface{}{"chats": chats}
+	if paged {
+		resp["hasMore"] = hasMore
+	}
+	writeJSON(w, resp)
 }
 
 // ---------------------------------------------------------------------------
@@ -110,16 +341,12 @@ func (s *Server) handleChats(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	chatID := r.PathValue("chatId")
 	if chatID == "" {
-		writeError(w, http.StatusBadRequest, "chatId is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
 		return
 	}
 
-	limit := 50
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
-			limit = parsed
-		}
-	}
+	limit := clampedInt(r, "limit", s.limits.MessagesDefault, s.limits.MessagesMax)
+	clamped := queryInt(r, "limit") > limit
 
 	var beforeTs int64
 	if b := r.URL.Query().Get("before"); b != "" {
@@ -128,9 +355,27 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// fields: only these message fields, to save bandwidth on slow links
+	fields, err := parseMessageFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, err.Error())
+		return
+	}
+
 	// Convert API JID to internal format for DB queries
 	internalJID := toInternalJID(chatID)
 
+	// count=true: metadata only, no message bodies
+	if r.URL.Query().Get("count") == "true" {
+		counts, err := s.store.GetMessageCounts(internalJID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+			return
+		}
+		writeJSON(w, counts)
+		return
+	}
+
 	refresh := r.URL.Query().Get("refresh") == "true"
 
 	if refresh {
@@ -155,23 +400,112 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	messages, err := s.store.GetMessages(internalJID, limit, beforeTs)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("get messages: %v", err))
+	meta := MessagesMeta{FromCache: !refresh}
+	if clamped {
+		meta.Clamped = true
+		meta.Limit = limit
+	}
+	// Opt-in: label every message with its sender's number (and a name, even
+	// if only the number is known) so clients can skip a contacts lookup.
+	includeSender := r.URL.Query().Get("includeSender") == "true"
+
+	// q: only the chat's messages matching a full-text query, newest first
+	if q := r.URL.Query().Get("q"); q != "" {
+		results, err := s.store.SearchMessages(SearchOptions{
+			Query: q, ChatJID: internalJID, Newest: true, Limit: min(limit, maxMessagesPerQuery),
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("search: %v", err))
+			return
+		}
+		msgs := make([]Message, len(results))
+		for i, r := range results {
+			msgs[i] = r.Message
+			if includeSender {
+				includeSenderDetails(&msgs[i])
+			}
+		}
+		resp, err := fields.response(msgs, meta)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("encode messages: %v", err))
+			return
+		}
+		writeJSON(w, resp)
 		return
 	}
 
-	resp := MessagesResponse{
-		Messages:  messages,
-		FromCache: !refresh,
+	s.streamMessages(w, internalJID, limit, beforeTs, includeSender, fields, meta)
+}
+
+// streamMessages writes a MessagesResponse, encoding each message as it is
+// read from the store rather than building the whole list first, so memory
+// stays flat however large the page. The output is identical to
+// writeJSON(MessagesResponse{...}), cut down to fields. An error after the
+// first message can no longer change the status, so the list ends there and
+// the error follows it, as in an error response.
+func (s *Server) streamMessages(w http.ResponseWriter, chatJID string, limit int, beforeTs int64, includeSender bool, fields messageFields, meta MessagesMeta) {
+	bw := bufio.NewWriterSize(w, 32<<10)
+	count := 0
+	err := s.store.ForEachMessage(chatJID, limit, beforeTs, func(m Message) error {
+		if includeSender {
+			includeSenderDetails(&m)
+		}
+		data, err := fields.marshal(m)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			bw.WriteString(`{"messages":[`)
+		} else {
+			bw.WriteByte(',')
+		}
+		count++
+		_, err = bw.Write(data)
+		return err
+	})
+	if err != nil {
+		if count == 0 {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get messages: %v", err))
+			return
+		}
+		log.Printf("stream messages for %s: %v", chatJID, err)
+		meta.Error, meta.Code = fmt.Sprintf("get messages: %v", err), ErrCodeInternal
 	}
 
-	if len(messages) == 0 {
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		bw.WriteString(`{"messages":[`)
 		empty := true
-		resp.Empty = &empty
+		meta.Empty = &empty
+	}
+	tail, err := json.Marshal(meta)
+	if err != nil {
+		log.Printf("stream messages for %s: %v", chatJID, err)
+		bw.Flush()
+		return
 	}
+	// tail is {"fromCache":...}; splice its fields in after the array
+	bw.WriteString("],")
+	bw.Write(tail[1:])
+	bw.WriteByte('\n')
+	if err := bw.Flush(); err != nil {
+		log.Printf("stream messages for %s: %v", chatJID, err)
+	}
+}
 
-	writeJSON(w, resp)
+// includeSenderDetails sets SenderNumber on a message and falls back to the
+// number for SenderName when no name is known.
+func includeSenderDetails(m *Message) {
+	number := phoneNumber(m.From)
+	if number == "" {
+		return
+	}
+	n := "+" + number
+	m.SenderNumber = &n
+	if m.SenderName == nil {
+		m.SenderName = &n
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -181,38 +515,49 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
 	chatID := r.PathValue("chatId")
 	if chatID == "" {
-		writeError(w, http.StatusBadRequest, "chatId is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
 		return
 	}
 
 	internalJID := toInternalJID(chatID)
 
-	// Mark read in our database
-	if err := s.store.MarkRead(internalJID); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("mark read in db: %v", err))
-		return
-	}
-
-	// Also mark read on WhatsApp
+	// Send WhatsApp a read receipt for the latest message first, so a client
+	// that gave up or a timed-out call leaves the chat unread here too. Other
+	// failures are logged, and the chat is only marked read here: synced is
+	// false.
+	synced := true
 	latestID, err := s.store.GetLatestMessageID(internalJID)
 	if err == nil && latestID != "" {
 		parts := parseMessageIDParts(latestID)
 		if parts != nil {
+			ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+			defer cancel()
+
 			chatJID := parseAPIJID(parts.chatJID)
-			err := s.wc.client.MarkRead(
-				context.Background(),
+			err := s.wa.MarkRead(
+				ctx,
 				[]types.MessageID{parts.messageID},
 				time.Now(),
 				chatJID,
 				types.EmptyJID,
 			)
 			if err != nil {
+				if ctx.Err() != nil {
+					writeWAError(w, r, ctx, "mark read on WhatsApp", err)
+					return
+				}
 				log.Printf("mark read on WhatsApp: %v", err)
+				synced = false
 			}
 		}
 	}
 
-	writeJSON(w, map[string]bool{"success": true})
+	if err := s.store.MarkRead(internalJID); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("mark read in db: %v", err))
+		return
+	}
+
+	writeJSON(w, map[string]bool{"success": true, "synced": synced})
 }
 
 // ---------------------------------------------------------------------------
@@ -221,83 +566,223 @@ func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
 	var req SendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxJSONBodyBytes, &req) {
 		return
 	}
 	if req.ChatID == "" || req.Message == "" {
-		writeError(w, http.StatusBadRequest, "chatId and message are required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and message are required")
 		return
 	}
 
-	// TODO [HIGH][SECURITY]: Add rate limiting to prevent message spam and WhatsApp account bans.
-	// Recommended: max 30 messages/minute across all chats, max 5 messages/minute per chat.
-
-	const maxMessageLen = 65536 // 64KB - WhatsApp's practical limit
 	if len(req.Message) > maxMessageLen {
-		writeError(w, http.StatusBadRequest, "message too long (max 64KB)")
+		writeError(w, http.StatusBadRequest, ErrCodeMessageTooLong, "message too long (max 64KB)")
 		return
 	}
 
 	chatJID := parseAPIJID(req.ChatID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
 
-	var msg waE2E.Message
-	if req.QuotedMessageID != nil && *req.QuotedMessageID != "" {
-		// Reply to a specific message using ExtendedTextMessage
+	hasQuotedID := req.QuotedMessageID != nil && *req.QuotedMessageID != ""
+	var quoted *waE2E.ContextInfo
+	switch {
+	case hasQuotedID && req.QuotedMatch != nil:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "use quotedMessageId or quotedMatch, not both")
+		return
+	case hasQuotedID:
 		parts := parseMessageIDParts(*req.QuotedMessageID)
 		if parts == nil {
-			writeError(w, http.StatusBadRequest, "invalid quotedMessageId format")
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid quotedMessageId format")
 			return
 		}
 		participantJID := parts.chatJID
+		quoted = &waE2E.ContextInfo{
+			StanzaID:    proto.String(parts.messageID),
+			Participant: proto.String(participantJID),
+		}
+	case req.QuotedMatch != nil:
+		var ok bool
+		if quoted, ok = s.resolveQuotedMatch(w, chatJID, req.QuotedMatch); !ok {
+			return
+		}
+	}
+
+	var msg waE2E.Message
+	if quoted != nil {
+		// Reply to a specific message using ExtendedTextMessage
 		msg.ExtendedTextMessage = &waE2E.ExtendedTextMessage{
-			Text: proto.String(req.Message),
-			ContextInfo: &waE2E.ContextInfo{
-				StanzaID:    proto.String(parts.messageID),
-				Participant: proto.String(participantJID),
-			},
+			Text:        proto.String(req.Message),
+			ContextInfo: quoted,
 		}
 	} else {
 		msg.Conversation = proto.String(req.Message)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	if isBroadcastList(chatJID.String()) {
+		s.sendToBroadcastList(w, r, req.TimeoutMs, chatJID, &msg, req.Message)
+		return
+	}
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
 
-	resp, err := s.wc.client.SendMessage(ctx, chatJID, &msg)
+	ctx, cancel := requestContext(r, req.TimeoutMs, 30*time.Second)
+	defer cancel()
+	resp, err := s.wa.SendMessage(ctx, chatJID, &msg)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("send message: %v", err))
+		writeWAError(w, r, ctx, "send message", err)
 		return
 	}
 
 	formattedID := formatMessageID(true, toAPIJID(chatJID), resp.ID)
+	s.storeSentText(formattedID, toInternalJID(req.ChatID), req.Message, resp.Timestamp.Unix())
 
-	// Store sent message in DB immediately (don't rely on echo event)
-	internalChatJID := toInternalJID(req.ChatID)
-	senderJID := ""
-	if s.wc.client.Store.ID != nil {
-		senderJID = s.wc.client.Store.ID.String()
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": formattedID,
+	})
+}
+
+// resolveQuotedMatch finds the stored message m describes in chatJID and
+// returns the context that quotes it. If there is none, or m is invalid, it
+// writes the error response and returns false.
+func (s *Server) resolveQuotedMatch(w http.ResponseWriter, chatJID types.JID, m *QuotedMatch) (*waE2E.ContextInfo, bool) {
+	if m.Text == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "quotedMatch.text is required")
+		return nil, false
 	}
-	now := resp.Timestamp.Unix()
+	fromMe := m.From == "me"
+	var senderJID string
+	if m.From != "" && !fromMe {
+		sender := parseAPIJID(m.From)
+		if !isValidJID(sender) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid quotedMatch.from")
+			return nil, false
+		}
+		senderJID = sender.String()
+	}
+
+	found, err := s.store.FindLatestMessage(chatJID.String(), senderJID, fromMe, m.Text)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no message matches quotedMatch")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("find quoted message: %v", err))
+		return nil, false
+	}
+	parts := parseMessageIDParts(found.ID)
+	if parts == nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("stored message has malformed ID %q", found.ID))
+		return nil, false
+	}
+	// Unlike a bare quotedMessageId, the stored message knows its sender,
+	// which is who a group reply should point at
+	participant := parts.chatJID
+	if found.From != "" {
+		participant = toInternalJID(found.From)
+	}
+	return &waE2E.ContextInfo{
+		StanzaID:      proto.String(parts.messageID),
+		Participant:   proto.String(participant),
+		QuotedMessage: &waE2E.Message{Conversation: proto.String(found.Body)},
+	}, true
+}
+
+// sendToBroadcastList delivers msg to each member of a broadcast list as a
+// direct message, which is how members receive broadcasts anyway: whatsmeow
+// can only address the status broadcast, not personal lists. Each copy is
+// stored in the member's chat, and gets the request's whole timeout rather
+// than a share of it. The response reports every member, and success only
+// if all of them got the message.
+func (s *Server) sendToBroadcastList(w http.ResponseWriter, r *http.Request, timeoutMs int, listJID types.JID, msg *waE2E.Message, body string) {
+	members, err := s.store.GetBroadcastListMembers(listJID.String())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get broadcast list members: %v", err))
+		return
+	}
+	if len(members) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no members known for this broadcast list (they arrive with history sync)")
+		return
+	}
+	results, recipients := broadcastRecipients(members)
+	// Each member gets their own message, so each counts against the limits
+	chats := make([]string, len(recipients))
+	for i, to := range recipients {
+		chats[i] = to.String()
+	}
+	if !s.allowSend(w, chats...) {
+		return
+	}
+
+	sent := 0
+	for _, to := range recipients {
+		result := BroadcastSendResult{ChatID: toAPIJIDString(to.String())}
+		ctx, cancel := requestContext(r, timeoutMs, 30*time.Second)
+		resp, err := s.wa.SendMessage(ctx, to, proto.Clone(msg).(*waE2E.Message))
+		cancel()
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.MessageID = formatMessageID(true, toAPIJID(to), resp.ID)
+			s.storeSentText(result.MessageID, to.String(), body, resp.Timestamp.Unix())
+			sent++
+		}
+		results = append(results, result)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": sent == len(results),
+		"sent":    sent,
+		"failed":  len(results) - sent,
+		"results": results,
+	})
+}
+
+// broadcastRecipients parses a broadcast list's members into the chats to
+// send to, each once however many device or duplicate entries it has.
+// Members that are not valid JIDs come back as failed results.
+func broadcastRecipients(members []string) ([]BroadcastSendResult, []types.JID) {
+	var failed []BroadcastSendResult
+	var recipients []types.JID
+	seen := make(map[types.JID]bool, len(members))
+	for _, member := range members {
+		to, err := types.ParseJID(member)
+		if err != nil {
+			failed = append(failed, BroadcastSendResult{ChatID: toAPIJIDString(member), Error: err.Error()})
+			continue
+		}
+		to = to.ToNonAD()
+		if seen[to] {
+			continue
+		}
+		seen[to] = true
+		recipients = append(recipients, to)
+	}
+	return failed, recipients
+}
+
+// storeSentText stores a text message sent through the bridge and updates the
+// chat preview immediately, rather than waiting for the echo event.
+func (s *Server) storeSentText(formattedID, internalChatJID, body string, ts int64) {
 	if err := s.store.UpsertMessage(
-		formattedID, internalChatJID, senderJID, "", true,
-		req.Message, now, false, nil, nil,
+		formattedID, internalChatJID, ownJIDString(s.wa), "", true,
+		body, ts, false, nil, nil,
 	); err != nil {
 		log.Printf("Error storing sent message: %v", err)
+	} else if err := s.store.SetMessageSource(formattedID, MessageSourceBridge); err != nil {
+		log.Printf("Error storing sent message source: %v", err)
 	}
 	// Update chat last message
-	preview := req.Message
+	preview := body
 	if len(preview) > 100 {
 		preview = preview[:100] + "..."
 	}
-	if err := s.store.UpdateChatLastMessage(internalChatJID, preview, now); err != nil {
+	if err := s.store.UpdateChatLastMessage(internalChatJID, preview, ts); err != nil {
 		log.Printf("Error updating chat last message: %v", err)
 	}
-
-	writeJSON(w, map[string]interface{}{
-		"success":   true,
-		"messageId": formattedID,
-	})
 }
 
 // ---------------------------------------------------------------------------
@@ -305,58 +790,105 @@ func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
 // ---------------------------------------------------------------------------
 
 func (s *Server) handleSendImage(w http.ResponseWriter, r *http.Request) {
+	// Wait for a slot before reading the body, so queued sends don't each
+	// hold a decoded file
+	release, ok := s.acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
 	var req SendImageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxMediaBodyBytes, &req) {
 		return
 	}
 	if req.ChatID == "" || req.Base64 == "" {
-		writeError(w, http.StatusBadRequest, "chatId and base64 are required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and base64 are required")
 		return
 	}
 
 	chatJID := parseAPIJID(req.ChatID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	if !checkMimetype(w, req.Mimetype, "image") {
+		return
+	}
 
 	// Strip data URL prefix if present
 	raw := stripDataURL(req.Base64)
 	data, err := base64.StdEncoding.DecodeString(raw)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid base64: %v", err))
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidBase64, fmt.Sprintf("invalid base64: %v", err))
+		return
+	}
+
+	if !s.allowSend(w, chatJID.String()) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := requestContext(r, req.TimeoutMs, 60*time.Second)
 	defer cancel()
 
-	// Upload the image to WhatsApp servers
-	uploaded, err := s.wc.client.Upload(ctx, data, whatsmeow.MediaImage)
+	// Upload the image to WhatsApp servers. As a document it is uploaded
+	// and delivered as is, where an image message is recompressed by the
+	// recipient's app.
+	appInfo := whatsmeow.MediaImage
+	if req.AsDocument {
+		appInfo = whatsmeow.MediaDocument
+	}
+	uploaded, err := s.wa.Upload(ctx, data, appInfo)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("upload image: %v", err))
+		writeWAError(w, r, ctx, "upload image", err)
 		return
 	}
 
+	// DetectContentType doesn't know HEIC, among others, so the client may
+	// say what it is sending
 	mimetype := http.DetectContentType(data)
-
-	imgMsg := &waE2E.ImageMessage{
-		URL:           proto.String(uploaded.URL),
-		DirectPath:    proto.String(uploaded.DirectPath),
-		MediaKey:      uploaded.MediaKey,
-		FileEncSHA256: uploaded.FileEncSHA256,
-		FileSHA256:    uploaded.FileSHA256,
-		FileLength:    proto.Uint64(uint64(len(data))),
-		Mimetype:      proto.String(mimetype),
+	if req.Mimetype != "" {
+		mimetype = req.Mimetype
 	}
+
+	var caption *string
 	if req.Caption != nil && *req.Caption != "" {
-		imgMsg.Caption = proto.String(*req.Caption)
+		caption = proto.String(*req.Caption)
 	}
-
-	msg := &waE2E.Message{
-		ImageMessage: imgMsg,
+	msg := &waE2E.Message{}
+	if req.AsDocument {
+		fileName := req.FileName
+		if fileName == "" {
+			fileName = imageFileName(mimetype)
+		}
+		msg.DocumentMessage = &waE2E.DocumentMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			Mimetype:      proto.String(mimetype),
+			FileName:      proto.String(fileName),
+			Title:         proto.String(fileName),
+			Caption:       caption,
+		}
+	} else {
+		msg.ImageMessage = &waE2E.ImageMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(data))),
+			Mimetype:      proto.String(mimetype),
+			Caption:       caption,
+		}
 	}
 
-	resp, err := s.wc.client.SendMessage(ctx, chatJID, msg)
+	resp, err := s.wa.SendMessage(ctx, chatJID, msg)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("send image: %v", err))
+		writeWAError(w, r, ctx, "send image", err)
 		return
 	}
 
@@ -364,21 +896,19 @@ func (s *Server) handleSendImage(w http.ResponseWriter, r *http.Request) {
 
 	// Store sent image in DB immediately
 	internalChatJID := toInternalJID(req.ChatID)
-	senderJID := ""
-	if s.wc.client.Store.ID != nil {
-		senderJID = s.wc.client.Store.ID.String()
-	}
+	senderJID := ownJIDString(s.wa)
 	now := resp.Timestamp.Unix()
-	caption := ""
+	body := ""
 	if req.Caption != nil {
-		caption = *req.Caption
+		body = *req.Caption
 	}
-	mediaType := "image"
 	if err := s.store.UpsertMessage(
 		formattedID, internalChatJID, senderJID, "", true,
-		caption, now, true, &mediaType, nil,
+		body, now, true, getMediaType(msg), nil,
 	); err != nil {
 		log.Printf("Error storing sent image: %v", err)
+	} else if err := s.store.SetMessageSource(formattedID, MessageSourceBridge); err != nil {
+		log.Printf("Error storing sent image source: %v", err)
 	}
 
 	writeJSON(w, map[string]interface{}{
@@ -393,83 +923,99 @@ func (s *Server) handleSendImage(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleReact(w http.ResponseWriter, r *http.Request) {
 	var req ReactRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
 		return
 	}
 	if req.MessageID == "" || req.Emoji == "" {
-		writeError(w, http.StatusBadRequest, "messageId and emoji are required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId and emoji are required")
 		return
 	}
 
 	parts := parseMessageIDParts(req.MessageID)
 	if parts == nil {
-		writeError(w, http.StatusBadRequest, "invalid messageId format")
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId format")
 		return
 	}
-
-	chatJID := parseAPIJID(parts.chatJID)
-	remoteJIDStr := chatJID.String()
-
-	msg := &waE2E.Message{
-		ReactionMessage: &waE2E.ReactionMessage{
-			Key: &waCommon.MessageKey{
-				RemoteJID: proto.String(remoteJIDStr),
-				FromMe:    proto.Bool(parts.fromMe),
-				ID:        proto.String(parts.messageID),
-			},
-			Text:              proto.String(req.Emoji),
-			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
-		},
+	if !s.allowSend(w, parseAPIJID(parts.chatJID).String()) {
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := requestContext(r, req.TimeoutMs, 15*time.Second)
 	defer cancel()
 
-	_, err := s.wc.client.SendMessage(ctx, chatJID, msg)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("send reaction: %v", err))
+	if err := s.sendReaction(ctx, req.MessageID, parts, req.Emoji); err != nil {
+		writeWAError(w, r, ctx, "send reaction", err)
 		return
 	}
 
 	writeJSON(w, map[string]bool{"success": true})
 }
 
+// sendReaction reacts with emoji to messageID. Reacting to someone else's
+// message in a group needs its sender in the key, which is looked up in the
+// store; without it WhatsApp can't tell whose message it is.
+func (s *Server) sendReaction(ctx context.Context, messageID string, parts *msgIDParts, emoji string) error {
+	chatJID := parseAPIJID(parts.chatJID)
+	key := &waCommon.MessageKey{
+		RemoteJID: proto.String(chatJID.String()),
+		FromMe:    proto.Bool(parts.fromMe),
+		ID:        proto.String(parts.messageID),
+	}
+	if chatJID.Server == types.GroupServer && !parts.fromMe {
+		if sender, err := s.store.GetMessageSender(messageID); err == nil && sender != "" {
+			key.Participant = proto.String(sender)
+		}
+	}
+
+	msg := &waE2E.Message{
+		ReactionMessage: &waE2E.ReactionMessage{
+			Key:               key,
+			Text:              proto.String(emoji),
+			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+		},
+	}
+	_, err := s.wa.SendMessage(ctx, chatJID, msg)
+	return err
+}
+
 // ---------------------------------------------------------------------------
 // 11. POST /download-media
 // ---------------------------------------------------------------------------
 
 func (s *Server) handleDownloadMedia(w http.ResponseWriter, r *http.Request) {
 	var req DownloadMediaRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
 		return
 	}
 	if req.MessageID == "" {
-		writeError(w, http.StatusBadRequest, "messageId is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId is required")
 		return
 	}
 
 	rawProto, err := s.store.GetRawProto(req.MessageID)
 	if err != nil {
-		writeError(w, http.StatusNotFound, fmt.Sprintf("message not found: %v", err))
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("message not found: %v", err))
 		return
 	}
 	if len(rawProto) == 0 {
-		writeError(w, http.StatusNotFound, "no raw proto stored for this message")
+		writeError(w, http.StatusNotFound, ErrCodeNoMedia, "no raw proto stored for this message")
 		return
 	}
 
 	var msg waE2E.Message
 	if err := proto.Unmarshal(rawProto, &msg); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unmarshal proto: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("unmarshal proto: %v", err))
 		return
 	}
 
-	data, err := s.wc.client.DownloadAny(context.Background(), &msg)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("download media: %v", err))
-		return
+	// Auto-downloaded media is served from disk
+	data, cached := s.media.Get(req.MessageID)
+	if !cached {
+		data, err = s.wa.DownloadAny(context.Background(), &msg)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeWhatsApp, fmt.Sprintf("download media: %v", err))
+			return
+		}
 	}
 
 	mimetype := detectMediaMimetype(&msg)
@@ -486,12 +1032,11 @@ func (s *Server) handleDownloadMedia(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleResolveNumber(w http.ResponseWriter, r *http.Request) {
 	var req ResolveNumberRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
 		return
 	}
 	if req.Number == "" {
-		writeError(w, http.StatusBadRequest, "number is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "number is required")
 		return
 	}
 
@@ -500,14 +1045,14 @@ func (s *Server) handleResolveNumber(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	resp, err := s.wc.client.IsOnWhatsApp(ctx, []string{"+" + cleaned})
+	resp, err := s.wa.IsOnWhatsApp(ctx, []string{"+" + cleaned})
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("check number: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeWhatsApp, fmt.Sprintf("check number: %v", err))
 		return
 	}
 
 	if len(resp) == 0 || !resp[0].IsIn {
-		writeError(w, http.StatusNotFound, "number not on WhatsApp")
+		writeError(w, http.StatusNotFound, ErrCodeNotOnWhatsApp, "number not on WhatsApp")
 		return
 	}
 
@@ -526,17 +1071,17 @@ type SyncHistoryRequest struct {
 
 func (s *Server) handleSyncHistory(w http.ResponseWriter, r *http.Request) {
 	var req SyncHistoryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
 		return
 	}
 	if req.ChatID == "" {
-		writeError(w, http.StatusBadRequest, "chatId is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
 		return
 	}
 	if req.Count <= 0 {
-		req.Count = 50
+		req.Count = s.limits.SyncCountDefault
 	}
+	req.Count = min(req.Count, s.limits.SyncCountMax)
 
 	internalJID := toInternalJID(req.ChatID)
 
@@ -544,7 +1089,7 @@ func (s *Server) handleSyncHistory(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	if err := s.wc.RequestHistorySync(ctx, internalJID, req.Count); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("request history: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeWhatsApp, fmt.Sprintf("request history: %v", err))
 		return
 	}
 
@@ -563,16 +1108,11 @@ func (s *Server) handleSyncHistory(w http.ResponseWriter, r *http.Request) {
 // ---------------------------------------------------------------------------
 
 func (s *Server) handleSyncAll(w http.ResponseWriter, r *http.Request) {
-	count := 50
-	if c := r.URL.Query().Get("count"); c != "" {
-		if parsed, err := strconv.Atoi(c); err == nil && parsed > 0 {
-			count = parsed
-		}
-	}
+	count := clampedInt(r, "count", s.limits.SyncCountDefault, s.limits.SyncCountMax)
 
 	chatJIDs, err := s.store.GetAllChatJIDs()
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("get chats: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get chats: %v", err))
 		return
 	}
 
@@ -600,7 +1140,7 @@ func (s *Server) handleSyncAll(w http.ResponseWriter, r *http.Request) {
 		results = append(results, result)
 
 		// Small delay between requests to avoid rate limiting
-		time.Sleep(200 * time.Millisecond)
+		time.Sleep(s.wc.syncDelays.Request)
 	}
 
 	writeJSON(w, map[string]interface{}{
@@ -621,14 +1161,17 @@ func (s *Server) handleDeepSync(w http.ResponseWriter, r *http.Request) {
 	deepSyncProgress.mu.Unlock()
 
 	if running {
-		writeError(w, http.StatusConflict, "deep sync already in progress — GET /deep-sync for status")
+		writeError(w, http.StatusConflict, ErrCodeSyncInProgress, "deep sync already in progress — GET /deep-sync for status")
 		return
 	}
 
-	go s.wc.DeepSync()
+	// force=true re-syncs chats already marked as fully synced
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	go s.wc.DeepSync(force)
 
 	writeJSON(w, map[string]interface{}{
 		"success": true,
+		"force":   force,
 		"message": "Deep sync started in background. GET /deep-sync to check progress.",
 	})
 }
@@ -670,7 +1213,7 @@ var uiTmpl = template.Must(template.New("ui").Parse(uiHTML))
 // or short-lived token instead of exposing the persistent API key in page source.
 func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	uiTmpl.Execute(w, struct{ APIKey string }{APIKey: apiKey})
+	uiTmpl.Execute(w, struct{ APIKey, TimeZone string }{APIKey: apiKey, TimeZone: s.timeZone})
 }
 
 // ---------------------------------------------------------------------------
@@ -680,20 +1223,49 @@ func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		writeError(w, http.StatusBadRequest, "q parameter is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "q parameter is required")
+		return
+	}
+
+	opts := SearchOptions{
+		Query: query,
+		Limit: clampedInt(r, "limit", s.limits.SearchDefault, s.limits.SearchMax),
+	}
+	// after= and before= bound the messages' times (unix seconds, inclusive);
+	// chatId= searches one chat and sender= one person's messages
+	var ok bool
+	if opts.After, ok = unixTimeParam(w, r, "after"); !ok {
+		return
+	}
+	if opts.Before, ok = unixTimeParam(w, r, "before"); !ok {
 		return
 	}
+	if chatID := r.URL.Query().Get("chatId"); chatID != "" {
+		opts.ChatJID = toInternalJID(chatID)
+	}
+	if sender := r.URL.Query().Get("sender"); sender != "" {
+		opts.Sender = toInternalJID(sender)
+	}
 
-	limit := 50
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
-			limit = parsed
+	// groupBy=chat returns the top perChat matches for each of up to `limit`
+	// chats, with a per-chat match count, instead of one flat ranked list.
+	if r.URL.Query().Get("groupBy") == "chat" {
+		perChat := clampedInt(r, "perChat", s.limits.PerChatDefault, s.limits.PerChatMax)
+		groups, err := s.store.SearchMessagesByChat(opts, perChat)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("search: %v", err))
+			return
 		}
+		writeJSON(w, map[string]interface{}{
+			"groups": groups,
+			"count":  len(groups),
+		})
+		return
 	}
 
-	results, err := s.store.SearchMessages(query, limit)
+	results, err := s.store.SearchMessages(opts)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("search: %v", err))
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("search: %v", err))
 		return
 	}
 
@@ -704,21 +1276,2037 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 }
 
 // ---------------------------------------------------------------------------
-// 19. DELETE /chats/{chatId} — delete a chat and all its messages
+// 19. DELETE /chats/{chatId}?trash=true — delete a chat and all its messages,
+// or with trash=true move it to the trash (see section 31)
 // ---------------------------------------------------------------------------
 
 func (s *Server) handleDeleteChat(w http.ResponseWriter, r *http.Request) {
 	chatID := r.PathValue("chatId")
 	if chatID == "" {
-		writeError(w, http.StatusBadRequest, "chatId is required")
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
 		return
 	}
 
 	internalJID := toInternalJID(chatID)
-	if err := s.store.DeleteChat(internalJID); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("delete chat: %v", err))
-		return
-	}
+	if trash, _ := strconv.ParseBool(r.URL.Query().Get("trash")); trash {
+		found, err := s.store.TrashChat(internalJID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("trash chat: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "chat not found")
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "trashed": true})
+		return
+	}
+
+	if !s.confirm.check(w, r, false) {
+		return
+	}
+	if err := s.store.DeleteChat(internalJID); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("delete chat: %v", err))
+		return
+	}
+
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// ---------------------------------------------------------------------------
+// 20. GET /events — server-sent event stream of real-time updates
+// ---------------------------------------------------------------------------
+
+// sseKeepAlive is how often a comment line is written to idle streams so
+// proxies and clients don't consider the connection dead.
+const sseKeepAlive = 25 * time.Second
+
+// sseRetry is how long clients wait before reconnecting a dropped stream.
+// Events published while disconnected are not replayed; refetch /chats on
+// reconnect to catch up.
+const sseRetry = 3 * time.Second
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	// Routed with timeoutNone, so the stream isn't cut off
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		log.Printf("events: streaming unsupported: %v", err)
+		return
+	}
+
+	ch, unsubscribe := s.wc.events.Subscribe()
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				log.Printf("events: marshal %s: %v", evt.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 21. GET /messages/{messageId}/raw — stored proto as JSON (debug, WAPP_DEBUG_API)
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleRawMessage(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("messageId")
+	if messageID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId is required")
+		return
+	}
+
+	rawProto, err := s.store.GetRawProto(messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get raw proto: %v", err))
+		return
+	}
+	if len(rawProto) == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no raw proto stored for this message")
+		return
+	}
+
+	var msg waE2E.Message
+	if err := proto.Unmarshal(rawProto, &msg); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("unmarshal proto: %v", err))
+		return
+	}
+	data, err := protojson.Marshal(&msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("marshal proto: %v", err))
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"messageId": messageID,
+		"message":   json.RawMessage(data),
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 22. POST /send-button-response, POST /send-list-response — answer a
+// business message by picking one of the options it offered
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleSendButtonResponse(w http.ResponseWriter, r *http.Request) {
+	var req ButtonResponseRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.MessageID == "" || req.ButtonID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId and buttonId are required")
+		return
+	}
+
+	original, ctxInfo, ok := s.loadInteractiveMessage(w, req.MessageID)
+	if !ok {
+		return
+	}
+	buttons := original.GetButtonsMessage()
+	if buttons == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, "message is not a buttons message")
+		return
+	}
+	var label string
+	found := false
+	for _, b := range buttons.GetButtons() {
+		if b.GetButtonID() == req.ButtonID {
+			label = b.GetButtonText().GetDisplayText()
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, fmt.Sprintf("message has no button %q", req.ButtonID))
+		return
+	}
+
+	msg := &waE2E.Message{ButtonsResponseMessage: &waE2E.ButtonsResponseMessage{
+		SelectedButtonID: proto.String(req.ButtonID),
+		Response:         &waE2E.ButtonsResponseMessage_SelectedDisplayText{SelectedDisplayText: label},
+		Type:             waE2E.ButtonsResponseMessage_DISPLAY_TEXT.Enum(),
+		ContextInfo:      ctxInfo,
+	}}
+	s.sendInteractiveResponse(w, r, req.MessageID, req.TimeoutMs, msg, label)
+}
+
+func (s *Server) handleSendListResponse(w http.ResponseWriter, r *http.Request) {
+	var req ListResponseRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.MessageID == "" || req.RowID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId and rowId are required")
+		return
+	}
+
+	original, ctxInfo, ok := s.loadInteractiveMessage(w, req.MessageID)
+	if !ok {
+		return
+	}
+	list := original.GetListMessage()
+	if list == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, "message is not a list message")
+		return
+	}
+	var row *waE2E.ListMessage_Row
+sections:
+	for _, sec := range list.GetSections() {
+		for _, rr := range sec.GetRows() {
+			if rr.GetRowID() == req.RowID {
+				row = rr
+				break sections
+			}
+		}
+	}
+	if row == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, fmt.Sprintf("message has no row %q", req.RowID))
+		return
+	}
+
+	msg := &waE2E.Message{ListResponseMessage: &waE2E.ListResponseMessage{
+		Title:             proto.String(row.GetTitle()),
+		Description:       proto.String(row.GetDescription()),
+		ListType:          waE2E.ListResponseMessage_SINGLE_SELECT.Enum(),
+		SingleSelectReply: &waE2E.ListResponseMessage_SingleSelectReply{SelectedRowID: proto.String(req.RowID)},
+		ContextInfo:       ctxInfo,
+	}}
+	s.sendInteractiveResponse(w, r, req.MessageID, req.TimeoutMs, msg, row.GetTitle())
+}
+
+// loadInteractiveMessage looks up the stored proto of the message being
+// answered and builds the ContextInfo that quotes it. On failure it writes
+// the error response and returns ok=false.
+func (s *Server) loadInteractiveMessage(w http.ResponseWriter, messageID string) (*waE2E.Message, *waE2E.ContextInfo, bool) {
+	parts := parseMessageIDParts(messageID)
+	if parts == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId format")
+		return nil, nil, false
+	}
+	rawProto, err := s.store.GetRawProto(messageID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("message not found: %v", err))
+		return nil, nil, false
+	}
+	if len(rawProto) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, "message has no options to respond to")
+		return nil, nil, false
+	}
+	var original waE2E.Message
+	if err := proto.Unmarshal(rawProto, &original); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("unmarshal proto: %v", err))
+		return nil, nil, false
+	}
+
+	participant, err := s.store.GetMessageSender(messageID)
+	if err != nil || participant == "" {
+		participant = toInternalJID(parts.chatJID)
+	}
+	ctxInfo := &waE2E.ContextInfo{
+		StanzaID:      proto.String(parts.messageID),
+		Participant:   proto.String(participant),
+		QuotedMessage: &original,
+	}
+	return &original, ctxInfo, true
+}
+
+// sendInteractiveResponse sends a response to the chat the answered message
+// belongs to and stores it with the chosen option's label as its body.
+func (s *Server) sendInteractiveResponse(w http.ResponseWriter, r *http.Request, messageID string, timeoutMs int, msg *waE2E.Message, label string) {
+	parts := parseMessageIDParts(messageID)
+	chatJID := parseAPIJID(parts.chatJID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chat in messageId")
+		return
+	}
+
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, timeoutMs, 30*time.Second)
+	defer cancel()
+
+	resp, err := s.wa.SendMessage(ctx, chatJID, msg)
+	if err != nil {
+		writeWAError(w, r, ctx, "send response", err)
+		return
+	}
+
+	formattedID := formatMessageID(true, toAPIJID(chatJID), resp.ID)
+	s.storeSentText(formattedID, toInternalJID(parts.chatJID), label, resp.Timestamp.Unix())
+
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": formattedID,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 23. POST /reconnect — restart the reconnect cycle, e.g. after status "failed"
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleReconnect(w http.ResponseWriter, r *http.Request) {
+	s.wc.Reconnect()
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Reconnect started",
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 24. GET /sync-state, GET /chats/{chatId}/sync-state — per-chat history sync
+// progress ("synced through <date>")
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleSyncStates(w http.ResponseWriter, r *http.Request) {
+	states, err := s.store.GetAllChatSyncStates()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get sync state: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"chats": states,
+		"count": len(states),
+	})
+}
+
+func (s *Server) handleChatSyncState(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	if chatID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
+		return
+	}
+
+	state, err := s.store.GetChatSyncState(toInternalJID(chatID))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "chat has not been synced")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get sync state: %v", err))
+		return
+	}
+	writeJSON(w, state)
+}
+
+// ---------------------------------------------------------------------------
+// 25. GET /contacts/export?format=vcf|csv — download all known contacts
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleContactsExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "vcf"
+	}
+	var contentType string
+	var write func(io.Writer, []Contact) error
+	switch format {
+	case "vcf":
+		contentType, write = "text/vcard; charset=utf-8", writeVCards
+	case "csv":
+		contentType, write = "text/csv; charset=utf-8", writeContactsCSV
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "format must be vcf or csv")
+		return
+	}
+
+	contacts, err := s.store.GetContactsForExport()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get contacts: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whatsapp-contacts.%s"`, format))
+	if err := write(w, contacts); err != nil {
+		log.Printf("contacts export: %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 26. POST /maintenance/{action} — one-off repairs of the local store.
+// Actions: reconcile-chats (recompute every chat's last message preview)
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	var updated int64
+	var err error
+	switch action {
+	case "reconcile-chats":
+		updated, err = s.store.ReconcileChatPreviews("")
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidOption, fmt.Sprintf("unknown maintenance action %q (valid: reconcile-chats)", action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("%s: %v", action, err))
+		return
+	}
+	log.Printf("Maintenance %s: %d updated", action, updated)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"action":  action,
+		"updated": updated,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 27. GET /broadcast-lists — personal broadcast lists and their members.
+// Send to one with POST /send and the list's chatId.
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleBroadcastLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := s.store.GetBroadcastLists()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get broadcast lists: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"broadcastLists": lists,
+		"count":          len(lists),
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 28. GET /chats/{chatId}/messages/{rawId} — look up a message by the raw ID
+// WhatsApp assigned it, returning it with its formatted ID for use with
+// /download-media, /react and friends
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleMessageByRawID(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	rawID := r.PathValue("rawId")
+	if chatID == "" || rawID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and rawId are required")
+		return
+	}
+
+	msg, err := s.store.GetMessageByRawID(toInternalJID(chatID), rawID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get message: %v", err))
+		return
+	}
+	writeJSON(w, msg)
+}
+
+// ---------------------------------------------------------------------------
+// 29. POST /chats/{chatId}/clear — delete a chat's messages but keep the chat
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleClearChat(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	if chatID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
+		return
+	}
+
+	if !s.confirm.check(w, r, false) {
+		return
+	}
+	deleted, err := s.store.ClearMessages(toInternalJID(chatID))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("clear chat: %v", err))
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"deleted": deleted,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 30. POST /chats/delete — delete several chats and their messages at once.
+// All-or-nothing: a store error deletes none of them.
+// ---------------------------------------------------------------------------
+
+// maxBulkDeleteChats bounds the chatIds one POST /chats/delete accepts.
+const maxBulkDeleteChats = 500
+
+func (s *Server) handleDeleteChats(w http.ResponseWriter, r *http.Request) {
+	var req DeleteChatsRequest
+	if !decodeJSONBody(w, r, maxJSONBodyBytes, &req) {
+		return
+	}
+	if len(req.ChatIDs) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatIds is required")
+		return
+	}
+	if len(req.ChatIDs) > maxBulkDeleteChats {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, fmt.Sprintf("at most %d chatIds per request", maxBulkDeleteChats))
+		return
+	}
+	if !s.confirm.check(w, r, req.Confirm) {
+		return
+	}
+
+	results := make([]DeleteChatResult, len(req.ChatIDs))
+	jids := make([]string, 0, len(req.ChatIDs))
+	index := make([]int, 0, len(req.ChatIDs)) // results index of each jid
+	for i, chatID := range req.ChatIDs {
+		results[i].ChatID = chatID
+		if !isValidJID(parseAPIJID(chatID)) {
+			results[i].Error = "invalid chatId"
+			continue
+		}
+		jids = append(jids, toInternalJID(chatID))
+		index = append(index, i)
+	}
+
+	found, err := s.store.DeleteChats(jids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("delete chats: %v", err))
+		return
+	}
+	deleted := 0
+	for j, ok := range found {
+		res := &results[index[j]]
+		res.Deleted = ok
+		if ok {
+			deleted++
+		} else {
+			res.Error = "chat not found"
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"deleted": deleted,
+		"results": results,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 31. GET /chats/trash, POST /chats/{chatId}/restore — chats moved to the
+// trash by DELETE /chats/{chatId}?trash=true. They are purged for good after
+// WAPP_TRASH_RETENTION_DAYS.
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleTrashedChats(w http.ResponseWriter, r *http.Request) {
+	chats, err := s.store.GetTrashedChats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get trashed chats: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"chats": chats})
+}
+
+func (s *Server) handleRestoreChat(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	if chatID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId is required")
+		return
+	}
+
+	restored, err := s.store.RestoreChat(toInternalJID(chatID))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("restore chat: %v", err))
+		return
+	}
+	if !restored {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "chat is not in the trash")
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// ---------------------------------------------------------------------------
+// 32. GET /confirm-token — one-time token for an irreversible request when
+// WAPP_REQUIRE_CONFIRM=token; send it back in X-Confirm-Token
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleConfirmToken(w http.ResponseWriter, r *http.Request) {
+	if s.confirm == nil || s.confirm.mode != confirmToken {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "confirmation tokens are not enabled (WAPP_REQUIRE_CONFIRM=token)")
+		return
+	}
+	token, expires, err := s.confirm.issue()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("issue token: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"token":     token,
+		"expiresAt": expires.Unix(),
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 33. POST /contacts/{chatId}/about — fetch a contact's about text or a
+// group's description from WhatsApp now, rather than waiting for the
+// periodic refresh, and store it
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleRefreshAbout(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	if !isValidJID(parseAPIJID(chatID)) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	jid := toInternalJID(chatID)
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	var n int
+	var err error
+	if strings.HasSuffix(jid, "@g.us") {
+		// A group's description comes with the rest of its info
+		n, err = refreshGroups(ctx, s.wa, s.store, []string{jid}, 0)
+	} else {
+		n, err = refreshAbout(ctx, s.wa, s.store, []string{jid})
+	}
+	if err != nil {
+		writeWAError(w, r, ctx, "refresh about", err)
+		return
+	}
+	if n == 0 {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "group info not available")
+		return
+	}
+	about, err := s.store.GetContactAbout(jid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get about: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"chatId":  toAPIJIDString(jid),
+		"about":   about,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 34. GET /contacts/{chatId} — one contact's or group's full detail, from the
+// local store plus live lookups of avatar and block status
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleContact(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	jid := parseAPIJID(chatID)
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	internalJID := jid.String()
+
+	detail, err := s.store.GetContactDetail(internalJID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get contact: %v", err))
+		return
+	}
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	info, err := s.wa.GetContact(ctx, jid)
+	if err != nil {
+		log.Printf("Error looking up contact %s: %v", internalJID, err)
+	}
+	if detail == nil {
+		if !info.Found {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "contact not found")
+			return
+		}
+		detail = &ContactDetail{ID: toAPIJIDString(internalJID), IsGroup: jid.Server == types.GroupServer}
+	}
+
+	// The store has what history sync and events delivered; whatsmeow's
+	// contact store fills the gaps
+	if detail.PushName == "" {
+		detail.PushName = info.PushName
+	}
+	if info.BusinessName != "" {
+		detail.BusinessName, detail.Verified = info.BusinessName, true
+	}
+	if detail.Number == "" {
+		detail.Number = phoneNumber(internalJID)
+	}
+	for _, name := range []string{info.FullName, detail.PushName, detail.BusinessName, detail.Number} {
+		if detail.Name != "" {
+			break
+		}
+		detail.Name = name
+	}
+
+	// Live lookups: either failing leaves its field out rather than failing
+	// the request
+	if pic, _, err := profilePicture(ctx, s.wa, s.store, jid, true, false); err != nil {
+		log.Printf("Error fetching avatar for %s: %v", internalJID, err)
+	} else {
+		detail.AvatarURL = pic.URL
+	}
+	if !detail.IsGroup {
+		if list, err := s.wa.GetBlocklist(ctx); err != nil {
+			log.Printf("Error fetching blocklist: %v", err)
+		} else {
+			blocked := false
+			for _, b := range list.JIDs {
+				if b.ToNonAD() == jid.ToNonAD() {
+					blocked = true
+					break
+				}
+			}
+			detail.Blocked = &blocked
+		}
+	}
+
+	writeJSON(w, detail)
+}
+
+// ---------------------------------------------------------------------------
+// 35. GET /messages/{messageId}/location — latest position of a live-location
+// share, kept current as updates arrive
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleLiveLocation(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("messageId")
+	if parseMessageIDParts(messageID) == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId")
+		return
+	}
+
+	loc, err := s.store.GetLiveLocation(normalizeMessageID(messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no live location for this message")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get live location: %v", err))
+		return
+	}
+	writeJSON(w, loc)
+}
+
+// ---------------------------------------------------------------------------
+// 36. POST /send-video — send a video, or a GIF with gifPlayback
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleSendVideo(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var req SendVideoRequest
+	if !decodeJSONBody(w, r, maxMediaBodyBytes, &req) {
+		return
+	}
+	if req.ChatID == "" || req.Base64 == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and base64 are required")
+		return
+	}
+
+	chatJID := parseAPIJID(req.ChatID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	if !checkMimetype(w, req.Mimetype, "video") {
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(stripDataURL(req.Base64))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidBase64, fmt.Sprintf("invalid base64: %v", err))
+		return
+	}
+
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 60*time.Second)
+	defer cancel()
+
+	uploaded, err := s.wa.Upload(ctx, data, whatsmeow.MediaVideo)
+	if err != nil {
+		writeWAError(w, r, ctx, "upload video", err)
+		return
+	}
+
+	// DetectContentType knows MP4 and WebM; anything else is most likely MP4
+	mimetype := http.DetectContentType(data)
+	switch {
+	case req.Mimetype != "":
+		mimetype = req.Mimetype
+	case !strings.HasPrefix(mimetype, "video/"):
+		mimetype = "video/mp4"
+	}
+
+	vidMsg := &waE2E.VideoMessage{
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(data))),
+		Mimetype:      proto.String(mimetype),
+	}
+	if req.Caption != nil && *req.Caption != "" {
+		vidMsg.Caption = proto.String(*req.Caption)
+	}
+	if req.GifPlayback {
+		vidMsg.GifPlayback = proto.Bool(true)
+	}
+
+	resp, err := s.wa.SendMessage(ctx, chatJID, &waE2E.Message{VideoMessage: vidMsg})
+	if err != nil {
+		writeWAError(w, r, ctx, "send video", err)
+		return
+	}
+
+	formattedID := formatMessageID(true, toAPIJID(chatJID), resp.ID)
+
+	caption := ""
+	if req.Caption != nil {
+		caption = *req.Caption
+	}
+	mediaType := "video"
+	if err := s.store.UpsertMessage(
+		formattedID, toInternalJID(req.ChatID), ownJIDString(s.wa), "", true,
+		caption, resp.Timestamp.Unix(), true, &mediaType, nil,
+	); err != nil {
+		log.Printf("Error storing sent video: %v", err)
+	} else if err := s.store.SetMessageSource(formattedID, MessageSourceBridge); err != nil {
+		log.Printf("Error storing sent video source: %v", err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": formattedID,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 37. POST /resolve-sender — look a sender's name up now and store it on
+// their messages, for names the connect-time backfill missed
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleResolveSender(w http.ResponseWriter, r *http.Request) {
+	var req ResolveSenderRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.SenderJID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "senderJid is required")
+		return
+	}
+	sender := parseAPIJID(req.SenderJID)
+	if !isValidJID(sender) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid senderJid")
+		return
+	}
+	var chatJID string
+	if req.ChatID != "" {
+		if !isValidJID(parseAPIJID(req.ChatID)) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+			return
+		}
+		chatJID = toInternalJID(req.ChatID)
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 15*time.Second)
+	defer cancel()
+
+	name := resolveSenderName(ctx, s.wa, s.store, sender, "", chatJID)
+	if name == "" {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no name found for this sender")
+		return
+	}
+	updated, err := s.store.SetSenderName(sender.String(), chatJID, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"senderJid": toAPIJID(sender),
+		"name":      name,
+		"updated":   updated,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 38. POST /send-audio — send an audio file, or a voice note with ptt
+// ---------------------------------------------------------------------------
+
+// maxWaveformSamples is the number of waveform samples WhatsApp draws.
+const maxWaveformSamples = 64
+
+func (s *Server) handleSendAudio(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.acquireUpload(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var req SendAudioRequest
+	if !decodeJSONBody(w, r, maxMediaBodyBytes, &req) {
+		return
+	}
+	if req.ChatID == "" || req.Base64 == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and base64 are required")
+		return
+	}
+	if len(req.Waveform) > maxWaveformSamples {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, fmt.Sprintf("waveform has more than %d samples", maxWaveformSamples))
+		return
+	}
+
+	chatJID := parseAPIJID(req.ChatID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	if !checkMimetype(w, req.Mimetype, "audio") {
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(stripDataURL(req.Base64))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidBase64, fmt.Sprintf("invalid base64: %v", err))
+		return
+	}
+
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 60*time.Second)
+	defer cancel()
+
+	uploaded, err := s.wa.Upload(ctx, data, whatsmeow.MediaAudio)
+	if err != nil {
+		writeWAError(w, r, ctx, "upload audio", err)
+		return
+	}
+
+	// Voice notes are always Ogg Opus, which DetectContentType reports as
+	// application/ogg
+	mimetype := http.DetectContentType(data)
+	switch {
+	case req.Mimetype != "":
+		mimetype = req.Mimetype
+	case req.PTT || mimetype == "application/ogg":
+		mimetype = "audio/ogg; codecs=opus"
+	case !strings.HasPrefix(mimetype, "audio/"):
+		mimetype = "audio/mpeg"
+	}
+
+	audioMsg := &waE2E.AudioMessage{
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(data))),
+		Mimetype:      proto.String(mimetype),
+		PTT:           proto.Bool(req.PTT),
+	}
+	if req.Seconds > 0 {
+		audioMsg.Seconds = proto.Uint32(req.Seconds)
+	}
+	if req.PTT && len(req.Waveform) > 0 {
+		audioMsg.Waveform = req.Waveform
+	}
+
+	resp, err := s.wa.SendMessage(ctx, chatJID, &waE2E.Message{AudioMessage: audioMsg})
+	if err != nil {
+		writeWAError(w, r, ctx, "send audio", err)
+		return
+	}
+
+	formattedID := formatMessageID(true, toAPIJID(chatJID), resp.ID)
+
+	mediaType := "audio"
+	if err := s.store.UpsertMessage(
+		formattedID, toInternalJID(req.ChatID), ownJIDString(s.wa), "", true,
+		"", resp.Timestamp.Unix(), true, &mediaType, nil,
+	); err != nil {
+		log.Printf("Error storing sent audio: %v", err)
+	} else if err := s.store.SetMessageSource(formattedID, MessageSourceBridge); err != nil {
+		log.Printf("Error storing sent audio source: %v", err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": formattedID,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 39. GET /status-updates — contacts' status updates (stories), newest first.
+// Only stored with WAPP_STORE_STATUS_UPDATES=true.
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleStatusUpdates(w http.ResponseWriter, r *http.Request) {
+	limit := clampedInt(r, "limit", s.limits.MessagesDefault, s.limits.MessagesMax)
+	var beforeTs int64
+	if b := r.URL.Query().Get("before"); b != "" {
+		if parsed, err := strconv.ParseInt(b, 10, 64); err == nil && parsed > 0 {
+			beforeTs = parsed
+		}
+	}
+
+	updates, err := s.store.GetMessages(types.StatusBroadcastJID.String(), limit, beforeTs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("get status updates: %v", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"updates": updates})
+}
+
+// ---------------------------------------------------------------------------
+// 40. POST /edit-message — replace the text of a message you sent
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
+	var req EditMessageRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.MessageID == "" || req.NewText == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId and newText are required")
+		return
+	}
+
+	parts := parseMessageIDParts(req.MessageID)
+	if parts == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId format")
+		return
+	}
+	if !parts.fromMe {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "only messages you sent can be edited")
+		return
+	}
+
+	if len(req.NewText) > maxMessageLen {
+		writeError(w, http.StatusBadRequest, ErrCodeMessageTooLong, "message too long (max 64KB)")
+		return
+	}
+
+	chatJID := parseAPIJID(parts.chatJID)
+	content := &waE2E.Message{Conversation: proto.String(req.NewText)}
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 15*time.Second)
+	defer cancel()
+
+	resp, err := s.wa.SendMessage(ctx, chatJID, s.wa.BuildEdit(chatJID, parts.messageID, content))
+	if err != nil {
+		writeWAError(w, r, ctx, "send edit", err)
+		return
+	}
+
+	// Store the edit as if it had come back from WhatsApp
+	id := normalizeMessageID(req.MessageID)
+	internalChatJID := toInternalJID(parts.chatJID)
+	applyEdit(s.store, id, internalChatJID, ownJIDString(s.wa), "", true, content, resp.Timestamp.Unix())
+	if _, err := s.store.ReconcileChatPreviews(internalChatJID); err != nil {
+		log.Printf("Error reconciling preview for %s: %v", internalChatJID, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": id,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 41. DELETE /messages/{messageId} — delete a message for everyone: your own,
+// or anyone's in a group you admin
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleRevokeMessage(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("messageId")
+	parts := parseMessageIDParts(messageID)
+	if parts == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId format")
+		return
+	}
+	chatJID := parseAPIJID(parts.chatJID)
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+
+	sender := types.EmptyJID
+	if !parts.fromMe {
+		if chatJID.Server != types.GroupServer {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "only messages you sent can be deleted in a direct chat")
+			return
+		}
+		// Revoking someone else's message needs its sender, and admin rights
+		senderJID, err := s.store.GetMessageSender(messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "message not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+			return
+		}
+		if sender, err = types.ParseJID(senderJID); err != nil || sender.IsEmpty() {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "message has no stored sender")
+			return
+		}
+		info, err := s.wa.GetGroupInfo(ctx, chatJID)
+		if err != nil {
+			writeWAError(w, r, ctx, "get group info", err)
+			return
+		}
+		if !isGroupAdmin(info, s.wa.OwnJID()) {
+			writeError(w, http.StatusForbidden, ErrCodeNotAdmin, "only group admins can delete others' messages")
+			return
+		}
+	}
+
+	// Deleting for everyone can't be undone, and others see it happen. Asked
+	// only now, so a request that was going to fail keeps its token.
+	if !s.confirm.check(w, r, false) {
+		return
+	}
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+	if _, err := s.wa.SendMessage(ctx, chatJID, s.wa.BuildRevoke(chatJID, sender, parts.messageID)); err != nil {
+		writeWAError(w, r, ctx, "send revoke", err)
+		return
+	}
+
+	if _, err := s.store.RevokeMessage(messageID); err != nil {
+		log.Printf("Error revoking stored message %s: %v", messageID, err)
+	}
+	internalChatJID := toInternalJID(parts.chatJID)
+	if _, err := s.store.ReconcileChatPreviews(internalChatJID); err != nil {
+		log.Printf("Error reconciling preview for %s: %v", internalChatJID, err)
+	}
+
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// isGroupAdmin reports whether own is an admin of the group in info.
+func isGroupAdmin(info *types.GroupInfo, own *types.JID) bool {
+	if own == nil {
+		return false
+	}
+	for _, p := range info.Participants {
+		if p.JID.User == own.User || p.PhoneNumber.User == own.User {
+			return p.IsAdmin || p.IsSuperAdmin
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// 42. GET /groups/{chatId} — a group's live name, description and members
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleGroupInfo(w http.ResponseWriter, r *http.Request) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if jid.Server != types.GroupServer {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "chatId must be a group (@g.us)")
+		return
+	}
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	info, err := s.wa.GetGroupInfo(ctx, jid)
+	if err != nil {
+		writeWAError(w, r, ctx, "get group info", err)
+		return
+	}
+
+	resp := GroupInfo{
+		ID:           toAPIJID(jid),
+		Name:         info.Name,
+		Topic:        info.Topic,
+		Participants: make([]GroupMember, 0, len(info.Participants)),
+	}
+	if !info.GroupCreated.IsZero() {
+		resp.CreatedAt = info.GroupCreated.Unix()
+	}
+	owner := info.OwnerPN
+	if owner.IsEmpty() {
+		owner = info.OwnerJID
+	}
+	if !owner.IsEmpty() {
+		resp.Owner = toAPIJID(owner)
+	}
+	for _, p := range info.Participants {
+		phone := p.PhoneNumber
+		if phone.IsEmpty() && p.JID.Server == types.DefaultUserServer {
+			phone = p.JID
+		}
+		// Without a chat, resolveSenderName skips refetching this group
+		// for every LID member; their number is tried instead
+		name := resolveSenderName(ctx, s.wa, s.store, p.JID, p.DisplayName)
+		if name == "" && !phone.IsEmpty() && phone != p.JID {
+			name = resolveSenderName(ctx, s.wa, s.store, phone, "")
+		}
+		if name == "" {
+			name = phone.User
+		}
+		resp.Participants = append(resp.Participants, GroupMember{
+			ID:           toAPIJID(p.JID),
+			Name:         name,
+			Number:       phone.User,
+			IsAdmin:      p.IsAdmin || p.IsSuperAdmin,
+			IsSuperAdmin: p.IsSuperAdmin,
+		})
+	}
+	writeJSON(w, resp)
+}
+
+// ---------------------------------------------------------------------------
+// 43. POST /groups — create a group with us as its admin
+// ---------------------------------------------------------------------------
+
+// maxGroupNameLength is the longest group name WhatsApp accepts, in
+// characters.
+const maxGroupNameLength = 25
+
+func (s *Server) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "name is required")
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > maxGroupNameLength {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam,
+			fmt.Sprintf("name is longer than %d characters", maxGroupNameLength))
+		return
+	}
+	if len(req.Participants) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "at least one participant is required")
+		return
+	}
+	participants := make([]types.JID, 0, len(req.Participants))
+	for _, p := range req.Participants {
+		jid := parseAPIJID(p)
+		if !isValidJID(jid) || (jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, fmt.Sprintf("invalid participant %q", p))
+			return
+		}
+		participants = append(participants, jid)
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 30*time.Second)
+	defer cancel()
+	info, err := s.wa.CreateGroup(ctx, whatsmeow.ReqCreateGroup{Name: req.Name, Participants: participants})
+	if err != nil {
+		writeWAError(w, r, ctx, "create group", err)
+		return
+	}
+
+	// Store the group now rather than waiting for its first message, so it
+	// is listed straight away
+	groupJID := info.JID.String()
+	name := info.Name
+	if name == "" {
+		name = req.Name
+	}
+	if err := s.store.UpsertChat(groupJID, name, true, nil, nil); err != nil {
+		log.Printf("Error storing new group %s: %v", groupJID, err)
+	}
+	// Members WhatsApp couldn't add, such as those whose privacy settings
+	// only allow contacts to add them, come back with an error code
+	members := make([]GroupParticipant, 0, len(info.Participants))
+	notAdded := []string{}
+	for _, p := range info.Participants {
+		if p.Error != 0 {
+			notAdded = append(notAdded, toAPIJID(p.JID))
+			continue
+		}
+		members = append(members, GroupParticipant{ID: p.JID.String(), Admin: p.IsAdmin || p.IsSuperAdmin})
+	}
+	if err := s.store.SetGroupInfo(groupJID, name, ChatTypeGroup, members); err != nil {
+		log.Printf("Error storing participants of new group %s: %v", groupJID, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"chatId":   toAPIJID(info.JID),
+		"name":     name,
+		"notAdded": notAdded,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 44. GET /livez — liveness probe: the process is up and serving
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// ---------------------------------------------------------------------------
+// 45. GET /readyz — readiness probe: 200 once WhatsApp is connected and the
+// database answers, 503 otherwise
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := ReadyzResponse{WhatsApp: StatusDisconnected, DB: "ok"}
+	if s.wc != nil {
+		resp.WhatsApp = s.wc.Status()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		log.Printf("Readiness check: database ping failed: %v", err)
+		resp.DB = "unavailable"
+	}
+	resp.Ready = resp.WhatsApp == StatusReady && resp.DB == "ok"
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ---------------------------------------------------------------------------
+// 46. POST /groups/{chatId}/participants — add members
+//     DELETE /groups/{chatId}/participants — remove members
+//     POST /groups/{chatId}/participants/promote — make members admins
+//     POST /groups/{chatId}/participants/demote — take admin rights away
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleAddParticipants(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupParticipants(w, r, whatsmeow.ParticipantChangeAdd)
+}
+
+func (s *Server) handleRemoveParticipants(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupParticipants(w, r, whatsmeow.ParticipantChangeRemove)
+}
+
+func (s *Server) handlePromoteParticipants(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupParticipants(w, r, whatsmeow.ParticipantChangePromote)
+}
+
+func (s *Server) handleDemoteParticipants(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupParticipants(w, r, whatsmeow.ParticipantChangeDemote)
+}
+
+// changeGroupParticipants applies action to the participants in the request
+// body, as long as we are an admin of the group. WhatsApp accepts or refuses
+// each participant separately, so the response lists one result per
+// participant rather than failing as a whole.
+func (s *Server) changeGroupParticipants(w http.ResponseWriter, r *http.Request, action whatsmeow.ParticipantChange) {
+	group := parseAPIJID(r.PathValue("chatId"))
+	if group.Server != types.GroupServer {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "chatId must be a group (@g.us)")
+		return
+	}
+	var req GroupParticipantsRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if len(req.Participants) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "at least one participant is required")
+		return
+	}
+	participants := make([]types.JID, 0, len(req.Participants))
+	requested := make(map[types.JID]bool, len(req.Participants))
+	for _, p := range req.Participants {
+		jid := parseAPIJID(p)
+		if !isValidJID(jid) || (jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer) {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, fmt.Sprintf("invalid participant %q", p))
+			return
+		}
+		participants = append(participants, jid)
+		requested[jid] = true
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 30*time.Second)
+	defer cancel()
+	info, err := s.wa.GetGroupInfo(ctx, group)
+	if err != nil {
+		writeWAError(w, r, ctx, "get group info", err)
+		return
+	}
+	if !isGroupAdmin(info, s.wa.OwnJID()) {
+		writeError(w, http.StatusForbidden, ErrCodeNotAdmin, "only group admins can change participants")
+		return
+	}
+
+	changed, err := s.wa.UpdateGroupParticipants(ctx, group, participants, action)
+	if err != nil {
+		writeWAError(w, r, ctx, string(action)+" participants", err)
+		return
+	}
+	results := make([]ParticipantChangeResult, 0, len(changed))
+	for _, p := range changed {
+		// Answer with the JID the caller used, which WhatsApp may have
+		// swapped for the member's LID
+		jid := p.JID
+		if !requested[jid] && requested[p.PhoneNumber] {
+			jid = p.PhoneNumber
+		}
+		result := ParticipantChangeResult{ID: toAPIJID(jid), Success: p.Error == 0}
+		if p.Error != 0 {
+			result.Code = p.Error
+			result.Error = participantChangeError(p)
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, map[string]interface{}{"results": results})
+}
+
+// participantChangeError describes the error code WhatsApp returned for one
+// participant of a group change.
+func participantChangeError(p types.GroupParticipant) string {
+	switch p.Error {
+	case 403:
+		if p.AddRequest != nil {
+			return "their privacy settings don't allow adding them; they were sent an invite instead"
+		}
+		return "not allowed"
+	case 404:
+		return "not on WhatsApp, or not a member of the group"
+	case 408:
+		return "they left the group recently and can't be added back yet"
+	case 409:
+		return "already a member of the group"
+	default:
+		return fmt.Sprintf("WhatsApp refused the change (error %d)", p.Error)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 47. POST /react-last — react to a chat's newest message without its ID
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleReactLast(w http.ResponseWriter, r *http.Request) {
+	var req ReactLastRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.ChatID == "" || req.Emoji == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "chatId and emoji are required")
+		return
+	}
+	if !isValidJID(parseAPIJID(req.ChatID)) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+
+	messageID, err := s.store.GetLatestMessageID(toInternalJID(req.ChatID))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "chat has no messages")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	parts := parseMessageIDParts(messageID)
+	if parts == nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, "latest message has an invalid stored ID")
+		return
+	}
+	if !s.allowSend(w, toInternalJID(req.ChatID)) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 15*time.Second)
+	defer cancel()
+	if err := s.sendReaction(ctx, messageID, parts, req.Emoji); err != nil {
+		writeWAError(w, r, ctx, "send reaction", err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"success": true, "messageId": messageID})
+}
+
+// ---------------------------------------------------------------------------
+// 48. POST /groups/{chatId}/leave — leave a group, keeping its history
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleLeaveGroup(w http.ResponseWriter, r *http.Request) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if jid.Server != types.GroupServer {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "chatId must be a group (@g.us)")
+		return
+	}
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	if err := s.wa.LeaveGroup(ctx, jid); err != nil {
+		writeWAError(w, r, ctx, "leave group", err)
+		return
+	}
+	// The chat stays listed, marked with leftAt, so its history can still be
+	// read; it is no longer refreshed
+	if err := s.store.SetGroupLeft(jid.String(), true); err != nil {
+		log.Printf("Error marking %s as left: %v", jid, err)
+	}
 
 	writeJSON(w, map[string]bool{"success": true})
 }
+
+// ---------------------------------------------------------------------------
+// 49. GET /contacts/{chatId}/avatar — a contact's or group's profile picture,
+// cached; preview=true for the thumbnail, refresh=true to skip the cache
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleProfilePicture(w http.ResponseWriter, r *http.Request) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	preview := r.URL.Query().Get("preview") == "true"
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	pic, cached, err := profilePicture(ctx, s.wa, s.store, jid, preview, r.URL.Query().Get("refresh") == "true")
+	if err != nil {
+		writeWAError(w, r, ctx, "get profile picture", err)
+		return
+	}
+	if pic.URL == "" {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no profile picture, or it is only shown to their contacts")
+		return
+	}
+
+	writeJSON(w, ProfilePicture{
+		ID:        toAPIJID(jid),
+		URL:       pic.URL,
+		PictureID: pic.ID,
+		Preview:   preview,
+		UpdatedAt: pic.UpdatedAt,
+		Cached:    cached,
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 50. POST /forward — forward a stored message to another chat
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+	var req ForwardRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	if req.SourceMessageID == "" || req.TargetChatID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "sourceMessageId and targetChatId are required")
+		return
+	}
+	parts := parseMessageIDParts(req.SourceMessageID)
+	if parts == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid sourceMessageId format")
+		return
+	}
+	chatJID := parseAPIJID(req.TargetChatID)
+	if !isValidJID(chatJID) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid targetChatId")
+		return
+	}
+
+	src, ok := s.forwardSource(w, req.SourceMessageID, parts)
+	if !ok {
+		return
+	}
+	msg := forwardCopy(src)
+	if msg == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "this kind of message cannot be forwarded")
+		return
+	}
+	body := extractMessageBody(msg)
+
+	if isBroadcastList(chatJID.String()) {
+		s.sendToBroadcastList(w, r, req.TimeoutMs, chatJID, msg, body)
+		return
+	}
+	if !s.allowSend(w, chatJID.String()) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, req.TimeoutMs, 30*time.Second)
+	defer cancel()
+	resp, err := s.wa.SendMessage(ctx, chatJID, msg)
+	if err != nil {
+		writeWAError(w, r, ctx, "forward message", err)
+		return
+	}
+
+	formattedID := formatMessageID(true, toAPIJID(chatJID), resp.ID)
+	s.storeForwarded(formattedID, toInternalJID(req.TargetChatID), msg, resp.Timestamp.Unix())
+
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"messageId": formattedID,
+	})
+}
+
+// forwardSource loads the message to forward. Media and interactive messages
+// come from their stored proto; text messages are stored without one and are
+// rebuilt from their body. If the message cannot be loaded it writes the
+// error response and returns false.
+func (s *Server) forwardSource(w http.ResponseWriter, messageID string, parts *msgIDParts) (*waE2E.Message, bool) {
+	rawProto, err := s.store.GetRawProto(messageID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("message not found: %v", err))
+		return nil, false
+	}
+	if len(rawProto) > 0 {
+		var msg waE2E.Message
+		if err := proto.Unmarshal(rawProto, &msg); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("unmarshal proto: %v", err))
+			return nil, false
+		}
+		return &msg, true
+	}
+
+	stored, err := s.store.GetMessageByRawID(toInternalJID(parts.chatJID), parts.messageID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("message not found: %v", err))
+		return nil, false
+	}
+	if stored.Revoked {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "the message was deleted for everyone and cannot be forwarded")
+		return nil, false
+	}
+	if stored.HasMedia {
+		writeError(w, http.StatusNotFound, ErrCodeNoMedia, "no raw proto stored for this message")
+		return nil, false
+	}
+	if stored.Body == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "this kind of message cannot be forwarded")
+		return nil, false
+	}
+	if !stored.Forwarded {
+		return &waE2E.Message{Conversation: proto.String(stored.Body)}, true
+	}
+	return &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+		Text: proto.String(stored.Body),
+		ContextInfo: &waE2E.ContextInfo{
+			IsForwarded:     proto.Bool(true),
+			ForwardingScore: proto.Uint32(uint32(stored.ForwardingScore)),
+		},
+	}}, true
+}
+
+// storeForwarded stores a message forwarded through the bridge, keeping the
+// proto of media so the copy can itself be downloaded or forwarded, and
+// updates the chat preview.
+func (s *Server) storeForwarded(formattedID, internalChatJID string, msg *waE2E.Message, ts int64) {
+	body := extractMessageBody(msg)
+	mediaType := getMediaType(msg)
+	var rawProto []byte
+	if mediaType != nil {
+		var err error
+		if rawProto, err = proto.Marshal(msg); err != nil {
+			log.Printf("Error marshalling proto for forwarded message %s: %v", formattedID, err)
+		}
+	}
+	if err := s.store.UpsertMessage(
+		formattedID, internalChatJID, ownJIDString(s.wa), "", true,
+		body, ts, hasMediaContent(msg), mediaType, rawProto,
+	); err != nil {
+		log.Printf("Error storing forwarded message: %v", err)
+		return
+	}
+	if err := s.store.SetMessageSource(formattedID, MessageSourceBridge); err != nil {
+		log.Printf("Error storing forwarded message source: %v", err)
+	}
+	recordForwarding(s.store, formattedID, msg)
+	if body != "" {
+		if err := s.store.UpdateChatLastMessage(internalChatJID, truncate(body, 100), ts); err != nil {
+			log.Printf("Error updating chat last message: %v", err)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 51. POST /session/reset — log out and pair again, keeping the stored
+// history; always needs confirm=true
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleSessionReset(w http.ResponseWriter, r *http.Request) {
+	if !s.confirm.require(w, r, false) {
+		return
+	}
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	if err := s.wc.ResetSession(ctx); err != nil {
+		if errors.Is(err, errNotPaired) {
+			writeError(w, http.StatusConflict, ErrCodeNotPaired, "not paired: there is no session to reset")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("reset session: %v", err))
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Session reset; fetch GET /qr to pair again",
+	})
+}
+
+// ---------------------------------------------------------------------------
+// 52. POST /chats/{chatId}/archive, POST /chats/{chatId}/unarchive — archive
+// or unarchive a chat, on the phone too
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleArchiveChat(w http.ResponseWriter, r *http.Request) {
+	s.setChatArchived(w, r, true)
+}
+
+func (s *Server) handleUnarchiveChat(w http.ResponseWriter, r *http.Request) {
+	s.setChatArchived(w, r, false)
+}
+
+func (s *Server) setChatArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	lastTs, lastKey, err := s.lastMessageKey(jid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 15*time.Second)
+	defer cancel()
+	if err := s.wa.SendAppState(ctx, appstate.BuildArchive(jid, archived, lastTs, lastKey)); err != nil {
+		writeWAError(w, r, ctx, "archive chat", err)
+		return
+	}
+	if err := s.store.SetChatArchived(jid.String(), archived); err != nil {
+		log.Printf("Error setting archived of %s: %v", jid, err)
+	}
+
+	writeJSON(w, map[string]bool{"success": true, "archived": archived})
+}
+
+// lastMessageKey returns the time and key of the newest stored message in
+// chatJID, which WhatsApp wants with changes to a chat's app state, or a zero
+// time and nil key if there is none.
+func (s *Server) lastMessageKey(chatJID types.JID) (time.Time, *waCommon.MessageKey, error) {
+	messageID, err := s.store.GetLatestMessageID(chatJID.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil, nil
+	}
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	parts := parseMessageIDParts(messageID)
+	if parts == nil {
+		return time.Time{}, nil, nil
+	}
+	msg, err := s.store.GetMessageByRawID(chatJID.String(), parts.messageID)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	key := &waCommon.MessageKey{
+		RemoteJID: proto.String(chatJID.String()),
+		FromMe:    proto.Bool(parts.fromMe),
+		ID:        proto.String(parts.messageID),
+	}
+	if chatJID.Server == types.GroupServer && !parts.fromMe {
+		key.Participant = proto.String(toInternalJID(msg.From))
+	}
+	return time.Unix(msg.Timestamp, 0), key, nil
+}
+
+// ---------------------------------------------------------------------------
+// 53. POST /chats/{chatId}/mute, POST /chats/{chatId}/unmute — mute a chat,
+// for a while or until unmuted, on the phone too
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleMuteChat(w http.ResponseWriter, r *http.Request) {
+	var req MuteChatRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	now := time.Now().Unix()
+	until := int64(MutedForever)
+	switch {
+	case req.Duration < 0 || req.Until < 0:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "duration and until must be positive")
+		return
+	case req.Duration > 0 && req.Until > 0:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "give duration or until, not both")
+		return
+	case req.Duration > 0:
+		until = now + req.Duration
+	case req.Until > 0:
+		if req.Until <= now {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidParam, "until is in the past")
+			return
+		}
+		until = req.Until
+	}
+	s.setChatMute(w, r, req.TimeoutMs, until)
+}
+
+func (s *Server) handleUnmuteChat(w http.ResponseWriter, r *http.Request) {
+	s.setChatMute(w, r, queryInt(r, "timeoutMs"), 0)
+}
+
+// setChatMute mutes the chat until the unix time until, or MutedForever, or
+// unmutes it for 0.
+func (s *Server) setChatMute(w http.ResponseWriter, r *http.Request, timeoutMs int, until int64) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	var endMs *int64
+	if until > 0 {
+		endMs = proto.Int64(until * 1000)
+	}
+
+	ctx, cancel := requestContext(r, timeoutMs, 15*time.Second)
+	defer cancel()
+	if err := s.wa.SendAppState(ctx, appstate.BuildMuteAbs(jid, until != 0, endMs)); err != nil {
+		writeWAError(w, r, ctx, "mute chat", err)
+		return
+	}
+	if err := s.store.SetChatMutedUntil(jid.String(), until); err != nil {
+		log.Printf("Error setting mute of %s: %v", jid, err)
+	}
+
+	writeJSON(w, map[string]interface{}{"success": true, "muted": until != 0, "mutedUntil": until})
+}
+
+// ---------------------------------------------------------------------------
+// 54. POST /resync-appstate — fetch contact names and chat settings from the
+// phone again and report which chats changed
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleResyncAppState(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), 60*time.Second)
+	defer cancel()
+	result, err := s.wc.ResyncAppState(ctx)
+	if errors.Is(err, errNotPaired) {
+		writeError(w, http.StatusConflict, ErrCodeNotPaired, "not paired: there is no app state to resync")
+		return
+	}
+	if err != nil {
+		writeWAError(w, r, ctx, "resync app state", err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// ---------------------------------------------------------------------------
+// 55. POST /chats/{chatId}/pin, DELETE /chats/{chatId}/pin?messageId=,
+// GET /chats/{chatId}/pins — pin messages in a chat
+// ---------------------------------------------------------------------------
+
+// Pins are kept by the bridge only, and responses say so with localOnly:
+// whatsmeow cannot send them, as it does not mark a PinInChatMessage as the
+// edit WhatsApp expects. Pins made on the phone or by other members are
+// mirrored here (see applyPin).
+func (s *Server) handlePinMessage(w http.ResponseWriter, r *http.Request) {
+	var req PinMessageRequest
+	if !decodeJSONBody(w, r, maxSmallBodyBytes, &req) {
+		return
+	}
+	s.setMessagePinned(w, r, req.MessageID, true)
+}
+
+func (s *Server) handleUnpinMessage(w http.ResponseWriter, r *http.Request) {
+	s.setMessagePinned(w, r, r.URL.Query().Get("messageId"), false)
+}
+
+func (s *Server) setMessagePinned(w http.ResponseWriter, r *http.Request, messageID string, pinned bool) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	if messageID == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeMissingField, "messageId is required")
+		return
+	}
+	parts := parseMessageIDParts(messageID)
+	if parts == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId format")
+		return
+	}
+	if parseAPIJID(parts.chatJID) != jid {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "messageId is not in this chat")
+		return
+	}
+
+	if err := s.store.SetMessagePinned(messageID, pinned); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "message not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true, "pinned": pinned, "localOnly": true})
+}
+
+func (s *Server) handlePinnedMessages(w http.ResponseWriter, r *http.Request) {
+	jid := parseAPIJID(r.PathValue("chatId"))
+	if !isValidJID(jid) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJID, "invalid chatId")
+		return
+	}
+	msgs, err := s.store.GetPinnedMessages(jid.String())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"messages": msgs})
+}
+
+// ---------------------------------------------------------------------------
+// 56. GET /diagnostics/history — why there are few or no messages, and what
+// to do about it; probe=true first asks the phone for recent messages
+// ---------------------------------------------------------------------------
+
+func (s *Server) handleHistoryDiagnostics(w http.ResponseWriter, r *http.Request) {
+	chats, err := s.store.GetChats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	status := s.wc.Status()
+
+	// The probe asks about the most recent chat and waits for the phone's
+	// answer, which settles whether it is reachable. By default it waits as
+	// long as the phone gets before counting as unreachable anyway.
+	unanswered := false
+	if r.URL.Query().Get("probe") == "true" && status == StatusReady && len(chats) > 0 {
+		ctx, cancel := requestContext(r, queryInt(r, "timeoutMs"), phoneAnswerWait)
+		defer cancel()
+		if err := s.wc.RequestRecentMessages(ctx, toInternalJID(chats[0].ID), 10); err != nil {
+			log.Printf("History probe failed: %v", err)
+		} else {
+			unanswered = !s.waitForHistoryAnswer(ctx)
+		}
+	}
+
+	stats := s.wc.historyStats()
+	d := HistoryDiagnosis{
+		Status:         status,
+		PhoneReachable: stats.phoneReachable(time.Now()),
+		HistorySyncs:   stats.Syncs,
+		Chats:          len(chats),
+	}
+	if unanswered {
+		d.PhoneReachable = new(bool)
+	}
+	if !stats.LastSyncAt.IsZero() {
+		ts := stats.LastSyncAt.Unix()
+		d.LastHistorySyncAt = &ts
+	}
+	if d.Messages, err = s.store.GetTotalMessageCount(); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	diagnoseHistory(&d)
+	writeJSON(w, d)
+}
+
+// waitForHistoryAnswer polls until the phone answers the latest on-demand
+// history request, and reports whether it did before ctx ended.
+func (s *Server) waitForHistoryAnswer(ctx context.Context) bool {
+	for {
+		stats := s.wc.historyStats()
+		if !stats.AnsweredAt.Before(stats.RequestedAt) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 57. POST /messages/{messageId}/star, POST /messages/{messageId}/unstar,
+// GET /starred — bookmark messages, in the bridge only
+// ---------------------------------------------------------------------------
+
+// Starred messages survive deleting or clearing their chat, here or on the
+// phone; unstar them first to have them deleted too.
+func (s *Server) handleStarMessage(w http.ResponseWriter, r *http.Request) {
+	s.setMessageStarred(w, r, true)
+}
+
+func (s *Server) handleUnstarMessage(w http.ResponseWriter, r *http.Request) {
+	s.setMessageStarred(w, r, false)
+}
+
+func (s *Server) setMessageStarred(w http.ResponseWriter, r *http.Request, starred bool) {
+	messageID := r.PathValue("messageId")
+	if parseMessageIDParts(messageID) == nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidMessageID, "invalid messageId")
+		return
+	}
+	if err := s.store.SetMessageStarred(messageID, starred); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "message not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true, "starred": starred})
+}
+
+func (s *Server) handleStarred(w http.ResponseWriter, r *http.Request) {
+	limit := clampedInt(r, "limit", s.limits.MessagesDefault, s.limits.MessagesMax)
+	msgs, err := s.store.GetStarredMessages(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"messages": msgs})
+}
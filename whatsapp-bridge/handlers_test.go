@@ -1,9 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
 )
 
+// newTestServer returns a Server backed by a real test store and a fake
+// WhatsApp API. The WAClient is left nil, so handlers that need the live
+// connection lifecycle can't be exercised.
+func newTestServer(t *testing.T) (*Server, *fakeWA) {
+	t.Helper()
+	fake := newFakeWA()
+	return &Server{store: newTestStore(t), wa: fake, limits: defaultResultLimits}, fake
+}
+
+// serve routes a single request through a mux with the given pattern so that
+// path values are populated like in main.
+func serve(t *testing.T, pattern string, h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, h)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
 func TestStripDataURL(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -42,6 +78,26 @@ func TestStripDataURL(t *testing.T) {
 	}
 }
 
+func TestClampedInt(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 50},
+		{"limit=10", 10},
+		{"limit=0", 50},
+		{"limit=-3", 50},
+		{"limit=abc", 50},
+		{"limit=100000", 1000},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/x?"+tt.query, nil)
+		if got := clampedInt(r, "limit", 50, 1000); got != tt.want {
+			t.Errorf("clampedInt(%q) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
 func TestBoolToInt(t *testing.T) {
 	if boolToInt(true) != 1 {
 		t.Error("boolToInt(true) != 1")
@@ -72,3 +128,2012 @@ func TestTruncate(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleMessages_FromStore(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chatJID, "Test", false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "first", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "", false, "second", 200, false, nil, nil)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?limit=1", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var resp MessagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].Body != "second" {
+		t.Errorf("messages = %+v, want only the newest", resp.Messages)
+	}
+	if !resp.FromCache {
+		t.Error("fromCache should be true without refresh")
+	}
+}
+
+func TestHandleMessages_ClampsLimit(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "hi", 100, false, nil, nil)
+
+	for _, tt := range []struct {
+		query       string
+		wantClamped bool
+	}{
+		{"limit=100000", true},
+		{"limit=20", false},
+	} {
+		req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?"+tt.query, nil)
+		rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+		var resp MessagesResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.Clamped != tt.wantClamped || len(resp.Messages) != 1 {
+			t.Errorf("%s: clamped = %v, messages = %d", tt.query, resp.Clamped, len(resp.Messages))
+		}
+		if tt.wantClamped && resp.Limit != maxMessagesPerQuery {
+			t.Errorf("%s: limit = %d, want %d", tt.query, resp.Limit, maxMessagesPerQuery)
+		}
+	}
+}
+
+func TestHandleMessages_IncludeSender(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "hi", 100, false, nil, nil)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	var resp MessagesResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Messages[0].SenderNumber != nil || resp.Messages[0].SenderName != nil {
+		t.Errorf("sender details without opt-in: %+v", resp.Messages[0])
+	}
+
+	req = httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?includeSender=true", nil)
+	rec = serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	resp = MessagesResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	m := resp.Messages[0]
+	if m.SenderNumber == nil || *m.SenderNumber != "+10000000001" || m.SenderName == nil || *m.SenderName != "+10000000001" {
+		t.Errorf("with includeSender: %+v", m)
+	}
+}
+
+func TestHandleMessages_EmptyChat(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+
+	var resp MessagesResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Empty == nil || !*resp.Empty {
+		t.Error("empty should be set for a chat with no messages")
+	}
+}
+
+func TestHandleMessages_CountOnly(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chatJID, "Test", false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "first", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "", false, "second", 200, false, nil, nil)
+	srv.store.IncrementUnread(chatJID)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?count=true", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "first") {
+		t.Errorf("count-only response includes message bodies: %s", rec.Body.String())
+	}
+	var got MessageCounts
+	json.NewDecoder(rec.Body).Decode(&got)
+	want := MessageCounts{Count: 2, UnreadCount: 1, OldestTs: 100, NewestTs: 200}
+	if got != want {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+
+	// Unknown chat: zeros, not an error
+	req = httptest.NewRequest("GET", "/chats/19999999999@c.us/messages?count=true", nil)
+	rec = serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	got = MessageCounts{}
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != http.StatusOK || got != (MessageCounts{}) {
+		t.Errorf("unknown chat: status = %d, counts = %+v", rec.Code, got)
+	}
+}
+
+func TestHandleMessages_StreamMatchesBuffered(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "Alice", false, "first", 100, false, nil, nil)
+	srv.store.UpsertMessage("true_10000000001@c.us_MSG2", chatJID, "", "", true, "second \"quoted\"", 200, false, nil, nil)
+
+	for _, chat := range []string{"10000000001@c.us", "10000000002@c.us"} {
+		req := httptest.NewRequest("GET", "/chats/"+chat+"/messages?limit=5000", nil)
+		rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+
+		messages, err := srv.store.GetMessages(toInternalJID(chat), maxMessagesPerQuery, 0)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		want := MessagesResponse{Messages: messages, MessagesMeta: MessagesMeta{FromCache: true, Clamped: true, Limit: maxMessagesPerQuery}}
+		if len(messages) == 0 {
+			empty := true
+			want.Empty = &empty
+		}
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(want)
+		if rec.Body.String() != buf.String() {
+			t.Errorf("%s: streamed body\n%s\nwant\n%s", chat, rec.Body.String(), buf.String())
+		}
+	}
+}
+
+func TestHandleMessages_StreamErrorAfterFirstMessage(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "Alice", false, "first", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "Alice", false, "second", 200, false, nil, nil)
+	// The older message no longer scans
+	srv.store.db.Exec(`UPDATE messages SET from_me = 'x' WHERE id = 'false_10000000001@c.us_MSG1'`)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	var resp MessagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, rec.Body.String())
+	}
+	if rec.Code != http.StatusOK || len(resp.Messages) != 1 || resp.Error == "" || resp.Code != ErrCodeInternal {
+		t.Errorf("status = %d, response = %+v; want the first message then the error", rec.Code, resp)
+	}
+}
+
+func TestHandleMessageByRawID(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_ABC", chatJID, chatJID, "", false, "hi", 100, false, nil, nil)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages/ABC", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages/{rawId}", srv.handleMessageByRawID, req)
+	var msg Message
+	json.NewDecoder(rec.Body).Decode(&msg)
+	if rec.Code != http.StatusOK || msg.ID != "false_10000000001@c.us_ABC" || msg.Body != "hi" {
+		t.Errorf("status = %d, message = %+v", rec.Code, msg)
+	}
+
+	req = httptest.NewRequest("GET", "/chats/10000000001@c.us/messages/NOPE", nil)
+	rec = serve(t, "GET /chats/{chatId}/messages/{rawId}", srv.handleMessageByRawID, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown id: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleChats(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	req := httptest.NewRequest("GET", "/chats", nil)
+	rec := serve(t, "GET /chats", srv.handleChats, req)
+
+	var resp struct {
+		Chats []Chat `json:"chats"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Chats) != 1 || resp.Chats[0].ID != "10000000001@c.us" {
+		t.Errorf("chats = %+v", resp.Chats)
+	}
+}
+
+func TestHandleChats_Paged(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for i, user := range []string{"10000000001", "10000000002", "10000000003"} {
+		ts := int64(100 * (i + 1))
+		srv.store.UpsertChat(user+"@s.whatsapp.net", "", false, nil, &ts)
+	}
+
+	page := func(query string) (ids []string, hasMore bool) {
+		t.Helper()
+		rec := serve(t, "GET /chats", srv.handleChats, httptest.NewRequest("GET", "/chats?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Chats   []Chat `json:"chats"`
+			HasMore bool   `json:"hasMore"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		for _, c := range resp.Chats {
+			ids = append(ids, c.ID)
+		}
+		return ids, resp.HasMore
+	}
+
+	if ids, more := page("limit=2"); strings.Join(ids, ",") != "10000000003@c.us,10000000002@c.us" || !more {
+		t.Errorf("first page = %v, hasMore %v", ids, more)
+	}
+	if ids, more := page("limit=2&offset=2"); strings.Join(ids, ",") != "10000000001@c.us" || more {
+		t.Errorf("last page = %v, hasMore %v", ids, more)
+	}
+	if ids, more := page("offset=1"); len(ids) != 2 || more {
+		t.Errorf("default limit = %v, hasMore %v", ids, more)
+	}
+	rec := serve(t, "GET /chats", srv.handleChats, httptest.NewRequest("GET", "/chats?offset=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative offset: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleArchiveChat(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := "120363000000000001@g.us"
+	srv.store.UpsertChat(group, "Team", true, nil, nil)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+	srv.store.UpsertMessage("false_120363000000000001@g.us_LAST", group, "10000000002@s.whatsapp.net", "", false, "hi", 200, false, nil, nil)
+
+	post := func(pattern, path string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		return serve(t, pattern, h, httptest.NewRequest("POST", path, nil))
+	}
+	listed := func(query string) int {
+		rec := serve(t, "GET /chats", srv.handleChats, httptest.NewRequest("GET", "/chats"+query, nil))
+		var resp struct {
+			Chats []Chat `json:"chats"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return len(resp.Chats)
+	}
+
+	if rec := post("POST /chats/{chatId}/archive", "/chats/garbage/archive", srv.handleArchiveChat); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid chatId: status = %d, want 400", rec.Code)
+	}
+	rec := post("POST /chats/{chatId}/archive", "/chats/"+group+"/archive", srv.handleArchiveChat)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("archive: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.appState) != 1 {
+		t.Fatalf("sent %d app state patches, want 1", len(fake.appState))
+	}
+	action := fake.appState[0].Mutations[0].Value.GetArchiveChatAction()
+	key := action.GetMessageRange().GetMessages()[0].GetKey()
+	if !action.GetArchived() || key.GetID() != "LAST" || key.GetParticipant() != "10000000002@s.whatsapp.net" {
+		t.Errorf("archive action = %+v", action)
+	}
+	if n := listed(""); n != 1 {
+		t.Errorf("listed %d chats, want the archived one left out", n)
+	}
+	if n := listed("?includeArchived=true"); n != 2 {
+		t.Errorf("listed %d chats with includeArchived, want 2", n)
+	}
+
+	if rec := post("POST /chats/{chatId}/unarchive", "/chats/"+group+"/unarchive", srv.handleUnarchiveChat); rec.Code != http.StatusOK {
+		t.Fatalf("unarchive: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := listed(""); n != 2 {
+		t.Errorf("listed %d chats after unarchive, want 2", n)
+	}
+}
+
+func TestHandlePinMessage(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chat := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_OLD", chat, chat, "Alice", false, "old", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_NEW", chat, chat, "Alice", false, "new", 200, false, nil, nil)
+
+	pin := func(messageID string) *httptest.ResponseRecorder {
+		body := `{"messageId":"` + messageID + `"}`
+		return serve(t, "POST /chats/{chatId}/pin", srv.handlePinMessage,
+			httptest.NewRequest("POST", "/chats/10000000001@c.us/pin", strings.NewReader(body)))
+	}
+	pinned := func() []string {
+		rec := serve(t, "GET /chats/{chatId}/pins", srv.handlePinnedMessages, httptest.NewRequest("GET", "/chats/10000000001@c.us/pins", nil))
+		var resp struct {
+			Messages []Message `json:"messages"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		ids := []string{}
+		for _, m := range resp.Messages {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		messageID string
+		want      int
+	}{
+		{"garbage", http.StatusBadRequest},
+		{"false_10000000002@c.us_OLD", http.StatusBadRequest}, // another chat
+		{"false_10000000001@c.us_MISSING", http.StatusNotFound},
+		{"false_10000000001@c.us_OLD", http.StatusOK},
+		{"false_10000000001@s.whatsapp.net_NEW", http.StatusOK}, // legacy form
+	}
+	for _, tt := range tests {
+		if rec := pin(tt.messageID); rec.Code != tt.want {
+			t.Errorf("pin %s: status = %d, want %d: %s", tt.messageID, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+	if got := pinned(); !reflect.DeepEqual(got, []string{"false_10000000001@c.us_NEW", "false_10000000001@c.us_OLD"}) {
+		t.Errorf("pinned = %v", got)
+	}
+
+	rec := serve(t, "DELETE /chats/{chatId}/pin", srv.handleUnpinMessage,
+		httptest.NewRequest("DELETE", "/chats/10000000001@c.us/pin?messageId=false_10000000001@c.us_NEW", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"localOnly":true`) {
+		t.Fatalf("unpin: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := pinned(); !reflect.DeepEqual(got, []string{"false_10000000001@c.us_OLD"}) {
+		t.Errorf("pinned after unpin = %v", got)
+	}
+}
+
+func TestHandleStarMessage(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chat := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chat, "Alice", false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_M1", chat, chat, "Alice", false, "remember this", 100, false, nil, nil)
+
+	star := func(action, messageID string) *httptest.ResponseRecorder {
+		pattern := "POST /messages/{messageId}/" + action
+		h := srv.handleStarMessage
+		if action == "unstar" {
+			h = srv.handleUnstarMessage
+		}
+		return serve(t, pattern, h, httptest.NewRequest("POST", "/messages/"+messageID+"/"+action, nil))
+	}
+	starred := func() []SearchResult {
+		rec := serve(t, "GET /starred", srv.handleStarred, httptest.NewRequest("GET", "/starred", nil))
+		var resp struct {
+			Messages []SearchResult `json:"messages"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Messages
+	}
+
+	if rec := star("star", "garbage"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid messageId: status = %d, want 400", rec.Code)
+	}
+	if rec := star("star", "false_10000000001@c.us_NONE"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing message: status = %d, want 404", rec.Code)
+	}
+	if rec := star("star", "false_10000000001@c.us_M1"); rec.Code != http.StatusOK {
+		t.Fatalf("star: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := starred(); len(got) != 1 || got[0].ChatName != "Alice" || got[0].ChatJID != "10000000001@c.us" || got[0].Body != "remember this" {
+		t.Errorf("starred = %+v", got)
+	}
+	if rec := star("unstar", "false_10000000001@c.us_M1"); rec.Code != http.StatusOK {
+		t.Fatalf("unstar: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := starred(); len(got) != 0 {
+		t.Errorf("starred after unstar = %+v", got)
+	}
+}
+
+func TestHandleMuteChat(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chat := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chat, "Alice", false, nil, nil)
+
+	mute := func(body string) *httptest.ResponseRecorder {
+		return serve(t, "POST /chats/{chatId}/mute", srv.handleMuteChat,
+			httptest.NewRequest("POST", "/chats/"+chat+"/mute", strings.NewReader(body)))
+	}
+	mutedUntil := func() int64 {
+		chats, _ := srv.store.GetChats()
+		return chats[0].MutedUntil
+	}
+
+	for _, body := range []string{`{"duration":-5}`, `{"until":100}`, `{"duration":60,"until":9999999999}`} {
+		if rec := mute(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if len(fake.appState) != 0 {
+		t.Fatalf("sent %d app state patches for bad requests", len(fake.appState))
+	}
+
+	before := time.Now().Unix()
+	if rec := mute(`{"duration":3600}`); rec.Code != http.StatusOK {
+		t.Fatalf("mute: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	action := fake.appState[0].Mutations[0].Value.GetMuteAction()
+	if got := mutedUntil(); got < before+3600 || got > time.Now().Unix()+3600 {
+		t.Errorf("mutedUntil = %d, want an hour from now", got)
+	}
+	if !action.GetMuted() || action.GetMuteEndTimestamp() != mutedUntil()*1000 {
+		t.Errorf("mute action = %+v", action)
+	}
+
+	if rec := mute(`{}`); rec.Code != http.StatusOK || mutedUntil() != MutedForever {
+		t.Errorf("mute forever: status = %d, mutedUntil = %d", rec.Code, mutedUntil())
+	}
+	if action := fake.appState[1].Mutations[0].Value.GetMuteAction(); action.GetMuteEndTimestamp() != -1 {
+		t.Errorf("mute forever action = %+v", action)
+	}
+
+	rec := serve(t, "POST /chats/{chatId}/unmute", srv.handleUnmuteChat, httptest.NewRequest("POST", "/chats/"+chat+"/unmute", nil))
+	if rec.Code != http.StatusOK || mutedUntil() != 0 {
+		t.Errorf("unmute: status = %d, mutedUntil = %d", rec.Code, mutedUntil())
+	}
+	if fake.appState[2].Mutations[0].Value.GetMuteAction().GetMuted() {
+		t.Error("unmute sent a mute")
+	}
+}
+
+func TestHandleChats_Filters(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+	srv.store.UpsertChat("10000000002@s.whatsapp.net", "Bob", false, nil, nil)
+	srv.store.UpsertChat("120363000000000001@g.us", "Team", true, nil, nil)
+	srv.store.UpsertChat("120363000000000002@g.us", "Family", true, nil, nil)
+	srv.store.SetUnread("10000000002@s.whatsapp.net", 2)
+	srv.store.SetUnread("120363000000000001@g.us", 1)
+
+	list := func(query string) string {
+		t.Helper()
+		rec := serve(t, "GET /chats", srv.handleChats, httptest.NewRequest("GET", "/chats?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Chats []Chat `json:"chats"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		var names []string
+		for _, c := range resp.Chats {
+			names = append(names, c.Name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct{ query, want string }{
+		{"unreadOnly=true", "Bob,Team"},
+		{"type=group", "Family,Team"},
+		{"type=individual", "Alice,Bob"},
+		{"unreadOnly=true&type=individual", "Bob"},
+	}
+	for _, tt := range tests {
+		if got := list(tt.query); got != tt.want {
+			t.Errorf("%s: chats = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+	rec := serve(t, "GET /chats", srv.handleChats, httptest.NewRequest("GET", "/chats?type=broadcast", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown type: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleSend_Validation(t *testing.T) {
+	srv, fake := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode string
+	}{
+		{"invalid json", `{`, ErrCodeInvalidBody},
+		{"missing message", `{"chatId":"10000000001@c.us"}`, ErrCodeMissingField},
+		{"missing chatId", `{"message":"hi"}`, ErrCodeMissingField},
+		{"invalid chatId", `{"chatId":"garbage","message":"hi"}`, ErrCodeInvalidJID},
+		{"bad quoted id", `{"chatId":"10000000001@c.us","message":"hi","quotedMessageId":"junk"}`, ErrCodeInvalidMessageID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/send", strings.NewReader(tt.body))
+			rec := serve(t, "POST /send", srv.handleSend, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			var body map[string]string
+			json.NewDecoder(rec.Body).Decode(&body)
+			if body["code"] != tt.wantCode || body["error"] == "" {
+				t.Errorf("error body = %v, want code %q", body, tt.wantCode)
+			}
+		})
+	}
+	if len(fake.sent) != 0 {
+		t.Errorf("nothing should be sent on validation errors, got %d", len(fake.sent))
+	}
+}
+
+func TestHandleSend_StoresAndReturnsID(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chatJID, "Alice", false, nil, nil)
+
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(`{"chatId":"10000000001@c.us","message":"hello"}`))
+	rec := serve(t, "POST /send", srv.handleSend, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Success   bool   `json:"success"`
+		MessageID string `json:"messageId"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Success || resp.MessageID != "true_10000000001@c.us_FAKEID" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	if len(fake.sent) != 1 || fake.sent[0].to.String() != chatJID {
+		t.Fatalf("sent = %+v", fake.sent)
+	}
+	if fake.sent[0].msg.GetConversation() != "hello" {
+		t.Errorf("sent body = %q", fake.sent[0].msg.GetConversation())
+	}
+
+	msgs, _ := srv.store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].Body != "hello" || !msgs[0].FromMe || msgs[0].Source != MessageSourceBridge {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+}
+
+func TestHandleSend_BroadcastList(t *testing.T) {
+	srv, fake := newTestServer(t)
+	list := "1700000000@broadcast"
+
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(`{"chatId":"1700000000@broadcast","message":"hi all"}`))
+	rec := serve(t, "POST /send", srv.handleSend, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("no members: status = %d, want 404", rec.Code)
+	}
+
+	srv.store.SetBroadcastListMembers(list, []string{"10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net"})
+	req = httptest.NewRequest("POST", "/send", strings.NewReader(`{"chatId":"1700000000@broadcast","message":"hi all"}`))
+	rec = serve(t, "POST /send", srv.handleSend, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Success bool                  `json:"success"`
+		Sent    int                   `json:"sent"`
+		Results []BroadcastSendResult `json:"results"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Success || resp.Sent != 2 || len(resp.Results) != 2 || resp.Results[1].MessageID != "true_10000000002@c.us_FAKEID" {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(fake.sent) != 2 || fake.sent[0].to.String() != "10000000001@s.whatsapp.net" || fake.sent[1].msg.GetConversation() != "hi all" {
+		t.Errorf("sent = %+v", fake.sent)
+	}
+	if msgs, _ := srv.store.GetMessages("10000000002@s.whatsapp.net", 10, 0); len(msgs) != 1 || msgs[0].Body != "hi all" {
+		t.Errorf("member chat messages = %+v", msgs)
+	}
+}
+
+func TestHandleSend_BroadcastListDuplicateMembers(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.sendLimiter = newSendLimiter(sendLimits{Global: 10, PerChat: 1, Window: time.Minute})
+	list := "1700000000@broadcast"
+	srv.store.SetBroadcastListMembers(list, []string{"10000000001@s.whatsapp.net", "10000000001:2@s.whatsapp.net"})
+
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(`{"chatId":"1700000000@broadcast","message":"hi all"}`))
+	rec := serve(t, "POST /send", srv.handleSend, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.sent) != 1 || fake.sent[0].to.String() != "10000000001@s.whatsapp.net" {
+		t.Errorf("sent = %+v, want one message to the member", fake.sent)
+	}
+}
+
+func TestHandleSend_QuotedReply(t *testing.T) {
+	srv, fake := newTestServer(t)
+
+	body := `{"chatId":"10000000001@c.us","message":"reply","quotedMessageId":"false_10000000001@c.us_ORIG"}`
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(body))
+	rec := serve(t, "POST /send", srv.handleSend, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	ext := fake.sent[0].msg.GetExtendedTextMessage()
+	if ext == nil || ext.GetContextInfo().GetStanzaID() != "ORIG" {
+		t.Errorf("expected extended text quoting ORIG, got %+v", fake.sent[0].msg)
+	}
+}
+
+func TestHandleSend_QuotedMatch(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := "120363000000000001@g.us"
+	alice, bob := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net"
+	srv.store.UpsertMessage("false_120363000000000001@g.us_A1", group, alice, "Alice", false, "Lunch at 1?", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_120363000000000001@g.us_B1", group, bob, "Bob", false, "lunch sounds good", 200, false, nil, nil)
+	srv.store.UpsertMessage("false_120363000000000001@g.us_A2", group, alice, "Alice", false, "100% in", 300, false, nil, nil)
+
+	send := func(match string) *httptest.ResponseRecorder {
+		body := `{"chatId":"120363000000000001@g.us","message":"ok","quotedMatch":` + match + `}`
+		return serve(t, "POST /send", srv.handleSend, httptest.NewRequest("POST", "/send", strings.NewReader(body)))
+	}
+
+	tests := []struct {
+		match           string
+		wantStanza      string
+		wantParticipant string
+	}{
+		{`{"text":"LUNCH"}`, "B1", bob},
+		{`{"from":"10000000001@c.us","text":"lunch"}`, "A1", alice},
+		{`{"text":"100%"}`, "A2", alice},
+	}
+	for _, tt := range tests {
+		rec := send(tt.match)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.match, rec.Code, rec.Body.String())
+		}
+		ci := fake.sent[len(fake.sent)-1].msg.GetExtendedTextMessage().GetContextInfo()
+		if ci.GetStanzaID() != tt.wantStanza || ci.GetParticipant() != tt.wantParticipant {
+			t.Errorf("%s: quoted %q from %q, want %q from %q", tt.match, ci.GetStanzaID(), ci.GetParticipant(), tt.wantStanza, tt.wantParticipant)
+		}
+	}
+
+	errTests := []struct {
+		match    string
+		wantCode int
+	}{
+		{`{"text":"10%"}`, http.StatusNotFound}, // % is literal, not a wildcard
+		{`{"from":"me","text":"lunch"}`, http.StatusNotFound},
+		{`{"text":""}`, http.StatusBadRequest},
+		{`{"from":"garbage","text":"lunch"}`, http.StatusBadRequest},
+	}
+	for _, tt := range errTests {
+		if rec := send(tt.match); rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.match, rec.Code, tt.wantCode)
+		}
+	}
+
+	body := `{"chatId":"120363000000000001@g.us","message":"ok","quotedMessageId":"false_120363000000000001@g.us_A1","quotedMatch":{"text":"lunch"}}`
+	rec := serve(t, "POST /send", srv.handleSend, httptest.NewRequest("POST", "/send", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("both quote forms: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleReact(t *testing.T) {
+	srv, fake := newTestServer(t)
+
+	req := httptest.NewRequest("POST", "/react", strings.NewReader(`{"messageId":"junk","emoji":"👍"}`))
+	rec := serve(t, "POST /react", srv.handleReact, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid messageId: status = %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/react", strings.NewReader(`{"messageId":"false_10000000001@c.us_MSG1","emoji":"👍"}`))
+	rec = serve(t, "POST /react", srv.handleReact, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	reaction := fake.sent[0].msg.GetReactionMessage()
+	if reaction.GetText() != "👍" || reaction.GetKey().GetID() != "MSG1" {
+		t.Errorf("reaction = %+v", reaction)
+	}
+}
+
+func TestHandleReact_GroupMessageKeyedBySender(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := "120363000000000001@g.us"
+	srv.store.UpsertMessage("false_120363000000000001@g.us_THEIRS", group, "10000000002@s.whatsapp.net", "", false, "hi", 100, false, nil, nil)
+
+	for _, body := range []string{
+		`{"messageId":"false_120363000000000001@g.us_THEIRS","emoji":"👍"}`,
+		`{"messageId":"true_120363000000000001@g.us_MINE","emoji":"👍"}`,
+	} {
+		if rec := serve(t, "POST /react", srv.handleReact, httptest.NewRequest("POST", "/react", strings.NewReader(body))); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if key := fake.sent[0].msg.GetReactionMessage().GetKey(); key.GetParticipant() != "10000000002@s.whatsapp.net" {
+		t.Errorf("reaction to someone else's message: key = %+v, want their JID as participant", key)
+	}
+	if key := fake.sent[1].msg.GetReactionMessage().GetKey(); key.Participant != nil {
+		t.Errorf("reaction to own message: key = %+v, want no participant", key)
+	}
+}
+
+func TestHandleReactLast(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := "120363000000000001@g.us"
+	srv.store.UpsertMessage("true_120363000000000001@g.us_OLD", group, "", "", true, "older", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_120363000000000001@g.us_NEW", group, "10000000002@s.whatsapp.net", "", false, "newest", 200, false, nil, nil)
+
+	reactLast := func(body string) *httptest.ResponseRecorder {
+		return serve(t, "POST /react-last", srv.handleReactLast, httptest.NewRequest("POST", "/react-last", strings.NewReader(body)))
+	}
+	if rec := reactLast(`{"chatId":"10000000001@c.us","emoji":"👍"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("empty chat: status = %d, want 404", rec.Code)
+	}
+	if rec := reactLast(`{"chatId":"120363000000000001@g.us"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no emoji: status = %d, want 400", rec.Code)
+	}
+
+	rec := reactLast(`{"chatId":"120363000000000001@g.us","emoji":"👍"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		MessageID string `json:"messageId"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.MessageID != "false_120363000000000001@g.us_NEW" {
+		t.Errorf("messageId = %q", resp.MessageID)
+	}
+	key := fake.sent[0].msg.GetReactionMessage().GetKey()
+	if key.GetID() != "NEW" || key.GetFromMe() || key.GetRemoteJID() != "120363000000000001@g.us" {
+		t.Errorf("reaction key = %+v", key)
+	}
+}
+
+func TestHandleForward(t *testing.T) {
+	srv, fake := newTestServer(t)
+	src := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("false_10000000001@c.us_TEXT", src, src, "", false, "hello there", 100, false, nil, nil)
+	img, _ := proto.Marshal(&waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+		Caption:     proto.String("a photo"),
+		DirectPath:  proto.String("/v/t62/img"),
+		ContextInfo: &waE2E.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(2), StanzaID: proto.String("QUOTED")},
+	}})
+	image := "image"
+	srv.store.UpsertMessage("false_10000000001@c.us_IMG", src, src, "", false, "a photo", 200, true, &image, img)
+
+	forward := func(body string) *httptest.ResponseRecorder {
+		return serve(t, "POST /forward", srv.handleForward, httptest.NewRequest("POST", "/forward", strings.NewReader(body)))
+	}
+	if rec := forward(`{"sourceMessageId":"false_10000000001@c.us_NONE","targetChatId":"10000000002@c.us"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown message: status = %d, want 404", rec.Code)
+	}
+	if rec := forward(`{"sourceMessageId":"false_10000000001@c.us_TEXT"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no target: status = %d, want 400", rec.Code)
+	}
+	srv.store.UpsertMessage("false_10000000001@c.us_GONE", src, src, "", false, "oops", 50, false, nil, nil)
+	srv.store.RevokeMessage("false_10000000001@c.us_GONE")
+	if rec := forward(`{"sourceMessageId":"false_10000000001@c.us_GONE","targetChatId":"10000000002@c.us"}`); rec.Code != http.StatusBadRequest || len(fake.sent) != 0 {
+		t.Errorf("revoked message: status = %d, sent = %d; want 400 and nothing sent", rec.Code, len(fake.sent))
+	}
+
+	rec := forward(`{"sourceMessageId":"false_10000000001@c.us_TEXT","targetChatId":"10000000002@c.us"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("text: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		MessageID string `json:"messageId"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.MessageID != "true_10000000002@c.us_FAKEID" {
+		t.Errorf("messageId = %q", resp.MessageID)
+	}
+	text := fake.sent[0].msg.GetExtendedTextMessage()
+	if text.GetText() != "hello there" || !text.GetContextInfo().GetIsForwarded() || text.GetContextInfo().GetForwardingScore() != 1 {
+		t.Errorf("forwarded text = %+v", text)
+	}
+
+	rec = forward(`{"sourceMessageId":"false_10000000001@c.us_IMG","targetChatId":"10000000002@c.us"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("image: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	sent := fake.sent[1].msg.GetImageMessage()
+	ci := sent.GetContextInfo()
+	if sent.GetDirectPath() != "/v/t62/img" || !ci.GetIsForwarded() || ci.GetForwardingScore() != 3 || ci.GetStanzaID() != "" {
+		t.Errorf("forwarded image = %+v", sent)
+	}
+
+	msgs, _ := srv.store.GetMessages("10000000002@s.whatsapp.net", 10, 0)
+	if len(msgs) != 1 {
+		t.Fatalf("stored %d messages in the target chat, want 1", len(msgs))
+	}
+	if m := msgs[0]; !m.Forwarded || m.ForwardingScore != 3 || !m.HasMedia || m.Body != "a photo" {
+		t.Errorf("stored forward = %+v", m)
+	}
+	if raw, _ := srv.store.GetRawProto(msgs[0].ID); len(raw) == 0 {
+		t.Error("forwarded image stored without its proto")
+	}
+}
+
+func TestHandleResolveNumber(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.onWhatsApp["+10000000001"] = types.NewJID("10000000001", types.DefaultUserServer)
+
+	req := httptest.NewRequest("POST", "/resolve-number", strings.NewReader(`{"number":"+1 000-000-0001"}`))
+	rec := serve(t, "POST /resolve-number", srv.handleResolveNumber, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["chatId"] != "10000000001@c.us" {
+		t.Errorf("chatId = %q", resp["chatId"])
+	}
+
+	req = httptest.NewRequest("POST", "/resolve-number", strings.NewReader(`{"number":"12345"}`))
+	rec = serve(t, "POST /resolve-number", srv.handleResolveNumber, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown number: status = %d, want 404", rec.Code)
+	}
+	var errBody map[string]string
+	json.NewDecoder(rec.Body).Decode(&errBody)
+	if errBody["code"] != ErrCodeNotOnWhatsApp {
+		t.Errorf("code = %q, want %q", errBody["code"], ErrCodeNotOnWhatsApp)
+	}
+}
+
+func TestDecodeJSONBody_TooLarge(t *testing.T) {
+	srv, fake := newTestServer(t)
+
+	huge := `{"messageId":"` + strings.Repeat("x", maxSmallBodyBytes) + `","emoji":"👍"}`
+	req := httptest.NewRequest("POST", "/react", strings.NewReader(huge))
+	rec := serve(t, "POST /react", srv.handleReact, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+	var body map[string]string
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["code"] != ErrCodeBodyTooLarge {
+		t.Errorf("code = %q, want %q", body["code"], ErrCodeBodyTooLarge)
+	}
+	if len(fake.sent) != 0 {
+		t.Error("oversized request must not be sent")
+	}
+}
+
+func TestHandleSend_Timeout(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.sendBlocks = true
+
+	body := `{"chatId":"10000000001@c.us","message":"hi","timeoutMs":20}`
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(body))
+	rec := serve(t, "POST /send", srv.handleSend, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", rec.Code)
+	}
+}
+
+func TestHandleSend_ClientCancelled(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.sendBlocks = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/send", strings.NewReader(`{"chatId":"10000000001@c.us","message":"hi"}`)).WithContext(ctx)
+	rec := serve(t, "POST /send", srv.handleSend, req)
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+}
+
+func TestHandleMarkRead(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.UpsertChat(chatJID, "Test", false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG", chatJID, chatJID, "Alice", false, "hi", 100, false, nil, nil)
+	markRead := func(ctx context.Context) (int, map[string]bool, int) {
+		srv.store.IncrementUnread(chatJID)
+		req := httptest.NewRequest("POST", "/mark-read/10000000001@c.us", nil).WithContext(ctx)
+		rec := serve(t, "POST /mark-read/{chatId}", srv.handleMarkRead, req)
+		var resp map[string]bool
+		json.NewDecoder(rec.Body).Decode(&resp)
+		chats, _ := srv.store.GetChats()
+		unread := chats[0].UnreadCount
+		srv.store.MarkRead(chatJID)
+		return rec.Code, resp, unread
+	}
+
+	if code, resp, unread := markRead(context.Background()); code != http.StatusOK || !resp["synced"] || unread != 0 {
+		t.Errorf("accepted: %d %v, unread %d", code, resp, unread)
+	}
+	if len(fake.markedRead) != 1 || fake.markedRead[0] != "MSG" {
+		t.Errorf("receipts sent for %v, want [MSG]", fake.markedRead)
+	}
+
+	// A failed receipt still marks the chat read here, but says so
+	fake.readErr = errors.New("server error")
+	if code, resp, unread := markRead(context.Background()); code != http.StatusOK || resp["synced"] || unread != 0 {
+		t.Errorf("failed: %d %v, unread %d", code, resp, unread)
+	}
+
+	// A client that gave up leaves the chat unread
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fake.readErr = context.Canceled
+	if code, _, unread := markRead(ctx); code != statusClientClosedRequest || unread != 1 {
+		t.Errorf("cancelled: %d, unread %d; want %d, unread 1", code, unread, statusClientClosedRequest)
+	}
+}
+
+func TestHandleRawMessage(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	raw, _ := proto.Marshal(&waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: proto.String("look")}})
+	srv.store.UpsertMessage("false_10000000001@c.us_IMG", chatJID, chatJID, "Alice", false, "look", 100, true, nil, raw)
+	srv.store.UpsertMessage("false_10000000001@c.us_TXT", chatJID, chatJID, "Alice", false, "hi", 200, false, nil, nil)
+
+	req := httptest.NewRequest("GET", "/messages/false_10000000001@c.us_IMG/raw", nil)
+	rec := serve(t, "GET /messages/{messageId}/raw", srv.handleRawMessage, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		MessageID string `json:"messageId"`
+		Message   struct {
+			ImageMessage struct {
+				Caption string `json:"caption"`
+			} `json:"imageMessage"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.MessageID != "false_10000000001@c.us_IMG" || resp.Message.ImageMessage.Caption != "look" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	for _, id := range []string{"false_10000000001@c.us_TXT", "false_10000000001@c.us_MISSING"} {
+		req := httptest.NewRequest("GET", "/messages/"+id+"/raw", nil)
+		rec := serve(t, "GET /messages/{messageId}/raw", srv.handleRawMessage, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", id, rec.Code)
+		}
+	}
+
+	// A database failure is not a missing message
+	srv.store.Close()
+	req = httptest.NewRequest("GET", "/messages/false_10000000001@c.us_IMG/raw", nil)
+	if rec := serve(t, "GET /messages/{messageId}/raw", srv.handleRawMessage, req); rec.Code != http.StatusInternalServerError {
+		t.Errorf("database closed: status = %d, want 500", rec.Code)
+	}
+}
+
+func TestHandleSendButtonResponse(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	raw, _ := proto.Marshal(&waE2E.Message{ButtonsMessage: &waE2E.ButtonsMessage{
+		ContentText: proto.String("Pick a slot"),
+		Buttons: []*waE2E.ButtonsMessage_Button{
+			{ButtonID: proto.String("am"), ButtonText: &waE2E.ButtonsMessage_Button_ButtonText{DisplayText: proto.String("Morning")}},
+		},
+	}})
+	mediaType := mediaTypeInteractive
+	srv.store.UpsertMessage("false_10000000001@c.us_BTN", chatJID, chatJID, "Shop", false, "Pick a slot", 100, false, &mediaType, raw)
+	srv.store.UpsertMessage("false_10000000001@c.us_TXT", chatJID, chatJID, "Shop", false, "hi", 50, false, nil, nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{"missing button", `{"messageId":"false_10000000001@c.us_BTN"}`, http.StatusBadRequest, ErrCodeMissingField},
+		{"bad id", `{"messageId":"junk","buttonId":"am"}`, http.StatusBadRequest, ErrCodeInvalidMessageID},
+		{"unknown message", `{"messageId":"false_10000000001@c.us_NOPE","buttonId":"am"}`, http.StatusNotFound, ErrCodeNotFound},
+		{"not interactive", `{"messageId":"false_10000000001@c.us_TXT","buttonId":"am"}`, http.StatusBadRequest, ErrCodeInvalidOption},
+		{"unknown button", `{"messageId":"false_10000000001@c.us_BTN","buttonId":"pm"}`, http.StatusBadRequest, ErrCodeInvalidOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/send-button-response", strings.NewReader(tt.body))
+			rec := serve(t, "POST /send-button-response", srv.handleSendButtonResponse, req)
+			var resp struct {
+				Code string `json:"code"`
+			}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != tt.wantCode || resp.Code != tt.wantErr {
+				t.Errorf("got %d %q, want %d %q", rec.Code, resp.Code, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+	if len(fake.sent) != 0 {
+		t.Fatalf("invalid requests sent %d messages", len(fake.sent))
+	}
+
+	req := httptest.NewRequest("POST", "/send-button-response", strings.NewReader(`{"messageId":"false_10000000001@c.us_BTN","buttonId":"am"}`))
+	rec := serve(t, "POST /send-button-response", srv.handleSendButtonResponse, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	br := fake.sent[0].msg.GetButtonsResponseMessage()
+	if br.GetSelectedButtonID() != "am" || br.GetSelectedDisplayText() != "Morning" {
+		t.Errorf("response = %+v", br)
+	}
+	if ci := br.GetContextInfo(); ci.GetStanzaID() != "BTN" || ci.GetParticipant() != chatJID || ci.GetQuotedMessage().GetButtonsMessage() == nil {
+		t.Errorf("context info = %+v", ci)
+	}
+	msgs, _ := srv.store.GetMessages(chatJID, 1, 0)
+	if len(msgs) != 1 || msgs[0].Body != "Morning" || !msgs[0].FromMe {
+		t.Errorf("stored = %+v", msgs)
+	}
+}
+
+func TestHandleSendListResponse(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	raw, _ := proto.Marshal(&waE2E.Message{ListMessage: &waE2E.ListMessage{
+		Title: proto.String("Menu"),
+		Sections: []*waE2E.ListMessage_Section{
+			{Rows: []*waE2E.ListMessage_Row{{RowID: proto.String("soup"), Title: proto.String("Soup"), Description: proto.String("Hot")}}},
+		},
+	}})
+	mediaType := mediaTypeInteractive
+	srv.store.UpsertMessage("false_10000000001@c.us_LIST", chatJID, chatJID, "Shop", false, "Menu", 100, false, &mediaType, raw)
+
+	req := httptest.NewRequest("POST", "/send-list-response", strings.NewReader(`{"messageId":"false_10000000001@c.us_LIST","rowId":"cake"}`))
+	rec := serve(t, "POST /send-list-response", srv.handleSendListResponse, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown row: status = %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/send-list-response", strings.NewReader(`{"messageId":"false_10000000001@c.us_LIST","rowId":"soup"}`))
+	rec = serve(t, "POST /send-list-response", srv.handleSendListResponse, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	lr := fake.sent[0].msg.GetListResponseMessage()
+	if lr.GetSingleSelectReply().GetSelectedRowID() != "soup" || lr.GetTitle() != "Soup" || lr.GetContextInfo().GetStanzaID() != "LIST" {
+		t.Errorf("response = %+v", lr)
+	}
+}
+
+func TestHandleChatSyncState(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/sync-state", nil)
+	rec := serve(t, "GET /chats/{chatId}/sync-state", srv.handleChatSyncState, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unsynced chat: status = %d, want 404", rec.Code)
+	}
+
+	srv.store.RecordChatSync("10000000001@s.whatsapp.net", true)
+	req = httptest.NewRequest("GET", "/chats/10000000001@c.us/sync-state", nil)
+	rec = serve(t, "GET /chats/{chatId}/sync-state", srv.handleChatSyncState, req)
+	var st ChatSyncState
+	json.NewDecoder(rec.Body).Decode(&st)
+	if rec.Code != http.StatusOK || st.ChatID != "10000000001@c.us" || !st.FullySynced {
+		t.Errorf("status = %d, state = %+v", rec.Code, st)
+	}
+}
+
+func TestHandleContactsExport(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.store.UpsertContact("10000000001@s.whatsapp.net", "Alice", "", "10000000001", false)
+	srv.store.UpsertContact("10000000002@s.whatsapp.net", "", "Bobby", "10000000002", false)
+	srv.store.UpsertContact("120363000000000001@g.us", "Family", "", "", true)
+	srv.store.UpsertContact("200000000000001@lid", "Hidden", "", "200000000000001", false)
+
+	req := httptest.NewRequest("GET", "/contacts/export?format=csv", nil)
+	rec := serve(t, "GET /contacts/export", srv.handleContactsExport, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := "name,number,whatsapp_id\nAlice,+10000000001,10000000001@c.us\nBobby,+10000000002,10000000002@c.us\n"
+	if rec.Body.String() != want {
+		t.Errorf("csv = %q, want %q", rec.Body.String(), want)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "whatsapp-contacts.csv") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	req = httptest.NewRequest("GET", "/contacts/export", nil)
+	rec = serve(t, "GET /contacts/export", srv.handleContactsExport, req)
+	if got := strings.Count(rec.Body.String(), "BEGIN:VCARD"); got != 2 {
+		t.Errorf("default vcf export has %d cards, want 2", got)
+	}
+
+	req = httptest.NewRequest("GET", "/contacts/export?format=xml", nil)
+	rec = serve(t, "GET /contacts/export", srv.handleContactsExport, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad format: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleTrashAndRestoreChat(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	req := httptest.NewRequest("DELETE", "/chats/10000000001@c.us?trash=true", nil)
+	rec := serve(t, "DELETE /chats/{chatId}", srv.handleDeleteChat, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("trash: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/chats/trash", nil)
+	rec = serve(t, "GET /chats/trash", srv.handleTrashedChats, req)
+	var resp struct {
+		Chats []Chat `json:"chats"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Chats) != 1 || resp.Chats[0].ID != "10000000001@c.us" {
+		t.Errorf("trash = %+v", resp.Chats)
+	}
+
+	req = httptest.NewRequest("POST", "/chats/10000000001@c.us/restore", nil)
+	rec = serve(t, "POST /chats/{chatId}/restore", srv.handleRestoreChat, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("restore: status = %d", rec.Code)
+	}
+	rec = serve(t, "POST /chats/{chatId}/restore", srv.handleRestoreChat, httptest.NewRequest("POST", "/chats/10000000001@c.us/restore", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("restore again: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleDeleteChat_RequiresConfirm(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.confirm = newConfirmGate(confirmFlag)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	rec := serve(t, "DELETE /chats/{chatId}", srv.handleDeleteChat, httptest.NewRequest("DELETE", "/chats/10000000001@c.us", nil))
+	if rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("unconfirmed: status = %d, want 428", rec.Code)
+	}
+	if chats, _ := srv.store.GetChats(); len(chats) != 1 {
+		t.Fatal("unconfirmed delete removed the chat")
+	}
+
+	// Moving to the trash is reversible and needs no confirmation
+	rec = serve(t, "DELETE /chats/{chatId}", srv.handleDeleteChat, httptest.NewRequest("DELETE", "/chats/10000000001@c.us?trash=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("trash: status = %d, want 200", rec.Code)
+	}
+
+	rec = serve(t, "DELETE /chats/{chatId}", srv.handleDeleteChat, httptest.NewRequest("DELETE", "/chats/10000000001@c.us?confirm=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("confirmed: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestHandleDeleteChats(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	req := httptest.NewRequest("POST", "/chats/delete", strings.NewReader(`{"chatIds":[]}`))
+	rec := serve(t, "POST /chats/delete", srv.handleDeleteChats, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty chatIds: status = %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/chats/delete", strings.NewReader(`{"chatIds":["10000000001@c.us","garbage","10000000002@c.us"]}`))
+	rec = serve(t, "POST /chats/delete", srv.handleDeleteChats, req)
+	var resp struct {
+		Deleted int                `json:"deleted"`
+		Results []DeleteChatResult `json:"results"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Deleted != 1 || len(resp.Results) != 3 {
+		t.Fatalf("status = %d, response = %+v", rec.Code, resp)
+	}
+	if !resp.Results[0].Deleted || resp.Results[1].Error != "invalid chatId" || resp.Results[2].Error != "chat not found" {
+		t.Errorf("results = %+v", resp.Results)
+	}
+}
+
+func TestHandleMaintenance(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	stale := "old preview"
+	staleTs := int64(50)
+	srv.store.UpsertChat(chatJID, "Alice", false, &stale, &staleTs)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "latest", 100, false, nil, nil)
+
+	req := httptest.NewRequest("POST", "/maintenance/defrag", nil)
+	rec := serve(t, "POST /maintenance/{action}", srv.handleMaintenance, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown action: status = %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/maintenance/reconcile-chats", nil)
+	rec = serve(t, "POST /maintenance/{action}", srv.handleMaintenance, req)
+	var resp struct {
+		Updated int `json:"updated"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Updated != 1 {
+		t.Errorf("status = %d, updated = %d, want 200 and 1", rec.Code, resp.Updated)
+	}
+}
+
+func TestHandleRefreshAbout(t *testing.T) {
+	srv, fake := newTestServer(t)
+	fake.userInfo[types.NewJID("10000000001", types.DefaultUserServer)] = types.UserInfo{Status: "At the gym"}
+
+	rec := serve(t, "POST /contacts/{chatId}/about", srv.handleRefreshAbout, httptest.NewRequest("POST", "/contacts/10000000001@c.us/about", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		ChatID string `json:"chatId"`
+		About  string `json:"about"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.ChatID != "10000000001@c.us" || resp.About != "At the gym" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	rec = serve(t, "POST /contacts/{chatId}/about", srv.handleRefreshAbout, httptest.NewRequest("POST", "/contacts/120363000000000009@g.us/about", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown group: status = %d, want 404", rec.Code)
+	}
+	rec = serve(t, "POST /contacts/{chatId}/about", srv.handleRefreshAbout, httptest.NewRequest("POST", "/contacts/garbage/about", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid chatId: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleContact(t *testing.T) {
+	srv, fake := newTestServer(t)
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	shop := types.NewJID("10000000002", types.DefaultUserServer)
+	srv.store.UpsertContact(alice.String(), "Alice", "ali", "10000000001", false)
+	srv.store.SetContactAbout(alice.String(), "Available")
+	fake.avatars[alice] = "https://example.invalid/alice.jpg"
+	fake.blocked = []types.JID{shop}
+	// Known only to whatsmeow's contact store
+	fake.contacts[shop] = types.ContactInfo{Found: true, PushName: "Corner Shop", BusinessName: "Corner Shop Ltd"}
+
+	get := func(chatID string) *httptest.ResponseRecorder {
+		return serve(t, "GET /contacts/{chatId}", srv.handleContact, httptest.NewRequest("GET", "/contacts/"+chatID, nil))
+	}
+
+	rec := get("10000000001@c.us")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("alice: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got ContactDetail
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.ID != "10000000001@c.us" || got.Name != "Alice" || got.PushName != "ali" || got.About != "Available" ||
+		got.AvatarURL != "https://example.invalid/alice.jpg" || got.Blocked == nil || *got.Blocked {
+		t.Errorf("alice = %+v", got)
+	}
+
+	rec = get("10000000002@c.us")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shop: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	got = ContactDetail{}
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.Name != "Corner Shop" || got.Number != "10000000002" || got.BusinessName != "Corner Shop Ltd" ||
+		got.AvatarURL != "" || got.Blocked == nil || !*got.Blocked {
+		t.Errorf("shop = %+v", got)
+	}
+
+	if rec := get("10000000003@c.us"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown: status = %d, want 404", rec.Code)
+	}
+	if rec := get("garbage"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleLiveLocation(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	srv.store.SetLiveLocation(chatJID, chatJID, LiveLocation{MessageID: "false_10000000001@c.us_LIVE", Latitude: 1.5, Longitude: 2.5, Sequence: 4})
+
+	get := func(id string) *httptest.ResponseRecorder {
+		return serve(t, "GET /messages/{messageId}/location", srv.handleLiveLocation, httptest.NewRequest("GET", "/messages/"+id+"/location", nil))
+	}
+
+	// The legacy @s.whatsapp.net form of the ID finds it too
+	rec := get("false_10000000001@s.whatsapp.net_LIVE")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var loc LiveLocation
+	json.NewDecoder(rec.Body).Decode(&loc)
+	if loc.Latitude != 1.5 || loc.Longitude != 2.5 || loc.Sequence != 4 {
+		t.Errorf("location = %+v", loc)
+	}
+
+	if rec := get("false_10000000001@c.us_OTHER"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown: status = %d, want 404", rec.Code)
+	}
+	if rec := get("garbage"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleUI_TimeZone(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.timeZone = "Asia/Tokyo"
+
+	rec := serve(t, "GET /ui", srv.handleUI, httptest.NewRequest("GET", "/ui", nil))
+	if !strings.Contains(rec.Body.String(), `const TZ = "Asia\/Tokyo" || undefined;`) {
+		t.Error("/ui does not carry the configured time zone")
+	}
+}
+
+func TestHandleSendVideo(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	clip := base64.StdEncoding.EncodeToString([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"))
+
+	body := `{"chatId":"10000000001@c.us","base64":"data:video/mp4;base64,` + clip + `","caption":"look","gifPlayback":true}`
+	rec := serve(t, "POST /send-video", srv.handleSendVideo, httptest.NewRequest("POST", "/send-video", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		MessageID string `json:"messageId"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.MessageID != "true_10000000001@c.us_FAKEID" {
+		t.Errorf("messageId = %q", resp.MessageID)
+	}
+
+	if len(fake.sent) != 1 {
+		t.Fatalf("sent = %+v", fake.sent)
+	}
+	vid := fake.sent[0].msg.GetVideoMessage()
+	if vid.GetCaption() != "look" || !vid.GetGifPlayback() || vid.GetMimetype() != "video/mp4" || vid.GetURL() == "" {
+		t.Errorf("video message = %+v", vid)
+	}
+
+	msgs, _ := srv.store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].Body != "look" || msgs[0].MediaType == nil || *msgs[0].MediaType != "video" {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+
+	rec = serve(t, "POST /send-video", srv.handleSendVideo, httptest.NewRequest("POST", "/send-video", strings.NewReader(`{"chatId":"10000000001@c.us","base64":"!!"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad base64: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleSendMedia_Mimetype(t *testing.T) {
+	srv, fake := newTestServer(t)
+	// HEIC, which DetectContentType reports as application/octet-stream
+	heic := base64.StdEncoding.EncodeToString([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+
+	tests := []struct {
+		name    string
+		pattern string
+		h       http.HandlerFunc
+		body    string
+		want    int
+	}{
+		{"image", "POST /send-image", srv.handleSendImage, `{"chatId":"10000000001@c.us","base64":"` + heic + `","mimetype":"image/heic"}`, http.StatusOK},
+		{"image as video", "POST /send-image", srv.handleSendImage, `{"chatId":"10000000001@c.us","base64":"` + heic + `","mimetype":"video/mp4"}`, http.StatusBadRequest},
+		{"malformed", "POST /send-image", srv.handleSendImage, `{"chatId":"10000000001@c.us","base64":"` + heic + `","mimetype":"image/"}`, http.StatusBadRequest},
+		{"video", "POST /send-video", srv.handleSendVideo, `{"chatId":"10000000001@c.us","base64":"` + heic + `","mimetype":"video/quicktime"}`, http.StatusOK},
+		{"voice note", "POST /send-audio", srv.handleSendAudio, `{"chatId":"10000000001@c.us","base64":"` + heic + `","ptt":true,"mimetype":"audio/mp4"}`, http.StatusOK},
+		{"audio as image", "POST /send-audio", srv.handleSendAudio, `{"chatId":"10000000001@c.us","base64":"` + heic + `","mimetype":"image/png"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		path := strings.TrimPrefix(tt.pattern, "POST ")
+		rec := serve(t, tt.pattern, tt.h, httptest.NewRequest("POST", path, strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	if len(fake.sent) != 3 {
+		t.Fatalf("sent = %d, want 3", len(fake.sent))
+	}
+	if got := fake.sent[0].msg.GetImageMessage().GetMimetype(); got != "image/heic" {
+		t.Errorf("image mimetype = %q", got)
+	}
+	if got := fake.sent[1].msg.GetVideoMessage().GetMimetype(); got != "video/quicktime" {
+		t.Errorf("video mimetype = %q", got)
+	}
+	if got := fake.sent[2].msg.GetAudioMessage().GetMimetype(); got != "audio/mp4" {
+		t.Errorf("audio mimetype = %q", got)
+	}
+}
+
+func TestHandleSendImage_AsDocument(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+
+	body := `{"chatId":"10000000001@c.us","base64":"` + png + `","caption":"full size","asDocument":true}`
+	rec := serve(t, "POST /send-image", srv.handleSendImage, httptest.NewRequest("POST", "/send-image", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.sent) != 1 || fake.sent[0].msg.GetImageMessage() != nil {
+		t.Fatalf("sent = %+v, want one document", fake.sent)
+	}
+	doc := fake.sent[0].msg.GetDocumentMessage()
+	if doc.GetMimetype() != "image/png" || doc.GetFileName() != "image.png" || doc.GetCaption() != "full size" || doc.GetURL() == "" {
+		t.Errorf("document message = %+v", doc)
+	}
+	msgs, _ := srv.store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].MediaType == nil || *msgs[0].MediaType != "document" || msgs[0].Body != "full size" {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+
+	body = `{"chatId":"10000000001@c.us","base64":"` + png + `","asDocument":true,"fileName":"scan.png"}`
+	serve(t, "POST /send-image", srv.handleSendImage, httptest.NewRequest("POST", "/send-image", strings.NewReader(body)))
+	if len(fake.sent) != 2 || fake.sent[1].msg.GetDocumentMessage().GetFileName() != "scan.png" {
+		t.Errorf("named document: sent = %+v", fake.sent)
+	}
+}
+
+func TestHandleResolveSender(t *testing.T) {
+	srv, fake := newTestServer(t)
+	groupJID := types.NewJID("120363000000000001", types.GroupServer)
+	lid := types.NewJID("200000000000001", types.HiddenUserServer)
+	phone := types.NewJID("10000000001", types.DefaultUserServer)
+	fake.groupInfo[groupJID] = &types.GroupInfo{
+		JID:          groupJID,
+		Participants: []types.GroupParticipant{{JID: phone, LID: lid}},
+	}
+	fake.contacts[phone] = types.ContactInfo{Found: true, FullName: "Alice"}
+
+	group := groupJID.String()
+	srv.store.UpsertMessage("false_120363000000000001@g.us_M1", group, lid.String(), "", false, "hi", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_120363000000000001@g.us_M2", group, lid.String(), "200000000000001", false, "again", 200, false, nil, nil)
+
+	body := `{"senderJid":"200000000000001@lid","chatId":"120363000000000001@g.us"}`
+	rec := serve(t, "POST /resolve-sender", srv.handleResolveSender, httptest.NewRequest("POST", "/resolve-sender", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Name    string `json:"name"`
+		Updated int    `json:"updated"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Name != "Alice" || resp.Updated != 2 {
+		t.Errorf("response = %+v, want Alice with 2 updated", resp)
+	}
+	msgs, _ := srv.store.GetMessages(group, 10, 0)
+	for _, m := range msgs {
+		if m.SenderName == nil || *m.SenderName != "Alice" {
+			t.Errorf("message %s sender name = %v", m.ID, m.SenderName)
+		}
+	}
+
+	// Without the group there is nothing to match the LID against
+	body = `{"senderJid":"200000000000001@lid"}`
+	rec = serve(t, "POST /resolve-sender", srv.handleResolveSender, httptest.NewRequest("POST", "/resolve-sender", strings.NewReader(body)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unresolvable sender: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleSendAudio(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	note := base64.StdEncoding.EncodeToString([]byte("OggS\x00\x02opus"))
+	waveform := base64.StdEncoding.EncodeToString([]byte{0, 50, 100})
+
+	body := `{"chatId":"10000000001@c.us","base64":"` + note + `","ptt":true,"seconds":7,"waveform":"` + waveform + `"}`
+	rec := serve(t, "POST /send-audio", srv.handleSendAudio, httptest.NewRequest("POST", "/send-audio", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.sent) != 1 {
+		t.Fatalf("sent = %+v", fake.sent)
+	}
+	audio := fake.sent[0].msg.GetAudioMessage()
+	if !audio.GetPTT() || audio.GetSeconds() != 7 || audio.GetMimetype() != "audio/ogg; codecs=opus" || !bytes.Equal(audio.GetWaveform(), []byte{0, 50, 100}) {
+		t.Errorf("audio message = %+v", audio)
+	}
+	msgs, _ := srv.store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].MediaType == nil || *msgs[0].MediaType != "audio" || !msgs[0].FromMe {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+
+	long := base64.StdEncoding.EncodeToString(make([]byte, maxWaveformSamples+1))
+	body = `{"chatId":"10000000001@c.us","base64":"` + note + `","ptt":true,"waveform":"` + long + `"}`
+	rec = serve(t, "POST /send-audio", srv.handleSendAudio, httptest.NewRequest("POST", "/send-audio", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || len(fake.sent) != 1 {
+		t.Errorf("oversized waveform: status = %d, sent = %d", rec.Code, len(fake.sent))
+	}
+}
+
+func TestHandleStatusUpdates(t *testing.T) {
+	srv, _ := newTestServer(t)
+	status := "status@broadcast"
+	srv.store.UpsertMessage("false_status@broadcast_S1", status, "10000000001@s.whatsapp.net", "Alice", false, "old story", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_status@broadcast_S2", status, "10000000002@s.whatsapp.net", "Bob", false, "new story", 200, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_M1", "10000000001@s.whatsapp.net", "10000000001@s.whatsapp.net", "", false, "chat", 300, false, nil, nil)
+
+	rec := serve(t, "GET /status-updates", srv.handleStatusUpdates, httptest.NewRequest("GET", "/status-updates?limit=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Updates []Message `json:"updates"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Updates) != 2 || resp.Updates[0].Body != "new story" || resp.Updates[1].From != "10000000001@c.us" {
+		t.Errorf("updates = %+v", resp.Updates)
+	}
+}
+
+func TestHandleEditMessage(t *testing.T) {
+	srv, fake := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	preview, ts := "typo", int64(100)
+	srv.store.UpsertChat(chatJID, "Alice", false, &preview, &ts)
+	srv.store.UpsertMessage("true_10000000001@c.us_OWN1", chatJID, "19999999999@s.whatsapp.net", "", true, "typo", 100, false, nil, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"missing text", `{"messageId":"true_10000000001@c.us_OWN1"}`, http.StatusBadRequest},
+		{"bad id", `{"messageId":"nope","newText":"x"}`, http.StatusBadRequest},
+		{"someone else's", `{"messageId":"false_10000000001@c.us_THEIRS","newText":"x"}`, http.StatusBadRequest},
+		{"own", `{"messageId":"true_10000000001@c.us_OWN1","newText":"fixed"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serve(t, "POST /edit-message", srv.handleEditMessage, httptest.NewRequest("POST", "/edit-message", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	if len(fake.sent) != 1 {
+		t.Fatalf("sent %d messages, want only the valid edit", len(fake.sent))
+	}
+	pm := fake.sent[0].msg.GetEditedMessage().GetMessage().GetProtocolMessage()
+	if pm.GetKey().GetID() != "OWN1" || pm.GetEditedMessage().GetConversation() != "fixed" {
+		t.Errorf("sent edit = %+v", pm)
+	}
+
+	msgs, _ := srv.store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].Body != "fixed" || msgs[0].EditedAt == nil {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+	chats, _ := srv.store.GetChats()
+	if chats[0].LastMessage == nil || *chats[0].LastMessage != "fixed" {
+		t.Errorf("chat preview = %v, want the edited text", chats[0].LastMessage)
+	}
+}
+
+func TestHandleRevokeMessage(t *testing.T) {
+	srv, fake := newTestServer(t)
+	alice := "10000000001@s.whatsapp.net"
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	photo := "image"
+	srv.store.UpsertChat(alice, "Alice", false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_OLD", alice, alice, "", false, "older", 100, false, nil, nil)
+	srv.store.UpsertMessage("true_10000000001@c.us_OWN1", alice, "19999999999@s.whatsapp.net", "", true, "oops", 200, true, &photo, []byte{1})
+	srv.store.UpsertMessage("false_120363000000000001@g.us_G1", group.String(), "10000000002@s.whatsapp.net", "", false, "spam", 300, false, nil, nil)
+	fake.groupInfo[group] = &types.GroupInfo{JID: group, Participants: []types.GroupParticipant{
+		{JID: types.NewJID("19999999999", types.DefaultUserServer)},
+	}}
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		return serve(t, "DELETE /messages/{messageId}", srv.handleRevokeMessage, httptest.NewRequest("DELETE", "/messages/"+id, nil))
+	}
+
+	if rec := revoke("nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad id: status = %d, want 400", rec.Code)
+	}
+	if rec := revoke("false_10000000001@c.us_OLD"); rec.Code != http.StatusBadRequest {
+		t.Errorf("their message in a direct chat: status = %d, want 400", rec.Code)
+	}
+	if rec := revoke("false_120363000000000001@g.us_G1"); rec.Code != http.StatusForbidden {
+		t.Errorf("not admin: status = %d, want 403", rec.Code)
+	}
+	if len(fake.sent) != 0 {
+		t.Fatalf("rejected revokes were sent: %+v", fake.sent)
+	}
+
+	if rec := revoke("true_10000000001@c.us_OWN1"); rec.Code != http.StatusOK {
+		t.Fatalf("own message: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	pm := fake.sent[0].msg.GetProtocolMessage()
+	if pm.GetType() != waE2E.ProtocolMessage_REVOKE || pm.GetKey().GetID() != "OWN1" || !pm.GetKey().GetFromMe() {
+		t.Errorf("sent revoke = %+v", pm)
+	}
+	msgs, _ := srv.store.GetMessages(alice, 10, 0)
+	if msgs[0].Body != revokedBody || !msgs[0].Revoked || msgs[0].HasMedia || msgs[0].MediaType != nil {
+		t.Errorf("revoked message = %+v", msgs[0])
+	}
+	if raw, _ := srv.store.GetRawProto("true_10000000001@c.us_OWN1"); raw != nil {
+		t.Error("revoked message kept its media")
+	}
+	chats, _ := srv.store.GetChats()
+	for _, c := range chats {
+		if c.ID == "10000000001@c.us" && (c.LastMessage == nil || *c.LastMessage != revokedBody) {
+			t.Errorf("chat preview = %v, want %q", c.LastMessage, revokedBody)
+		}
+	}
+
+	// As an admin, others' group messages can go too
+	fake.groupInfo[group].Participants[0].IsAdmin = true
+	if rec := revoke("false_120363000000000001@g.us_G1"); rec.Code != http.StatusOK {
+		t.Fatalf("as admin: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	key := fake.sent[1].msg.GetProtocolMessage().GetKey()
+	if key.GetFromMe() || key.GetParticipant() != "10000000002@s.whatsapp.net" {
+		t.Errorf("admin revoke key = %+v", key)
+	}
+}
+
+func TestHandleRevokeMessage_ConfirmedAndRateLimited(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.confirm = newConfirmGate(confirmFlag)
+	srv.sendLimiter = newSendLimiter(sendLimits{Global: 30, PerChat: 1, Window: time.Minute})
+	alice := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("true_10000000001@c.us_OWN1", alice, "19999999999@s.whatsapp.net", "", true, "oops", 100, false, nil, nil)
+	srv.store.UpsertMessage("true_10000000001@c.us_OWN2", alice, "19999999999@s.whatsapp.net", "", true, "again", 200, false, nil, nil)
+	revoke := func(target string) *httptest.ResponseRecorder {
+		return serve(t, "DELETE /messages/{messageId}", srv.handleRevokeMessage, httptest.NewRequest("DELETE", target, nil))
+	}
+
+	if rec := revoke("/messages/true_10000000001@c.us_OWN1"); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("unconfirmed: status = %d, want 428", rec.Code)
+	}
+	if len(fake.sent) != 0 {
+		t.Fatal("unconfirmed revoke was sent")
+	}
+	if rec := revoke("/messages/true_10000000001@c.us_OWN1?confirm=true"); rec.Code != http.StatusOK {
+		t.Fatalf("confirmed: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := revoke("/messages/true_10000000001@c.us_OWN2?confirm=true"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the per-chat limit: status = %d, want 429", rec.Code)
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d revokes, want 1", len(fake.sent))
+	}
+}
+
+func TestHandleRevokeMessage_FailedRequestKeepsToken(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.confirm = newConfirmGate(confirmToken)
+	token, _, _ := srv.confirm.issue()
+	alice := "10000000001@s.whatsapp.net"
+	srv.store.UpsertMessage("true_10000000001@c.us_OWN", alice, "19999999999@s.whatsapp.net", "", true, "oops", 100, false, nil, nil)
+	revoke := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", target, nil)
+		req.Header.Set("X-Confirm-Token", token)
+		return serve(t, "DELETE /messages/{messageId}", srv.handleRevokeMessage, req)
+	}
+
+	// Not ours to delete in a direct chat: refused before the token is spent
+	if rec := revoke("/messages/false_10000000001@c.us_THEIRS"); rec.Code != http.StatusBadRequest {
+		t.Errorf("someone else's message: status = %d, want 400", rec.Code)
+	}
+	if rec := revoke("/messages/true_10000000001@c.us_OWN"); rec.Code != http.StatusOK {
+		t.Fatalf("own message: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d revokes, want 1", len(fake.sent))
+	}
+}
+
+func TestHandleQR_Wait(t *testing.T) {
+	wc := &WAClient{store: newTestStore(t), status: StatusConnecting, events: NewBroadcaster()}
+	srv := &Server{wc: wc}
+
+	// Nothing changes: the wait times out with the current state
+	start := time.Now()
+	rec := serve(t, "GET /qr", srv.handleQR, httptest.NewRequest(http.MethodGet, "/qr?wait=true&timeoutMs=50", nil))
+	var resp QRResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.QR != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("timed-out wait = %d %+v after %s", rec.Code, resp, time.Since(start))
+	}
+
+	// A new code ends the wait
+	go func() {
+		for wc.events.SubscriberCount() == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		code := "2@test-code"
+		wc.mu.Lock()
+		wc.qrCode = &code
+		wc.status = StatusQR
+		wc.mu.Unlock()
+		wc.events.Publish(Event{Type: EventStatus, Status: string(StatusQR), FetchQR: true})
+	}()
+	rec = serve(t, "GET /qr", srv.handleQR, httptest.NewRequest(http.MethodGet, "/qr?wait=true&timeoutMs=5000", nil))
+	resp = QRResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.QR == nil || !strings.HasPrefix(*resp.QR, "data:image/png;base64,") {
+		t.Errorf("wait after new code = %+v, want the code", resp)
+	}
+}
+
+func TestHandleSearch_InvalidTimeRange(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, q := range []string{"after=yesterday", "before=-5"} {
+		rec := serve(t, "GET /search", srv.handleSearch, httptest.NewRequest(http.MethodGet, "/search?q=hi&"+q, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ErrCodeInvalidParam) {
+			t.Errorf("%s: %d %s, want 400 invalid_param", q, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestHandleGroupInfo(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	owner := types.NewJID("10000000001", types.DefaultUserServer)
+	bob := types.NewJID("10000000002", types.DefaultUserServer)
+	lid := types.NewJID("200000000000003", types.HiddenUserServer)
+	fake.groupInfo[group] = &types.GroupInfo{
+		JID:          group,
+		OwnerJID:     owner,
+		GroupName:    types.GroupName{Name: "Climbing"},
+		GroupTopic:   types.GroupTopic{Topic: "Tuesdays at 7"},
+		GroupCreated: time.Unix(1700000000, 0),
+		Participants: []types.GroupParticipant{
+			{JID: owner, IsAdmin: true, IsSuperAdmin: true},
+			{JID: bob},
+			{JID: lid, PhoneNumber: types.NewJID("10000000003", types.DefaultUserServer), DisplayName: "Carol"},
+		},
+	}
+	fake.contacts[owner] = types.ContactInfo{Found: true, FullName: "Alice"}
+	fake.contacts[bob] = types.ContactInfo{Found: true, PushName: "Bob"}
+
+	rec := serve(t, "GET /groups/{chatId}", srv.handleGroupInfo, httptest.NewRequest("GET", "/groups/120363000000000001@g.us", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp GroupInfo
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Name != "Climbing" || resp.Topic != "Tuesdays at 7" || resp.CreatedAt != 1700000000 || resp.Owner != "10000000001@c.us" {
+		t.Errorf("group = %+v", resp)
+	}
+	want := []GroupMember{
+		{ID: "10000000001@c.us", Name: "Alice", Number: "10000000001", IsAdmin: true, IsSuperAdmin: true},
+		{ID: "10000000002@c.us", Name: "Bob", Number: "10000000002"},
+		{ID: "200000000000003@lid", Name: "Carol", Number: "10000000003"},
+	}
+	if len(resp.Participants) != len(want) {
+		t.Fatalf("participants = %+v", resp.Participants)
+	}
+	for i, p := range resp.Participants {
+		if p != want[i] {
+			t.Errorf("participant %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+
+	rec = serve(t, "GET /groups/{chatId}", srv.handleGroupInfo, httptest.NewRequest("GET", "/groups/10000000001@c.us", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("not a group: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleMessages_Fields(t *testing.T) {
+	srv, _ := newTestServer(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	photo := "image"
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "Alice", false, "first", 100, false, nil, nil)
+	srv.store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "Alice", false, "", 200, true, &photo, []byte{1})
+
+	req := httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?fields=timestamp,id,mediaType,body", nil)
+	rec := serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	// Fields keep Message's order; empty omitempty fields stay out
+	want := `{"messages":[{"id":"false_10000000001@c.us_MSG2","body":"","timestamp":200,"mediaType":"image"},` +
+		`{"id":"false_10000000001@c.us_MSG1","body":"first","timestamp":100}],"fromCache":true}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("body\n%s\nwant\n%s", rec.Body.String(), want)
+	}
+
+	req = httptest.NewRequest("GET", "/chats/10000000001@c.us/messages?fields=id,nope", nil)
+	rec = serve(t, "GET /chats/{chatId}/messages", srv.handleMessages, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleCreateGroup(t *testing.T) {
+	srv, fake := newTestServer(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		return serve(t, "POST /groups", srv.handleCreateGroup, httptest.NewRequest("POST", "/groups", strings.NewReader(body)))
+	}
+
+	for body, want := range map[string]int{
+		`{"name":"","participants":["10000000001@c.us"]}`:                                        http.StatusBadRequest,
+		`{"name":"Climbing"}`:                                                                    http.StatusBadRequest,
+		`{"name":"Climbing","participants":["120363000000000001@g.us"]}`:                         http.StatusBadRequest,
+		`{"name":"A name well over twenty-five characters","participants":["10000000001@c.us"]}`: http.StatusBadRequest,
+	} {
+		if rec := create(body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+	if len(fake.created) != 0 {
+		t.Fatalf("invalid requests created groups: %+v", fake.created)
+	}
+
+	rec := create(`{"name":" Climbing ","participants":["10000000001@c.us","200000000000001@lid"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		ChatID string `json:"chatId"`
+		Name   string `json:"name"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.ChatID != "120363000000000099@g.us" || resp.Name != "Climbing" {
+		t.Errorf("response = %+v", resp)
+	}
+	req := fake.created[0]
+	if req.Name != "Climbing" || len(req.Participants) != 2 || req.Participants[0].String() != "10000000001@s.whatsapp.net" {
+		t.Errorf("create request = %+v", req)
+	}
+
+	chats, _ := srv.store.GetChats()
+	if len(chats) != 1 || chats[0].ID != "120363000000000099@g.us" || chats[0].Name != "Climbing" || !chats[0].IsGroup {
+		t.Errorf("chats = %+v, want the new group", chats)
+	}
+	members, _ := srv.store.GetGroupParticipants("120363000000000099@g.us")
+	if len(members) != 3 {
+		t.Errorf("stored participants = %+v", members)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.wc = &WAClient{status: StatusConnecting}
+	readyz := func() (int, ReadyzResponse) {
+		rec := serve(t, "GET /readyz", srv.handleReadyz, httptest.NewRequest("GET", "/readyz", nil))
+		var resp ReadyzResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Ready || resp.WhatsApp != StatusConnecting || resp.DB != "ok" {
+		t.Errorf("connecting: %d %+v, want 503", code, resp)
+	}
+	srv.wc.status = StatusReady
+	if code, resp := readyz(); code != http.StatusOK || !resp.Ready {
+		t.Errorf("ready: %d %+v, want 200", code, resp)
+	}
+	srv.store.Close()
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.DB != "unavailable" {
+		t.Errorf("database closed: %d %+v, want 503", code, resp)
+	}
+
+	// Liveness doesn't depend on either
+	if rec := serve(t, "GET /livez", srv.handleLivez, httptest.NewRequest("GET", "/livez", nil)); rec.Code != http.StatusOK {
+		t.Errorf("livez: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestHandleGroupParticipants(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	fake.groupInfo[group] = &types.GroupInfo{JID: group, Participants: []types.GroupParticipant{
+		{JID: *fake.ownJID},
+	}}
+	bob := types.NewJID("10000000002", types.DefaultUserServer)
+	carol := types.NewJID("10000000003", types.DefaultUserServer)
+	fake.changeErrs[carol] = 409
+
+	call := func(method, path string, h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		return serve(t, method+" "+path, h, httptest.NewRequest(method, strings.Replace(path, "{chatId}", "120363000000000001@g.us", 1), strings.NewReader(body)))
+	}
+	body := `{"participants":["10000000002@c.us","10000000003@c.us"]}`
+
+	if rec := call("POST", "/groups/{chatId}/participants", srv.handleAddParticipants, body); rec.Code != http.StatusForbidden {
+		t.Errorf("not admin: status = %d, want 403", rec.Code)
+	}
+	if rec := call("POST", "/groups/{chatId}/participants", srv.handleAddParticipants, `{"participants":[]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no participants: status = %d, want 400", rec.Code)
+	}
+	if len(fake.changes) != 0 {
+		t.Fatalf("rejected requests made changes: %+v", fake.changes)
+	}
+
+	fake.groupInfo[group].Participants[0].IsAdmin = true
+	rec := call("POST", "/groups/{chatId}/participants", srv.handleAddParticipants, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Results []ParticipantChangeResult `json:"results"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Results) != 2 || !resp.Results[0].Success || resp.Results[0].ID != "10000000002@c.us" ||
+		resp.Results[1].Success || resp.Results[1].Code != 409 || resp.Results[1].Error == "" {
+		t.Errorf("results = %+v", resp.Results)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		h            http.HandlerFunc
+		want         whatsmeow.ParticipantChange
+	}{
+		{"DELETE", "/groups/{chatId}/participants", srv.handleRemoveParticipants, whatsmeow.ParticipantChangeRemove},
+		{"POST", "/groups/{chatId}/participants/promote", srv.handlePromoteParticipants, whatsmeow.ParticipantChangePromote},
+		{"POST", "/groups/{chatId}/participants/demote", srv.handleDemoteParticipants, whatsmeow.ParticipantChangeDemote},
+	} {
+		if rec := call(tc.method, tc.path, tc.h, `{"participants":["10000000002@c.us"]}`); rec.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d", tc.method, tc.path, rec.Code)
+		}
+		last := fake.changes[len(fake.changes)-1]
+		if last.action != tc.want || last.group != group || len(last.participants) != 1 || last.participants[0] != bob {
+			t.Errorf("%s %s: change = %+v", tc.method, tc.path, last)
+		}
+	}
+}
+
+func TestHandleLeaveGroup(t *testing.T) {
+	srv, fake := newTestServer(t)
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	fake.groupInfo[group] = &types.GroupInfo{JID: group}
+	srv.store.UpsertChat(group.String(), "Climbing", true, nil, nil)
+
+	leave := func(chatID string) *httptest.ResponseRecorder {
+		return serve(t, "POST /groups/{chatId}/leave", srv.handleLeaveGroup, httptest.NewRequest("POST", "/groups/"+chatID+"/leave", nil))
+	}
+	if rec := leave("10000000001@c.us"); rec.Code != http.StatusBadRequest {
+		t.Errorf("not a group: status = %d, want 400", rec.Code)
+	}
+	if rec := leave("120363000000000002@g.us"); rec.Code == http.StatusOK {
+		t.Error("unknown group: leave succeeded")
+	}
+	if rec := leave("120363000000000001@g.us"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(fake.left) != 1 || fake.left[0] != group {
+		t.Errorf("left = %v", fake.left)
+	}
+
+	// The chat stays listed, marked as left, and drops out of group refreshes
+	chats, _ := srv.store.GetChats()
+	if len(chats) != 1 || chats[0].LeftAt == nil {
+		t.Errorf("chats = %+v, want the group marked left", chats)
+	}
+	if stale, _ := srv.store.GetStaleGroupJIDs(time.Now().Unix()+1, 10); len(stale) != 0 {
+		t.Errorf("stale groups = %v, want none", stale)
+	}
+
+	// Rejoining clears it
+	srv.store.SetGroupLeft(group.String(), false)
+	if chats, _ := srv.store.GetChats(); chats[0].LeftAt != nil {
+		t.Errorf("rejoined chat still left: %+v", chats[0])
+	}
+}
+
+func TestHandleProfilePicture(t *testing.T) {
+	srv, fake := newTestServer(t)
+	alice := types.NewJID("10000000001", types.DefaultUserServer)
+	fake.avatars[alice] = "https://example.invalid/alice.jpg"
+
+	get := func(path string) (*httptest.ResponseRecorder, ProfilePicture) {
+		rec := serve(t, "GET /contacts/{chatId}/avatar", srv.handleProfilePicture, httptest.NewRequest("GET", path, nil))
+		var pic ProfilePicture
+		json.NewDecoder(rec.Body).Decode(&pic)
+		return rec, pic
+	}
+
+	rec, pic := get("/contacts/10000000001@c.us/avatar?preview=true")
+	if rec.Code != http.StatusOK || pic.URL != "https://example.invalid/alice.jpg" || pic.PictureID != "pic-10000000001" || !pic.Preview || pic.Cached {
+		t.Fatalf("status = %d, picture = %+v", rec.Code, pic)
+	}
+	// Served from the cache the second time, unless refreshed
+	if _, pic = get("/contacts/10000000001@c.us/avatar?preview=true"); !pic.Cached || fake.avatarHits != 1 {
+		t.Errorf("second lookup: cached = %t, %d WhatsApp calls", pic.Cached, fake.avatarHits)
+	}
+	if _, pic = get("/contacts/10000000001@c.us/avatar?preview=true&refresh=true"); pic.Cached || fake.avatarHits != 2 {
+		t.Errorf("refresh: cached = %t, %d WhatsApp calls", pic.Cached, fake.avatarHits)
+	}
+	// Full size is cached separately
+	if _, pic = get("/contacts/10000000001@c.us/avatar"); pic.Cached || pic.Preview {
+		t.Errorf("full size = %+v", pic)
+	}
+
+	// No picture is cached as well
+	if rec, _ := get("/contacts/10000000002@c.us/avatar"); rec.Code != http.StatusNotFound {
+		t.Errorf("no picture: status = %d, want 404", rec.Code)
+	}
+	hits := fake.avatarHits
+	if rec, _ := get("/contacts/10000000002@c.us/avatar"); rec.Code != http.StatusNotFound || fake.avatarHits != hits {
+		t.Errorf("no picture again: status = %d, %d new WhatsApp calls", rec.Code, fake.avatarHits-hits)
+	}
+
+	if rec, _ := get("/contacts/nope/avatar"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid chatId: status = %d, want 400", rec.Code)
+	}
+}
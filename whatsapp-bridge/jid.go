@@ -40,6 +40,12 @@ func parseAPIJID(id string) types.JID {
 	return jid
 }
 
+// isValidJID reports whether a parsed JID has both a user and a server part.
+// parseAPIJID swallows parse errors, so handlers use this to reject garbage.
+func isValidJID(jid types.JID) bool {
+	return jid.User != "" && jid.Server != ""
+}
+
 // extractNumber extracts the phone number from a JID string
 func extractNumber(jid string) string {
 	at := strings.Index(jid, "@")
@@ -49,6 +55,59 @@ func extractNumber(jid string) string {
 	return jid[:at]
 }
 
+// phoneNumber returns the phone number of a user JID in either API or
+// internal format, without any device suffix. It returns "" for groups, LIDs
+// and anything else that doesn't carry a phone number.
+func phoneNumber(jid string) string {
+	parsed := parseAPIJID(jid)
+	if parsed.Server != types.DefaultUserServer || parsed.User == "" {
+		return ""
+	}
+	return parsed.User
+}
+
+// Chat types. A chat's JID tells individuals, groups and broadcasts apart;
+// whether a group is a community or its announcement group comes from the
+// group's metadata.
+const (
+	ChatTypeIndividual   = "individual"
+	ChatTypeGroup        = "group"
+	ChatTypeCommunity    = "community"    // a community's parent group
+	ChatTypeAnnouncement = "announcement" // a community's announcement group
+	ChatTypeBroadcast    = "broadcast"    // a broadcast list or the status feed
+)
+
+// chatTypeFromJID returns the type of a chat as far as its internal or API
+// JID tells: any group is a plain group until its metadata says otherwise.
+func chatTypeFromJID(jid string) string {
+	switch parseAPIJID(jid).Server {
+	case types.GroupServer:
+		return ChatTypeGroup
+	case types.BroadcastServer:
+		return ChatTypeBroadcast
+	default:
+		return ChatTypeIndividual
+	}
+}
+
+// groupChatType returns a group's type from its metadata.
+func groupChatType(isParent, isDefaultSubGroup bool) string {
+	switch {
+	case isParent:
+		return ChatTypeCommunity
+	case isDefaultSubGroup:
+		return ChatTypeAnnouncement
+	default:
+		return ChatTypeGroup
+	}
+}
+
+// isBroadcastList reports whether an internal or API JID is a personal
+// broadcast list. The status feed (status@broadcast) is not one.
+func isBroadcastList(jid string) bool {
+	return strings.HasSuffix(jid, "@"+types.BroadcastServer) && jid != types.StatusBroadcastJID.String()
+}
+
 // parseMessageIDParts parses a formatted message ID into its components.
 // Format: "{fromMe}_{chatJID}_{messageID}"
 // Example: "true_1234567890@c.us_3EB0ABCDEF"
@@ -90,3 +149,31 @@ func formatMessageID(fromMe bool, chatJID, messageID string) string {
 	}
 	return f + "_" + chatJID + "_" + messageID
 }
+
+// normalizeMessageID rewrites a formatted message ID into its canonical form,
+// with the chat JID in API format (@c.us rather than @s.whatsapp.net).
+// IDs that cannot be parsed are returned unchanged.
+func normalizeMessageID(id string) string {
+	parts := parseMessageIDParts(id)
+	if parts == nil {
+		return id
+	}
+	return formatMessageID(parts.fromMe, toAPIJIDString(parts.chatJID), parts.messageID)
+}
+
+// messageIDVariants returns the canonical form of a formatted message ID
+// followed by its legacy @s.whatsapp.net form, when that differs. Lookups use
+// both so messages stored before IDs were canonicalized are still found.
+func messageIDVariants(id string) []string {
+	canonical := normalizeMessageID(id)
+	variants := []string{canonical}
+	parts := parseMessageIDParts(canonical)
+	if parts == nil {
+		return variants
+	}
+	legacy := formatMessageID(parts.fromMe, toInternalJID(parts.chatJID), parts.messageID)
+	if legacy != canonical {
+		variants = append(variants, legacy)
+	}
+	return variants
+}
@@ -100,6 +100,48 @@ func TestExtractNumber(t *testing.T) {
 	}
 }
 
+func TestPhoneNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"10000000001@s.whatsapp.net", "10000000001"},
+		{"10000000001@c.us", "10000000001"},
+		{"10000000001:7@s.whatsapp.net", "10000000001"},
+		{"120363000000000001@g.us", ""},
+		{"200000000000001@lid", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := phoneNumber(tt.input); got != tt.want {
+				t.Errorf("phoneNumber(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBroadcastList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"1700000000@broadcast", true},
+		{"status@broadcast", false},
+		{"10000000001@s.whatsapp.net", false},
+		{"120363000000000001@g.us", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := isBroadcastList(tt.input); got != tt.want {
+				t.Errorf("isBroadcastList(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestParseMessageIDParts(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -221,3 +263,74 @@ func TestFormatAndParseRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeMessageID(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"true_10000000001@s.whatsapp.net_MSG1", "true_10000000001@c.us_MSG1"},
+		{"false_10000000001@c.us_MSG1", "false_10000000001@c.us_MSG1"},
+		{"false_120363000000000000@g.us_MSG1", "false_120363000000000000@g.us_MSG1"},
+		{"garbage", "garbage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := normalizeMessageID(tt.input); got != tt.want {
+				t.Errorf("normalizeMessageID(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageIDVariants(t *testing.T) {
+	got := messageIDVariants("true_10000000001@s.whatsapp.net_MSG1")
+	if len(got) != 2 || got[0] != "true_10000000001@c.us_MSG1" || got[1] != "true_10000000001@s.whatsapp.net_MSG1" {
+		t.Errorf("variants for direct chat = %v", got)
+	}
+
+	got = messageIDVariants("false_120363000000000000@g.us_MSG1")
+	if len(got) != 1 {
+		t.Errorf("group IDs have no legacy form, got %v", got)
+	}
+}
+
+func TestIsValidJID(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"10000000001@c.us", true},
+		{"120363000000000000@g.us", true},
+		{"garbage", false},
+		{"", false},
+		{"@c.us", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := isValidJID(parseAPIJID(tt.input)); got != tt.want {
+				t.Errorf("isValidJID(parseAPIJID(%q)) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatType(t *testing.T) {
+	for jid, want := range map[string]string{
+		"10000000001@c.us":           ChatTypeIndividual,
+		"10000000001@s.whatsapp.net": ChatTypeIndividual,
+		"200000000000001@lid":        ChatTypeIndividual,
+		"120363000000000000@g.us":    ChatTypeGroup,
+		"1700000000@broadcast":       ChatTypeBroadcast,
+		"status@broadcast":           ChatTypeBroadcast,
+	} {
+		if got := chatTypeFromJID(jid); got != want {
+			t.Errorf("chatTypeFromJID(%q) = %q, want %q", jid, got, want)
+		}
+	}
+	if groupChatType(true, false) != ChatTypeCommunity || groupChatType(false, true) != ChatTypeAnnouncement || groupChatType(false, false) != ChatTypeGroup {
+		t.Error("groupChatType mismatch")
+	}
+}
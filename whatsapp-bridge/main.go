@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -11,7 +12,13 @@ import (
 )
 
 func main() {
+	resetSession := flag.Bool("reset-session", false, "discard the stored WhatsApp session and pair again")
+	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Log timestamps (and anything else formatted in local time) follow
+	// WAPP_TIMEZONE; /ui is handed the same zone for its dates
+	loc, timeZone := loadTimezone()
+	time.Local = loc
 
 	// 1. Load or create API key for authentication
 	if err := loadOrCreateAPIKey(); err != nil {
@@ -26,9 +33,12 @@ func main() {
 	}
 	defer appStore.Close()
 	log.Println("Database initialized")
+	if days := envInt(envTrashRetentionDays, defaultTrashRetentionDays); days > 0 {
+		go purgeTrashPeriodically(appStore, time.Duration(days)*24*time.Hour)
+	}
 
 	// 3. Initialize the WhatsApp client
-	wc, err := NewWAClient(appStore)
+	wc, err := NewWAClient(appStore, *resetSession)
 	if err != nil {
 		log.Fatalf("Failed to init WhatsApp client: %v", err)
 	}
@@ -38,41 +48,113 @@ func main() {
 		log.Fatalf("Failed to connect: %v", err)
 	}
 	log.Println("WhatsApp client connected")
+	if interval := envDuration(envAboutRefresh, defaultAboutRefresh); interval > 0 {
+		go refreshAboutPeriodically(wc, appStore, interval)
+	}
+	if interval := envDuration(envGroupRefresh, defaultGroupRefresh); interval > 0 {
+		go refreshGroupsPeriodically(wc, appStore, interval)
+	}
 
 	// 5. Set up HTTP routes (Go 1.22+ method+pattern routing)
-	srv := &Server{wc: wc, store: appStore}
+	srv := &Server{
+		wc:       wc,
+		store:    appStore,
+		wa:       wc.wa,
+		limits:   loadResultLimits(),
+		confirm:  newConfirmGate(loadConfirmMode()),
+		timeZone: timeZone,
+		media:    appStore.media,
+
+		sendLimiter: newSendLimiter(defaultSendLimits),
+		uploads:     loadUploadGate(),
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", srv.handleHealth)
-	mux.HandleFunc("GET /status", srv.handleStatus)
-	mux.HandleFunc("GET /qr", srv.handleQR)
-	mux.HandleFunc("GET /contacts", srv.handleContacts)
-	mux.HandleFunc("GET /chats", srv.handleChats)
-	mux.HandleFunc("GET /chats/{chatId}/messages", srv.handleMessages)
-	mux.HandleFunc("POST /mark-read/{chatId}", srv.handleMarkRead)
-	mux.HandleFunc("POST /send", srv.handleSend)
-	mux.HandleFunc("POST /send-image", srv.handleSendImage)
-	mux.HandleFunc("POST /react", srv.handleReact)
-	mux.HandleFunc("POST /download-media", srv.handleDownloadMedia)
-	mux.HandleFunc("POST /resolve-number", srv.handleResolveNumber)
-	mux.HandleFunc("POST /sync-history", srv.handleSyncHistory)
-	mux.HandleFunc("POST /sync-all", srv.handleSyncAll)
-	mux.HandleFunc("POST /deep-sync", srv.handleDeepSync)
-	mux.HandleFunc("GET /deep-sync", srv.handleDeepSyncStatus)
-	mux.HandleFunc("GET /search", srv.handleSearch)
-	mux.HandleFunc("GET /ui", srv.handleUI)
-	mux.HandleFunc("DELETE /chats/{chatId}", srv.handleDeleteChat)
+	mux.HandleFunc("GET /health", withTimeout(timeoutQuick, srv.handleHealth))
+	mux.HandleFunc("GET /livez", withTimeout(timeoutQuick, srv.handleLivez))
+	mux.HandleFunc("GET /readyz", withTimeout(timeoutQuick, srv.handleReadyz))
+	mux.HandleFunc("GET /status", withTimeout(timeoutQuick, srv.handleStatus))
+	mux.HandleFunc("GET /qr", withTimeout(timeoutWhatsApp, srv.handleQR))
+	mux.HandleFunc("GET /contacts", withTimeout(timeoutQuick, srv.handleContacts))
+	mux.HandleFunc("GET /contacts/export", withTimeout(timeoutLong, srv.handleContactsExport))
+	mux.HandleFunc("GET /contacts/{chatId}", withTimeout(timeoutWhatsApp, srv.handleContact))
+	mux.HandleFunc("GET /contacts/{chatId}/avatar", withTimeout(timeoutWhatsApp, srv.handleProfilePicture))
+	mux.HandleFunc("POST /contacts/{chatId}/about", withTimeout(timeoutWhatsApp, srv.handleRefreshAbout))
+	mux.HandleFunc("GET /broadcast-lists", withTimeout(timeoutQuick, srv.handleBroadcastLists))
+	mux.HandleFunc("GET /chats", withTimeout(timeoutQuick, srv.handleChats))
+	mux.HandleFunc("GET /status-updates", withTimeout(timeoutQuick, srv.handleStatusUpdates))
+	mux.HandleFunc("GET /chats/{chatId}/messages", withTimeout(timeoutLong, srv.handleMessages))
+	mux.HandleFunc("GET /chats/{chatId}/messages/{rawId}", withTimeout(timeoutQuick, srv.handleMessageByRawID))
+	mux.HandleFunc("GET /chats/{chatId}/sync-state", withTimeout(timeoutQuick, srv.handleChatSyncState))
+	mux.HandleFunc("POST /mark-read/{chatId}", withTimeout(timeoutWhatsApp, srv.handleMarkRead))
+	mux.HandleFunc("POST /send", withTimeout(timeoutWhatsApp, srv.handleSend))
+	mux.HandleFunc("POST /send-image", withTimeout(timeoutLong, srv.handleSendImage))
+	mux.HandleFunc("POST /send-video", withTimeout(timeoutLong, srv.handleSendVideo))
+	mux.HandleFunc("POST /send-audio", withTimeout(timeoutLong, srv.handleSendAudio))
+	mux.HandleFunc("POST /react", withTimeout(timeoutWhatsApp, srv.handleReact))
+	mux.HandleFunc("POST /react-last", withTimeout(timeoutWhatsApp, srv.handleReactLast))
+	mux.HandleFunc("POST /forward", withTimeout(timeoutWhatsApp, srv.handleForward))
+	mux.HandleFunc("POST /edit-message", withTimeout(timeoutWhatsApp, srv.handleEditMessage))
+	mux.HandleFunc("DELETE /messages/{messageId}", withTimeout(timeoutWhatsApp, srv.handleRevokeMessage))
+	mux.HandleFunc("GET /groups/{chatId}", withTimeout(timeoutWhatsApp, srv.handleGroupInfo))
+	mux.HandleFunc("POST /groups", withTimeout(timeoutWhatsApp, srv.handleCreateGroup))
+	mux.HandleFunc("POST /groups/{chatId}/leave", withTimeout(timeoutWhatsApp, srv.handleLeaveGroup))
+	mux.HandleFunc("POST /groups/{chatId}/participants", withTimeout(timeoutWhatsApp, srv.handleAddParticipants))
+	mux.HandleFunc("DELETE /groups/{chatId}/participants", withTimeout(timeoutWhatsApp, srv.handleRemoveParticipants))
+	mux.HandleFunc("POST /groups/{chatId}/participants/promote", withTimeout(timeoutWhatsApp, srv.handlePromoteParticipants))
+	mux.HandleFunc("POST /groups/{chatId}/participants/demote", withTimeout(timeoutWhatsApp, srv.handleDemoteParticipants))
+	mux.HandleFunc("POST /send-button-response", withTimeout(timeoutWhatsApp, srv.handleSendButtonResponse))
+	mux.HandleFunc("POST /send-list-response", withTimeout(timeoutWhatsApp, srv.handleSendListResponse))
+	mux.HandleFunc("POST /download-media", withTimeout(timeoutLong, srv.handleDownloadMedia))
+	mux.HandleFunc("POST /resolve-number", withTimeout(timeoutWhatsApp, srv.handleResolveNumber))
+	mux.HandleFunc("POST /resolve-sender", withTimeout(timeoutWhatsApp, srv.handleResolveSender))
+	mux.HandleFunc("POST /sync-history", withTimeout(timeoutWhatsApp, srv.handleSyncHistory))
+	mux.HandleFunc("POST /sync-all", withTimeout(timeoutLong, srv.handleSyncAll))
+	mux.HandleFunc("POST /deep-sync", withTimeout(timeoutQuick, srv.handleDeepSync))
+	mux.HandleFunc("GET /deep-sync", withTimeout(timeoutQuick, srv.handleDeepSyncStatus))
+	mux.HandleFunc("GET /sync-state", withTimeout(timeoutQuick, srv.handleSyncStates))
+	mux.HandleFunc("GET /diagnostics/history", withTimeout(timeoutWhatsApp, srv.handleHistoryDiagnostics))
+	mux.HandleFunc("GET /search", withTimeout(timeoutQuick, srv.handleSearch))
+	mux.HandleFunc("GET /ui", withTimeout(timeoutQuick, srv.handleUI))
+	mux.HandleFunc("DELETE /chats/{chatId}", withTimeout(timeoutQuick, srv.handleDeleteChat))
+	mux.HandleFunc("POST /chats/{chatId}/clear", withTimeout(timeoutQuick, srv.handleClearChat))
+	mux.HandleFunc("POST /chats/delete", withTimeout(timeoutQuick, srv.handleDeleteChats))
+	mux.HandleFunc("GET /chats/trash", withTimeout(timeoutQuick, srv.handleTrashedChats))
+	mux.HandleFunc("POST /chats/{chatId}/restore", withTimeout(timeoutQuick, srv.handleRestoreChat))
+	mux.HandleFunc("POST /chats/{chatId}/archive", withTimeout(timeoutWhatsApp, srv.handleArchiveChat))
+	mux.HandleFunc("POST /chats/{chatId}/unarchive", withTimeout(timeoutWhatsApp, srv.handleUnarchiveChat))
+	mux.HandleFunc("POST /chats/{chatId}/mute", withTimeout(timeoutWhatsApp, srv.handleMuteChat))
+	mux.HandleFunc("POST /chats/{chatId}/unmute", withTimeout(timeoutWhatsApp, srv.handleUnmuteChat))
+	mux.HandleFunc("POST /resync-appstate", withTimeout(timeoutWhatsApp, srv.handleResyncAppState))
+	mux.HandleFunc("POST /chats/{chatId}/pin", withTimeout(timeoutQuick, srv.handlePinMessage))
+	mux.HandleFunc("DELETE /chats/{chatId}/pin", withTimeout(timeoutQuick, srv.handleUnpinMessage))
+	mux.HandleFunc("GET /chats/{chatId}/pins", withTimeout(timeoutQuick, srv.handlePinnedMessages))
+	mux.HandleFunc("GET /messages/{messageId}/location", withTimeout(timeoutQuick, srv.handleLiveLocation))
+	mux.HandleFunc("POST /messages/{messageId}/star", withTimeout(timeoutQuick, srv.handleStarMessage))
+	mux.HandleFunc("POST /messages/{messageId}/unstar", withTimeout(timeoutQuick, srv.handleUnstarMessage))
+	mux.HandleFunc("GET /starred", withTimeout(timeoutQuick, srv.handleStarred))
+	mux.HandleFunc("GET /events", withTimeout(timeoutNone, srv.handleEvents))
+	mux.HandleFunc("POST /reconnect", withTimeout(timeoutQuick, srv.handleReconnect))
+	mux.HandleFunc("POST /session/reset", withTimeout(timeoutWhatsApp, srv.handleSessionReset))
+	mux.HandleFunc("POST /maintenance/{action}", withTimeout(timeoutLong, srv.handleMaintenance))
+	mux.HandleFunc("GET /confirm-token", withTimeout(timeoutQuick, srv.handleConfirmToken))
+	if envBool(envDebugAPI, false) {
+		mux.HandleFunc("GET /messages/{messageId}/raw", withTimeout(timeoutQuick, srv.handleRawMessage))
+		log.Println("Debug API endpoints enabled")
+	}
 
 	// 6. Wrap with auth middleware
 	handler := authMiddleware(mux)
 
-	// 7. Configure and start HTTP server
+	// 7. Configure and start HTTP server. Read and write timeouts are set
+	// per route by withTimeout rather than here: a server-wide WriteTimeout
+	// would cut off /events and slow transfers.
 	httpServer := &http.Server{
-		Addr:           "127.0.0.1:3847",
-		Handler:        handler,
-		ReadTimeout:    30 * time.Second,
-		WriteTimeout:   60 * time.Second,
-		MaxHeaderBytes: 1 << 20, // 1 MB
+		Addr:              "127.0.0.1:3847",
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 20, // 1 MB
 	}
 
 	// Start server in a goroutine
@@ -102,3 +184,80 @@ func main() {
 
 	log.Println("Shutdown complete")
 }
+
+// purgeTrashPeriodically deletes chats that have been in the trash longer
+// than retention, at startup and then every few hours.
+func purgeTrashPeriodically(store *AppStore, retention time.Duration) {
+	for {
+		n, err := store.PurgeTrash(time.Now().Add(-retention).Unix())
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d chats from the trash", n)
+		}
+		time.Sleep(6 * time.Hour)
+	}
+}
+
+// aboutRefreshBatch bounds how many chats one periodic about refresh fetches,
+// spreading a large contact list over several rounds.
+const aboutRefreshBatch = 200
+
+// refreshAboutPeriodically refetches about text older than interval, hourly,
+// a batch at a time, while connected.
+func refreshAboutPeriodically(wc *WAClient, store *AppStore, interval time.Duration) {
+	for {
+		time.Sleep(time.Hour)
+		if !wc.client.IsConnected() || wc.client.Store.ID == nil {
+			continue
+		}
+		jids, err := store.GetStaleAboutJIDs(time.Now().Add(-interval).Unix(), aboutRefreshBatch)
+		if err != nil {
+			log.Printf("About refresh failed: %v", err)
+			continue
+		}
+		if len(jids) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		n, err := refreshAbout(ctx, wc.wa, store, jids)
+		cancel()
+		if err != nil {
+			log.Printf("About refresh failed after %d chats: %v", n, err)
+		} else {
+			log.Printf("Refreshed about text for %d chats", n)
+		}
+	}
+}
+
+// groupRefreshBatch bounds how many groups one periodic refresh fetches. At
+// groupRefreshSpacing apart, a full batch takes a few minutes.
+const groupRefreshBatch = 100
+
+// refreshGroupsPeriodically refetches the name, participants and description
+// of groups not refreshed within interval, while connected, so renames and
+// membership changes show up without a reconnect.
+func refreshGroupsPeriodically(wc *WAClient, store *AppStore, interval time.Duration) {
+	for {
+		time.Sleep(min(interval, time.Hour))
+		if !wc.client.IsConnected() || wc.client.Store.ID == nil {
+			continue
+		}
+		jids, err := store.GetStaleGroupJIDs(time.Now().Add(-interval).Unix(), groupRefreshBatch)
+		if err != nil {
+			log.Printf("Group refresh failed: %v", err)
+			continue
+		}
+		if len(jids) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		n, err := refreshGroups(ctx, wc.wa, store, jids, groupRefreshSpacing)
+		cancel()
+		if err != nil {
+			log.Printf("Group refresh failed after %d groups: %v", n, err)
+		} else {
+			log.Printf("Refreshed %d groups", n)
+		}
+	}
+}
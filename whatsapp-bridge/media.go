@@ -1,9 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"strings"
+
 	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
 )
 
+// mediaTypeInteractive marks business messages with buttons, lists or
+// templates. They have no downloadable media but keep their raw proto so the
+// offered options can be inspected later.
+const mediaTypeInteractive = "interactive"
+
+// mediaTypeLiveLocation marks the first message of a live-location share.
+// Later position updates refresh its stored coordinates (see live_locations)
+// instead of adding messages.
+const mediaTypeLiveLocation = "live_location"
+
 // getMediaType returns the media type string from a whatsmeow message
 func getMediaType(msg *waE2E.Message) *string {
 	if msg == nil {
@@ -21,15 +35,152 @@ func getMediaType(msg *waE2E.Message) *string {
 		t = "sticker"
 	case msg.GetDocumentMessage() != nil:
 		t = "document"
+	case msg.GetButtonsMessage() != nil, msg.GetListMessage() != nil,
+		msg.GetTemplateMessage() != nil, msg.GetInteractiveMessage() != nil:
+		t = mediaTypeInteractive
+	case msg.GetLiveLocationMessage() != nil:
+		t = mediaTypeLiveLocation
 	default:
 		return nil
 	}
 	return &t
 }
 
+// imageFileName is the file name of an image of the given mimetype sent as a
+// document without one, e.g. image.jpg, or just "image" if the mimetype
+// isn't an image's.
+func imageFileName(mimetype string) string {
+	ext, ok := strings.CutPrefix(mimetype, "image/")
+	if !ok {
+		return "image"
+	}
+	ext, _, _ = strings.Cut(ext, ";")
+	ext, _, _ = strings.Cut(ext, "+") // svg+xml
+	if ext = strings.TrimSpace(ext); ext == "" {
+		return "image"
+	}
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	return "image." + ext
+}
+
 // hasMediaContent returns true if the message contains downloadable media
 func hasMediaContent(msg *waE2E.Message) bool {
-	return getMediaType(msg) != nil
+	t := getMediaType(msg)
+	return t != nil && *t != mediaTypeInteractive && *t != mediaTypeLiveLocation
+}
+
+// mediaFileLength returns the size WhatsApp gives for a message's
+// downloadable media, or 0 if it has none.
+func mediaFileLength(msg *waE2E.Message) uint64 {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetFileLength()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetFileLength()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetFileLength()
+	}
+	return 0
+}
+
+// mediaSearchText is what the search index holds for a message besides its
+// body: the media type and mimetype, and a document's file name, so that a
+// search for "pdf" or "invoice" finds a document sent without a caption.
+// rawProto is the stored message proto, if any.
+func mediaSearchText(mediaType *string, rawProto []byte) string {
+	if mediaType == nil {
+		return ""
+	}
+	parts := []string{*mediaType}
+	var msg waE2E.Message
+	if len(rawProto) > 0 && proto.Unmarshal(rawProto, &msg) == nil {
+		if name := msg.GetDocumentMessage().GetFileName(); name != "" {
+			parts = append(parts, name)
+		}
+		for _, m := range []interface{ GetMimetype() string }{
+			msg.GetImageMessage(), msg.GetVideoMessage(), msg.GetAudioMessage(),
+			msg.GetDocumentMessage(), msg.GetStickerMessage(),
+		} {
+			if mt := m.GetMimetype(); mt != "" {
+				parts = append(parts, mt)
+				break
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// messageContextInfo returns the ContextInfo of whichever message type msg
+// carries that has one, or nil.
+func messageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	for _, m := range []interface{ GetContextInfo() *waE2E.ContextInfo }{
+		msg.GetExtendedTextMessage(), msg.GetImageMessage(), msg.GetVideoMessage(),
+		msg.GetAudioMessage(), msg.GetDocumentMessage(), msg.GetStickerMessage(),
+		msg.GetContactMessage(), msg.GetLocationMessage(), msg.GetLiveLocationMessage(),
+	} {
+		if ci := m.GetContextInfo(); ci != nil {
+			return ci
+		}
+	}
+	return nil
+}
+
+// forwardingInfo reports whether msg was forwarded and its forwarding score.
+func forwardingInfo(msg *waE2E.Message) (forwarded bool, score int) {
+	ci := messageContextInfo(msg)
+	return ci.GetIsForwarded(), int(ci.GetForwardingScore())
+}
+
+// forwardCopy returns a copy of msg to forward, marked as forwarded with its
+// forwarding score raised by one. Any other context, such as the message it
+// replied to or its mentions, is dropped. Media keeps its upload references,
+// so it is not uploaded again. It returns nil if msg is not something
+// WhatsApp forwards.
+func forwardCopy(msg *waE2E.Message) *waE2E.Message {
+	_, score := forwardingInfo(msg)
+	ci := &waE2E.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(uint32(score + 1)),
+	}
+	var out waE2E.Message
+	switch {
+	case msg.GetConversation() != "":
+		// A plain conversation message has no ContextInfo to carry the flag
+		out.ExtendedTextMessage = &waE2E.ExtendedTextMessage{Text: proto.String(msg.GetConversation()), ContextInfo: ci}
+	case msg.GetExtendedTextMessage() != nil:
+		out.ExtendedTextMessage = proto.Clone(msg.GetExtendedTextMessage()).(*waE2E.ExtendedTextMessage)
+		out.ExtendedTextMessage.ContextInfo = ci
+	case msg.GetImageMessage() != nil:
+		out.ImageMessage = proto.Clone(msg.GetImageMessage()).(*waE2E.ImageMessage)
+		out.ImageMessage.ContextInfo = ci
+	case msg.GetVideoMessage() != nil:
+		out.VideoMessage = proto.Clone(msg.GetVideoMessage()).(*waE2E.VideoMessage)
+		out.VideoMessage.ContextInfo = ci
+	case msg.GetAudioMessage() != nil:
+		out.AudioMessage = proto.Clone(msg.GetAudioMessage()).(*waE2E.AudioMessage)
+		out.AudioMessage.ContextInfo = ci
+	case msg.GetDocumentMessage() != nil:
+		out.DocumentMessage = proto.Clone(msg.GetDocumentMessage()).(*waE2E.DocumentMessage)
+		out.DocumentMessage.ContextInfo = ci
+	case msg.GetStickerMessage() != nil:
+		out.StickerMessage = proto.Clone(msg.GetStickerMessage()).(*waE2E.StickerMessage)
+		out.StickerMessage.ContextInfo = ci
+	case msg.GetContactMessage() != nil:
+		out.ContactMessage = proto.Clone(msg.GetContactMessage()).(*waE2E.ContactMessage)
+		out.ContactMessage.ContextInfo = ci
+	case msg.GetLocationMessage() != nil:
+		out.LocationMessage = proto.Clone(msg.GetLocationMessage()).(*waE2E.LocationMessage)
+		out.LocationMessage.ContextInfo = ci
+	default:
+		return nil
+	}
+	return &out
 }
 
 // extractMessageBody extracts the text body from a whatsmeow message
@@ -52,7 +203,78 @@ func extractMessageBody(msg *waE2E.Message) string {
 	if doc := msg.GetDocumentMessage(); doc != nil {
 		return doc.GetCaption()
 	}
-	return ""
+	if live := msg.GetLiveLocationMessage(); live != nil {
+		return live.GetCaption()
+	}
+	return interactivePreview(msg)
+}
+
+// interactivePreview renders a business message as its text followed by the
+// offered options, e.g. "Pick a slot\n[Morning] [Evening]".
+func interactivePreview(msg *waE2E.Message) string {
+	var text, options []string
+	if btn := msg.GetButtonsMessage(); btn != nil {
+		text = append(text, btn.GetText(), btn.GetContentText(), btn.GetFooterText())
+		for _, b := range btn.GetButtons() {
+			options = append(options, b.GetButtonText().GetDisplayText())
+		}
+	} else if list := msg.GetListMessage(); list != nil {
+		text = append(text, list.GetTitle(), list.GetDescription(), list.GetFooterText())
+		for _, sec := range list.GetSections() {
+			for _, row := range sec.GetRows() {
+				options = append(options, row.GetTitle())
+			}
+		}
+	} else if tmpl := msg.GetTemplateMessage(); tmpl != nil {
+		if im := tmpl.GetInteractiveMessageTemplate(); im != nil {
+			return interactivePreview(&waE2E.Message{InteractiveMessage: im})
+		}
+		h := tmpl.GetHydratedTemplate()
+		if h == nil {
+			h = tmpl.GetHydratedFourRowTemplate()
+		}
+		text = append(text, h.GetHydratedTitleText(), h.GetHydratedContentText(), h.GetHydratedFooterText())
+		for _, b := range h.GetHydratedButtons() {
+			switch {
+			case b.GetQuickReplyButton() != nil:
+				options = append(options, b.GetQuickReplyButton().GetDisplayText())
+			case b.GetUrlButton() != nil:
+				options = append(options, b.GetUrlButton().GetDisplayText())
+			case b.GetCallButton() != nil:
+				options = append(options, b.GetCallButton().GetDisplayText())
+			}
+		}
+	} else if im := msg.GetInteractiveMessage(); im != nil {
+		text = append(text, im.GetHeader().GetTitle(), im.GetBody().GetText(), im.GetFooter().GetText())
+		for _, b := range im.GetNativeFlowMessage().GetButtons() {
+			// Native flow buttons carry their label inside a JSON blob
+			var params struct {
+				DisplayText string `json:"display_text"`
+			}
+			if json.Unmarshal([]byte(b.GetButtonParamsJSON()), &params) == nil && params.DisplayText != "" {
+				options = append(options, params.DisplayText)
+			} else {
+				options = append(options, b.GetName())
+			}
+		}
+	}
+
+	var lines []string
+	for _, t := range text {
+		if t != "" {
+			lines = append(lines, t)
+		}
+	}
+	var labels []string
+	for _, o := range options {
+		if o != "" {
+			labels = append(labels, "["+o+"]")
+		}
+	}
+	if len(labels) > 0 {
+		lines = append(lines, strings.Join(labels, " "))
+	}
+	return strings.Join(lines, "\n")
 }
 
 // detectMediaMimetype extracts the mimetype from a media message
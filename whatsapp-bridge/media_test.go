@@ -21,6 +21,12 @@ func TestGetMediaType(t *testing.T) {
 		{"sticker message", &waE2E.Message{StickerMessage: &waE2E.StickerMessage{}}, strPtr("sticker")},
 		{"document message", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{}}, strPtr("document")},
 		{"text only", &waE2E.Message{Conversation: proto.String("hello")}, nil},
+		{"buttons message", &waE2E.Message{ButtonsMessage: &waE2E.ButtonsMessage{}}, strPtr("interactive")},
+		{"list message", &waE2E.Message{ListMessage: &waE2E.ListMessage{}}, strPtr("interactive")},
+		{"template message", &waE2E.Message{TemplateMessage: &waE2E.TemplateMessage{}}, strPtr("interactive")},
+		{"interactive message", &waE2E.Message{InteractiveMessage: &waE2E.InteractiveMessage{}}, strPtr("interactive")},
+		{"live location", &waE2E.Message{LiveLocationMessage: &waE2E.LiveLocationMessage{}}, strPtr("live_location")},
+		{"static location", &waE2E.Message{LocationMessage: &waE2E.LocationMessage{}}, nil},
 	}
 
 	for _, tt := range tests {
@@ -52,6 +58,79 @@ func TestHasMediaContent(t *testing.T) {
 	if !hasMediaContent(&waE2E.Message{ImageMessage: &waE2E.ImageMessage{}}) {
 		t.Error("hasMediaContent(image) = false, want true")
 	}
+	if hasMediaContent(&waE2E.Message{ButtonsMessage: &waE2E.ButtonsMessage{}}) {
+		t.Error("hasMediaContent(buttons) = true, want false")
+	}
+	if hasMediaContent(&waE2E.Message{LiveLocationMessage: &waE2E.LiveLocationMessage{}}) {
+		t.Error("hasMediaContent(live location) = true, want false")
+	}
+}
+
+func TestMediaSearchText(t *testing.T) {
+	raw := func(msg *waE2E.Message) []byte {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return b
+	}
+	tests := []struct {
+		name      string
+		mediaType *string
+		rawProto  []byte
+		want      string
+	}{
+		{"text message", nil, nil, ""},
+		{"no proto", strPtr("image"), nil, "image"},
+		{"image", strPtr("image"), raw(&waE2E.Message{ImageMessage: &waE2E.ImageMessage{Mimetype: proto.String("image/jpeg")}}), "image image/jpeg"},
+		{"document", strPtr("document"), raw(&waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			FileName: proto.String("invoice.pdf"), Mimetype: proto.String("application/pdf"),
+		}}), "document invoice.pdf application/pdf"},
+		{"document without name", strPtr("document"), raw(&waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			Mimetype: proto.String("application/pdf"),
+		}}), "document application/pdf"},
+		{"corrupt proto", strPtr("video"), []byte{0xff, 0xff}, "video"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mediaSearchText(tt.mediaType, tt.rawProto); got != tt.want {
+				t.Errorf("mediaSearchText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardingInfo(t *testing.T) {
+	forwarded := func(score uint32) *waE2E.ContextInfo {
+		return &waE2E.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(score)}
+	}
+	tests := []struct {
+		name          string
+		msg           *waE2E.Message
+		wantForwarded bool
+		wantScore     int
+	}{
+		{"nil message", nil, false, 0},
+		{"plain conversation", &waE2E.Message{Conversation: proto.String("hi")}, false, 0},
+		{"reply, not forwarded", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text: proto.String("hi"), ContextInfo: &waE2E.ContextInfo{StanzaID: proto.String("ORIG")},
+		}}, false, 0},
+		{"forwarded text", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text: proto.String("hi"), ContextInfo: forwarded(1),
+		}}, true, 1},
+		{"forwarded many times image", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{ContextInfo: forwarded(7)}}, true, 7},
+		{"forwarded location", &waE2E.Message{LocationMessage: &waE2E.LocationMessage{ContextInfo: forwarded(2)}}, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded, score := forwardingInfo(tt.msg)
+			if forwarded != tt.wantForwarded || score != tt.wantScore {
+				t.Errorf("forwardingInfo() = (%v, %d), want (%v, %d)", forwarded, score, tt.wantForwarded, tt.wantScore)
+			}
+		})
+	}
 }
 
 func TestExtractMessageBody(t *testing.T) {
@@ -68,6 +147,47 @@ func TestExtractMessageBody(t *testing.T) {
 		{"video caption", &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Caption: proto.String("cool vid")}}, "cool vid"},
 		{"document caption", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Caption: proto.String("my doc")}}, "my doc"},
 		{"image no caption", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{}}, ""},
+		{"buttons", &waE2E.Message{ButtonsMessage: &waE2E.ButtonsMessage{
+			Header:      &waE2E.ButtonsMessage_Text{Text: "Delivery"},
+			ContentText: proto.String("Pick a slot"),
+			Buttons: []*waE2E.ButtonsMessage_Button{
+				{ButtonText: &waE2E.ButtonsMessage_Button_ButtonText{DisplayText: proto.String("Morning")}},
+				{ButtonText: &waE2E.ButtonsMessage_Button_ButtonText{DisplayText: proto.String("Evening")}},
+			},
+		}}, "Delivery\nPick a slot\n[Morning] [Evening]"},
+		{"list", &waE2E.Message{ListMessage: &waE2E.ListMessage{
+			Title:       proto.String("Menu"),
+			Description: proto.String("Choose a dish"),
+			ButtonText:  proto.String("View"),
+			Sections: []*waE2E.ListMessage_Section{
+				{Rows: []*waE2E.ListMessage_Row{{Title: proto.String("Soup")}, {Title: proto.String("Salad")}}},
+				{Rows: []*waE2E.ListMessage_Row{{Title: proto.String("Cake")}}},
+			},
+		}}, "Menu\nChoose a dish\n[Soup] [Salad] [Cake]"},
+		{"hydrated template", &waE2E.Message{TemplateMessage: &waE2E.TemplateMessage{
+			HydratedTemplate: &waE2E.TemplateMessage_HydratedFourRowTemplate{
+				HydratedContentText: proto.String("Your order shipped"),
+				HydratedFooterText:  proto.String("Acme Inc"),
+				HydratedButtons: []*waE2E.HydratedTemplateButton{
+					{HydratedButton: &waE2E.HydratedTemplateButton_UrlButton{UrlButton: &waE2E.HydratedTemplateButton_HydratedURLButton{DisplayText: proto.String("Track")}}},
+					{HydratedButton: &waE2E.HydratedTemplateButton_QuickReplyButton{QuickReplyButton: &waE2E.HydratedTemplateButton_HydratedQuickReplyButton{DisplayText: proto.String("Thanks")}}},
+				},
+			},
+		}}, "Your order shipped\nAcme Inc\n[Track] [Thanks]"},
+		{"interactive native flow", &waE2E.Message{InteractiveMessage: &waE2E.InteractiveMessage{
+			Body: &waE2E.InteractiveMessage_Body{Text: proto.String("Confirm booking?")},
+			InteractiveMessage: &waE2E.InteractiveMessage_NativeFlowMessage_{NativeFlowMessage: &waE2E.InteractiveMessage_NativeFlowMessage{
+				Buttons: []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+					{Name: proto.String("quick_reply"), ButtonParamsJSON: proto.String(`{"display_text":"Yes","id":"y"}`)},
+					{Name: proto.String("cta_url"), ButtonParamsJSON: proto.String(`not json`)},
+				},
+			}},
+		}}, "Confirm booking?\n[Yes] [cta_url]"},
+		{"template wrapping interactive", &waE2E.Message{TemplateMessage: &waE2E.TemplateMessage{
+			Format: &waE2E.TemplateMessage_InteractiveMessageTemplate{InteractiveMessageTemplate: &waE2E.InteractiveMessage{
+				Body: &waE2E.InteractiveMessage_Body{Text: proto.String("Hello")},
+			}},
+		}}, "Hello"},
 	}
 
 	for _, tt := range tests {
@@ -104,6 +224,20 @@ func TestDetectMediaMimetype(t *testing.T) {
 	}
 }
 
+func TestImageFileName(t *testing.T) {
+	for mimetype, want := range map[string]string{
+		"image/jpeg":               "image.jpg",
+		"image/png":                "image.png",
+		"image/svg+xml":            "image.svg",
+		"image/heic; q=1":          "image.heic",
+		"application/octet-stream": "image",
+	} {
+		if got := imageFileName(mimetype); got != want {
+			t.Errorf("imageFileName(%q) = %q, want %q", mimetype, got, want)
+		}
+	}
+}
+
 func strPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media download states stored per message (messages.media_status). Messages
+// never considered for auto-download have none.
+const (
+	mediaStatusPending    = "pending"
+	mediaStatusDownloaded = "downloaded"
+	mediaStatusFailed     = "failed"
+	mediaStatusSkipped    = "skipped" // larger than the size cap, or the queue was full
+)
+
+// Auto-download defaults.
+const (
+	defaultAutoDownloadMaxMB    = 16
+	defaultAutoDownloadInterval = time.Second
+	autoDownloadTimeout         = 2 * time.Minute
+	autoDownloadQueueSize       = 256
+)
+
+// Media cache size and age caps by default. Media is kept for good unless
+// an age cap is set, as it is there to outlive WhatsApp's media URLs.
+const (
+	defaultMediaCacheMaxMB  = 2048
+	defaultMediaCacheMaxAge = 0
+)
+
+// mediaCachePruneInterval is how often Put prunes the cache, unless a tenth
+// of maxBytes has been written since the last time.
+const mediaCachePruneInterval = time.Minute
+
+// mediaCache keeps downloaded media on disk, one file per message, so it
+// outlives WhatsApp's media URLs. Files older than maxAge are dropped, then
+// the oldest until the cache fits in maxBytes; zero caps are unlimited. A
+// nil *mediaCache caches nothing.
+type mediaCache struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// evicted, if set, is told the names of the files pruning removed
+	evicted func(files map[string]bool)
+
+	mu         sync.Mutex // serializes pruning, and guards the fields below
+	lastPrune  time.Time
+	sincePrune int64 // bytes written since lastPrune
+}
+
+// loadMediaCache returns the cache at ~/.whatsapp-raycast/media, capped as
+// configured in the environment.
+func loadMediaCache() *mediaCache {
+	home, _ := os.UserHomeDir()
+	maxMB := envInt(envMediaCacheMaxMB, defaultMediaCacheMaxMB)
+	if maxMB < 0 {
+		log.Printf("Invalid %s=%d, using %d", envMediaCacheMaxMB, maxMB, defaultMediaCacheMaxMB)
+		maxMB = defaultMediaCacheMaxMB
+	}
+	return &mediaCache{
+		dir:      filepath.Join(home, ".whatsapp-raycast", "media"),
+		maxBytes: int64(maxMB) << 20,
+		maxAge:   envDuration(envMediaCacheMaxAge, defaultMediaCacheMaxAge),
+	}
+}
+
+// path is where messageID's media is kept. Both ID forms map to the same file.
+func (c *mediaCache) path(messageID string) string {
+	sum := sha256.Sum256([]byte(normalizeMessageID(messageID)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns messageID's cached media, if any.
+func (c *mediaCache) Get(messageID string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(messageID))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores messageID's media. The file is written under a temporary name
+// and renamed, so a reader never sees it half written.
+func (c *mediaCache) Put(messageID string, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("create media cache: %w", err)
+	}
+	path := c.path(messageID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write cached media: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write cached media: %w", err)
+	}
+	if now := time.Now(); c.prunable(now, int64(len(data))) {
+		c.prune(now)
+	}
+	return nil
+}
+
+// has reports whether messageID's media is cached, without reading it.
+func (c *mediaCache) has(messageID string) bool {
+	if c == nil {
+		return false
+	}
+	_, err := os.Stat(c.path(messageID))
+	return err == nil
+}
+
+// prunable counts written bytes and reports whether it is time
+// to prune: mediaCachePruneInterval after the last prune, or sooner if a
+// tenth of maxBytes has been written since.
+func (c *mediaCache) prunable(now time.Time, written int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sincePrune += written
+	return now.Sub(c.lastPrune) >= mediaCachePruneInterval ||
+		(c.maxBytes > 0 && c.sincePrune >= c.maxBytes/10)
+}
+
+// Remove deletes the cached media of messageIDs, such as messages deleted or
+// revoked.
+func (c *mediaCache) Remove(messageIDs ...string) {
+	if c == nil {
+		return
+	}
+	for _, id := range messageIDs {
+		if err := os.Remove(c.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error removing cached media of %s: %v", id, err)
+		}
+	}
+}
+
+// prune enforces the cache's caps as of now, telling evicted which files
+// went.
+func (c *mediaCache) prune(now time.Time) {
+	if c.maxBytes <= 0 && c.maxAge <= 0 {
+		return
+	}
+	removed := c.pruneFiles(now)
+	if len(removed) > 0 && c.evicted != nil {
+		c.evicted(removed)
+	}
+}
+
+func (c *mediaCache) pruneFiles(now time.Time) map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastPrune, c.sincePrune = now, 0
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("Error reading media cache: %v", err)
+		return nil
+	}
+	type cached struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	removed := map[string]bool{}
+	remove := func(name string) bool {
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil {
+			return false
+		}
+		removed[name] = true
+		return true
+	}
+	var files []cached
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if c.maxAge > 0 && now.Sub(info.ModTime()) > c.maxAge {
+			remove(e.Name())
+			continue
+		}
+		files = append(files, cached{e.Name(), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	if c.maxBytes <= 0 || total <= c.maxBytes {
+		return removed
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if remove(f.name) {
+			total -= f.size
+		}
+	}
+	return removed
+}
+
+// mediaAutoDownloader downloads incoming media into the cache in the
+// background, one message at a time with a pause between downloads. A nil
+// *mediaAutoDownloader downloads nothing.
+type mediaAutoDownloader struct {
+	api      waAPI
+	store    *AppStore
+	cache    *mediaCache
+	maxBytes uint64
+	interval time.Duration
+	queue    chan string
+}
+
+// loadMediaAutoDownloader starts an auto-downloader configured from the
+// environment, or returns nil unless WAPP_AUTO_DOWNLOAD_MEDIA is set.
+func loadMediaAutoDownloader(api waAPI, store *AppStore) *mediaAutoDownloader {
+	if !envBool(envAutoDownloadMedia, false) {
+		return nil
+	}
+	maxMB := envInt(envAutoDownloadMaxMB, defaultAutoDownloadMaxMB)
+	if maxMB < 1 {
+		log.Printf("Invalid %s=%d, using %d", envAutoDownloadMaxMB, maxMB, defaultAutoDownloadMaxMB)
+		maxMB = defaultAutoDownloadMaxMB
+	}
+	d := newMediaAutoDownloader(api, store, store.media, uint64(maxMB)<<20,
+		envDuration(envAutoDownloadInterval, defaultAutoDownloadInterval))
+	log.Printf("Auto-downloading incoming media up to %d MB to %s", maxMB, d.cache.dir)
+	return d
+}
+
+func newMediaAutoDownloader(api waAPI, store *AppStore, cache *mediaCache, maxBytes uint64, interval time.Duration) *mediaAutoDownloader {
+	d := &mediaAutoDownloader{
+		api:      api,
+		store:    store,
+		cache:    cache,
+		maxBytes: maxBytes,
+		interval: interval,
+		queue:    make(chan string, autoDownloadQueueSize),
+	}
+	go d.run()
+	return d
+}
+
+// Enqueue schedules messageID's media for download, or marks it skipped if
+// it is over the size cap or the queue is full. It never blocks.
+func (d *mediaAutoDownloader) Enqueue(messageID string, msg *waE2E.Message) {
+	if d == nil || !hasMediaContent(msg) {
+		return
+	}
+	status := mediaStatusPending
+	if size := mediaFileLength(msg); size > d.maxBytes {
+		status = mediaStatusSkipped
+	} else {
+		select {
+		case d.queue <- messageID:
+		default:
+			log.Printf("Auto-download queue full, skipping media of %s", messageID)
+			status = mediaStatusSkipped
+		}
+	}
+	if err := d.store.SetMediaStatus(messageID, status); err != nil {
+		log.Printf("Error storing media status of %s: %v", messageID, err)
+	}
+}
+
+// run downloads the media queued by Enqueue, after any left pending when the
+// bridge last stopped.
+func (d *mediaAutoDownloader) run() {
+	pending, err := d.store.GetMediaPendingIDs()
+	if err != nil {
+		log.Printf("Error loading pending media downloads: %v", err)
+	} else if len(pending) > 0 {
+		log.Printf("Resuming %d pending media downloads", len(pending))
+	}
+	for _, id := range pending {
+		d.process(id)
+	}
+	for id := range d.queue {
+		d.process(id)
+	}
+}
+
+// process downloads messageID's media, unless it is already cached, and
+// records how that went.
+func (d *mediaAutoDownloader) process(messageID string) {
+	status := mediaStatusDownloaded
+	if !d.cache.has(messageID) {
+		if err := d.download(messageID); err != nil {
+			log.Printf("Auto-download of media of %s failed: %v", messageID, err)
+			status = mediaStatusFailed
+		}
+		defer time.Sleep(d.interval)
+	}
+	if err := d.store.SetMediaStatus(messageID, status); err != nil {
+		log.Printf("Error storing media status of %s: %v", messageID, err)
+	}
+}
+
+func (d *mediaAutoDownloader) download(messageID string) error {
+	rawProto, err := d.store.GetRawProto(messageID)
+	if err != nil {
+		return err
+	}
+	var msg waE2E.Message
+	if err := proto.Unmarshal(rawProto, &msg); err != nil {
+		return fmt.Errorf("unmarshal proto: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), autoDownloadTimeout)
+	defer cancel()
+	data, err := d.api.DownloadAny(ctx, &msg)
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	return d.cache.Put(messageID, data)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// storeImage stores an incoming image message of the given size and returns
+// its proto.
+func storeImage(t *testing.T, store *AppStore, id string, size uint64) *waE2E.Message {
+	t.Helper()
+	msg := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Mimetype: proto.String("image/jpeg"), FileLength: proto.Uint64(size)}}
+	raw, _ := proto.Marshal(msg)
+	mediaType := "image"
+	if err := store.UpsertMessage(id, "10000000001@s.whatsapp.net", "10000000001@s.whatsapp.net", "Alice", false, "", 100, true, &mediaType, raw); err != nil {
+		t.Fatalf("UpsertMessage: %v", err)
+	}
+	return msg
+}
+
+func mediaStatusOf(t *testing.T, store *AppStore, id string) string {
+	t.Helper()
+	msgs, err := store.GetMessages("10000000001@s.whatsapp.net", 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	for _, m := range msgs {
+		if m.ID == id {
+			return m.MediaStatus
+		}
+	}
+	t.Fatalf("message %s not stored", id)
+	return ""
+}
+
+func TestMediaAutoDownloader(t *testing.T) {
+	store := newTestStore(t)
+	cache := &mediaCache{dir: t.TempDir()}
+	d := newMediaAutoDownloader(newFakeWA(), store, cache, 1000, 0)
+
+	small := storeImage(t, store, "false_10000000001@c.us_SMALL", 500)
+	large := storeImage(t, store, "false_10000000001@c.us_LARGE", 5000)
+	d.Enqueue("false_10000000001@c.us_LARGE", large)
+	d.Enqueue("false_10000000001@c.us_SMALL", small)
+	d.Enqueue("false_10000000001@c.us_TEXT", &waE2E.Message{Conversation: proto.String("hi")})
+
+	if got := mediaStatusOf(t, store, "false_10000000001@c.us_LARGE"); got != mediaStatusSkipped {
+		t.Errorf("large media status = %q, want skipped", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mediaStatusOf(t, store, "false_10000000001@c.us_SMALL") != mediaStatusDownloaded {
+		if time.Now().After(deadline) {
+			t.Fatalf("small media status = %q, want downloaded", mediaStatusOf(t, store, "false_10000000001@c.us_SMALL"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Either ID form finds the cached file
+	if data, ok := cache.Get("false_10000000001@s.whatsapp.net_SMALL"); !ok || string(data) != "media" {
+		t.Errorf("cached = %q, %v", data, ok)
+	}
+	if _, ok := cache.Get("false_10000000001@c.us_LARGE"); ok {
+		t.Error("skipped media was cached")
+	}
+
+	// Off by default
+	var none *mediaAutoDownloader
+	none.Enqueue("false_10000000001@c.us_SMALL", small)
+}
+
+func TestHandleDownloadMedia_Cached(t *testing.T) {
+	srv, _ := newTestServer(t)
+	srv.media = &mediaCache{dir: t.TempDir()}
+	storeImage(t, srv.store, "false_10000000001@c.us_IMG", 500)
+	if err := srv.media.Put("false_10000000001@c.us_IMG", []byte("cached")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	body, _ := json.Marshal(DownloadMediaRequest{MessageID: "false_10000000001@c.us_IMG"})
+	rec := serve(t, "POST /download-media", srv.handleDownloadMedia, httptest.NewRequest(http.MethodPost, "/download-media", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if data, _ := base64.StdEncoding.DecodeString(resp["data"]); string(data) != "cached" || !strings.HasPrefix(resp["mimetype"], "image/jpeg") {
+		t.Errorf("response = %v, want the cached media", resp)
+	}
+}
+
+func TestMediaCache_Prune(t *testing.T) {
+	cache := &mediaCache{dir: t.TempDir(), maxBytes: 10, maxAge: time.Hour}
+	now := time.Now()
+	put := func(id string, size int, age time.Duration) {
+		if err := cache.Put(id, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(cache.path(id), now.Add(-age), now.Add(-age))
+	}
+	put("false_10000000001@c.us_STALE", 1, 2*time.Hour)
+	put("false_10000000001@c.us_OLD", 4, 30*time.Minute)
+	put("false_10000000001@c.us_MID", 4, 20*time.Minute)
+	put("false_10000000001@c.us_NEW", 4, 10*time.Minute)
+	cache.prune(now)
+
+	for id, want := range map[string]bool{
+		"false_10000000001@c.us_STALE": false, // past maxAge
+		"false_10000000001@c.us_OLD":   false, // oldest, evicted to fit maxBytes
+		"false_10000000001@c.us_MID":   true,
+		"false_10000000001@c.us_NEW":   true,
+	} {
+		if _, ok := cache.Get(id); ok != want {
+			t.Errorf("%s cached = %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestMediaCache_DroppedWithMessages(t *testing.T) {
+	store := newTestStore(t)
+	store.media = &mediaCache{dir: t.TempDir()}
+	chatJID := "10000000001@s.whatsapp.net"
+	for _, id := range []string{"false_10000000001@c.us_REVOKED", "false_10000000001@c.us_CLEARED", "false_10000000001@c.us_STARRED"} {
+		storeImage(t, store, id, 100)
+		store.media.Put(id, []byte("media"))
+		store.SetMediaStatus(id, mediaStatusDownloaded)
+	}
+	store.SetMessageStarred("false_10000000001@c.us_STARRED", true)
+
+	store.RevokeMessage("false_10000000001@c.us_REVOKED")
+	if _, ok := store.media.Get("false_10000000001@c.us_REVOKED"); ok {
+		t.Error("revoked message's media still cached")
+	}
+	store.ClearMessages(chatJID)
+	if _, ok := store.media.Get("false_10000000001@c.us_CLEARED"); ok {
+		t.Error("cleared message's media still cached")
+	}
+	if _, ok := store.media.Get("false_10000000001@c.us_STARRED"); !ok {
+		t.Error("starred message's media dropped with the chat's other messages")
+	}
+
+	store.SetMessageStarred("false_10000000001@c.us_STARRED", false)
+	store.DeleteChat(chatJID)
+	if _, ok := store.media.Get("false_10000000001@c.us_STARRED"); ok {
+		t.Error("deleted chat's media still cached")
+	}
+}
+
+func TestMediaCache_PruneResetsStatus(t *testing.T) {
+	store := newTestStore(t)
+	store.setMediaCache(&mediaCache{dir: t.TempDir(), maxBytes: 10})
+	now := time.Now()
+	for i, id := range []string{"false_10000000001@c.us_OLD", "false_10000000001@c.us_NEW"} {
+		storeImage(t, store, id, 8)
+		store.media.Put(id, make([]byte, 8))
+		os.Chtimes(store.media.path(id), now.Add(time.Duration(i-2)*time.Minute), now.Add(time.Duration(i-2)*time.Minute))
+		store.SetMediaStatus(id, mediaStatusDownloaded)
+	}
+	store.media.prune(now)
+
+	if got := mediaStatusOf(t, store, "false_10000000001@c.us_OLD"); got != "" {
+		t.Errorf("evicted media status = %q, want none", got)
+	}
+	if got := mediaStatusOf(t, store, "false_10000000001@c.us_NEW"); got != mediaStatusDownloaded {
+		t.Errorf("kept media status = %q, want downloaded", got)
+	}
+}
+
+func TestMediaCache_PruneThrottled(t *testing.T) {
+	cache := &mediaCache{dir: t.TempDir(), maxBytes: 1000}
+	now := time.Now()
+	if !cache.prunable(now, 1) {
+		t.Error("first write not pruned")
+	}
+	cache.prune(now)
+	if cache.prunable(now.Add(time.Second), 10) {
+		t.Error("small write right after a prune pruned again")
+	}
+	if !cache.prunable(now.Add(2*time.Second), 100) {
+		t.Error("a tenth of the cap written since the last prune not pruned")
+	}
+	cache.prune(now.Add(2 * time.Second))
+	if !cache.prunable(now.Add(2*time.Second+mediaCachePruneInterval), 1) {
+		t.Error("write after the prune interval not pruned")
+	}
+}
+
+func TestMediaAutoDownloader_ResumesPending(t *testing.T) {
+	store := newTestStore(t)
+	cache := &mediaCache{dir: t.TempDir()}
+	storeImage(t, store, "false_10000000001@c.us_PENDING", 500)
+	storeImage(t, store, "false_10000000001@c.us_CACHED", 500)
+	for _, id := range []string{"false_10000000001@c.us_PENDING", "false_10000000001@c.us_CACHED"} {
+		store.SetMediaStatus(id, mediaStatusPending)
+	}
+	cache.Put("false_10000000001@c.us_CACHED", []byte("cached"))
+	newMediaAutoDownloader(newFakeWA(), store, cache, 1000, 0)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range []string{"false_10000000001@c.us_PENDING", "false_10000000001@c.us_CACHED"} {
+		for mediaStatusOf(t, store, id) != mediaStatusDownloaded {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s media status = %q, want downloaded", id, mediaStatusOf(t, store, id))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if data, _ := cache.Get("false_10000000001@c.us_CACHED"); string(data) != "cached" {
+		t.Errorf("cached media downloaded again: %q", data)
+	}
+	if data, _ := cache.Get("false_10000000001@c.us_PENDING"); string(data) != "media" {
+		t.Errorf("pending media = %q, want downloaded", data)
+	}
+}
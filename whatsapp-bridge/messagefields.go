@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// messageJSONField is one field of Message as it is serialized.
+type messageJSONField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// messageJSONFields lists Message's JSON fields in declaration order.
+var messageJSONFields = func() []messageJSONField {
+	t := reflect.TypeOf(Message{})
+	var fields []messageJSONField
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, messageJSONField{name: name, index: i, omitEmpty: strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}()
+
+// messageFields selects which Message fields a response carries, from the
+// fields query parameter (fields=id,body,timestamp). Selected fields keep
+// their usual order and omitempty behavior. A nil messageFields selects all
+// of them and serializes exactly like json.Marshal.
+type messageFields []messageJSONField
+
+// parseMessageFields parses a comma-separated list of Message JSON field
+// names. An empty list selects every field.
+func parseMessageFields(raw string) (messageFields, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	want := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			want[name] = true
+		}
+	}
+	var fields messageFields
+	for _, f := range messageJSONFields {
+		if want[f.name] {
+			fields = append(fields, f)
+			delete(want, f.name)
+		}
+	}
+	for name := range want {
+		return nil, fmt.Errorf("unknown message field %q", name)
+	}
+	return fields, nil
+}
+
+// marshal serializes m with only the selected fields.
+func (f messageFields) marshal(m Message) ([]byte, error) {
+	if f == nil {
+		return json.Marshal(m)
+	}
+	v := reflect.ValueOf(m)
+	buf := []byte{'{'}
+	for _, field := range f {
+		fv := v.Field(field.index)
+		if field.omitEmpty && isEmptyJSONValue(fv) {
+			continue
+		}
+		data, err := json.Marshal(fv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '"')
+		buf = append(buf, field.name...)
+		buf = append(buf, '"', ':')
+		buf = append(buf, data...)
+	}
+	return append(buf, '}'), nil
+}
+
+// isEmptyJSONValue reports whether omitempty leaves v out, by
+// encoding/json's rule: false, 0, a nil pointer or interface, and an empty
+// array, slice, map or string. Structs are never empty.
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// response returns msgs and meta as a MessagesResponse with only the
+// selected message fields.
+func (f messageFields) response(msgs []Message, meta MessagesMeta) (any, error) {
+	if f == nil {
+		return MessagesResponse{Messages: msgs, MessagesMeta: meta}, nil
+	}
+	raw := make([]json.RawMessage, len(msgs))
+	for i, m := range msgs {
+		data, err := f.marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		raw[i] = data
+	}
+	return struct {
+		Messages []json.RawMessage `json:"messages"`
+		MessagesMeta
+	}{raw, meta}, nil
+}
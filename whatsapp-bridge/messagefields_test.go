@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMessageFieldsMarshal(t *testing.T) {
+	name := "Alice"
+	edited := int64(300)
+	m := Message{ID: "false_10000000001@c.us_A", Body: "hi", Timestamp: 100, From: "10000000001@c.us", SenderName: &name, EditedAt: &edited}
+
+	// No selection serializes exactly like json.Marshal
+	all, err := parseMessageFields(" ")
+	if err != nil || all != nil {
+		t.Fatalf("parseMessageFields(blank) = %v, %v", all, err)
+	}
+	got, _ := all.marshal(m)
+	want, _ := json.Marshal(m)
+	if string(got) != string(want) {
+		t.Errorf("marshal all = %s, want %s", got, want)
+	}
+
+	f, err := parseMessageFields("editedAt, senderName,fromMe,mediaType")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = f.marshal(m)
+	if string(got) != `{"fromMe":false,"senderName":"Alice","editedAt":300}` {
+		t.Errorf("marshal selected = %s", got)
+	}
+
+	if _, err := parseMessageFields("id,Body"); err == nil {
+		t.Error("field names should be case-sensitive, like the JSON")
+	}
+}
+
+func TestMessageFieldsMarshal_EveryFieldMatchesJSON(t *testing.T) {
+	names := make([]string, len(messageJSONFields))
+	for i, f := range messageJSONFields {
+		names[i] = f.name
+	}
+	every, err := parseMessageFields(strings.Join(names, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := ""
+	for _, m := range []Message{
+		{},
+		{ID: "true_10000000001@c.us_A", FromMe: true, Status: DeliveryStatusRead, SenderName: &empty, Pinned: true},
+	} {
+		got, _ := every.marshal(m)
+		want, _ := json.Marshal(m)
+		if string(got) != string(want) {
+			t.Errorf("every field selected = %s, want %s", got, want)
+		}
+	}
+}
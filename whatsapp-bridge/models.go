@@ -3,27 +3,191 @@ package main
 // Response types — must match raycast-whatsapp/src/api.ts exactly
 
 type Contact struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Number  string `json:"number"`
-	IsGroup bool   `json:"isGroup"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Number       string `json:"number"`
+	IsGroup      bool   `json:"isGroup"`
+	About        string `json:"about,omitempty"` // a contact's status line or a group's description
+	BusinessName string `json:"businessName,omitempty"`
+	Verified     bool   `json:"verified,omitempty"` // BusinessName is WhatsApp-verified
+
+	// Name is whichever of these comes first. They are also given apart, as
+	// a push name unlike the saved name can be a sign of impersonation.
+	SavedName string `json:"savedName,omitempty"` // from your address book
+	PushName  string `json:"pushName,omitempty"`  // the name they chose for themselves
+}
+
+// ContactDetail is everything known about one contact or group, for
+// GET /contacts/{chatId}.
+type ContactDetail struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Number       string `json:"number"`
+	IsGroup      bool   `json:"isGroup"`
+	SavedName    string `json:"savedName,omitempty"` // from your address book; Name falls back to the chat name
+	PushName     string `json:"pushName,omitempty"`
+	BusinessName string `json:"businessName,omitempty"`
+	Verified     bool   `json:"verified,omitempty"`
+	About        string `json:"about,omitempty"`
+	AvatarURL    string `json:"avatarUrl,omitempty"`
+	Blocked      *bool  `json:"blocked,omitempty"` // nil if the blocklist couldn't be fetched
+
+	// Groups only, as of the last group refresh
+	Participants     []GroupParticipant `json:"participants,omitempty"`
+	GroupRefreshedAt int64              `json:"groupRefreshedAt,omitempty"` // unix seconds
+}
+
+// GroupParticipant is a member of a group.
+type GroupParticipant struct {
+	ID    string `json:"id"`
+	Admin bool   `json:"admin,omitempty"`
+	// LID and Phone are the participant's other JIDs, internal format, where
+	// WhatsApp gives them; kept for naming LID senders, not served
+	LID   string `json:"-"`
+	Phone string `json:"-"`
+}
+
+// GroupInfo is a group's live metadata, for GET /groups/{chatId}.
+type GroupInfo struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	Topic        string        `json:"topic,omitempty"`     // the group description
+	CreatedAt    int64         `json:"createdAt,omitempty"` // unix seconds
+	Owner        string        `json:"owner,omitempty"`
+	Participants []GroupMember `json:"participants"`
+}
+
+// GroupMember is a participant of a group as WhatsApp reports it, named the
+// way message senders are.
+type GroupMember struct {
+	ID           string `json:"id"`
+	Name         string `json:"name,omitempty"`
+	Number       string `json:"number,omitempty"` // empty if hidden
+	IsAdmin      bool   `json:"isAdmin"`
+	IsSuperAdmin bool   `json:"isSuperAdmin"`
 }
 
 type Message struct {
-	ID         string  `json:"id"`
-	Body       string  `json:"body"`
-	FromMe     bool    `json:"fromMe"`
-	Timestamp  int64   `json:"timestamp"`
-	From       string  `json:"from"`
-	SenderName *string `json:"senderName,omitempty"`
-	HasMedia   bool    `json:"hasMedia"`
-	MediaType  *string `json:"mediaType,omitempty"`
+	ID           string  `json:"id"`
+	Body         string  `json:"body"`
+	FromMe       bool    `json:"fromMe"`
+	Timestamp    int64   `json:"timestamp"`
+	From         string  `json:"from"`
+	SenderName   *string `json:"senderName,omitempty"`
+	SenderNumber *string `json:"senderNumber,omitempty"` // only with includeSender=true
+	HasMedia     bool    `json:"hasMedia"`
+	MediaType    *string `json:"mediaType,omitempty"`
+	Source       string  `json:"source,omitempty"`
+	EditedAt     *int64  `json:"editedAt,omitempty"` // unix seconds of the latest edit
+	// ReceivedAt is when the bridge first stored the message (unix seconds);
+	// against Timestamp it shows processing lag. Unset for messages stored
+	// before it was recorded.
+	ReceivedAt *int64 `json:"receivedAt,omitempty"`
+	// MediaStatus is how auto-download (WAPP_AUTO_DOWNLOAD_MEDIA) of the
+	// message's media went: pending, downloaded, failed or skipped.
+	MediaStatus string `json:"mediaStatus,omitempty"`
+
+	Forwarded       bool `json:"forwarded,omitempty"`
+	ForwardingScore int  `json:"forwardingScore,omitempty"` // 5+ is "Forwarded many times"
+
+	// QuotedMessageID is the message this one replies to, which may not be
+	// stored; QuotedPreview is the start of its text as the reply carried it.
+	QuotedMessageID string `json:"quotedMessageId,omitempty"`
+	QuotedPreview   string `json:"quotedPreview,omitempty"`
+
+	Pinned  bool `json:"pinned,omitempty"`
+	Starred bool `json:"starred,omitempty"`
+	// Revoked is set once the message was deleted for everyone; its body
+	// is then "[deleted]"
+	Revoked bool `json:"revoked,omitempty"`
+
+	// Status is how far a message we sent has got: sent, delivered or read
+	// (the single, double and blue check marks). Unset on incoming messages.
+	Status string `json:"status,omitempty"`
+}
+
+// Delivery statuses of messages we sent, in the order they advance.
+const (
+	DeliveryStatusSent      = "sent"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusRead      = "read"
+)
+
+// Message sources. Only messages you sent carry one: incoming messages and
+// those imported from history sync have an empty source.
+const (
+	MessageSourceBridge = "bridge" // sent through this bridge's API
+	MessageSourcePhone  = "phone"  // sent from the primary phone
+	MessageSourceDevice = "device" // sent from another linked device (Web, Desktop)
+)
+
+// ChatSyncState records how far back a chat's history has been synced.
+type ChatSyncState struct {
+	ChatID         string `json:"chatId"`
+	LastSyncAt     int64  `json:"lastSyncAt"`
+	OldestSyncedTs *int64 `json:"oldestSyncedTs,omitempty"`
+	FullySynced    bool   `json:"fullySynced"`
+}
+
+// LiveLocation is the latest known position of a live-location share.
+type LiveLocation struct {
+	MessageID      string  `json:"messageId"`
+	ChatID         string  `json:"chatId"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyMeters int     `json:"accuracyMeters,omitempty"`
+	SpeedMps       float64 `json:"speedMps,omitempty"`
+	Heading        int     `json:"heading,omitempty"` // degrees clockwise from magnetic north
+	Caption        string  `json:"caption,omitempty"`
+	Sequence       int64   `json:"sequence"`
+	StartedAt      int64   `json:"startedAt"` // unix seconds the share began
+	UpdatedAt      int64   `json:"updatedAt"` // unix seconds of the latest position
+}
+
+// BroadcastList is a personal broadcast list and the members it delivers to.
+type BroadcastList struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// BroadcastSendResult is the outcome of delivering a broadcast list message
+// to one member.
+type BroadcastSendResult struct {
+	ChatID    string `json:"chatId"`
+	MessageID string `json:"messageId,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type MessagesResponse struct {
-	Messages  []Message `json:"messages"`
-	FromCache bool      `json:"fromCache"`
-	Empty     *bool     `json:"empty,omitempty"`
+	Messages []Message `json:"messages"`
+	MessagesMeta
+}
+
+// MessagesMeta holds the MessagesResponse fields that follow the message
+// list. It is separate so the list can be streamed ahead of it.
+type MessagesMeta struct {
+	FromCache bool  `json:"fromCache"`
+	Empty     *bool `json:"empty,omitempty"`
+	// Clamped is set when the requested limit exceeded the maximum; Limit is
+	// then the limit actually applied. Page further back with before=.
+	Clamped bool `json:"clamped,omitempty"`
+	Limit   int  `json:"limit,omitempty"`
+	// Error and Code are set, as in an error response, when reading the
+	// messages failed partway through a streamed list, which is then cut
+	// short.
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// MessageCounts is the count=true response of GET /chats/{chatId}/messages:
+// chat metadata without the messages themselves. OldestTs and NewestTs are
+// omitted when the chat has no stored messages.
+type MessageCounts struct {
+	Count       int   `json:"count"`
+	UnreadCount int   `json:"unreadCount"`
+	OldestTs    int64 `json:"oldestTs,omitempty"`
+	NewestTs    int64 `json:"newestTs,omitempty"`
 }
 
 type Chat struct {
@@ -34,6 +198,14 @@ type Chat struct {
 	LastMessageTimestamp  *int64  `json:"lastMessageTimestamp,omitempty"`
 	IsGroup              bool   `json:"isGroup"`
 	MessageCount         int    `json:"messageCount"`
+	DeletedAt            *int64 `json:"deletedAt,omitempty"` // set while the chat is in the trash
+	LeftAt               *int64 `json:"leftAt,omitempty"`    // set once we have left the group
+	// Type is individual, group, community, announcement or broadcast
+	Type     string `json:"chatType"`
+	Archived bool   `json:"archived,omitempty"`
+	// MutedUntil is when (unix seconds) the chat's mute ends, or
+	// MutedForever; unset if not muted.
+	MutedUntil int64 `json:"mutedUntil,omitempty"`
 }
 
 type ConnectionStatus string
@@ -44,6 +216,8 @@ const (
 	StatusQR            ConnectionStatus = "qr"
 	StatusAuthenticated ConnectionStatus = "authenticated"
 	StatusReady         ConnectionStatus = "ready"
+	StatusFailed        ConnectionStatus = "failed" // gave up reconnecting; needs POST /reconnect
+	StatusStuck         ConnectionStatus = "stuck"  // paired but never became ready; about to reconnect
 )
 
 type StatusResponse struct {
@@ -52,30 +226,199 @@ type StatusResponse struct {
 	LastConnectedAt *int64           `json:"lastConnectedAt,omitempty"`
 	LastDisconnectedAt *int64        `json:"lastDisconnectedAt,omitempty"`
 	OfflineGapSecs  *int64           `json:"offlineGapSecs,omitempty"`
+	// Consecutive reconnect attempts since the last successful connection.
+	ReconnectAttempts int `json:"reconnectAttempts,omitempty"`
+	// Per-connection counters; reset every time the client (re)connects.
+	MessagesReceived int64  `json:"messagesReceived"`
+	LastMessageAt    *int64 `json:"lastMessageAt,omitempty"`
+	// Times since startup that pairing succeeded but the connection never
+	// became ready within WAPP_READY_TIMEOUT, and when that last happened.
+	ReadyTimeouts      int    `json:"readyTimeouts,omitempty"`
+	LastReadyTimeoutAt *int64 `json:"lastReadyTimeoutAt,omitempty"`
+	// The recent-message sync run on connect, as configured.
+	ConnectSync ConnectSync `json:"connectSync"`
+
+	// The paired device (JID with device number), its phone number and our
+	// push name; unset before pairing.
+	DeviceJID string `json:"deviceJid,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+	PushName  string `json:"pushName,omitempty"`
+	// Stored chats (as listed by GET /chats, archived included) and messages.
+	Chats    int `json:"chats"`
+	Messages int `json:"messages"`
+	// When a chat's history last synced, from history sync or on demand.
+	LastSyncAt      *int64 `json:"lastSyncAt,omitempty"`
+	DeepSyncRunning bool   `json:"deepSyncRunning"`
 }
 
 type QRResponse struct {
 	QR      *string `json:"qr"`
 	Message *string `json:"message,omitempty"`
+	// With a QR: its sequence number, which goes up as WhatsApp rotates
+	// codes, and when (unix seconds) the next one replaces it.
+	Seq       int   `json:"seq,omitempty"`
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// ProfilePicture is the response of GET /contacts/{chatId}/avatar. PictureID
+// changes whenever the picture does; URL is WhatsApp's, valid for a limited
+// time.
+type ProfilePicture struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	PictureID string `json:"pictureId,omitempty"`
+	Preview   bool   `json:"preview"`
+	UpdatedAt int64  `json:"updatedAt"` // when it was fetched from WhatsApp
+	Cached    bool   `json:"cached"`
 }
 
-// Request bodies
+// ReadyzResponse reports each readiness check; DB is "unavailable" if the
+// database check failed, with the error only in the bridge's log.
+type ReadyzResponse struct {
+	Ready    bool             `json:"ready"`
+	WhatsApp ConnectionStatus `json:"whatsapp"`
+	DB       string           `json:"db"`
+}
+
+// Request bodies. TimeoutMs optionally overrides the default WhatsApp call
+// timeout for the request (capped server-side).
 
 type SendRequest struct {
-	ChatID          string  `json:"chatId"`
-	Message         string  `json:"message"`
-	QuotedMessageID *string `json:"quotedMessageId,omitempty"`
+	ChatID          string       `json:"chatId"`
+	Message         string       `json:"message"`
+	QuotedMessageID *string      `json:"quotedMessageId,omitempty"`
+	QuotedMatch     *QuotedMatch `json:"quotedMatch,omitempty"` // alternative to quotedMessageId
+	TimeoutMs       int          `json:"timeoutMs,omitempty"`
+}
+
+// QuotedMatch picks the message to quote by content: the newest message in
+// the chat whose text contains Text, from From if given. From is a chatId,
+// or "me" for our own messages.
+type QuotedMatch struct {
+	From string `json:"from,omitempty"`
+	Text string `json:"text"`
 }
 
 type SendImageRequest struct {
 	ChatID  string  `json:"chatId"`
 	Base64  string  `json:"base64"`
 	Caption *string `json:"caption,omitempty"`
+	// Mimetype overrides the type detected from the data; it must be image/*
+	Mimetype string `json:"mimetype,omitempty"`
+	// AsDocument sends the image as a document, which WhatsApp doesn't
+	// compress, named FileName (by default image.<type>)
+	AsDocument bool   `json:"asDocument,omitempty"`
+	FileName   string `json:"fileName,omitempty"`
+	TimeoutMs  int    `json:"timeoutMs,omitempty"`
+}
+
+type SendVideoRequest struct {
+	ChatID  string  `json:"chatId"`
+	Base64  string  `json:"base64"`
+	Caption *string `json:"caption,omitempty"`
+	// GifPlayback sends the clip as a looping, muted GIF
+	GifPlayback bool `json:"gifPlayback,omitempty"`
+	// Mimetype overrides the type detected from the data; it must be video/*
+	Mimetype  string `json:"mimetype,omitempty"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+type SendAudioRequest struct {
+	ChatID string `json:"chatId"`
+	Base64 string `json:"base64"`
+	// PTT sends a push-to-talk voice note, which WhatsApp expects as Ogg
+	// Opus; Waveform (up to 64 samples, 0-100, base64 in JSON) is the
+	// waveform it draws for one
+	PTT      bool   `json:"ptt,omitempty"`
+	Seconds  uint32 `json:"seconds,omitempty"`
+	Waveform []byte `json:"waveform,omitempty"`
+	// Mimetype overrides the type detected from the data, and the Ogg Opus
+	// of a voice note; it must be audio/*
+	Mimetype  string `json:"mimetype,omitempty"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+type EditMessageRequest struct {
+	MessageID string `json:"messageId"`
+	NewText   string `json:"newText"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
 }
 
 type ReactRequest struct {
 	MessageID string `json:"messageId"`
 	Emoji     string `json:"emoji"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+// ReactLastRequest reacts to the newest message in a chat, whatever its ID.
+type ReactLastRequest struct {
+	ChatID    string `json:"chatId"`
+	Emoji     string `json:"emoji"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+// ForwardRequest forwards a stored message, text or media, to another chat.
+type ForwardRequest struct {
+	SourceMessageID string `json:"sourceMessageId"`
+	TargetChatID    string `json:"targetChatId"`
+	TimeoutMs       int    `json:"timeoutMs,omitempty"`
+}
+
+// PinMessageRequest pins a message in its chat.
+type PinMessageRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+// MuteChatRequest mutes a chat for Duration seconds or until the unix time
+// Until. With neither, the chat stays muted until unmuted.
+type MuteChatRequest struct {
+	Duration  int64 `json:"duration,omitempty"`
+	Until     int64 `json:"until,omitempty"`
+	TimeoutMs int   `json:"timeoutMs,omitempty"`
+}
+
+type ButtonResponseRequest struct {
+	MessageID string `json:"messageId"`
+	ButtonID  string `json:"buttonId"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+type ListResponseRequest struct {
+	MessageID string `json:"messageId"`
+	RowID     string `json:"rowId"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
+type DeleteChatsRequest struct {
+	ChatIDs []string `json:"chatIds"`
+	Confirm bool     `json:"confirm,omitempty"`
+}
+
+// DeleteChatResult reports what POST /chats/delete did with one chat.
+type DeleteChatResult struct {
+	ChatID  string `json:"chatId"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+type CreateGroupRequest struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"` // chatIds of the members to add
+	TimeoutMs    int      `json:"timeoutMs,omitempty"`
+}
+
+type GroupParticipantsRequest struct {
+	Participants []string `json:"participants"` // chatIds
+	TimeoutMs    int      `json:"timeoutMs,omitempty"`
+}
+
+// ParticipantChangeResult is the outcome of adding, removing, promoting or
+// demoting one group participant. Code is WhatsApp's error code on failure.
+type ParticipantChangeResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 type DownloadMediaRequest struct {
@@ -86,6 +429,15 @@ type ResolveNumberRequest struct {
 	Number string `json:"number"`
 }
 
+type ResolveSenderRequest struct {
+	SenderJID string `json:"senderJid"`
+	// ChatID is the group the sender wrote in: it lets a LID sender be
+	// matched through the group's participants, and limits the update to
+	// that chat's messages
+	ChatID    string `json:"chatId,omitempty"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+}
+
 // Search types
 
 type SearchResult struct {
@@ -94,6 +446,15 @@ type SearchResult struct {
 	ChatJID  string `json:"chatJid"`
 }
 
+// ChatSearchGroup is one chat's slice of a grouped search: its best matches
+// plus the total number of matching messages in that chat.
+type ChatSearchGroup struct {
+	ChatJID    string    `json:"chatJid"`
+	ChatName   string    `json:"chatName"`
+	MatchCount int       `json:"matchCount"`
+	Messages   []Message `json:"messages"`
+}
+
 // Internal types
 
 type msgIDParts struct {
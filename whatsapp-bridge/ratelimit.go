@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sendLimits caps how fast messages go out through the API. WhatsApp bans
+// accounts that send like bots, so each limit is a token bucket holding up
+// to its count, refilled evenly over Window: bursts up to the count are
+// allowed, then sends are spaced out.
+type sendLimits struct {
+	Global  int // messages per Window across all chats
+	PerChat int // messages per Window to any one chat
+	Window  time.Duration
+}
+
+var defaultSendLimits = sendLimits{
+	Global:  30,
+	PerChat: 5,
+	Window:  time.Minute,
+}
+
+// tokenBucket is a bucket of send tokens, as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill, up to capacity.
+func (b *tokenBucket) refill(now time.Time, capacity int, window time.Duration) {
+	rate := float64(capacity) / window.Seconds()
+	b.tokens = math.Min(float64(capacity), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// wait is how long until the bucket holds n tokens.
+func (b *tokenBucket) wait(n int, capacity int, window time.Duration) time.Duration {
+	missing := float64(n) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	rate := float64(capacity) / window.Seconds()
+	return time.Duration(missing / rate * float64(time.Second))
+}
+
+// sendLimiter enforces sendLimits. Per-chat buckets are dropped once idle
+// long enough to have refilled, so the map only holds recently used chats.
+type sendLimiter struct {
+	limits sendLimits
+	now    func() time.Time
+
+	mu        sync.Mutex
+	global    tokenBucket
+	perChat   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newSendLimiter(limits sendLimits) *sendLimiter {
+	return &sendLimiter{
+		limits:  limits,
+		now:     time.Now,
+		global:  tokenBucket{tokens: float64(limits.Global)},
+		perChat: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token for one message to each of chats, plus one global
+// token per message, and returns 0. If any bucket is short it takes nothing
+// and returns how long until the send would be allowed.
+func (l *sendLimiter) Allow(chats ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if l.global.last.IsZero() {
+		l.global.last = now
+	}
+	l.sweep(now)
+
+	l.global.refill(now, l.limits.Global, l.limits.Window)
+	retry := l.global.wait(len(chats), l.limits.Global, l.limits.Window)
+	buckets := make([]*tokenBucket, len(chats))
+	for i, chat := range chats {
+		b := l.perChat[chat]
+		if b == nil {
+			b = &tokenBucket{tokens: float64(l.limits.PerChat), last: now}
+			l.perChat[chat] = b
+		}
+		b.refill(now, l.limits.PerChat, l.limits.Window)
+		retry = max(retry, b.wait(1, l.limits.PerChat, l.limits.Window))
+		buckets[i] = b
+	}
+	if retry > 0 {
+		return retry
+	}
+
+	l.global.tokens -= float64(len(chats))
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+// sweep drops per-chat buckets idle for a whole window, at most once a
+// window. Such a bucket is full again, the same as a new one.
+func (l *sendLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limits.Window {
+		return
+	}
+	for chat, b := range l.perChat {
+		if now.Sub(b.last) >= l.limits.Window {
+			delete(l.perChat, chat)
+		}
+	}
+	l.lastSweep = now
+}
+
+// allowSend applies the send rate limit to a message to each of chats
+// (internal JIDs), writing a 429 with Retry-After if it is exceeded. A send
+// to more chats than the global limit could never be allowed, so it gets a
+// 400 instead. A server without a limiter allows everything.
+func (s *Server) allowSend(w http.ResponseWriter, chats ...string) bool {
+	if s.sendLimiter == nil {
+		return true
+	}
+	if limit := s.sendLimiter.limits.Global; len(chats) > limit {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidParam,
+			fmt.Sprintf("cannot send to %d chats at once: the send rate limit allows %d messages per %s",
+				len(chats), limit, s.sendLimiter.limits.Window))
+		return false
+	}
+	retry := s.sendLimiter.Allow(chats...)
+	if retry == 0 {
+		return true
+	}
+	secs := int(math.Ceil(retry.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited,
+		fmt.Sprintf("send rate limit reached (%d messages per %s, %d per chat); retry in %ds",
+			s.sendLimiter.limits.Global, s.sendLimiter.limits.Window, s.sendLimiter.limits.PerChat, secs))
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newSendLimiter(sendLimits{Global: 3, PerChat: 2, Window: time.Minute})
+	l.now = func() time.Time { return now }
+
+	// A chat's burst is capped at PerChat
+	for i := 0; i < 2; i++ {
+		if retry := l.Allow("a"); retry != 0 {
+			t.Fatalf("send %d to a: retry %s, want allowed", i+1, retry)
+		}
+	}
+	if retry := l.Allow("a"); retry != 30*time.Second {
+		t.Errorf("third send to a: retry %s, want 30s (one per-chat token)", retry)
+	}
+
+	// The global bucket has one token left, so two chats at once wait
+	if retry := l.Allow("b", "c"); retry != 20*time.Second {
+		t.Errorf("send to b and c: retry %s, want 20s (one global token)", retry)
+	}
+	if retry := l.Allow("b"); retry != 0 {
+		t.Errorf("send to b: retry %s, want allowed", retry)
+	}
+	if retry := l.Allow("c"); retry == 0 {
+		t.Error("send to c allowed with the global bucket empty")
+	}
+
+	// Tokens come back over the window, and idle chats are swept
+	now = now.Add(time.Minute)
+	if retry := l.Allow("a"); retry != 0 {
+		t.Errorf("send to a after a window: retry %s, want allowed", retry)
+	}
+	if _, ok := l.perChat["c"]; ok {
+		t.Error("idle bucket for c not swept")
+	}
+}
+
+func TestHandleSend_RateLimited(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.sendLimiter = newSendLimiter(sendLimits{Global: 30, PerChat: 1, Window: time.Minute})
+
+	send := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(SendRequest{ChatID: "10000000001@c.us", Message: "hi"})
+		return serve(t, "POST /send", srv.handleSend, httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader(body)))
+	}
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Fatalf("first send = %d: %s", rec.Code, rec.Body)
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second send = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	var resp map[string]string
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["code"] != ErrCodeRateLimited {
+		t.Errorf("code = %q", resp["code"])
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d messages, want 1", len(fake.sent))
+	}
+}
+
+func TestHandleSend_BroadcastListOverLimit(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.sendLimiter = newSendLimiter(sendLimits{Global: 2, PerChat: 1, Window: time.Minute})
+	srv.store.SetBroadcastListMembers("1700000000@broadcast", []string{
+		"10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "10000000003@s.whatsapp.net",
+	})
+
+	body, _ := json.Marshal(SendRequest{ChatID: "1700000000@broadcast", Message: "hi all"})
+	rec := serve(t, "POST /send", srv.handleSend, httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("list larger than the limit: status = %d, Retry-After = %q; want 400 without", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if len(fake.sent) != 0 {
+		t.Errorf("sent %d messages, want none", len(fake.sent))
+	}
+}
+
+func TestHandleEditAndReact_RateLimited(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.sendLimiter = newSendLimiter(sendLimits{Global: 30, PerChat: 1, Window: time.Minute})
+	srv.store.UpsertMessage("true_10000000001@c.us_MINE", "10000000001@s.whatsapp.net", "", "", true, "hi", 100, false, nil, nil)
+
+	body, _ := json.Marshal(EditMessageRequest{MessageID: "true_10000000001@c.us_MINE", NewText: "hello"})
+	if rec := serve(t, "POST /edit-message", srv.handleEditMessage, httptest.NewRequest(http.MethodPost, "/edit-message", bytes.NewReader(body))); rec.Code != http.StatusOK {
+		t.Fatalf("edit = %d: %s", rec.Code, rec.Body)
+	}
+	body, _ = json.Marshal(ReactLastRequest{ChatID: "10000000001@c.us", Emoji: "👍"})
+	rec := serve(t, "POST /react-last", srv.handleReactLast, httptest.NewRequest(http.MethodPost, "/react-last", bytes.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("react-last after edit = %d, want 429", rec.Code)
+	}
+	body, _ = json.Marshal(ReactRequest{MessageID: "true_10000000001@c.us_MINE", Emoji: "👍"})
+	rec = serve(t, "POST /react", srv.handleReact, httptest.NewRequest(http.MethodPost, "/react", bytes.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("react after edit = %d, want 429", rec.Code)
+	}
+	body, _ = json.Marshal(EditMessageRequest{MessageID: "true_10000000001@c.us_MINE", NewText: "hello again"})
+	rec = serve(t, "POST /edit-message", srv.handleEditMessage, httptest.NewRequest(http.MethodPost, "/edit-message", bytes.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second edit = %d, want 429", rec.Code)
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("sent %d messages, want 1", len(fake.sent))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// openSession opens the whatsmeow session database at dbPath (with the
+// SQLite parameters params) and returns its device, a new unpaired one if
+// the database holds none.
+//
+// A database SQLite reports as corrupt, or as not a database at all, is
+// moved aside and replaced by an empty one, so the bridge starts and asks to
+// pair again rather than failing on every start. Other errors are returned
+// with a hint to start with -reset-session, which moves the database aside
+// whatever state it is in.
+func openSession(dbPath, params string, reset bool, dbLog waLog.Logger) (*sqlstore.Container, *store.Device, error) {
+	if reset {
+		moved, err := moveSessionAside(dbPath, "reset")
+		if err != nil {
+			return nil, nil, err
+		}
+		if moved != "" {
+			log.Printf("Session reset: moved %s to %s; scan the QR code to pair again", dbPath, moved)
+		}
+	}
+
+	container, device, err := loadSession(dbPath, params, dbLog)
+	if err == nil {
+		return container, device, nil
+	}
+	if !isCorruptDB(err) {
+		return nil, nil, fmt.Errorf("%w (if the session is broken, start with -reset-session to discard it and pair again)", err)
+	}
+
+	moved, moveErr := moveSessionAside(dbPath, "corrupt")
+	if moveErr != nil {
+		return nil, nil, fmt.Errorf("%w; moving it aside failed: %v", err, moveErr)
+	}
+	log.Printf("Session database is corrupted (%v); moved it to %s. Scan the QR code to pair again", err, moved)
+	return loadSession(dbPath, params, dbLog)
+}
+
+// loadSession opens the session database and loads its device.
+func loadSession(dbPath, params string, dbLog waLog.Logger) (*sqlstore.Container, *store.Device, error) {
+	ctx := context.Background()
+	container, err := sqlstore.New(ctx, "sqlite3", "file:"+dbPath+"?_foreign_keys=on&"+params, dbLog)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open session store: %w", err)
+	}
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		container.Close()
+		return nil, nil, fmt.Errorf("get first device: %w", err)
+	}
+	return container, device, nil
+}
+
+// isCorruptDB reports whether err is SQLite finding a damaged database file,
+// as opposed to one it couldn't open or lock.
+func isCorruptDB(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+}
+
+// moveSessionAside renames the session database, with its WAL and shared
+// memory files, to dbPath.<reason>-<time> and returns the new name, or ""
+// if there was no database to move.
+func moveSessionAside(dbPath, reason string) (string, error) {
+	backup := fmt.Sprintf("%s.%s-%s", dbPath, reason, time.Now().Format("20060102-150405"))
+	moved := ""
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+suffix, backup+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("move session database aside: %w", err)
+		}
+		if suffix == "" {
+			moved = backup
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestOpenSession(t *testing.T) {
+	params := loadSQLiteOptions("").params()
+	backups := func(dbPath, reason string) []string {
+		matches, _ := filepath.Glob(dbPath + "." + reason + "-*")
+		return matches
+	}
+
+	t.Run("fresh install", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "whatsmeow.db")
+		container, device, err := openSession(dbPath, params, false, waLog.Noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer container.Close()
+		if device.ID != nil {
+			t.Errorf("device ID = %v, want an unpaired device", device.ID)
+		}
+	})
+
+	t.Run("corrupt database is moved aside", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "whatsmeow.db")
+		garbage := []byte(strings.Repeat("not a database ", 512))
+		if err := os.WriteFile(dbPath, garbage, 0600); err != nil {
+			t.Fatal(err)
+		}
+		container, device, err := openSession(dbPath, params, false, waLog.Noop)
+		if err != nil {
+			t.Fatalf("corrupt session: %v", err)
+		}
+		defer container.Close()
+		if device.ID != nil {
+			t.Errorf("device ID = %v, want an unpaired device", device.ID)
+		}
+		moved := backups(dbPath, "corrupt")
+		if len(moved) != 1 {
+			t.Fatalf("backups = %v, want one", moved)
+		}
+		if data, _ := os.ReadFile(moved[0]); string(data) != string(garbage) {
+			t.Error("backup does not hold the corrupt database")
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "whatsmeow.db")
+		container, _, err := openSession(dbPath, params, false, waLog.Noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		container.Close()
+
+		container, _, err = openSession(dbPath, params, true, waLog.Noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer container.Close()
+		if moved := backups(dbPath, "reset"); len(moved) != 1 {
+			t.Errorf("backups = %v, want one", moved)
+		}
+	})
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -13,7 +16,25 @@ import (
 
 // AppStore is the SQLite data access layer for the WhatsApp bridge.
 type AppStore struct {
-	db *sql.DB
+	db         *sql.DB
+	chatFilter chatFilter
+	media      *mediaCache // dropped with the messages it holds media of; nil: none
+}
+
+// chatFilterSQL returns a WHERE condition on the chat JID column that drops
+// the chats f hides and those in the trash. Every chat listing uses it so
+// they all agree.
+func chatFilterSQL(f chatFilter, column string) string {
+	conds := []string{column + ` NOT IN (SELECT jid FROM chats WHERE deleted_at IS NOT NULL)`}
+	if !f.IncludeLID {
+		conds = append(conds, column+` NOT LIKE '%@lid'`)
+	}
+	if f.IncludeBroadcast {
+		conds = append(conds, column+` != 'status@broadcast'`)
+	} else {
+		conds = append(conds, column+` NOT LIKE '%@broadcast'`)
+	}
+	return strings.Join(conds, " AND ")
 }
 
 // boolToInt converts a Go bool to an integer for SQLite storage.
@@ -24,8 +45,9 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-// NewAppStore opens the database at ~/.whatsapp-raycast/app.db, enables WAL mode
-// with a 5000ms busy timeout, and runs schema migrations.
+// NewAppStore opens the database at ~/.whatsapp-raycast/app.db, in WAL mode
+// with a 5s busy timeout unless configured otherwise (see loadSQLiteOptions),
+// and runs schema migrations.
 func NewAppStore() (*AppStore, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -38,7 +60,9 @@ func NewAppStore() (*AppStore, error) {
 	}
 
 	dbPath := filepath.Join(dir, "app.db")
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	opts := loadSQLiteOptions("WAL")
+	log.Printf("App database: %s", opts)
+	db, err := sql.Open("sqlite3", dbPath+"?"+opts.params())
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
@@ -52,6 +76,14 @@ func NewAppStore() (*AppStore, error) {
 		db.Close()
 		return nil, fmt.Errorf("run migrations: %w", err)
 	}
+	if err := migrateSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("run migrations: %w", err)
+	}
+	if err := migrateFTS(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("run migrations: %w", err)
+	}
 
 	// One-time FTS population: rebuild index if FTS is empty but messages exist.
 	// Using 'rebuild' is the correct way to populate a content= FTS5 table.
@@ -67,9 +99,196 @@ func NewAppStore() (*AppStore, error) {
 		}
 	}
 
+	store := &AppStore{db: db, chatFilter: loadChatFilter()}
+	store.setMediaCache(loadMediaCache())
+
+	// Canonicalize message IDs stored under the legacy @s.whatsapp.net chat form
+	if n, err := store.CanonicalizeMessageIDs(); err != nil {
+		log.Printf("Message ID canonicalization failed: %v", err)
+	} else if n > 0 {
+		log.Printf("Canonicalized %d legacy message IDs", n)
+	}
+
+	return store, nil
+}
+
+// NewAppStoreFromDB wraps an already-open database in an AppStore. No schema
+// or migrations are applied; the caller owns the schema. Tests use this to
+// inject a schema without the FTS5 virtual table.
+func NewAppStoreFromDB(db *sql.DB) (*AppStore, error) {
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
 	return &AppStore{db: db}, nil
 }
 
+// schemaTx is what a migration step runs against: the migration's
+// transaction, or the database itself.
+type schemaTx interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// schemaVersion returns how many of migrations have been applied to db.
+func schemaVersion(db schemaTx) (int, error) {
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrateSchema applies the migrations db has not had yet, in order, each in
+// its own transaction along with the bump of schema_version, so a failed
+// step leaves the database at the previous version. appSchema must have
+// been run first. A database from a newer build is refused rather than
+// used with a schema this build doesn't know.
+func migrateSchema(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin tx: %w", err)
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", i+1, m.description, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_version`); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: clear schema version: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: set schema version: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: commit: %w", i+1, err)
+		}
+		log.Printf("Schema migrated to version %d: %s", i+1, m.description)
+	}
+	return nil
+}
+
+// addMissingColumns adds any column listed in appColumns that its table does
+// not yet have. It is safe to run more than once.
+func addMissingColumns(db schemaTx) error {
+	for _, c := range appColumns {
+		if err := addColumn(c.table, c.column, c.definition)(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn returns a migration step that adds a column to a table, unless
+// the table already has it, as one created by the current appSchema does.
+func addColumn(table, column, definition string) func(tx schemaTx) error {
+	return func(tx schemaTx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("inspect %s.%s: %w", table, column, err)
+		}
+		if n > 0 {
+			return nil
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", table, column, err)
+		}
+		return nil
+	}
+}
+
+// migrateFTS creates the full-text index. An index from before media_search
+// was indexed is dropped and recreated, after filling in media_search for
+// existing messages; NewAppStore then rebuilds the empty index.
+func migrateFTS(db *sql.DB) error {
+	var exists, current int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'`).Scan(&exists); err != nil {
+		return fmt.Errorf("inspect messages_fts: %w", err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('messages_fts') WHERE name = 'media_search'`).Scan(&current); err != nil {
+		return fmt.Errorf("inspect messages_fts: %w", err)
+	}
+	if exists > 0 && current == 0 {
+		for _, stmt := range []string{
+			`DROP TRIGGER IF EXISTS messages_fts_ai`,
+			`DROP TRIGGER IF EXISTS messages_fts_ad`,
+			`DROP TRIGGER IF EXISTS messages_fts_au`,
+			`DROP TABLE messages_fts`,
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("drop old FTS index: %w", err)
+			}
+		}
+		n, err := backfillMediaSearch(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("FTS upgrade: indexed media details of %d messages", n)
+	}
+	if _, err := db.Exec(ftsSchema); err != nil {
+		return fmt.Errorf("create FTS index: %w", err)
+	}
+	return nil
+}
+
+// backfillMediaSearch fills in media_search for stored media messages.
+func backfillMediaSearch(db *sql.DB) (int, error) {
+	rows, err := db.Query(`SELECT rowid, media_type, raw_proto FROM messages WHERE media_type IS NOT NULL AND media_search = ''`)
+	if err != nil {
+		return 0, fmt.Errorf("query media messages: %w", err)
+	}
+	type update struct {
+		rowid  int64
+		search string
+	}
+	var updates []update
+	for rows.Next() {
+		var rowid int64
+		var mediaType string
+		var rawProto []byte
+		if err := rows.Scan(&rowid, &mediaType, &rawProto); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan media message: %w", err)
+		}
+		updates = append(updates, update{rowid, mediaSearchText(&mediaType, rawProto)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate media messages: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	for _, u := range updates {
+		if _, err := tx.Exec(`UPDATE messages SET media_search = ? WHERE rowid = ?`, u.search, u.rowid); err != nil {
+			return 0, fmt.Errorf("update media search text: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return len(updates), nil
+}
+
+// Ping runs a trivial query, checking the database can still be read.
+func (s *AppStore) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+}
+
 // Close closes the underlying database connection.
 func (s *AppStore) Close() error {
 	return s.db.Close()
@@ -114,22 +333,152 @@ func (s *AppStore) UpdatePushName(jid, pushName string) error {
 	return nil
 }
 
+// SetBusinessName stores the verified business name of jid. WhatsApp only
+// sends business names with a verified-name certificate, so a contact with
+// one is verified.
+func (s *AppStore) SetBusinessName(jid, name string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO contacts (jid, business_name, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET
+			business_name = excluded.business_name,
+			updated_at    = excluded.updated_at
+	`, jid, name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("set business name %s: %w", jid, err)
+	}
+	return nil
+}
+
+// SetContactAbout stores the about text of a contact, or the description of a
+// group, and marks it as refreshed now. An empty about is stored too: it is
+// what WhatsApp returns for users who hide theirs.
+func (s *AppStore) SetContactAbout(jid, about string) error {
+	now := time.Now().Unix()
+	_, err := s.db.Exec(`
+		INSERT INTO contacts (jid, is_group, about, about_updated_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET
+			about            = excluded.about,
+			about_updated_at = excluded.about_updated_at
+	`, jid, boolToInt(strings.HasSuffix(jid, "@g.us")), about, now, now)
+	if err != nil {
+		return fmt.Errorf("set about %s: %w", jid, err)
+	}
+	return nil
+}
+
+// MarkAboutRefreshed records a refresh attempt of jid's about text that
+// failed, so it waits a full interval before the next one. The stored about
+// text stays.
+func (s *AppStore) MarkAboutRefreshed(jid string) error {
+	now := time.Now().Unix()
+	_, err := s.db.Exec(`
+		INSERT INTO contacts (jid, about_updated_at, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET about_updated_at = excluded.about_updated_at
+	`, jid, now, now)
+	if err != nil {
+		return fmt.Errorf("mark about %s refreshed: %w", jid, err)
+	}
+	return nil
+}
+
+// GetContactAbout returns the stored about text for jid, or a wrapped
+// sql.ErrNoRows if the contact is unknown.
+func (s *AppStore) GetContactAbout(jid string) (string, error) {
+	var about string
+	err := s.db.QueryRow(`SELECT about FROM contacts WHERE jid = ?`, jid).Scan(&about)
+	if err != nil {
+		return "", fmt.Errorf("get about %s: %w", jid, err)
+	}
+	return about, nil
+}
+
+// ProfilePic is a cached profile picture lookup. An empty URL records that
+// the chat has no picture we may see.
+type ProfilePic struct {
+	URL       string
+	ID        string
+	UpdatedAt int64 // unix seconds
+}
+
+// GetProfilePic returns the cached profile picture of jid, full size or
+// preview, or a wrapped sql.ErrNoRows if it was never looked up.
+func (s *AppStore) GetProfilePic(jid string, preview bool) (*ProfilePic, error) {
+	var p ProfilePic
+	err := s.db.QueryRow(`
+		SELECT url, id, updated_at FROM profile_pics WHERE jid = ? AND preview = ?
+	`, jid, boolToInt(preview)).Scan(&p.URL, &p.ID, &p.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get profile picture %s: %w", jid, err)
+	}
+	return &p, nil
+}
+
+// SetProfilePic caches a profile picture lookup of jid as of now.
+func (s *AppStore) SetProfilePic(jid string, preview bool, url, id string) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO profile_pics (jid, preview, url, id, updated_at) VALUES (?, ?, ?, ?, ?)
+	`, jid, boolToInt(preview), url, id, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("set profile picture %s: %w", jid, err)
+	}
+	return nil
+}
+
+// GetStaleAboutJIDs returns up to limit listed user chats whose about text
+// was last refreshed before staleBefore (unix seconds), least recently
+// refreshed first. Group descriptions are kept fresh by the group refresh.
+func (s *AppStore) GetStaleAboutJIDs(staleBefore int64, limit int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT ch.jid
+		FROM chats ch
+		LEFT JOIN contacts ct ON ch.jid = ct.jid
+		WHERE `+chatFilterSQL(s.chatFilter, "ch.jid")+`
+			AND ch.jid LIKE '%@s.whatsapp.net'
+			AND COALESCE(ct.about_updated_at, 0) < ?
+		ORDER BY COALESCE(ct.about_updated_at, 0) ASC, ch.last_msg_ts DESC
+		LIMIT ?
+	`, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query stale about: %w", err)
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, fmt.Errorf("scan stale about: %w", err)
+		}
+		jids = append(jids, jid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate stale about: %w", err)
+	}
+	return jids, nil
+}
+
 // GetContacts returns all contacts sorted by display name.
-// Display name precedence: name, then push_name, then number.
+// Display name precedence: name, then business_name, then push_name, then
+// number.
 // JIDs are returned in API format via toAPIJIDString.
 func (s *AppStore) GetContacts() ([]Contact, error) {
 	// Query all chats (individuals + groups) LEFT JOIN contacts for display names.
 	rows, err := s.db.Query(`
 		SELECT ch.jid,
-			COALESCE(NULLIF(ct.name, ''), NULLIF(ct.push_name, ''), NULLIF(ch.name, ''),
+			COALESCE(NULLIF(ct.name, ''), NULLIF(ct.business_name, ''), NULLIF(ct.push_name, ''), NULLIF(ch.name, ''),
 				REPLACE(REPLACE(ch.jid, '@s.whatsapp.net', ''), '@c.us', '')) AS display_name,
 			COALESCE(NULLIF(ct.number, ''),
 				REPLACE(REPLACE(ch.jid, '@s.whatsapp.net', ''), '@c.us', '')) AS number,
-			ch.is_group
+			ch.is_group,
+			COALESCE(ct.about, ''),
+			COALESCE(ct.business_name, ''),
+			COALESCE(ct.name, ''),
+			COALESCE(ct.push_name, '')
 		FROM chats ch
 		LEFT JOIN contacts ct ON ch.jid = ct.jid
-		WHERE ch.jid NOT LIKE '%@lid'
-			AND ch.jid NOT LIKE '%@broadcast'
+		WHERE `+chatFilterSQL(s.chatFilter, "ch.jid")+`
 		ORDER BY display_name COLLATE NOCASE ASC
 	`)
 	if err != nil {
@@ -139,17 +488,96 @@ func (s *AppStore) GetContacts() ([]Contact, error) {
 
 	contacts := make([]Contact, 0)
 	for rows.Next() {
-		var jid, displayName, number string
+		var jid, displayName, number, about, businessName, savedName, pushName string
 		var isGroup int
-		if err := rows.Scan(&jid, &displayName, &number, &isGroup); err != nil {
+		if err := rows.Scan(&jid, &displayName, &number, &isGroup, &about, &businessName, &savedName, &pushName); err != nil {
 			return nil, fmt.Errorf("scan contact: %w", err)
 		}
 
 		contacts = append(contacts, Contact{
-			ID:      toAPIJIDString(jid),
-			Name:    displayName,
-			Number:  number,
-			IsGroup: isGroup != 0,
+			ID:           toAPIJIDString(jid),
+			Name:         displayName,
+			Number:       number,
+			IsGroup:      isGroup != 0,
+			About:        about,
+			BusinessName: businessName,
+			Verified:     businessName != "",
+			SavedName:    savedName,
+			PushName:     pushName,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// GetContactDetail returns the stored record for jid, a contact or a group.
+// It returns a wrapped sql.ErrNoRows if jid is neither in the contacts table
+// nor a chat.
+func (s *AppStore) GetContactDetail(jid string) (*ContactDetail, error) {
+	var name, savedName, pushName, number, about, businessName string
+	var isGroup int
+	var groupRefreshedAt int64
+	err := s.db.QueryRow(`
+		SELECT COALESCE(NULLIF(ct.name, ''), ch.name, ''), COALESCE(ct.name, ''), COALESCE(ct.push_name, ''), COALESCE(ct.number, ''),
+			COALESCE(ct.is_group, ch.is_group, 0), COALESCE(ct.about, ''), COALESCE(ch.group_refreshed_at, 0),
+			COALESCE(ct.business_name, '')
+		FROM (SELECT ? AS jid) k
+		LEFT JOIN contacts ct ON ct.jid = k.jid
+		LEFT JOIN chats ch ON ch.jid = k.jid
+		WHERE ct.jid IS NOT NULL OR ch.jid IS NOT NULL
+	`, jid).Scan(&name, &savedName, &pushName, &number, &isGroup, &about, &groupRefreshedAt, &businessName)
+	if err != nil {
+		return nil, fmt.Errorf("get contact %s: %w", jid, err)
+	}
+	detail := &ContactDetail{
+		ID:               toAPIJIDString(jid),
+		Name:             name,
+		Number:           number,
+		IsGroup:          isGroup != 0,
+		PushName:         pushName,
+		SavedName:        savedName,
+		BusinessName:     businessName,
+		Verified:         businessName != "",
+		About:            about,
+		GroupRefreshedAt: groupRefreshedAt,
+	}
+	if detail.IsGroup {
+		if detail.Participants, err = s.GetGroupParticipants(jid); err != nil {
+			return nil, err
+		}
+	}
+	return detail, nil
+}
+
+// GetContactsForExport returns every individual contact with a phone number,
+// straight from the contacts table, named by saved name or else push name.
+// Groups, LIDs, broadcast lists and newsletters are left out.
+func (s *AppStore) GetContactsForExport() ([]Contact, error) {
+	rows, err := s.db.Query(`
+		SELECT jid, COALESCE(NULLIF(name, ''), NULLIF(push_name, ''), number) AS display_name, number
+		FROM contacts
+		WHERE is_group = 0
+			AND number != ''
+			AND jid LIKE '%@s.whatsapp.net'
+		ORDER BY display_name COLLATE NOCASE ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query contacts for export: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := make([]Contact, 0)
+	for rows.Next() {
+		var jid, displayName, number string
+		if err := rows.Scan(&jid, &displayName, &number); err != nil {
+			return nil, fmt.Errorf("scan contact: %w", err)
+		}
+		contacts = append(contacts, Contact{
+			ID:     toAPIJIDString(jid),
+			Name:   displayName,
+			Number: number,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -162,7 +590,7 @@ func (s *AppStore) GetContacts() ([]Contact, error) {
 func (s *AppStore) GetContactName(jid string) (string, error) {
 	var name string
 	err := s.db.QueryRow(`
-		SELECT COALESCE(NULLIF(name, ''), NULLIF(push_name, ''), '')
+		SELECT COALESCE(NULLIF(name, ''), NULLIF(business_name, ''), NULLIF(push_name, ''), '')
 		FROM contacts WHERE jid = ?
 	`, jid).Scan(&name)
 	if err != nil {
@@ -204,21 +632,82 @@ func (s *AppStore) UpsertChat(jid, name string, isGroup bool, lastMsg *string, l
 	return nil
 }
 
+// chatListOrder orders the chat list newest first. The JID breaks ties so
+// that pages of it never overlap.
+const chatListOrder = `COALESCE(ch.last_msg_ts, 0) DESC, ch.jid`
+
 // GetChats returns all chats ordered by last_msg_ts descending.
 // JIDs are returned in API format.
 func (s *AppStore) GetChats() ([]Chat, error) {
+	return s.queryChats(chatFilterSQL(s.chatFilter, "ch.jid"), chatListOrder)
+}
+
+// GetChatsPaged returns one page of GetChats: up to limit chats, skipping
+// the first offset. hasMore reports whether there are chats after the page.
+func (s *AppStore) GetChatsPaged(limit, offset int) (chats []Chat, hasMore bool, err error) {
+	return s.GetChatsPage(ChatQuery{IncludeArchived: true, Limit: limit, Offset: offset})
+}
+
+// ChatQuery narrows and pages the chat list. The zero value selects every
+// chat GetChats returns that is not archived.
+type ChatQuery struct {
+	UnreadOnly      bool   // only chats with unread messages
+	Type            string // ChatTypeGroup for groups (communities included), ChatTypeIndividual for the rest, "" for both
+	IncludeArchived bool   // archived chats too, which are otherwise left out
+	Limit           int    // most chats returned; 0 for no limit
+	Offset          int    // chats skipped
+}
+
+// GetChatsPage returns the chats q selects, in GetChats order. hasMore
+// reports whether q.Limit cut the list short.
+func (s *AppStore) GetChatsPage(q ChatQuery) (chats []Chat, hasMore bool, err error) {
+	where := chatFilterSQL(s.chatFilter, "ch.jid")
+	if q.UnreadOnly {
+		where += ` AND ch.unread_count > 0`
+	}
+	if !q.IncludeArchived {
+		where += ` AND ch.archived = 0`
+	}
+	switch q.Type {
+	case ChatTypeGroup:
+		where += ` AND ch.is_group = 1`
+	case ChatTypeIndividual:
+		where += ` AND ch.is_group = 0`
+	}
+	if q.Limit <= 0 {
+		// LIMIT -1 is SQLite for no limit
+		chats, err = s.queryChats(where, chatListOrder+` LIMIT -1 OFFSET ?`, q.Offset)
+		return chats, false, err
+	}
+	chats, err = s.queryChats(where, chatListOrder+` LIMIT ? OFFSET ?`, q.Limit+1, q.Offset)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(chats) > q.Limit {
+		return chats[:q.Limit], true, nil
+	}
+	return chats, false, nil
+}
+
+// GetTrashedChats returns the chats in the trash, most recently trashed first.
+func (s *AppStore) GetTrashedChats() ([]Chat, error) {
+	return s.queryChats(`ch.deleted_at IS NOT NULL`, `ch.deleted_at DESC`)
+}
+
+func (s *AppStore) queryChats(where, orderBy string, args ...interface{}) ([]Chat, error) {
+	// The first placeholder is the time an expired mute is reported unmuted at
+	args = append([]interface{}{time.Now().Unix()}, args...)
 	rows, err := s.db.Query(`
 		SELECT ch.jid,
 			COALESCE(NULLIF(ch.name, ''), NULLIF(ct.push_name, ''), NULLIF(ct.name, ''),
 				REPLACE(REPLACE(ch.jid, '@s.whatsapp.net', ''), '@g.us', '')) AS display_name,
-			ch.is_group, ch.unread_count, ch.last_message, ch.last_msg_ts,
+			ch.is_group, ch.unread_count, ch.last_message, ch.last_msg_ts, ch.deleted_at, ch.left_at, ch.chat_type, ch.archived,
+			CASE WHEN ch.muted_until > 0 AND ch.muted_until <= ? THEN 0 ELSE ch.muted_until END,
 			(SELECT COUNT(*) FROM messages m WHERE m.chat_jid = ch.jid) AS msg_count
 		FROM chats ch
 		LEFT JOIN contacts ct ON ch.jid = ct.jid
-		WHERE ch.jid NOT LIKE '%@lid'
-			AND ch.jid NOT LIKE '%@broadcast'
-		ORDER BY COALESCE(ch.last_msg_ts, 0) DESC
-	`)
+		WHERE ` + where + `
+		ORDER BY ` + orderBy, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query chats: %w", err)
 	}
@@ -226,13 +715,17 @@ func (s *AppStore) GetChats() ([]Chat, error) {
 
 	chats := make([]Chat, 0)
 	for rows.Next() {
-		var jid, name string
-		var isGroup, unreadCount, msgCount int
+		var jid, name, chatType string
+		var isGroup, unreadCount, archived, msgCount int
+		var mutedUntil int64
 		var lastMessage *string
-		var lastMsgTs *int64
-		if err := rows.Scan(&jid, &name, &isGroup, &unreadCount, &lastMessage, &lastMsgTs, &msgCount); err != nil {
+		var lastMsgTs, deletedAt, leftAt *int64
+		if err := rows.Scan(&jid, &name, &isGroup, &unreadCount, &lastMessage, &lastMsgTs, &deletedAt, &leftAt, &chatType, &archived, &mutedUntil, &msgCount); err != nil {
 			return nil, fmt.Errorf("scan chat: %w", err)
 		}
+		if chatType == "" {
+			chatType = chatTypeFromJID(jid)
+		}
 
 		chats = append(chats, Chat{
 			ID:                  toAPIJIDString(jid),
@@ -242,6 +735,11 @@ func (s *AppStore) GetChats() ([]Chat, error) {
 			LastMessage:         lastMessage,
 			LastMessageTimestamp: lastMsgTs,
 			MessageCount:        msgCount,
+			DeletedAt:           deletedAt,
+			LeftAt:              leftAt,
+			Type:                chatType,
+			Archived:            archived != 0,
+			MutedUntil:          mutedUntil,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -301,140 +799,1450 @@ func (s *AppStore) DeleteChat(chatJID string) error {
 	}
 	defer tx.Rollback()
 
-	if _, err := tx.Exec(`DELETE FROM messages WHERE chat_jid = ?`, chatJID); err != nil {
-		return fmt.Errorf("delete messages for %s: %w", chatJID, err)
+	_, media, err := deleteChat(tx, chatJID)
+	if err != nil {
+		return err
 	}
-	if _, err := tx.Exec(`DELETE FROM chats WHERE jid = ?`, chatJID); err != nil {
-		return fmt.Errorf("delete chat %s: %w", chatJID, err)
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-
-	return tx.Commit()
+	s.media.Remove(media...)
+	return nil
 }
 
-// UpdateChatLastMessage updates the last message preview and timestamp for a chat.
-func (s *AppStore) UpdateChatLastMessage(chatJID, body string, timestamp int64) error {
-	_, err := s.db.Exec(`
-		UPDATE chats SET last_message = ?, last_msg_ts = ?, updated_at = ? WHERE jid = ?
-	`, body, timestamp, time.Now().Unix(), chatJID)
+// TrashChat moves a chat to the trash: it disappears from every listing but
+// keeps its messages until restored or purged. Trashing a chat already in the
+// trash keeps its original trash time. It reports whether the chat exists.
+func (s *AppStore) TrashChat(chatJID string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE chats SET deleted_at = COALESCE(deleted_at, ?) WHERE jid = ?`, time.Now().Unix(), chatJID)
 	if err != nil {
-		return fmt.Errorf("update chat last message %s: %w", chatJID, err)
+		return false, fmt.Errorf("trash chat %s: %w", chatJID, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// SetGroupLeft records that we left a group, or with left false that we are
+// in it again. Leaving again keeps the original time.
+func (s *AppStore) SetGroupLeft(groupJID string, left bool) error {
+	query := `UPDATE chats SET left_at = NULL WHERE jid = ?`
+	args := []interface{}{groupJID}
+	if left {
+		query = `UPDATE chats SET left_at = COALESCE(left_at, ?) WHERE jid = ?`
+		args = []interface{}{time.Now().Unix(), groupJID}
+	}
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("set left of %s: %w", groupJID, err)
 	}
 	return nil
 }
 
-// ---------------------------------------------------------------------------
-// Messages
-// ---------------------------------------------------------------------------
+// SetChatArchived archives or unarchives a chat.
+func (s *AppStore) SetChatArchived(chatJID string, archived bool) error {
+	if _, err := s.db.Exec(`UPDATE chats SET archived = ? WHERE jid = ?`, boolToInt(archived), chatJID); err != nil {
+		return fmt.Errorf("set archived of %s: %w", chatJID, err)
+	}
+	return nil
+}
 
-// UpsertMessage inserts a message or updates select fields on conflict.
-// Body and sender_name are updated only if the new value is non-empty.
-// Media fields are always updated on conflict.
-func (s *AppStore) UpsertMessage(id, chatJID, senderJID, senderName string, fromMe bool, body string, timestamp int64, hasMedia bool, mediaType *string, rawProto []byte) error {
-	_, err := s.db.Exec(`
-		INSERT INTO messages (id, chat_jid, sender_jid, sender_name, from_me, body, timestamp, has_media, media_type, raw_proto)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(id) DO UPDATE SET
-			body        = CASE WHEN excluded.body        != '' THEN excluded.body        ELSE messages.body        END,
-			sender_name = CASE WHEN excluded.sender_name != '' THEN excluded.sender_name ELSE messages.sender_name END,
-			has_media   = excluded.has_media,
-			media_type  = excluded.media_type,
-			raw_proto   = excluded.raw_proto
-	`, id, chatJID, senderJID, senderName, boolToInt(fromMe), body, timestamp, boolToInt(hasMedia), mediaType, rawProto)
-	if err != nil {
-		return fmt.Errorf("upsert message %s: %w", id, err)
+// MutedForever is the muted_until of a chat muted until it is unmuted.
+const MutedForever = -1
+
+// SetChatMutedUntil mutes a chat until the unix time until, or MutedForever;
+// 0 unmutes it.
+func (s *AppStore) SetChatMutedUntil(chatJID string, until int64) error {
+	if _, err := s.db.Exec(`UPDATE chats SET muted_until = ? WHERE jid = ?`, until, chatJID); err != nil {
+		return fmt.Errorf("set muted_until of %s: %w", chatJID, err)
 	}
 	return nil
 }
 
-// GetMessages returns messages for a chat ordered by timestamp descending, limited to n.
-// If beforeTs > 0, only returns messages with timestamp <= beforeTs.
-// The From field is the sender JID in API format. SenderName is set only if non-empty.
-func (s *AppStore) GetMessages(chatJID string, limit int, beforeTs int64) ([]Message, error) {
-	var rows *sql.Rows
-	var err error
-	// Resolve sender names: direct JID match first, then push_name→contact fallback
-	nameCoalesce := `IFNULL(COALESCE(
-				NULLIF(ct.name, ''), NULLIF(ct.push_name, ''),
-				(SELECT NULLIF(c2.name, '') FROM contacts c2 WHERE c2.push_name = m.sender_name AND c2.push_name != '' LIMIT 1),
-				NULLIF(m.sender_name, ''),
-				(SELECT NULLIF(m2.sender_name, '') FROM messages m2 WHERE m2.sender_jid = m.sender_jid AND m2.sender_name != '' LIMIT 1)
-			), '')`
-	if beforeTs > 0 {
-		rows, err = s.db.Query(`
-			SELECT m.id, m.sender_jid,
-				`+nameCoalesce+` AS sender_name,
-				m.from_me, m.body, m.timestamp, m.has_media, m.media_type
-			FROM messages m
-			LEFT JOIN contacts ct ON ct.jid = m.sender_jid
-			WHERE m.chat_jid = ? AND m.timestamp <= ?
-			ORDER BY m.timestamp DESC
-			LIMIT ?
-		`, chatJID, beforeTs, limit)
-	} else {
-		rows, err = s.db.Query(`
-			SELECT m.id, m.sender_jid,
-				`+nameCoalesce+` AS sender_name,
-				m.from_me, m.body, m.timestamp, m.has_media, m.media_type
-			FROM messages m
-			LEFT JOIN contacts ct ON ct.jid = m.sender_jid
-			WHERE m.chat_jid = ?
-			ORDER BY m.timestamp DESC
-			LIMIT ?
-		`, chatJID, limit)
+// IsChatMuted reports whether a chat is muted at now (unix seconds). A mute
+// that has run out by then is cleared.
+func (s *AppStore) IsChatMuted(chatJID string, now int64) (bool, error) {
+	var until int64
+	err := s.db.QueryRow(`SELECT muted_until FROM chats WHERE jid = ?`, chatJID).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("query messages for %s: %w", chatJID, err)
+		return false, fmt.Errorf("get muted_until of %s: %w", chatJID, err)
 	}
-	defer rows.Close()
-
-	messages := make([]Message, 0)
-	for rows.Next() {
-		var id, senderJID, senderName, body string
-		var fromMe, hasMedia int
-		var ts int64
-		var mediaType *string
-		if err := rows.Scan(&id, &senderJID, &senderName, &fromMe, &body, &ts, &hasMedia, &mediaType); err != nil {
-			return nil, fmt.Errorf("scan message: %w", err)
-		}
-
-		msg := Message{
-			ID:        id,
-			Body:      body,
-			FromMe:    fromMe != 0,
-			Timestamp: ts,
-			From:      toAPIJIDString(senderJID),
-			HasMedia:  hasMedia != 0,
-			MediaType: mediaType,
-		}
-
-		if senderName != "" {
-			sn := senderName
-			msg.SenderName = &sn
+	if until > 0 && until <= now {
+		// Only clear the mute that ran out, not one set since
+		if _, err := s.db.Exec(`UPDATE chats SET muted_until = 0 WHERE jid = ? AND muted_until = ?`, chatJID, until); err != nil {
+			return false, fmt.Errorf("clear expired mute of %s: %w", chatJID, err)
 		}
-
-		messages = append(messages, msg)
+		return false, nil
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate messages: %w", err)
+	return until != 0, nil
+}
+
+// SetChatType stores a chat's type, as learned from history sync or group
+// metadata.
+func (s *AppStore) SetChatType(chatJID, chatType string) error {
+	if _, err := s.db.Exec(`UPDATE chats SET chat_type = ? WHERE jid = ?`, chatType, chatJID); err != nil {
+		return fmt.Errorf("set type of %s: %w", chatJID, err)
 	}
-	return messages, nil
+	return nil
 }
 
-// GetRawProto returns the stored raw protobuf bytes for a message.
-func (s *AppStore) GetRawProto(messageID string) ([]byte, error) {
-	var rawProto []byte
-	err := s.db.QueryRow(`SELECT raw_proto FROM messages WHERE id = ?`, messageID).Scan(&rawProto)
+// RestoreChat takes a chat out of the trash, reporting whether it was there.
+func (s *AppStore) RestoreChat(chatJID string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE chats SET deleted_at = NULL WHERE jid = ? AND deleted_at IS NOT NULL`, chatJID)
 	if err != nil {
-		return nil, fmt.Errorf("get raw proto %s: %w", messageID, err)
+		return false, fmt.Errorf("restore chat %s: %w", chatJID, err)
 	}
-	return rawProto, nil
+	n, _ := res.RowsAffected()
+	return n > 0, nil
 }
 
-// GetLatestMessageID returns the formatted message ID of the most recent message
-// in a chat. The ID is formatted via formatMessageID for API compatibility.
-func (s *AppStore) GetLatestMessageID(chatJID string) (string, error) {
-	var id string
-	err := s.db.QueryRow(`
+// PurgeTrash permanently deletes chats trashed at or before cutoff (unix
+// seconds), with their messages, and returns how many it deleted.
+func (s *AppStore) PurgeTrash(cutoff int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT jid FROM chats WHERE deleted_at IS NOT NULL AND deleted_at <= ?`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("query trashed chats: %w", err)
+	}
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan trashed chat: %w", err)
+		}
+		jids = append(jids, jid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate trashed chats: %w", err)
+	}
+
+	var media []string
+	for _, jid := range jids {
+		_, chatMedia, err := deleteChat(tx, jid)
+		if err != nil {
+			return 0, err
+		}
+		media = append(media, chatMedia...)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	s.media.Remove(media...)
+	return len(jids), nil
+}
+
+// DeleteChats removes several chats and their messages in one transaction:
+// either all are deleted or, on error, none are. found reports, per JID,
+// whether there was a chat or any message to delete.
+func (s *AppStore) DeleteChats(chatJIDs []string) (found []bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	found = make([]bool, len(chatJIDs))
+	var media []string
+	for i, jid := range chatJIDs {
+		var chatMedia []string
+		if found[i], chatMedia, err = deleteChat(tx, jid); err != nil {
+			return nil, err
+		}
+		media = append(media, chatMedia...)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	s.media.Remove(media...)
+	return found, nil
+}
+
+// deleteChat deletes a chat, its messages (the FTS delete trigger drops their
+// index entries), its sync state and its live locations within tx. It
+// reports whether the chat or any of its messages existed, and the IDs of
+// the deleted messages whose media may be cached, to drop once tx commits.
+// Starred messages are kept, as WhatsApp keeps them by default; unstar them
+// first to delete them too.
+func deleteChat(tx *sql.Tx, chatJID string) (bool, []string, error) {
+	media, err := cachedMediaIDs(tx, `chat_jid = ? AND starred = 0`, chatJID)
+	if err != nil {
+		return false, nil, err
+	}
+	msgs, err := tx.Exec(`DELETE FROM messages WHERE chat_jid = ? AND starred = 0`, chatJID)
+	if err != nil {
+		return false, nil, fmt.Errorf("delete messages for %s: %w", chatJID, err)
+	}
+	chats, err := tx.Exec(`DELETE FROM chats WHERE jid = ?`, chatJID)
+	if err != nil {
+		return false, nil, fmt.Errorf("delete chat %s: %w", chatJID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM chat_sync_state WHERE chat_jid = ?`, chatJID); err != nil {
+		return false, nil, fmt.Errorf("delete sync state for %s: %w", chatJID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_participants WHERE group_jid = ?`, chatJID); err != nil {
+		return false, nil, fmt.Errorf("delete participants of %s: %w", chatJID, err)
+	}
+	if err := deleteOrphanLiveLocations(tx, chatJID); err != nil {
+		return false, nil, err
+	}
+	nMsgs, _ := msgs.RowsAffected()
+	nChats, _ := chats.RowsAffected()
+	return nMsgs+nChats > 0, media, nil
+}
+
+// cachedMediaIDs returns the IDs of the messages matching where, a condition
+// on messages, whose media the auto-downloader cached.
+func cachedMediaIDs(tx *sql.Tx, where string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(`SELECT id FROM messages WHERE media_status = ? AND `+where,
+		append([]interface{}{mediaStatusDownloaded}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("query cached media: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan cached media: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ClearMessages deletes every message in a chat but keeps the chat itself,
+// like WhatsApp's "Clear chat": the chat stays listed, with no preview and
+// nothing unread. Its sync state goes too, as it described the deleted
+// messages. Starred messages stay, as with deleteChat. It returns the number
+// of messages deleted.
+func (s *AppStore) ClearMessages(chatJID string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	media, err := cachedMediaIDs(tx, `chat_jid = ? AND starred = 0`, chatJID)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE chat_jid = ? AND starred = 0`, chatJID)
+	if err != nil {
+		return 0, fmt.Errorf("delete messages for %s: %w", chatJID, err)
+	}
+	deleted, _ := res.RowsAffected()
+	if _, err := tx.Exec(`
+		UPDATE chats SET last_message = NULL, last_msg_ts = NULL, unread_count = 0, updated_at = ? WHERE jid = ?
+	`, time.Now().Unix(), chatJID); err != nil {
+		return 0, fmt.Errorf("reset chat %s: %w", chatJID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM chat_sync_state WHERE chat_jid = ?`, chatJID); err != nil {
+		return 0, fmt.Errorf("delete sync state for %s: %w", chatJID, err)
+	}
+	if err := deleteOrphanLiveLocations(tx, chatJID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	s.media.Remove(media...)
+	return deleted, nil
+}
+
+// DeleteMessagesUpTo deletes a chat's messages sent at or before ts (unix
+// seconds), starred ones aside, returning how many were deleted and how many
+// remain.
+func (s *AppStore) DeleteMessagesUpTo(chatJID string, ts int64) (deleted int64, remaining int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	media, err := cachedMediaIDs(tx, `chat_jid = ? AND timestamp <= ? AND starred = 0`, chatJID, ts)
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE chat_jid = ? AND timestamp <= ? AND starred = 0`, chatJID, ts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("delete messages for %s: %w", chatJID, err)
+	}
+	deleted, _ = res.RowsAffected()
+	if err := deleteOrphanLiveLocations(tx, chatJID); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_jid = ?`, chatJID).Scan(&remaining); err != nil {
+		return 0, 0, fmt.Errorf("count messages for %s: %w", chatJID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("commit: %w", err)
+	}
+	s.media.Remove(media...)
+	return deleted, remaining, nil
+}
+
+// UpdateChatLastMessage updates the last message preview and timestamp for a chat.
+func (s *AppStore) UpdateChatLastMessage(chatJID, body string, timestamp int64) error {
+	_, err := s.db.Exec(`
+		UPDATE chats SET last_message = ?, last_msg_ts = ?, updated_at = ? WHERE jid = ?
+	`, body, timestamp, time.Now().Unix(), chatJID)
+	if err != nil {
+		return fmt.Errorf("update chat last message %s: %w", chatJID, err)
+	}
+	return nil
+}
+
+// ReconcileChatPreviews recomputes last_message and last_msg_ts from the
+// newest stored message, fixing previews that drifted from the messages table
+// (after edits, imports out of order, and so on). It covers one chat, or all
+// chats if chatJID is empty, leaves chats without messages untouched, and
+// returns how many chats changed.
+func (s *AppStore) ReconcileChatPreviews(chatJID string) (int64, error) {
+	rows, err := s.db.Query(`
+		SELECT c.jid, c.last_message, c.last_msg_ts, m.body, m.timestamp
+		FROM chats c
+		JOIN messages m ON m.rowid = (
+			SELECT rowid FROM messages WHERE chat_jid = c.jid
+			ORDER BY timestamp DESC, rowid DESC LIMIT 1
+		)
+		WHERE ? = '' OR c.jid = ?
+	`, chatJID, chatJID)
+	if err != nil {
+		return 0, fmt.Errorf("query chat previews: %w", err)
+	}
+	type preview struct {
+		jid, body string
+		ts        int64
+	}
+	var stale []preview
+	for rows.Next() {
+		var jid, body string
+		var lastMsg sql.NullString
+		var lastTs sql.NullInt64
+		var ts int64
+		if err := rows.Scan(&jid, &lastMsg, &lastTs, &body, &ts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan chat preview: %w", err)
+		}
+		body = truncate(body, 100)
+		if !lastMsg.Valid || lastMsg.String != body || !lastTs.Valid || lastTs.Int64 != ts {
+			stale = append(stale, preview{jid, body, ts})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate chat previews: %w", err)
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	for _, p := range stale {
+		if _, err := tx.Exec(`UPDATE chats SET last_message = ?, last_msg_ts = ? WHERE jid = ?`, p.body, p.ts, p.jid); err != nil {
+			return 0, fmt.Errorf("update chat preview %s: %w", p.jid, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return int64(len(stale)), nil
+}
+
+// ---------------------------------------------------------------------------
+// Groups
+// ---------------------------------------------------------------------------
+
+// SetGroupInfo records a refresh of a group: its name (kept if name is
+// empty), its chat type (likewise), its participant roster, and the refresh
+// time.
+func (s *AppStore) SetGroupInfo(groupJID, name, chatType string, participants []GroupParticipant) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE chats SET
+			name = CASE WHEN ? != '' THEN ? ELSE name END,
+			chat_type = CASE WHEN ? != '' THEN ? ELSE chat_type END,
+			group_refreshed_at = ?
+		WHERE jid = ?
+	`, name, name, chatType, chatType, time.Now().Unix(), groupJID); err != nil {
+		return fmt.Errorf("update group %s: %w", groupJID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM group_participants WHERE group_jid = ?`, groupJID); err != nil {
+		return fmt.Errorf("clear participants of %s: %w", groupJID, err)
+	}
+	for _, p := range participants {
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO group_participants (group_jid, participant_jid, is_admin, lid, phone) VALUES (?, ?, ?, ?, ?)
+		`, groupJID, p.ID, boolToInt(p.Admin), p.LID, p.Phone); err != nil {
+			return fmt.Errorf("add participant %s to %s: %w", p.ID, groupJID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkGroupRefreshed records a refresh attempt of a group that failed, so it
+// waits a full interval before the next one. Its name and roster stay.
+func (s *AppStore) MarkGroupRefreshed(groupJID string) error {
+	if _, err := s.db.Exec(`UPDATE chats SET group_refreshed_at = ? WHERE jid = ?`, time.Now().Unix(), groupJID); err != nil {
+		return fmt.Errorf("mark group %s refreshed: %w", groupJID, err)
+	}
+	return nil
+}
+
+// GetGroupParticipants returns a group's roster as of its last refresh,
+// admins first. IDs are in API format.
+func (s *AppStore) GetGroupParticipants(groupJID string) ([]GroupParticipant, error) {
+	rows, err := s.db.Query(`
+		SELECT participant_jid, is_admin FROM group_participants
+		WHERE group_jid = ?
+		ORDER BY is_admin DESC, participant_jid
+	`, groupJID)
+	if err != nil {
+		return nil, fmt.Errorf("query participants of %s: %w", groupJID, err)
+	}
+	defer rows.Close()
+
+	var participants []GroupParticipant
+	for rows.Next() {
+		var jid string
+		var admin int
+		if err := rows.Scan(&jid, &admin); err != nil {
+			return nil, fmt.Errorf("scan participant: %w", err)
+		}
+		participants = append(participants, GroupParticipant{ID: toAPIJIDString(jid), Admin: admin != 0})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate participants: %w", err)
+	}
+	return participants, nil
+}
+
+// GetGroupRoster returns a group's roster as stored, in internal format and
+// with each participant's LID and phone number where known. It is empty if
+// the group was never refreshed.
+func (s *AppStore) GetGroupRoster(groupJID string) ([]GroupParticipant, error) {
+	rows, err := s.db.Query(`
+		SELECT participant_jid, is_admin, lid, phone FROM group_participants WHERE group_jid = ?
+	`, groupJID)
+	if err != nil {
+		return nil, fmt.Errorf("query roster of %s: %w", groupJID, err)
+	}
+	defer rows.Close()
+
+	var roster []GroupParticipant
+	for rows.Next() {
+		var p GroupParticipant
+		var admin int
+		if err := rows.Scan(&p.ID, &admin, &p.LID, &p.Phone); err != nil {
+			return nil, fmt.Errorf("scan participant: %w", err)
+		}
+		p.Admin = admin != 0
+		roster = append(roster, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate roster: %w", err)
+	}
+	return roster, nil
+}
+
+// GetStaleGroupJIDs returns up to limit untrashed group chats we are still in
+// last refreshed before staleBefore (unix seconds), least recently refreshed
+// first.
+func (s *AppStore) GetStaleGroupJIDs(staleBefore int64, limit int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT jid FROM chats
+		WHERE jid LIKE '%@g.us' AND deleted_at IS NULL AND left_at IS NULL AND group_refreshed_at < ?
+		ORDER BY group_refreshed_at ASC, last_msg_ts DESC
+		LIMIT ?
+	`, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query stale groups: %w", err)
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, fmt.Errorf("scan stale group: %w", err)
+		}
+		jids = append(jids, jid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate stale groups: %w", err)
+	}
+	return jids, nil
+}
+
+// ---------------------------------------------------------------------------
+// Live locations
+// ---------------------------------------------------------------------------
+
+// SetLiveLocation stores loc as the current position of the live-location
+// share loc.MessageID, unless a later update (higher sequence) is already
+// stored.
+func (s *AppStore) SetLiveLocation(chatJID, senderJID string, loc LiveLocation) error {
+	return setLiveLocation(s.db, chatJID, senderJID, loc)
+}
+
+// SetLiveLocation stores a live-location position within the batch.
+func (b *MessageBatch) SetLiveLocation(chatJID, senderJID string, loc LiveLocation) error {
+	return setLiveLocation(b.tx, chatJID, senderJID, loc)
+}
+
+func setLiveLocation(db execer, chatJID, senderJID string, loc LiveLocation) error {
+	_, err := db.Exec(`
+		INSERT INTO live_locations (message_id, chat_jid, sender_jid, latitude, longitude,
+			accuracy_m, speed_mps, heading, caption, sequence, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(message_id) DO UPDATE SET
+			latitude   = excluded.latitude,
+			longitude  = excluded.longitude,
+			accuracy_m = excluded.accuracy_m,
+			speed_mps  = excluded.speed_mps,
+			heading    = excluded.heading,
+			caption    = CASE WHEN excluded.caption != '' THEN excluded.caption ELSE live_locations.caption END,
+			sequence   = excluded.sequence,
+			updated_at = excluded.updated_at
+		WHERE excluded.sequence >= live_locations.sequence
+	`, loc.MessageID, chatJID, senderJID, loc.Latitude, loc.Longitude,
+		loc.AccuracyMeters, loc.SpeedMps, loc.Heading, loc.Caption, loc.Sequence, loc.StartedAt, loc.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("set live location %s: %w", loc.MessageID, err)
+	}
+	return nil
+}
+
+// HasLiveLocation reports whether messageID started a stored live-location
+// share.
+func (s *AppStore) HasLiveLocation(messageID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM live_locations WHERE message_id = ?`, messageID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("look up live location %s: %w", messageID, err)
+	}
+	return n > 0, nil
+}
+
+// deleteOrphanLiveLocations deletes the live-location shares of chatJID whose
+// message is gone.
+func deleteOrphanLiveLocations(tx *sql.Tx, chatJID string) error {
+	if _, err := tx.Exec(`
+		DELETE FROM live_locations
+		WHERE chat_jid = ? AND message_id NOT IN (SELECT id FROM messages WHERE chat_jid = ?)
+	`, chatJID, chatJID); err != nil {
+		return fmt.Errorf("delete live locations for %s: %w", chatJID, err)
+	}
+	return nil
+}
+
+// GetLiveLocation returns the latest position of the live-location share
+// started by messageID, or a wrapped sql.ErrNoRows if it isn't one.
+func (s *AppStore) GetLiveLocation(messageID string) (*LiveLocation, error) {
+	var loc LiveLocation
+	var chatJID string
+	err := s.db.QueryRow(`
+		SELECT message_id, chat_jid, latitude, longitude, accuracy_m, speed_mps, heading,
+			caption, sequence, started_at, updated_at
+		FROM live_locations WHERE message_id = ?
+	`, messageID).Scan(&loc.MessageID, &chatJID, &loc.Latitude, &loc.Longitude, &loc.AccuracyMeters,
+		&loc.SpeedMps, &loc.Heading, &loc.Caption, &loc.Sequence, &loc.StartedAt, &loc.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get live location %s: %w", messageID, err)
+	}
+	loc.ChatID = toAPIJIDString(chatJID)
+	return &loc, nil
+}
+
+// ---------------------------------------------------------------------------
+// Broadcast lists
+// ---------------------------------------------------------------------------
+
+// SetBroadcastListMembers replaces the stored members of a broadcast list.
+func (s *AppStore) SetBroadcastListMembers(listJID string, members []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM broadcast_list_members WHERE list_jid = ?`, listJID); err != nil {
+		return fmt.Errorf("clear broadcast list %s: %w", listJID, err)
+	}
+	for _, member := range members {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO broadcast_list_members (list_jid, member_jid) VALUES (?, ?)`, listJID, member); err != nil {
+			return fmt.Errorf("add broadcast list member %s: %w", member, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetBroadcastListMembers returns the member JIDs of a broadcast list in
+// internal format.
+func (s *AppStore) GetBroadcastListMembers(listJID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT member_jid FROM broadcast_list_members WHERE list_jid = ? ORDER BY member_jid`, listJID)
+	if err != nil {
+		return nil, fmt.Errorf("query broadcast list members %s: %w", listJID, err)
+	}
+	defer rows.Close()
+	members := make([]string, 0)
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, fmt.Errorf("scan broadcast list member: %w", err)
+		}
+		members = append(members, jid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate broadcast list members: %w", err)
+	}
+	return members, nil
+}
+
+// GetBroadcastLists returns every known broadcast list (a chat or a member
+// set seen in history sync) with its members in API format. The status feed
+// is not included.
+func (s *AppStore) GetBroadcastLists() ([]BroadcastList, error) {
+	rows, err := s.db.Query(`
+		WITH lists AS (
+			SELECT jid FROM chats WHERE jid LIKE '%@broadcast'
+			UNION
+			SELECT DISTINCT list_jid FROM broadcast_list_members
+		)
+		SELECT l.jid, IFNULL(ch.name, ''), bm.member_jid
+		FROM lists l
+		LEFT JOIN chats ch ON ch.jid = l.jid
+		LEFT JOIN broadcast_list_members bm ON bm.list_jid = l.jid
+		WHERE l.jid != 'status@broadcast'
+		ORDER BY IFNULL(ch.name, '') COLLATE NOCASE, l.jid, bm.member_jid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query broadcast lists: %w", err)
+	}
+	defer rows.Close()
+
+	lists := make([]BroadcastList, 0)
+	for rows.Next() {
+		var jid, name string
+		var member *string
+		if err := rows.Scan(&jid, &name, &member); err != nil {
+			return nil, fmt.Errorf("scan broadcast list: %w", err)
+		}
+		if id := toAPIJIDString(jid); len(lists) == 0 || lists[len(lists)-1].ID != id {
+			lists = append(lists, BroadcastList{ID: id, Name: name, Members: make([]string, 0)})
+		}
+		if member != nil {
+			l := &lists[len(lists)-1]
+			l.Members = append(l.Members, toAPIJIDString(*member))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate broadcast lists: %w", err)
+	}
+	return lists, nil
+}
+
+// ---------------------------------------------------------------------------
+// Messages
+// ---------------------------------------------------------------------------
+
+// upsertMessageSQL inserts a message or updates select fields on conflict.
+// Body and sender_name are updated only if the new value is non-empty.
+// Media fields are always updated on conflict. received_at, when the bridge
+// first stored the message, is set on insert only.
+const upsertMessageSQL = `
+		INSERT INTO messages (id, chat_jid, sender_jid, sender_name, from_me, body, timestamp, has_media, media_type, raw_proto, media_search, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			body         = CASE WHEN excluded.body        != '' THEN excluded.body        ELSE messages.body        END,
+			sender_name  = CASE WHEN excluded.sender_name != '' THEN excluded.sender_name ELSE messages.sender_name END,
+			has_media    = excluded.has_media,
+			media_type   = excluded.media_type,
+			raw_proto    = excluded.raw_proto,
+			media_search = excluded.media_search
+	`
+
+// UpsertMessage inserts a message or updates select fields on conflict.
+// Body and sender_name are updated only if the new value is non-empty.
+// Media fields are always updated on conflict.
+func (s *AppStore) UpsertMessage(id, chatJID, senderJID, senderName string, fromMe bool, body string, timestamp int64, hasMedia bool, mediaType *string, rawProto []byte) error {
+	_, err := s.db.Exec(upsertMessageSQL, id, chatJID, senderJID, senderName, boolToInt(fromMe), body, timestamp, boolToInt(hasMedia), mediaType, rawProto,
+		mediaSearchText(mediaType, rawProto), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("upsert message %s: %w", id, err)
+	}
+	return nil
+}
+
+// MessageBatch upserts many messages in one transaction. Bulk imports such as
+// history sync use it so a conversation costs one commit instead of one per
+// message, which keeps the write lock free for live messages and for the
+// count polling done by deep sync.
+type MessageBatch struct {
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+// BeginMessageBatch starts a batch. Callers must Commit or Rollback it.
+func (s *AppStore) BeginMessageBatch() (*MessageBatch, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	stmt, err := tx.Prepare(upsertMessageSQL)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("prepare upsert message: %w", err)
+	}
+	return &MessageBatch{tx: tx, stmt: stmt}, nil
+}
+
+// UpsertMessage adds a message to the batch, with the same semantics as
+// AppStore.UpsertMessage.
+func (b *MessageBatch) UpsertMessage(id, chatJID, senderJID, senderName string, fromMe bool, body string, timestamp int64, hasMedia bool, mediaType *string, rawProto []byte) error {
+	_, err := b.stmt.Exec(id, chatJID, senderJID, senderName, boolToInt(fromMe), body, timestamp, boolToInt(hasMedia), mediaType, rawProto,
+		mediaSearchText(mediaType, rawProto), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("upsert message %s: %w", id, err)
+	}
+	return nil
+}
+
+// Commit writes the batch.
+func (b *MessageBatch) Commit() error {
+	b.stmt.Close()
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("commit message batch: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the batch. It is a no-op after Commit.
+func (b *MessageBatch) Rollback() {
+	b.stmt.Close()
+	b.tx.Rollback()
+}
+
+// editMessageSQL replaces a message's body with an edit made at edited_at.
+// The timestamp is left alone so the message keeps its place in the chat, and
+// an edit older than one already applied (edits can arrive out of order) only
+// leaves body untouched.
+const editMessageSQL = `
+		UPDATE messages SET
+			body      = CASE WHEN edited_at IS NULL OR edited_at <= ? THEN ? ELSE body END,
+			edited_at = MAX(IFNULL(edited_at, 0), ?)
+		WHERE id = ?
+	`
+
+// EditMessage applies an edit to a stored message. It reports false if the
+// message is not stored.
+func (s *AppStore) EditMessage(id, body string, editedAt int64) (bool, error) {
+	return editMessage(s.db, id, body, editedAt)
+}
+
+// EditMessage applies an edit within the batch, with the same semantics as
+// AppStore.EditMessage.
+func (b *MessageBatch) EditMessage(id, body string, editedAt int64) (bool, error) {
+	return editMessage(b.tx, id, body, editedAt)
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx, so a write can run inside a
+// MessageBatch or on its own.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func editMessage(db execer, id, body string, editedAt int64) (bool, error) {
+	res, err := db.Exec(editMessageSQL, editedAt, body, editedAt, id)
+	if err != nil {
+		return false, fmt.Errorf("edit message %s: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("edit message %s: %w", id, err)
+	}
+	return n > 0, nil
+}
+
+// SetMessageForwarded marks a message as forwarded. score is WhatsApp's
+// forwarding score, how many times it has been forwarded along the way
+// (WhatsApp shows "Forwarded many times" from 5).
+func (s *AppStore) SetMessageForwarded(id string, score int) error {
+	return setMessageForwarded(s.db, id, score)
+}
+
+// SetMessageForwarded marks a message as forwarded within the batch.
+func (b *MessageBatch) SetMessageForwarded(id string, score int) error {
+	return setMessageForwarded(b.tx, id, score)
+}
+
+func setMessageForwarded(db execer, id string, score int) error {
+	if _, err := db.Exec(`
+		UPDATE messages SET is_forwarded = 1, forwarding_score = MAX(forwarding_score, ?) WHERE id = ?
+	`, score, id); err != nil {
+		return fmt.Errorf("set message forwarded %s: %w", id, err)
+	}
+	return nil
+}
+
+// SetMessageQuote records that a message replies to quotedID, a formatted
+// message ID that need not be stored, with preview the start of the quoted
+// text.
+func (s *AppStore) SetMessageQuote(id, quotedID, preview string) error {
+	return setMessageQuote(s.db, id, quotedID, preview)
+}
+
+// SetMessageQuote records a message's quote within the batch.
+func (b *MessageBatch) SetMessageQuote(id, quotedID, preview string) error {
+	return setMessageQuote(b.tx, id, quotedID, preview)
+}
+
+func setMessageQuote(db execer, id, quotedID, preview string) error {
+	if _, err := db.Exec(`
+		UPDATE messages SET quoted_id = ?, quoted_preview = ? WHERE id = ?
+	`, quotedID, preview, id); err != nil {
+		return fmt.Errorf("set message quote %s: %w", id, err)
+	}
+	return nil
+}
+
+// SetMessagePinned pins or unpins a message, by its formatted ID in either
+// form. It returns a wrapped sql.ErrNoRows if there is no such message.
+func (s *AppStore) SetMessagePinned(messageID string, pinned bool) error {
+	return s.setMessageFlag(messageID, "pinned", pinned)
+}
+
+// SetMessageStarred stars or unstars a message, like SetMessagePinned.
+func (s *AppStore) SetMessageStarred(messageID string, starred bool) error {
+	return s.setMessageFlag(messageID, "starred", starred)
+}
+
+// setMessageFlag sets a message's boolean column.
+func (s *AppStore) setMessageFlag(messageID, column string, on bool) error {
+	variants := messageIDVariants(messageID)
+	res, err := s.db.Exec(`UPDATE messages SET `+column+` = ? WHERE id IN (?, ?)`,
+		boolToInt(on), variants[0], variants[len(variants)-1])
+	if err != nil {
+		return fmt.Errorf("set %s of message %s: %w", column, messageID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("set %s of message %s: %w", column, messageID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// GetStarredMessages returns up to limit starred messages across all chats,
+// newest first, with their chat's name as SearchMessages gives it. Starred
+// messages outlive their chat (see deleteChat), and are then listed under
+// its number.
+func (s *AppStore) GetStarredMessages(limit int) ([]SearchResult, error) {
+	rows, err := s.db.Query(`
+		SELECT `+messageColumnsSQL+`, m.chat_jid,
+			COALESCE(NULLIF(ch.name, ''), NULLIF(ct.push_name, ''), NULLIF(ct.name, ''),
+				REPLACE(REPLACE(m.chat_jid, '@s.whatsapp.net', ''), '@g.us', '')) AS chat_name
+		FROM messages m
+		LEFT JOIN contacts sc ON sc.jid = m.sender_jid
+		LEFT JOIN chats ch ON ch.jid = m.chat_jid
+		LEFT JOIN contacts ct ON ct.jid = m.chat_jid
+		WHERE m.starred = 1
+		ORDER BY m.timestamp DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query starred messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var chatJID, chatName string
+		msg, err := scanMessageWith(rows, &chatJID, &chatName)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Message: msg, ChatName: chatName, ChatJID: toAPIJIDString(chatJID)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate starred messages: %w", err)
+	}
+	return results, nil
+}
+
+// GetPinnedMessages returns a chat's pinned messages, newest first.
+func (s *AppStore) GetPinnedMessages(chatJID string) ([]Message, error) {
+	rows, err := s.db.Query(selectMessageSQL+`
+		WHERE m.chat_jid = ? AND m.pinned = 1
+		ORDER BY m.timestamp DESC
+	`, chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("query pinned messages for %s: %w", chatJID, err)
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate pinned messages: %w", err)
+	}
+	return messages, nil
+}
+
+// groupSender is a sender in a group chat, both as internal JIDs.
+type groupSender struct {
+	ChatJID, SenderJID string
+}
+
+// GetUnnamedGroupSenders returns up to limit distinct LID senders of group
+// messages stored without a sender name, in order and starting after the
+// pair after, so callers can page through them. A non-empty groupJID limits
+// them to that group.
+func (s *AppStore) GetUnnamedGroupSenders(groupJID string, after groupSender, limit int) ([]groupSender, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT chat_jid, sender_jid FROM messages
+		WHERE sender_jid LIKE '%@lid' AND sender_name = '' AND chat_jid LIKE '%@g.us'
+			AND (? = '' OR chat_jid = ?)
+			AND (chat_jid, sender_jid) > (?, ?)
+		ORDER BY chat_jid, sender_jid
+		LIMIT ?
+	`, groupJID, groupJID, after.ChatJID, after.SenderJID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query unnamed group senders: %w", err)
+	}
+	defer rows.Close()
+
+	var senders []groupSender
+	for rows.Next() {
+		var gs groupSender
+		if err := rows.Scan(&gs.ChatJID, &gs.SenderJID); err != nil {
+			return nil, fmt.Errorf("scan group sender: %w", err)
+		}
+		senders = append(senders, gs)
+	}
+	return senders, rows.Err()
+}
+
+// FillSenderName stores name on senderJID's messages in chatJID that have no
+// sender name, returning how many were named.
+func (s *AppStore) FillSenderName(senderJID, chatJID, name string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE messages SET sender_name = ? WHERE sender_jid = ? AND chat_jid = ? AND sender_name = ''
+	`, name, senderJID, chatJID)
+	if err != nil {
+		return 0, fmt.Errorf("fill sender name for %s: %w", senderJID, err)
+	}
+	return res.RowsAffected()
+}
+
+// SetSenderName stores name as the sender name of senderJID's messages,
+// only those in chatJID unless it is empty, and returns how many changed.
+func (s *AppStore) SetSenderName(senderJID, chatJID, name string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE messages SET sender_name = ?
+		WHERE sender_jid = ? AND (? = '' OR chat_jid = ?) AND sender_name != ?
+	`, name, senderJID, chatJID, chatJID, name)
+	if err != nil {
+		return 0, fmt.Errorf("set sender name for %s: %w", senderJID, err)
+	}
+	return res.RowsAffected()
+}
+
+// setMediaCache makes c the store's media cache, and has the cache report
+// the files it evicts so their messages stop claiming to be downloaded.
+func (s *AppStore) setMediaCache(c *mediaCache) {
+	s.media = c
+	if c == nil {
+		return
+	}
+	c.evicted = func(files map[string]bool) {
+		if n, err := s.ForgetEvictedMedia(files); err != nil {
+			log.Printf("Error resetting media status of evicted media: %v", err)
+		} else if n > 0 {
+			log.Printf("Media cache full: evicted the media of %d messages", n)
+		}
+	}
+}
+
+// ForgetEvictedMedia clears the media status of downloaded messages whose
+// cached file, named as by mediaCache.path, is among files. It returns how
+// many were cleared.
+func (s *AppStore) ForgetEvictedMedia(files map[string]bool) (int, error) {
+	rows, err := s.db.Query(`SELECT id FROM messages WHERE media_status = ?`, mediaStatusDownloaded)
+	if err != nil {
+		return 0, fmt.Errorf("query downloaded media: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan downloaded media: %w", err)
+		}
+		if files[filepath.Base(s.media.path(id))] {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate downloaded media: %w", err)
+	}
+	for _, id := range ids {
+		if _, err := s.db.Exec(`UPDATE messages SET media_status = '' WHERE id = ?`, id); err != nil {
+			return 0, fmt.Errorf("reset media status of %s: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+// GetMediaPendingIDs returns the messages whose media auto-download was
+// queued but never finished, oldest first.
+func (s *AppStore) GetMediaPendingIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM messages WHERE media_status = ? ORDER BY timestamp`, mediaStatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("query pending media: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan pending media: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetMediaStatus records how auto-download of a message's media went. Both
+// ID forms are accepted, as with GetRawProto.
+func (s *AppStore) SetMediaStatus(messageID, status string) error {
+	variants := messageIDVariants(messageID)
+	legacy := variants[len(variants)-1]
+	if _, err := s.db.Exec(`
+		UPDATE messages SET media_status = ? WHERE id IN (?, ?)
+	`, status, variants[0], legacy); err != nil {
+		return fmt.Errorf("set media status of %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// SetDeliveryStatus records a delivered or read receipt for the messages we
+// sent with the given IDs, in either form. The status only advances, so a
+// late delivery receipt doesn't undo a read one; in groups the first
+// participant's receipt advances it. It returns the number of messages
+// updated.
+func (s *AppStore) SetDeliveryStatus(messageIDs []string, status string) (int64, error) {
+	return setDeliveryStatus(s.db, messageIDs, status)
+}
+
+// SetDeliveryStatus records delivery statuses within the batch.
+func (b *MessageBatch) SetDeliveryStatus(messageIDs []string, status string) (int64, error) {
+	return setDeliveryStatus(b.tx, messageIDs, status)
+}
+
+func setDeliveryStatus(db execer, messageIDs []string, status string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{status}
+	placeholders := make([]string, 0, 2*len(messageIDs))
+	for _, id := range messageIDs {
+		for _, v := range messageIDVariants(id) {
+			args = append(args, v)
+			placeholders = append(placeholders, "?")
+		}
+	}
+	args = append(args, deliveryRank(status))
+	res, err := db.Exec(`
+		UPDATE messages SET delivery_status = ?
+		WHERE from_me = 1 AND id IN (`+strings.Join(placeholders, ", ")+`)
+			AND CASE delivery_status WHEN 'read' THEN 2 WHEN 'delivered' THEN 1 ELSE 0 END < ?
+	`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("set delivery status: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// deliveryRank orders delivery statuses as the CASE in SetDeliveryStatus does.
+func deliveryRank(status string) int {
+	switch status {
+	case DeliveryStatusRead:
+		return 2
+	case DeliveryStatusDelivered:
+		return 1
+	}
+	return 0
+}
+
+// revokedBody replaces the text of a message deleted for everyone.
+const revokedBody = "[deleted]"
+
+// RevokeMessage blanks a message deleted for everyone: its body becomes
+// revokedBody and its media is dropped, so it can neither be read nor
+// downloaded, and it is flagged revoked. Both ID forms are accepted, as with
+// GetRawProto. It reports whether the message was stored.
+func (s *AppStore) RevokeMessage(messageID string) (bool, error) {
+	variants := messageIDVariants(messageID)
+	legacy := variants[len(variants)-1]
+	res, err := s.db.Exec(`
+		UPDATE messages SET body = ?, has_media = 0, media_type = NULL, raw_proto = NULL,
+			media_search = '', media_status = '', is_forwarded = 0, forwarding_score = 0,
+			quoted_id = '', quoted_preview = '', revoked = 1
+		WHERE id IN (?, ?)
+	`, revokedBody, variants[0], legacy)
+	if err != nil {
+		return false, fmt.Errorf("revoke message %s: %w", messageID, err)
+	}
+	s.media.Remove(messageID)
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetMessageSource records where a message was sent from (see the
+// MessageSource constants). Unknown message IDs are ignored.
+func (s *AppStore) SetMessageSource(id, source string) error {
+	if _, err := s.db.Exec(`UPDATE messages SET source = ? WHERE id = ?`, source, id); err != nil {
+		return fmt.Errorf("set message source %s: %w", id, err)
+	}
+	return nil
+}
+
+// senderNameSQL resolves a message's display sender name. It expects the
+// message aliased as m and its sender's contacts row LEFT JOINed as sc.
+// Precedence: contact name, contact push name, a contact whose push name
+// matches the stored sender name, the stored sender name, and finally any
+// non-empty sender name seen on another message from the same JID. COALESCE
+// stops at the first name found, so the lookups run only for messages that
+// need them; the last one searches idx_messages_sender, which holds only
+// messages with a sender name.
+const senderNameSQL = `IFNULL(COALESCE(
+				NULLIF(sc.name, ''), NULLIF(sc.push_name, ''),
+				(SELECT c2.name FROM contacts c2 WHERE c2.push_name = m.sender_name AND c2.push_name != '' AND c2.name != '' LIMIT 1),
+				NULLIF(m.sender_name, ''),
+				(SELECT m2.sender_name FROM messages m2 WHERE m2.sender_jid = m.sender_jid AND m2.sender_name != '' LIMIT 1)
+			), '')`
+
+// maxMessagesPerQuery is the hard ceiling on messages returned by one
+// GetMessages call, whatever the caller asks for.
+const maxMessagesPerQuery = 1000
+
+// GetMessages returns messages for a chat ordered by timestamp descending, limited to n
+// (at most maxMessagesPerQuery). If beforeTs > 0, only returns messages with
+// timestamp <= beforeTs.
+// The From field is the sender JID in API format. SenderName is set only if non-empty.
+func (s *AppStore) GetMessages(chatJID string, limit int, beforeTs int64) ([]Message, error) {
+	messages := make([]Message, 0)
+	err := s.ForEachMessage(chatJID, limit, beforeTs, func(m Message) error {
+		messages = append(messages, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ForEachMessage calls fn for each message GetMessages would return, in the
+// same order, as rows are read, so callers can stream large results without
+// holding them all in memory. It stops at the first error fn returns.
+func (s *AppStore) ForEachMessage(chatJID string, limit int, beforeTs int64, fn func(Message) error) error {
+	limit = min(limit, maxMessagesPerQuery)
+	var rows *sql.Rows
+	var err error
+	if beforeTs > 0 {
+		rows, err = s.db.Query(selectMessageSQL+`
+			WHERE m.chat_jid = ? AND m.timestamp <= ?
+			ORDER BY m.timestamp DESC
+			LIMIT ?
+		`, chatJID, beforeTs, limit)
+	} else {
+		rows, err = s.db.Query(selectMessageSQL+`
+			WHERE m.chat_jid = ?
+			ORDER BY m.timestamp DESC
+			LIMIT ?
+		`, chatJID, limit)
+	}
+	if err != nil {
+		return fmt.Errorf("query messages for %s: %w", chatJID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate messages: %w", err)
+	}
+	return nil
+}
+
+// GetMessageByRawID finds a message in a chat by its raw WhatsApp ID (the
+// last part of a formatted ID), for callers that only have the ID WhatsApp
+// assigned, e.g. from a webhook. It returns a wrapped sql.ErrNoRows if there
+// is no such message.
+func (s *AppStore) GetMessageByRawID(chatJID, rawID string) (*Message, error) {
+	apiChat := toAPIJIDString(chatJID)
+	row := s.db.QueryRow(selectMessageSQL+`
+		WHERE m.id IN (?, ?)
+		ORDER BY m.timestamp DESC
+		LIMIT 1
+	`, formatMessageID(true, apiChat, rawID), formatMessageID(false, apiChat, rawID))
+	msg, err := scanMessage(row)
+	if err != nil {
+		return nil, fmt.Errorf("get message %s in %s: %w", rawID, chatJID, err)
+	}
+	return &msg, nil
+}
+
+// FindLatestMessage returns the newest message in chatJID whose body contains
+// text (case-insensitively for ASCII letters), limited to messages we sent if
+// fromMe is set, or else to those from senderJID if it is non-empty. It
+// returns a wrapped sql.ErrNoRows if none matches.
+func (s *AppStore) FindLatestMessage(chatJID, senderJID string, fromMe bool, text string) (*Message, error) {
+	query := selectMessageSQL + `
+		WHERE m.chat_jid = ? AND m.body LIKE ? ESCAPE '\'`
+	args := []interface{}{chatJID, "%" + escapeLike(text) + "%"}
+	switch {
+	case fromMe:
+		query += ` AND m.from_me = 1`
+	case senderJID != "":
+		query += ` AND m.from_me = 0 AND m.sender_jid = ?`
+		args = append(args, senderJID)
+	}
+	query += `
+		ORDER BY m.timestamp DESC
+		LIMIT 1`
+
+	msg, err := scanMessage(s.db.QueryRow(query, args...))
+	if err != nil {
+		return nil, fmt.Errorf("find message matching %q in %s: %w", text, chatJID, err)
+	}
+	return &msg, nil
+}
+
+// escapeLike escapes LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// selectMessageSQL selects the columns scanMessage reads, from messages m.
+// Callers append the WHERE clause.
+const selectMessageSQL = `
+		SELECT ` + messageColumnsSQL + `
+		FROM messages m
+		LEFT JOIN contacts sc ON sc.jid = m.sender_jid
+	`
+
+// messageColumnsSQL are the columns scanMessage reads, for queries that
+// select more after them (see scanMessageWith).
+const messageColumnsSQL = `m.id, m.sender_jid,
+			` + senderNameSQL + ` AS sender_name,
+			m.from_me, m.body, m.timestamp, m.has_media, m.media_type, m.source, m.edited_at,
+			m.is_forwarded, m.forwarding_score, m.received_at, m.media_status,
+			m.quoted_id, m.quoted_preview, m.pinned, m.starred, m.delivery_status, m.revoked`
+
+// scanMessage reads a row selected by selectMessageSQL. The From field is the
+// sender JID in API format; SenderName is set only if non-empty.
+func scanMessage(row interface{ Scan(dest ...interface{}) error }) (Message, error) {
+	var id, senderJID, senderName, body, source, mediaStatus, quotedID, quotedPreview, status string
+	var fromMe, hasMedia, forwarded, forwardingScore, pinned, starred, revoked int
+	var ts int64
+	var mediaType *string
+	var editedAt, receivedAt *int64
+	if err := row.Scan(&id, &senderJID, &senderName, &fromMe, &body, &ts, &hasMedia, &mediaType, &source, &editedAt,
+		&forwarded, &forwardingScore, &receivedAt, &mediaStatus, &quotedID, &quotedPreview, &pinned, &starred, &status, &revoked); err != nil {
+		return Message{}, fmt.Errorf("scan message: %w", err)
+	}
+
+	msg := Message{
+		ID:        id,
+		Body:      body,
+		FromMe:    fromMe != 0,
+		Timestamp: ts,
+		From:      toAPIJIDString(senderJID),
+		HasMedia:  hasMedia != 0,
+		MediaType: mediaType,
+		Source:    source,
+		EditedAt:  editedAt,
+
+		ReceivedAt:  receivedAt,
+		MediaStatus: mediaStatus,
+
+		Forwarded:       forwarded != 0,
+		ForwardingScore: forwardingScore,
+
+		QuotedMessageID: quotedID,
+		QuotedPreview:   quotedPreview,
+
+		Pinned:  pinned != 0,
+		Starred: starred != 0,
+		Revoked: revoked != 0,
+	}
+	// A message we sent is stored once the server has it, so with no
+	// receipt yet it is sent
+	if msg.FromMe {
+		msg.Status = status
+		if msg.Status == "" {
+			msg.Status = DeliveryStatusSent
+		}
+	}
+	if senderName != "" {
+		sn := senderName
+		msg.SenderName = &sn
+	}
+	return msg, nil
+}
+
+// scanMessageWith scans a row of messageColumnsSQL followed by the columns
+// read into extra.
+func scanMessageWith(row interface{ Scan(dest ...interface{}) error }, extra ...interface{}) (Message, error) {
+	return scanMessage(extraScanner{row, extra})
+}
+
+// extraScanner appends extra destinations to each Scan call.
+type extraScanner struct {
+	row   interface{ Scan(dest ...interface{}) error }
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// GetRawProto returns the stored raw protobuf bytes for a message. Both the
+// canonical (@c.us) and legacy (@s.whatsapp.net) ID forms are accepted, with
+// the canonical row preferred if both exist.
+func (s *AppStore) GetRawProto(messageID string) ([]byte, error) {
+	variants := messageIDVariants(messageID)
+	legacy := variants[0]
+	if len(variants) > 1 {
+		legacy = variants[1]
+	}
+	var rawProto []byte
+	err := s.db.QueryRow(`
+		SELECT raw_proto FROM messages
+		WHERE id IN (?, ?)
+		ORDER BY id = ? DESC
+		LIMIT 1
+	`, variants[0], legacy, variants[0]).Scan(&rawProto)
+	if err != nil {
+		return nil, fmt.Errorf("get raw proto %s: %w", messageID, err)
+	}
+	return rawProto, nil
+}
+
+// GetMessageSender returns the stored sender JID (internal format) for a
+// message. Both ID forms are accepted, as with GetRawProto.
+func (s *AppStore) GetMessageSender(messageID string) (string, error) {
+	variants := messageIDVariants(messageID)
+	legacy := variants[0]
+	if len(variants) > 1 {
+		legacy = variants[1]
+	}
+	var senderJID string
+	err := s.db.QueryRow(`
+		SELECT sender_jid FROM messages
+		WHERE id IN (?, ?)
+		ORDER BY id = ? DESC
+		LIMIT 1
+	`, variants[0], legacy, variants[0]).Scan(&senderJID)
+	if err != nil {
+		return "", fmt.Errorf("get message sender %s: %w", messageID, err)
+	}
+	return senderJID, nil
+}
+
+// CanonicalizeMessageIDs rewrites message IDs stored with the legacy
+// @s.whatsapp.net chat JID into the canonical @c.us form. Where both forms of
+// the same message exist, the legacy row's raw proto is carried over to the
+// canonical row if it lacks one, and the legacy duplicate is dropped.
+// Returns the number of rows rewritten or removed.
+func (s *AppStore) CanonicalizeMessageIDs() (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const legacyMatch = `id LIKE '%@s.whatsapp.net\_%' ESCAPE '\'`
+	const canonicalOf = `REPLACE(id, '@s.whatsapp.net_', '@c.us_')`
+
+	if _, err := tx.Exec(`
+		UPDATE messages SET raw_proto = (
+			SELECT l.raw_proto FROM messages l
+			WHERE l.id = REPLACE(messages.id, '@c.us_', '@s.whatsapp.net_')
+		)
+		WHERE raw_proto IS NULL
+			AND id LIKE '%@c.us\_%' ESCAPE '\'
+			AND EXISTS (
+				SELECT 1 FROM messages l
+				WHERE l.id = REPLACE(messages.id, '@c.us_', '@s.whatsapp.net_')
+					AND l.raw_proto IS NOT NULL
+			)
+	`); err != nil {
+		return 0, fmt.Errorf("merge duplicate raw protos: %w", err)
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM messages
+		WHERE ` + legacyMatch + `
+			AND ` + canonicalOf + ` IN (SELECT id FROM messages)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("delete duplicate message ids: %w", err)
+	}
+	dupes, _ := res.RowsAffected()
+	if dupes > 0 {
+		log.Printf("Found %d messages stored under both legacy and canonical IDs", dupes)
+	}
+
+	res, err = tx.Exec(`UPDATE messages SET id = ` + canonicalOf + ` WHERE ` + legacyMatch)
+	if err != nil {
+		return 0, fmt.Errorf("rewrite legacy message ids: %w", err)
+	}
+	rewritten, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return dupes + rewritten, nil
+}
+
+// GetLatestMessageID returns the formatted message ID of the most recent message
+// in a chat. The ID is formatted via formatMessageID for API compatibility.
+func (s *AppStore) GetLatestMessageID(chatJID string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`
 		SELECT id FROM messages
 		WHERE chat_jid = ?
 		ORDER BY timestamp DESC
@@ -481,9 +2289,9 @@ func (s *AppStore) GetOldestMessage(chatJID string) (*OldestMessageInfo, error)
 	}, nil
 }
 
-// GetAllChatJIDs returns all chat JIDs.
+// GetAllChatJIDs returns all chat JIDs the chat filter lets through.
 func (s *AppStore) GetAllChatJIDs() ([]string, error) {
-	rows, err := s.db.Query(`SELECT jid FROM chats WHERE jid NOT LIKE '%@lid' AND jid NOT LIKE '%@broadcast'`)
+	rows, err := s.db.Query(`SELECT jid FROM chats WHERE ` + chatFilterSQL(s.chatFilter, "jid"))
 	if err != nil {
 		return nil, fmt.Errorf("query chat jids: %w", err)
 	}
@@ -507,6 +2315,27 @@ func (s *AppStore) GetMessageCount(chatJID string) (int, error) {
 	return count, nil
 }
 
+// GetMessageCounts returns the stored message count, timestamp range and
+// unread count for a chat, using aggregate queries only. A chat with no row
+// or no messages yields zeros rather than an error.
+func (s *AppStore) GetMessageCounts(chatJID string) (MessageCounts, error) {
+	var c MessageCounts
+	var oldest, newest sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM messages WHERE chat_jid = ?
+	`, chatJID).Scan(&c.Count, &oldest, &newest)
+	if err != nil {
+		return c, fmt.Errorf("count messages for %s: %w", chatJID, err)
+	}
+	c.OldestTs, c.NewestTs = oldest.Int64, newest.Int64
+
+	err = s.db.QueryRow(`SELECT unread_count FROM chats WHERE jid = ?`, chatJID).Scan(&c.UnreadCount)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("get unread count for %s: %w", chatJID, err)
+	}
+	return c, nil
+}
+
 // GetTotalMessageCount returns the total number of messages across all chats.
 func (s *AppStore) GetTotalMessageCount() (int, error) {
 	var count int
@@ -517,6 +2346,17 @@ func (s *AppStore) GetTotalMessageCount() (int, error) {
 	return count, nil
 }
 
+// GetTotalChatCount returns the number of chats GetChats would list with
+// archived chats included.
+func (s *AppStore) GetTotalChatCount() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM chats WHERE ` + chatFilterSQL(s.chatFilter, "jid")).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count chats: %w", err)
+	}
+	return count, nil
+}
+
 // ---------------------------------------------------------------------------
 // Sync State
 // ---------------------------------------------------------------------------
@@ -556,23 +2396,168 @@ func (s *AppStore) GetOfflineGap() (time.Duration, error) {
 	return time.Since(time.Unix(ts, 0)), nil
 }
 
+// RecordChatSync notes that history for a chat was just synced. The oldest
+// synced timestamp is taken from the messages now stored for the chat.
+// fullySynced marks the chat as synced back to the start of its history; once
+// set it sticks until cleared with SetChatFullySynced.
+func (s *AppStore) RecordChatSync(chatJID string, fullySynced bool) error {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_sync_state (chat_jid, last_sync_at, oldest_synced_ts, fully_synced)
+		VALUES (?, ?, (SELECT MIN(timestamp) FROM messages WHERE chat_jid = ?), ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET
+			last_sync_at     = excluded.last_sync_at,
+			oldest_synced_ts = excluded.oldest_synced_ts,
+			fully_synced     = MAX(chat_sync_state.fully_synced, excluded.fully_synced)
+	`, chatJID, time.Now().Unix(), chatJID, boolToInt(fullySynced))
+	if err != nil {
+		return fmt.Errorf("record chat sync %s: %w", chatJID, err)
+	}
+	return nil
+}
+
+// GetLastChatSyncAt returns when any chat was last synced (unix seconds), or
+// nil if none has been.
+func (s *AppStore) GetLastChatSyncAt() (*int64, error) {
+	var ts sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(last_sync_at) FROM chat_sync_state`).Scan(&ts); err != nil {
+		return nil, fmt.Errorf("get last chat sync: %w", err)
+	}
+	if !ts.Valid || ts.Int64 == 0 {
+		return nil, nil
+	}
+	return &ts.Int64, nil
+}
+
+// SetChatFullySynced sets or clears the fully-synced flag for a chat.
+func (s *AppStore) SetChatFullySynced(chatJID string, fullySynced bool) error {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_sync_state (chat_jid, fully_synced) VALUES (?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET fully_synced = excluded.fully_synced
+	`, chatJID, boolToInt(fullySynced))
+	if err != nil {
+		return fmt.Errorf("set chat fully synced %s: %w", chatJID, err)
+	}
+	return nil
+}
+
+// GetChatSyncState returns the sync state for one chat. sql.ErrNoRows
+// (wrapped) means the chat has never been synced.
+func (s *AppStore) GetChatSyncState(chatJID string) (*ChatSyncState, error) {
+	states, err := s.queryChatSyncStates(`WHERE chat_jid = ?`, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, fmt.Errorf("get chat sync state %s: %w", chatJID, sql.ErrNoRows)
+	}
+	return &states[0], nil
+}
+
+// GetAllChatSyncStates returns the sync state of every chat that has been
+// synced, most recently synced first.
+func (s *AppStore) GetAllChatSyncStates() ([]ChatSyncState, error) {
+	return s.queryChatSyncStates(`WHERE ` + chatFilterSQL(s.chatFilter, "chat_jid") + ` ORDER BY last_sync_at DESC`)
+}
+
+func (s *AppStore) queryChatSyncStates(clause string, args ...interface{}) ([]ChatSyncState, error) {
+	rows, err := s.db.Query(`
+		SELECT chat_jid, last_sync_at, oldest_synced_ts, fully_synced
+		FROM chat_sync_state `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query chat sync state: %w", err)
+	}
+	defer rows.Close()
+
+	states := make([]ChatSyncState, 0)
+	for rows.Next() {
+		var st ChatSyncState
+		var chatJID string
+		var fully int
+		if err := rows.Scan(&chatJID, &st.LastSyncAt, &st.OldestSyncedTs, &fully); err != nil {
+			return nil, fmt.Errorf("scan chat sync state: %w", err)
+		}
+		st.ChatID = toAPIJIDString(chatJID)
+		st.FullySynced = fully != 0
+		states = append(states, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate chat sync state: %w", err)
+	}
+	return states, nil
+}
+
+// SearchOptions are a full-text search's query and filters. Zero values
+// don't filter.
+type SearchOptions struct {
+	Query   string // FTS5 query
+	Limit   int    // results; chats for SearchMessagesByChat
+	After   int64  // only messages at or after this unix time
+	Before  int64  // only messages at or before this unix time
+	ChatJID string // only messages in this chat (internal JID)
+	Newest  bool   // newest first rather than best match first; SearchMessages only
+	Sender  string // only messages from this sender (internal JID), by number or LID
+}
+
+// senderAliasesSQL selects the sender JID ? stands for, along with its LID
+// or phone JID as known from group rosters. Groups address members by
+// either, so a sender's messages may be stored under both.
+const senderAliasesSQL = `
+	SELECT ? UNION
+	SELECT alias FROM (
+		SELECT lid AS alias FROM group_participants WHERE ? IN (participant_jid, phone)
+		UNION SELECT phone FROM group_participants WHERE ? IN (participant_jid, lid)
+		UNION SELECT participant_jid FROM group_participants WHERE ? IN (lid, phone)
+	) WHERE alias != ''`
+
+// filterSQL returns the conditions, to AND onto a search's WHERE, and their
+// arguments for the filters in o. Messages are aliased m.
+func (o SearchOptions) filterSQL() (string, []interface{}) {
+	var cond strings.Builder
+	var args []interface{}
+	if o.After > 0 {
+		cond.WriteString(" AND m.timestamp >= ?")
+		args = append(args, o.After)
+	}
+	if o.Before > 0 {
+		cond.WriteString(" AND m.timestamp <= ?")
+		args = append(args, o.Before)
+	}
+	if o.ChatJID != "" {
+		cond.WriteString(" AND m.chat_jid = ?")
+		args = append(args, o.ChatJID)
+	}
+	if o.Sender != "" {
+		cond.WriteString(" AND m.sender_jid IN (" + senderAliasesSQL + ")")
+		args = append(args, o.Sender, o.Sender, o.Sender, o.Sender)
+	}
+	return cond.String(), args
+}
+
 // SearchMessages performs full-text search across all messages using the FTS5 index.
 // Results are joined with chats/contacts to include chat display name and JID,
-// and ordered by FTS5 relevance rank.
-func (s *AppStore) SearchMessages(query string, limit int) ([]SearchResult, error) {
+// sender names are resolved the same way as GetMessages, and results are
+// ordered by FTS5 relevance rank, or newest first if opts.Newest is set.
+func (s *AppStore) SearchMessages(opts SearchOptions) ([]SearchResult, error) {
+	filter, filterArgs := opts.filterSQL()
+	args := append([]interface{}{opts.Query}, filterArgs...)
+	order := "fts.rank"
+	if opts.Newest {
+		order = "m.timestamp DESC"
+	}
 	rows, err := s.db.Query(`
-		SELECT m.id, m.sender_jid, m.sender_name, m.from_me, m.body, m.timestamp,
-			m.has_media, m.media_type, m.chat_jid,
+		SELECT `+messageColumnsSQL+`, m.chat_jid,
 			COALESCE(NULLIF(ch.name, ''), NULLIF(ct.push_name, ''), NULLIF(ct.name, ''),
 				REPLACE(REPLACE(m.chat_jid, '@s.whatsapp.net', ''), '@g.us', '')) AS chat_name
 		FROM messages_fts fts
 		JOIN messages m ON m.rowid = fts.rowid
 		LEFT JOIN chats ch ON ch.jid = m.chat_jid
 		LEFT JOIN contacts ct ON ct.jid = m.chat_jid
+		LEFT JOIN contacts sc ON sc.jid = m.sender_jid
 		WHERE messages_fts MATCH ?
-		ORDER BY fts.rank
+			AND `+chatFilterSQL(s.chatFilter, "m.chat_jid")+filter+`
+		ORDER BY `+order+`
 		LIMIT ?
-	`, query, limit)
+	`, append(args, opts.Limit)...)
 	if err != nil {
 		return nil, fmt.Errorf("search messages: %w", err)
 	}
@@ -580,34 +2565,12 @@ func (s *AppStore) SearchMessages(query string, limit int) ([]SearchResult, erro
 
 	results := make([]SearchResult, 0)
 	for rows.Next() {
-		var id, senderJID, senderName, body, chatJID, chatName string
-		var fromMe, hasMedia int
-		var ts int64
-		var mediaType *string
-		if err := rows.Scan(&id, &senderJID, &senderName, &fromMe, &body, &ts,
-			&hasMedia, &mediaType, &chatJID, &chatName); err != nil {
+		var chatJID, chatName string
+		msg, err := scanMessageWith(rows, &chatJID, &chatName)
+		if err != nil {
 			return nil, fmt.Errorf("scan search result: %w", err)
 		}
-
-		msg := Message{
-			ID:        id,
-			Body:      body,
-			FromMe:    fromMe != 0,
-			Timestamp: ts,
-			From:      toAPIJIDString(senderJID),
-			HasMedia:  hasMedia != 0,
-			MediaType: mediaType,
-		}
-		if senderName != "" {
-			sn := senderName
-			msg.SenderName = &sn
-		}
-
-		results = append(results, SearchResult{
-			Message:  msg,
-			ChatName: chatName,
-			ChatJID:  toAPIJIDString(chatJID),
-		})
+		results = append(results, SearchResult{Message: msg, ChatName: chatName, ChatJID: toAPIJIDString(chatJID)})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterate search results: %w", err)
@@ -615,3 +2578,70 @@ func (s *AppStore) SearchMessages(query string, limit int) ([]SearchResult, erro
 	return results, nil
 }
 
+// SearchMessagesByChat performs full-text search and groups the hits by chat,
+// returning at most perChat top-ranked messages for each of up to maxChats
+// chats along with each chat's total match count. Chats are ordered by their
+// best-ranked match, mirroring how WhatsApp presents cross-chat results.
+// maxChats is opts.Limit. Messages are read as SearchMessages reads them.
+func (s *AppStore) SearchMessagesByChat(opts SearchOptions, perChat int) ([]ChatSearchGroup, error) {
+	filter, filterArgs := opts.filterSQL()
+	args := append([]interface{}{opts.Query}, filterArgs...)
+	rows, err := s.db.Query(`
+		WITH hits AS (
+			SELECT m.rowid AS msg_rowid, m.chat_jid,
+				ROW_NUMBER() OVER (PARTITION BY m.chat_jid ORDER BY fts.rank) AS rn,
+				COUNT(*) OVER (PARTITION BY m.chat_jid) AS chat_matches,
+				MIN(fts.rank) OVER (PARTITION BY m.chat_jid) AS best_rank
+			FROM messages_fts fts
+			JOIN messages m ON m.rowid = fts.rowid
+			WHERE messages_fts MATCH ?
+				AND `+chatFilterSQL(s.chatFilter, "m.chat_jid")+filter+`
+		),
+		top_chats AS (
+			SELECT DISTINCT chat_jid, best_rank FROM hits
+			ORDER BY best_rank
+			LIMIT ?
+		)
+		SELECT `+messageColumnsSQL+`, h.chat_jid, h.chat_matches,
+			COALESCE(NULLIF(ch.name, ''), NULLIF(ct.push_name, ''), NULLIF(ct.name, ''),
+				REPLACE(REPLACE(h.chat_jid, '@s.whatsapp.net', ''), '@g.us', '')) AS chat_name
+		FROM hits h
+		JOIN top_chats tc ON tc.chat_jid = h.chat_jid
+		JOIN messages m ON m.rowid = h.msg_rowid
+		LEFT JOIN contacts sc ON sc.jid = m.sender_jid
+		LEFT JOIN chats ch ON ch.jid = h.chat_jid
+		LEFT JOIN contacts ct ON ct.jid = h.chat_jid
+		WHERE h.rn <= ?
+		ORDER BY tc.best_rank, h.chat_jid, h.rn
+	`, append(args, opts.Limit, perChat)...)
+	if err != nil {
+		return nil, fmt.Errorf("search messages by chat: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make([]ChatSearchGroup, 0)
+	for rows.Next() {
+		var chatJID, chatName string
+		var chatMatches int
+		msg, err := scanMessageWith(rows, &chatJID, &chatMatches, &chatName)
+		if err != nil {
+			return nil, fmt.Errorf("scan grouped search result: %w", err)
+		}
+
+		apiChatJID := toAPIJIDString(chatJID)
+		if n := len(groups); n == 0 || groups[n-1].ChatJID != apiChatJID {
+			groups = append(groups, ChatSearchGroup{
+				ChatJID:    apiChatJID,
+				ChatName:   chatName,
+				MatchCount: chatMatches,
+				Messages:   make([]Message, 0, perChat),
+			})
+		}
+		g := &groups[len(groups)-1]
+		g.Messages = append(g.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate grouped search results: %w", err)
+	}
+	return groups, nil
+}
@@ -7,7 +7,10 @@ CREATE TABLE IF NOT EXISTS contacts (
     push_name TEXT NOT NULL DEFAULT '',
     number TEXT NOT NULL DEFAULT '',
     is_group INTEGER NOT NULL DEFAULT 0,
-    updated_at INTEGER NOT NULL DEFAULT 0
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    about TEXT NOT NULL DEFAULT '',
+    about_updated_at INTEGER NOT NULL DEFAULT 0,
+    business_name TEXT NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS chats (
@@ -17,7 +20,13 @@ CREATE TABLE IF NOT EXISTS chats (
     unread_count INTEGER NOT NULL DEFAULT 0,
     last_message TEXT,
     last_msg_ts INTEGER,
-    updated_at INTEGER NOT NULL DEFAULT 0
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    deleted_at INTEGER,
+    group_refreshed_at INTEGER NOT NULL DEFAULT 0,
+    left_at INTEGER,
+    chat_type TEXT NOT NULL DEFAULT '',
+    archived INTEGER NOT NULL DEFAULT 0,
+    muted_until INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS messages (
@@ -30,28 +39,151 @@ CREATE TABLE IF NOT EXISTS messages (
     timestamp INTEGER NOT NULL DEFAULT 0,
     has_media INTEGER NOT NULL DEFAULT 0,
     media_type TEXT,
-    raw_proto BLOB
+    raw_proto BLOB,
+    source TEXT NOT NULL DEFAULT '',
+    edited_at INTEGER,
+    is_forwarded INTEGER NOT NULL DEFAULT 0,
+    forwarding_score INTEGER NOT NULL DEFAULT 0,
+    media_search TEXT NOT NULL DEFAULT '',
+    received_at INTEGER,
+    media_status TEXT NOT NULL DEFAULT '',
+    quoted_id TEXT NOT NULL DEFAULT '',
+    quoted_preview TEXT NOT NULL DEFAULT '',
+    pinned INTEGER NOT NULL DEFAULT 0,
+    starred INTEGER NOT NULL DEFAULT 0,
+    delivery_status TEXT NOT NULL DEFAULT '',
+    revoked INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE INDEX IF NOT EXISTS idx_messages_chat_ts ON messages(chat_jid, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_jid) WHERE sender_name != '';
+
+CREATE TABLE IF NOT EXISTS sync_state (
+    key TEXT PRIMARY KEY,
+    value TEXT
+);
+
+CREATE TABLE IF NOT EXISTS chat_sync_state (
+    chat_jid TEXT PRIMARY KEY,
+    last_sync_at INTEGER NOT NULL DEFAULT 0,
+    oldest_synced_ts INTEGER,
+    fully_synced INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS live_locations (
+    message_id TEXT PRIMARY KEY,
+    chat_jid TEXT NOT NULL,
+    sender_jid TEXT NOT NULL DEFAULT '',
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    accuracy_m INTEGER NOT NULL DEFAULT 0,
+    speed_mps REAL NOT NULL DEFAULT 0,
+    heading INTEGER NOT NULL DEFAULT 0,
+    caption TEXT NOT NULL DEFAULT '',
+    sequence INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_live_locations_sender ON live_locations(chat_jid, sender_jid, started_at);
 
-CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(body, content=messages, content_rowid=rowid);
+CREATE TABLE IF NOT EXISTS group_participants (
+    group_jid TEXT NOT NULL,
+    participant_jid TEXT NOT NULL,
+    is_admin INTEGER NOT NULL DEFAULT 0,
+    lid TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (group_jid, participant_jid)
+);
+
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_list_members (
+    list_jid TEXT NOT NULL,
+    member_jid TEXT NOT NULL,
+    PRIMARY KEY (list_jid, member_jid)
+);
+
+CREATE TABLE IF NOT EXISTS profile_pics (
+    jid TEXT NOT NULL,
+    preview INTEGER NOT NULL DEFAULT 0,
+    url TEXT NOT NULL DEFAULT '',
+    id TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (jid, preview)
+);
+`
+
+// migration is one step in bringing an existing database up to the current
+// schema. appSchema creates new tables with every column already in place,
+// so a step must be safe to run on those too.
+type migration struct {
+	description string
+	apply       func(tx schemaTx) error
+}
+
+// migrations are applied in order by migrateSchema; a database's
+// schema_version is how many it has had. Only ever append: a released step
+// must not be changed or reordered. A new column goes into appSchema (and
+// the tests' schema) and gets a step here, such as
+//
+//	{"add messages.starred", addColumn("messages", "starred", "INTEGER NOT NULL DEFAULT 0")},
+//
+// addColumn skips a column that is already there, so the step is harmless
+// on a database appSchema has just created.
+var migrations = []migration{
+	{"add columns from before schema versioning", addMissingColumns},
+	{"add contacts.business_name", addColumn("contacts", "business_name", "TEXT NOT NULL DEFAULT ''")},
+	{"add messages.media_status", addColumn("messages", "media_status", "TEXT NOT NULL DEFAULT ''")},
+	{"add chats.left_at", addColumn("chats", "left_at", "INTEGER")},
+	{"add chats.chat_type", addColumn("chats", "chat_type", "TEXT NOT NULL DEFAULT ''")},
+	{"add messages.quoted_id", addColumn("messages", "quoted_id", "TEXT NOT NULL DEFAULT ''")},
+	{"add messages.quoted_preview", addColumn("messages", "quoted_preview", "TEXT NOT NULL DEFAULT ''")},
+	{"add chats.archived", addColumn("chats", "archived", "INTEGER NOT NULL DEFAULT 0")},
+	{"add chats.muted_until", addColumn("chats", "muted_until", "INTEGER NOT NULL DEFAULT 0")},
+	{"add messages.pinned", addColumn("messages", "pinned", "INTEGER NOT NULL DEFAULT 0")},
+	{"add messages.starred", addColumn("messages", "starred", "INTEGER NOT NULL DEFAULT 0")},
+	{"add messages.delivery_status", addColumn("messages", "delivery_status", "TEXT NOT NULL DEFAULT ''")},
+	{"add messages.revoked", addColumn("messages", "revoked", "INTEGER NOT NULL DEFAULT 0")},
+	{"add group_participants.lid", addColumn("group_participants", "lid", "TEXT NOT NULL DEFAULT ''")},
+	{"add group_participants.phone", addColumn("group_participants", "phone", "TEXT NOT NULL DEFAULT ''")},
+}
+
+// appColumns lists columns added to existing tables before schema versioning.
+// Databases of that time are in no known state, so the first migration adds
+// whichever of these are missing.
+var appColumns = []struct {
+	table, column, definition string
+}{
+	{"messages", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "edited_at", "INTEGER"},
+	{"chats", "deleted_at", "INTEGER"},
+	{"messages", "is_forwarded", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "forwarding_score", "INTEGER NOT NULL DEFAULT 0"},
+	{"contacts", "about", "TEXT NOT NULL DEFAULT ''"},
+	{"contacts", "about_updated_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"chats", "group_refreshed_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "media_search", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "received_at", "INTEGER"},
+}
+
+// ftsSchema is the full-text index over messages: the body (text or caption)
+// and media_search (media type, mimetype and document file name, see
+// mediaSearchText). migrateFTS creates it after the tables exist.
+const ftsSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(body, media_search, content=messages, content_rowid=rowid);
 
 CREATE TRIGGER IF NOT EXISTS messages_fts_ai AFTER INSERT ON messages BEGIN
-    INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+    INSERT INTO messages_fts(rowid, body, media_search) VALUES (new.rowid, new.body, new.media_search);
 END;
 
 CREATE TRIGGER IF NOT EXISTS messages_fts_ad AFTER DELETE ON messages BEGIN
-    INSERT INTO messages_fts(messages_fts, rowid, body) VALUES('delete', old.rowid, old.body);
+    INSERT INTO messages_fts(messages_fts, rowid, body, media_search) VALUES('delete', old.rowid, old.body, old.media_search);
 END;
 
 CREATE TRIGGER IF NOT EXISTS messages_fts_au AFTER UPDATE ON messages BEGIN
-    INSERT INTO messages_fts(messages_fts, rowid, body) VALUES('delete', old.rowid, old.body);
-    INSERT INTO messages_fts(rowid, body) VALUES (new.rowid, new.body);
+    INSERT INTO messages_fts(messages_fts, rowid, body, media_search) VALUES('delete', old.rowid, old.body, old.media_search);
+    INSERT INTO messages_fts(rowid, body, media_search) VALUES (new.rowid, new.body, new.media_search);
 END;
-
-CREATE TABLE IF NOT EXISTS sync_state (
-    key TEXT PRIMARY KEY,
-    value TEXT
-);
 `
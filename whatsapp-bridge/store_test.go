@@ -2,11 +2,18 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
 )
 
 // testSchema is the schema without FTS5 (which may not be compiled into the
@@ -42,14 +49,64 @@ CREATE TABLE IF NOT EXISTS messages (
     raw_proto BLOB
 );
 CREATE INDEX IF NOT EXISTS idx_messages_chat_ts ON messages(chat_jid, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_jid) WHERE sender_name != '';
 CREATE TABLE IF NOT EXISTS sync_state (
     key TEXT PRIMARY KEY,
     value TEXT
 );
+CREATE TABLE IF NOT EXISTS chat_sync_state (
+    chat_jid TEXT PRIMARY KEY,
+    last_sync_at INTEGER NOT NULL DEFAULT 0,
+    oldest_synced_ts INTEGER,
+    fully_synced INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS live_locations (
+    message_id TEXT PRIMARY KEY,
+    chat_jid TEXT NOT NULL,
+    sender_jid TEXT NOT NULL DEFAULT '',
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    accuracy_m INTEGER NOT NULL DEFAULT 0,
+    speed_mps REAL NOT NULL DEFAULT 0,
+    heading INTEGER NOT NULL DEFAULT 0,
+    caption TEXT NOT NULL DEFAULT '',
+    sequence INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS group_participants (
+    group_jid TEXT NOT NULL,
+    participant_jid TEXT NOT NULL,
+    is_admin INTEGER NOT NULL DEFAULT 0,
+    lid TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (group_jid, participant_jid)
+);
+
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_list_members (
+    list_jid TEXT NOT NULL,
+    member_jid TEXT NOT NULL,
+    PRIMARY KEY (list_jid, member_jid)
+);
+
+CREATE TABLE IF NOT EXISTS profile_pics (
+    jid TEXT NOT NULL,
+    preview INTEGER NOT NULL DEFAULT 0,
+    url TEXT NOT NULL DEFAULT '',
+    id TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (jid, preview)
+);
 `
 
 // newTestStore creates a temporary SQLite database for testing.
-func newTestStore(t *testing.T) *AppStore {
+func newTestStore(t testing.TB) *AppStore {
 	t.Helper()
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
@@ -60,11 +117,18 @@ func newTestStore(t *testing.T) *AppStore {
 	if _, err := db.Exec(testSchema); err != nil {
 		t.Fatalf("run schema: %v", err)
 	}
+	if err := migrateSchema(db); err != nil {
+		t.Fatalf("migrate schema: %v", err)
+	}
 	t.Cleanup(func() {
 		db.Close()
 		os.Remove(dbPath)
 	})
-	return &AppStore{db: db}
+	store, err := NewAppStoreFromDB(db)
+	if err != nil {
+		t.Fatalf("NewAppStoreFromDB: %v", err)
+	}
+	return store
 }
 
 func TestUpsertAndGetContacts(t *testing.T) {
@@ -139,6 +203,26 @@ func TestUpsertAndGetChats(t *testing.T) {
 	}
 }
 
+func TestGetChatsPaged(t *testing.T) {
+	store := newTestStore(t)
+	for i, jid := range []string{"10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "10000000003@s.whatsapp.net"} {
+		ts := int64(100 + i)
+		store.UpsertChat(jid, "", false, nil, &ts)
+	}
+	store.SetChatArchived("10000000002@s.whatsapp.net", true)
+
+	chats, hasMore, err := store.GetChatsPaged(2, 0)
+	if err != nil {
+		t.Fatalf("GetChatsPaged: %v", err)
+	}
+	if len(chats) != 2 || !hasMore || chats[0].ID != "10000000003@c.us" || chats[1].ID != "10000000002@c.us" {
+		t.Errorf("first page = %+v, hasMore %v; want the two newest, archived included", chats, hasMore)
+	}
+	if chats, hasMore, _ = store.GetChatsPaged(2, 2); len(chats) != 1 || hasMore {
+		t.Errorf("last page = %+v, hasMore %v; want the oldest only", chats, hasMore)
+	}
+}
+
 func TestIncrementAndMarkRead(t *testing.T) {
 	store := newTestStore(t)
 	jid := "10000000001@s.whatsapp.net"
@@ -245,6 +329,188 @@ func TestDeleteChat(t *testing.T) {
 	}
 }
 
+func TestStarredMessages(t *testing.T) {
+	store := newTestStore(t)
+	alice, bob := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net"
+	store.UpsertChat(alice, "Alice", false, nil, nil)
+	store.UpsertChat(bob, "Bob", false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_KEEP", alice, alice, "", false, "address", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_DROP", alice, alice, "", false, "ok", 200, false, nil, nil)
+	store.UpsertMessage("false_10000000002@c.us_BOB", bob, bob, "", false, "code", 300, false, nil, nil)
+	store.SetMessageStarred("false_10000000001@c.us_KEEP", true)
+	store.SetMessageStarred("false_10000000002@c.us_BOB", true)
+
+	if err := store.SetMessageStarred("false_10000000001@c.us_NONE", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("starring a missing message: err = %v, want sql.ErrNoRows", err)
+	}
+	starred, err := store.GetStarredMessages(10)
+	if err != nil || len(starred) != 2 || starred[0].ChatName != "Bob" || starred[1].ChatName != "Alice" || !starred[1].Starred {
+		t.Fatalf("starred = %+v, %v", starred, err)
+	}
+
+	// Deleting the chat keeps its starred message, listed under the number
+	if err := store.DeleteChat(alice); err != nil {
+		t.Fatal(err)
+	}
+	starred, _ = store.GetStarredMessages(10)
+	if len(starred) != 2 || starred[1].ID != "false_10000000001@c.us_KEEP" || starred[1].ChatName != "10000000001" {
+		t.Errorf("starred after delete = %+v", starred)
+	}
+	if n, _ := store.GetMessageCount(alice); n != 1 {
+		t.Errorf("%d messages left in deleted chat, want the starred one", n)
+	}
+
+	store.SetMessageStarred("false_10000000002@c.us_BOB", false)
+	if n, _ := store.ClearMessages(bob); n != 1 {
+		t.Errorf("clear after unstar deleted %d messages, want 1", n)
+	}
+}
+
+func TestSetDeliveryStatus(t *testing.T) {
+	store := newTestStore(t)
+	alice := "10000000001@s.whatsapp.net"
+	store.UpsertMessage("true_10000000001@c.us_OWN", alice, "19999999999@s.whatsapp.net", "", true, "hi", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_THEIRS", alice, alice, "", false, "hey", 200, false, nil, nil)
+	status := func() map[string]string {
+		msgs, _ := store.GetMessages(alice, 10, 0)
+		got := make(map[string]string, len(msgs))
+		for _, m := range msgs {
+			got[m.ID] = m.Status
+		}
+		return got
+	}
+
+	if got := status(); got["true_10000000001@c.us_OWN"] != DeliveryStatusSent || got["false_10000000001@c.us_THEIRS"] != "" {
+		t.Errorf("before receipts: %v", got)
+	}
+	ids := []string{"true_10000000001@s.whatsapp.net_OWN", "true_10000000001@c.us_THEIRS"}
+	for _, step := range []struct {
+		status string
+		n      int64
+		want   string
+	}{
+		{DeliveryStatusDelivered, 1, DeliveryStatusDelivered},
+		{DeliveryStatusRead, 1, DeliveryStatusRead},
+		{DeliveryStatusDelivered, 0, DeliveryStatusRead}, // late: doesn't go back
+	} {
+		n, err := store.SetDeliveryStatus(ids, step.status)
+		if err != nil || n != step.n {
+			t.Errorf("%s: updated %d, %v; want %d", step.status, n, err, step.n)
+		}
+		if got := status(); got["true_10000000001@c.us_OWN"] != step.want || got["false_10000000001@c.us_THEIRS"] != "" {
+			t.Errorf("after %s: %v", step.status, got)
+		}
+	}
+}
+
+func TestTrashChat(t *testing.T) {
+	store := newTestStore(t)
+	alice, bob := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net"
+	for _, jid := range []string{alice, bob} {
+		store.UpsertChat(jid, "", false, nil, nil)
+		store.UpsertMessage("false_"+toAPIJIDString(jid)+"_MSG", jid, jid, "", false, "hi", 100, false, nil, nil)
+	}
+
+	if found, err := store.TrashChat(alice); err != nil || !found {
+		t.Fatalf("TrashChat = %v, %v", found, err)
+	}
+	if found, _ := store.TrashChat("10000000009@s.whatsapp.net"); found {
+		t.Error("TrashChat found a chat that does not exist")
+	}
+
+	// Hidden from listings, messages kept
+	if chats, _ := store.GetChats(); len(chats) != 1 || chats[0].ID != "10000000002@c.us" {
+		t.Errorf("chats = %+v, want only bob", chats)
+	}
+	if contacts, _ := store.GetContacts(); len(contacts) != 1 {
+		t.Errorf("contacts = %+v, want only bob", contacts)
+	}
+	trashed, _ := store.GetTrashedChats()
+	if len(trashed) != 1 || trashed[0].ID != "10000000001@c.us" || trashed[0].DeletedAt == nil || trashed[0].MessageCount != 1 {
+		t.Errorf("trashed = %+v", trashed)
+	}
+
+	if restored, _ := store.RestoreChat(alice); !restored {
+		t.Error("RestoreChat(alice) = false")
+	}
+	if restored, _ := store.RestoreChat(alice); restored {
+		t.Error("RestoreChat restored a chat that was not trashed")
+	}
+	if chats, _ := store.GetChats(); len(chats) != 2 {
+		t.Errorf("chats after restore = %d, want 2", len(chats))
+	}
+
+	// Purge only what was trashed before the cutoff
+	store.TrashChat(alice)
+	if n, _ := store.PurgeTrash(time.Now().Add(-time.Hour).Unix()); n != 0 {
+		t.Errorf("purged %d chats trashed after the cutoff", n)
+	}
+	if n, err := store.PurgeTrash(time.Now().Unix()); err != nil || n != 1 {
+		t.Errorf("PurgeTrash = %d, %v, want 1", n, err)
+	}
+	if total, _ := store.GetTotalMessageCount(); total != 1 {
+		t.Errorf("messages left = %d, want bob's 1", total)
+	}
+}
+
+func TestDeleteChats(t *testing.T) {
+	store := newTestStore(t)
+	alice, bob, carol := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "10000000003@s.whatsapp.net"
+	for _, jid := range []string{alice, bob, carol} {
+		store.UpsertChat(jid, "", false, nil, nil)
+		store.UpsertMessage("false_"+toAPIJIDString(jid)+"_MSG", jid, jid, "", false, "hi", 100, false, nil, nil)
+	}
+
+	found, err := store.DeleteChats([]string{alice, "10000000009@s.whatsapp.net", bob})
+	if err != nil {
+		t.Fatalf("DeleteChats: %v", err)
+	}
+	if len(found) != 3 || !found[0] || found[1] || !found[2] {
+		t.Errorf("found = %v, want [true false true]", found)
+	}
+	chats, _ := store.GetChats()
+	if len(chats) != 1 || chats[0].ID != "10000000003@c.us" {
+		t.Errorf("chats left = %+v, want only carol", chats)
+	}
+	if total, _ := store.GetTotalMessageCount(); total != 1 {
+		t.Errorf("messages left = %d, want 1", total)
+	}
+}
+
+func TestClearMessages(t *testing.T) {
+	store := newTestStore(t)
+	chatJID, otherJID := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net"
+	preview, ts := "msg", int64(200)
+	store.UpsertChat(chatJID, "Test", false, &preview, &ts)
+	store.IncrementUnread(chatJID)
+	store.UpsertMessage("false_10000000001@c.us_MSG1", chatJID, chatJID, "", false, "msg", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "", false, "msg", 200, false, nil, nil)
+	store.UpsertMessage("false_10000000002@c.us_MSG3", otherJID, otherJID, "", false, "keep", 300, false, nil, nil)
+	store.RecordChatSync(chatJID, true)
+
+	n, err := store.ClearMessages(chatJID)
+	if err != nil {
+		t.Fatalf("ClearMessages: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("deleted = %d, want 2", n)
+	}
+
+	chats, _ := store.GetChats()
+	if len(chats) != 1 || chats[0].LastMessage != nil || chats[0].LastMessageTimestamp != nil || chats[0].UnreadCount != 0 {
+		t.Errorf("chats after clear = %+v", chats)
+	}
+	if count, _ := store.GetMessageCount(chatJID); count != 0 {
+		t.Errorf("%d messages left in cleared chat", count)
+	}
+	if count, _ := store.GetMessageCount(otherJID); count != 1 {
+		t.Errorf("other chat has %d messages, want 1", count)
+	}
+	if _, err := store.GetChatSyncState(chatJID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("sync state survived clear: %v", err)
+	}
+}
+
 func TestGetMessageCount(t *testing.T) {
 	store := newTestStore(t)
 	chatJID := "10000000001@s.whatsapp.net"
@@ -355,6 +621,36 @@ func TestGetContacts_IncludesGroups(t *testing.T) {
 	}
 }
 
+func TestGetContacts_SeparateNames(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertChat("10000000001@s.whatsapp.net", "", false, nil, nil)
+	store.UpsertContact("10000000001@s.whatsapp.net", "Alice Smith", "Bank Support", "10000000001", false)
+	store.UpsertChat("10000000002@s.whatsapp.net", "", false, nil, nil)
+	store.UpsertContact("10000000002@s.whatsapp.net", "", "Bob", "10000000002", false)
+
+	contacts, err := store.GetContacts()
+	if err != nil {
+		t.Fatalf("GetContacts: %v", err)
+	}
+	if len(contacts) != 2 {
+		t.Fatalf("GetContacts: got %d, want 2", len(contacts))
+	}
+	if c := contacts[0]; c.Name != "Alice Smith" || c.SavedName != "Alice Smith" || c.PushName != "Bank Support" {
+		t.Errorf("saved contact = %+v", c)
+	}
+	if c := contacts[1]; c.Name != "Bob" || c.SavedName != "" || c.PushName != "Bob" {
+		t.Errorf("unsaved contact = %+v", c)
+	}
+
+	detail, err := store.GetContactDetail("10000000001@s.whatsapp.net")
+	if err != nil {
+		t.Fatalf("GetContactDetail: %v", err)
+	}
+	if detail.SavedName != "Alice Smith" || detail.PushName != "Bank Support" {
+		t.Errorf("detail = %+v", detail)
+	}
+}
+
 func TestGetContacts_ExcludesLidAndBroadcast(t *testing.T) {
 	store := newTestStore(t)
 
@@ -445,7 +741,7 @@ func TestGetMessages_PushNameFallbackViaSubquery(t *testing.T) {
 	senderJID := "10000000077@s.whatsapp.net"
 
 	// Contact has no direct JID match, but push_name matches a contact row
-	// This tests the "(SELECT c2.name FROM contacts c2 WHERE c2.push_name = m.sender_name)" fallback
+	// This tests the fallback to a contact by push name (a subquery in senderNameSQL)
 	store.UpsertContact("10000000077@s.whatsapp.net", "Real Name", "NickPush", "10000000077", false)
 
 	// Message whose sender_name is "NickPush" but sender_jid matches the contact
@@ -471,6 +767,53 @@ func TestGetMessages_PushNameFallbackViaSubquery(t *testing.T) {
 	}
 }
 
+func TestGetMessages_SenderNamePrecedence(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "120363000000000001@g.us"
+	// Each sender's best name comes from a different step of senderNameSQL
+	store.UpsertContact("10000000001@s.whatsapp.net", "Saved", "Pushed", "", false)
+	store.UpsertContact("10000000002@s.whatsapp.net", "", "Pushed Only", "", false)
+	store.UpsertContact("10000000099@s.whatsapp.net", "Known Elsewhere", "Nick", "", false)
+	store.UpsertMessage("false_120363000000000001@g.us_EARLIER", chatJID, "10000000005@s.whatsapp.net", "Seen Before", false, "hi", 90, false, nil, nil)
+	for id, m := range map[string]struct{ sender, name string }{
+		"CONTACT":   {"10000000001@s.whatsapp.net", "Stored"},
+		"PUSH":      {"10000000002@s.whatsapp.net", "Stored"},
+		"BYPUSH":    {"10000000003@s.whatsapp.net", "Nick"},
+		"STORED":    {"10000000004@s.whatsapp.net", "Stored"},
+		"OTHER":     {"10000000005@s.whatsapp.net", ""},
+		"ANONYMOUS": {"10000000006@s.whatsapp.net", ""},
+	} {
+		store.UpsertMessage("false_120363000000000001@g.us_"+id, chatJID, m.sender, m.name, false, "hi", 100, false, nil, nil)
+	}
+
+	msgs, err := store.GetMessages(chatJID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	want := map[string]string{
+		"CONTACT":   "Saved",
+		"PUSH":      "Pushed Only",
+		"BYPUSH":    "Known Elsewhere",
+		"STORED":    "Stored",
+		"OTHER":     "Seen Before",
+		"EARLIER":   "Seen Before",
+		"ANONYMOUS": "",
+	}
+	for _, m := range msgs {
+		id := strings.TrimPrefix(m.ID, "false_120363000000000001@g.us_")
+		got := ""
+		if m.SenderName != nil {
+			got = *m.SenderName
+		}
+		if got != want[id] {
+			t.Errorf("%s: sender name = %q, want %q", id, got, want[id])
+		}
+	}
+	if len(msgs) != len(want) {
+		t.Errorf("got %d messages, want %d", len(msgs), len(want))
+	}
+}
+
 // ---------------------------------------------------------------------------
 // GetMessages sender name fallback from other messages with same sender_jid
 // ---------------------------------------------------------------------------
@@ -673,6 +1016,60 @@ func TestUpdateChatLastMessage(t *testing.T) {
 // SQLite builds. SearchMessages is tested via integration tests with the
 // full bridge binary that includes FTS5 support.
 
+func TestMediaSearchColumn(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	doc, _ := proto.Marshal(&waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+		FileName: proto.String("invoice.pdf"), Mimetype: proto.String("application/pdf"),
+	}})
+	store.UpsertMessage("false_10000000001@c.us_DOC", chatJID, chatJID, "", false, "", 100, true, strPtr("document"), doc)
+	store.UpsertMessage("false_10000000001@c.us_TXT", chatJID, chatJID, "", false, "hi", 200, false, nil, nil)
+
+	searchText := func(id string) string {
+		var s string
+		if err := store.db.QueryRow(`SELECT media_search FROM messages WHERE id = ?`, id).Scan(&s); err != nil {
+			t.Fatalf("select media_search: %v", err)
+		}
+		return s
+	}
+	if got := searchText("false_10000000001@c.us_DOC"); got != "document invoice.pdf application/pdf" {
+		t.Errorf("document media_search = %q", got)
+	}
+	if got := searchText("false_10000000001@c.us_TXT"); got != "" {
+		t.Errorf("text media_search = %q, want empty", got)
+	}
+
+	// Messages stored before the column existed are filled in on upgrade
+	store.db.Exec(`UPDATE messages SET media_search = ''`)
+	if n, err := backfillMediaSearch(store.db); err != nil || n != 1 {
+		t.Fatalf("backfillMediaSearch = %d, %v; want 1", n, err)
+	}
+	if got := searchText("false_10000000001@c.us_DOC"); got != "document invoice.pdf application/pdf" {
+		t.Errorf("backfilled media_search = %q", got)
+	}
+}
+
+func TestUpsertMessage_ReceivedAt(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	id := "false_10000000001@c.us_MSG1"
+
+	before := time.Now().Unix()
+	store.UpsertMessage(id, chatJID, chatJID, "", false, "hi", 100, false, nil, nil)
+	msgs, _ := store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 1 || msgs[0].ReceivedAt == nil || *msgs[0].ReceivedAt < before {
+		t.Fatalf("receivedAt = %v, want at least %d", msgs[0].ReceivedAt, before)
+	}
+
+	// A redelivery (e.g. history sync) keeps the first-seen time
+	store.db.Exec(`UPDATE messages SET received_at = 50 WHERE id = ?`, id)
+	store.UpsertMessage(id, chatJID, chatJID, "", false, "hi", 100, false, nil, nil)
+	msgs, _ = store.GetMessages(chatJID, 10, 0)
+	if *msgs[0].ReceivedAt != 50 {
+		t.Errorf("receivedAt after re-upsert = %d, want 50", *msgs[0].ReceivedAt)
+	}
+}
+
 func TestGetRawProto(t *testing.T) {
 	store := newTestStore(t)
 	chatJID := "10000000001@s.whatsapp.net"
@@ -689,6 +1086,57 @@ func TestGetRawProto(t *testing.T) {
 	}
 }
 
+func TestGetRawProto_LegacyIDForm(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+
+	// Stored under the legacy form, requested with the canonical form
+	store.UpsertMessage("false_10000000001@s.whatsapp.net_MSG1", chatJID, chatJID, "", false, "", 100, true, strPtr("image"), []byte{0x01})
+
+	raw, err := store.GetRawProto("false_10000000001@c.us_MSG1")
+	if err != nil {
+		t.Fatalf("GetRawProto: %v", err)
+	}
+	if len(raw) != 1 || raw[0] != 0x01 {
+		t.Errorf("raw proto mismatch: %v", raw)
+	}
+}
+
+func TestCanonicalizeMessageIDs(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+
+	// Legacy-only row gets rewritten
+	store.UpsertMessage("false_10000000001@s.whatsapp.net_MSG1", chatJID, chatJID, "", false, "a", 100, false, nil, nil)
+	// Duplicate pair: legacy carries the proto, canonical does not
+	store.UpsertMessage("false_10000000001@s.whatsapp.net_MSG2", chatJID, chatJID, "", false, "", 200, true, strPtr("image"), []byte{0x02})
+	store.UpsertMessage("false_10000000001@c.us_MSG2", chatJID, chatJID, "", false, "", 200, true, strPtr("image"), nil)
+
+	n, err := store.CanonicalizeMessageIDs()
+	if err != nil {
+		t.Fatalf("CanonicalizeMessageIDs: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("changed rows = %d, want 2", n)
+	}
+
+	count, _ := store.GetMessageCount(chatJID)
+	if count != 2 {
+		t.Errorf("message count = %d, want 2", count)
+	}
+
+	var legacy int
+	store.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE id LIKE '%@s.whatsapp.net_%'`).Scan(&legacy)
+	if legacy != 0 {
+		t.Errorf("legacy IDs remaining = %d, want 0", legacy)
+	}
+
+	raw, err := store.GetRawProto("false_10000000001@c.us_MSG2")
+	if err != nil || len(raw) != 1 || raw[0] != 0x02 {
+		t.Errorf("raw proto should be merged into canonical row: %v, %v", raw, err)
+	}
+}
+
 func TestGetOldestMessage(t *testing.T) {
 	store := newTestStore(t)
 	chatJID := "10000000001@s.whatsapp.net"
@@ -707,3 +1155,607 @@ func TestGetOldestMessage(t *testing.T) {
 		t.Errorf("oldest rawMsgID = %q, want %q", oldest.RawMsgID, "MSG1")
 	}
 }
+
+func TestAddMissingColumns_UpgradesOldSchema(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	for _, ddl := range []string{
+		`CREATE TABLE messages (id TEXT PRIMARY KEY, chat_jid TEXT NOT NULL)`,
+		`CREATE TABLE chats (jid TEXT PRIMARY KEY)`,
+		`CREATE TABLE contacts (jid TEXT PRIMARY KEY)`,
+	} {
+		if _, err := db.Exec(ddl); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+	db.Exec(`INSERT INTO messages (id, chat_jid) VALUES ('m1', 'c1')`)
+
+	// Running twice must be a no-op the second time
+	for i := 0; i < 2; i++ {
+		if err := addMissingColumns(db); err != nil {
+			t.Fatalf("addMissingColumns run %d: %v", i+1, err)
+		}
+	}
+
+	var source string
+	if err := db.QueryRow(`SELECT source FROM messages WHERE id = 'm1'`).Scan(&source); err != nil {
+		t.Fatalf("select source: %v", err)
+	}
+	if source != "" {
+		t.Errorf("source = %q, want empty default", source)
+	}
+}
+
+// firstReleaseSchema is the app schema of the first release, less the FTS
+// index, which the test SQLite lacks.
+const firstReleaseSchema = `
+CREATE TABLE contacts (jid TEXT PRIMARY KEY, name TEXT NOT NULL DEFAULT '', push_name TEXT NOT NULL DEFAULT '',
+	number TEXT NOT NULL DEFAULT '', is_group INTEGER NOT NULL DEFAULT 0, updated_at INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE chats (jid TEXT PRIMARY KEY, name TEXT NOT NULL DEFAULT '', is_group INTEGER NOT NULL DEFAULT 0,
+	unread_count INTEGER NOT NULL DEFAULT 0, last_message TEXT, last_msg_ts INTEGER, updated_at INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE messages (id TEXT PRIMARY KEY, chat_jid TEXT NOT NULL, sender_jid TEXT NOT NULL DEFAULT '',
+	sender_name TEXT NOT NULL DEFAULT '', from_me INTEGER NOT NULL DEFAULT 0, body TEXT NOT NULL DEFAULT '',
+	timestamp INTEGER NOT NULL DEFAULT 0, has_media INTEGER NOT NULL DEFAULT 0, media_type TEXT, raw_proto BLOB);
+CREATE INDEX idx_messages_chat_ts ON messages(chat_jid, timestamp DESC);
+CREATE TABLE sync_state (key TEXT PRIMARY KEY, value TEXT);
+`
+
+func TestMigrateSchema(t *testing.T) {
+	open := func(t *testing.T) *sql.DB {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "app.db"))
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	migrate := func(t *testing.T, db *sql.DB) {
+		t.Helper()
+		// Startup runs the schema then the migrations; a restart repeats both
+		for i := 0; i < 2; i++ {
+			if _, err := db.Exec(testSchema); err != nil {
+				t.Fatalf("run schema: %v", err)
+			}
+			if err := migrateSchema(db); err != nil {
+				t.Fatalf("migrateSchema run %d: %v", i+1, err)
+			}
+		}
+		if v, err := schemaVersion(db); err != nil || v != len(migrations) {
+			t.Errorf("schema version = %d, %v; want %d", v, err, len(migrations))
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		migrate(t, open(t))
+	})
+
+	t.Run("populated from before versioning", func(t *testing.T) {
+		db := open(t)
+		for _, ddl := range []string{
+			firstReleaseSchema,
+			`INSERT INTO chats (jid, name) VALUES ('10000000001@s.whatsapp.net', 'Alice')`,
+			`INSERT INTO messages (id, chat_jid, body, timestamp) VALUES ('false_10000000001@c.us_M1', '10000000001@s.whatsapp.net', 'hi', 100)`,
+		} {
+			if _, err := db.Exec(ddl); err != nil {
+				t.Fatalf("set up old database: %v", err)
+			}
+		}
+		migrate(t, db)
+
+		store, _ := NewAppStoreFromDB(db)
+		msgs, err := store.GetMessages("10000000001@s.whatsapp.net", 10, 0)
+		if err != nil || len(msgs) != 1 || msgs[0].Body != "hi" {
+			t.Errorf("messages after migration = %+v, %v", msgs, err)
+		}
+	})
+
+	t.Run("newer than this build", func(t *testing.T) {
+		db := open(t)
+		db.Exec(testSchema)
+		db.Exec(`INSERT INTO schema_version (version) VALUES (?)`, len(migrations)+1)
+		if err := migrateSchema(db); err == nil {
+			t.Error("migrateSchema accepted a database from a newer build")
+		}
+	})
+}
+
+// TestMigrateSchema_UpgradeMatchesFreshSchema catches a column added to the
+// schema without a migration: an upgraded first-release database must end up
+// with the same columns as a new one.
+func TestMigrateSchema_UpgradeMatchesFreshSchema(t *testing.T) {
+	columns := func(t *testing.T, db *sql.DB) map[string][]string {
+		t.Helper()
+		rows, err := db.Query(`
+			SELECT m.name, p.name FROM sqlite_master m, pragma_table_info(m.name) p
+			WHERE m.type = 'table' ORDER BY m.name, p.name
+		`)
+		if err != nil {
+			t.Fatalf("list columns: %v", err)
+		}
+		defer rows.Close()
+		cols := map[string][]string{}
+		for rows.Next() {
+			var table, column string
+			rows.Scan(&table, &column)
+			cols[table] = append(cols[table], column)
+		}
+		return cols
+	}
+	build := func(t *testing.T, setup, schema string) *sql.DB {
+		t.Helper()
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "app.db"))
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		for _, ddl := range []string{setup, schema} {
+			if _, err := db.Exec(ddl); err != nil {
+				t.Fatalf("run schema: %v", err)
+			}
+		}
+		if err := migrateSchema(db); err != nil {
+			t.Fatalf("migrateSchema: %v", err)
+		}
+		return db
+	}
+
+	// As NewAppStore does, minus the FTS index
+	fresh := columns(t, build(t, "", appSchema))
+	upgraded := columns(t, build(t, firstReleaseSchema, appSchema))
+	tests := columns(t, build(t, "", testSchema))
+	for table, want := range fresh {
+		if got := strings.Join(upgraded[table], ","); got != strings.Join(want, ",") {
+			t.Errorf("%s columns after upgrade = %s, want %s", table, got, strings.Join(want, ","))
+		}
+		if got := strings.Join(tests[table], ","); got != strings.Join(want, ",") {
+			t.Errorf("%s columns of the tests' schema = %s, want %s", table, got, strings.Join(want, ","))
+		}
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage("false_10000000001@c.us_M1", "10000000001@s.whatsapp.net", "", "", false, "hi", 100, false, nil, nil)
+
+	// Twice: the second run finds the column and does nothing
+	for i := 0; i < 2; i++ {
+		if err := addColumn("messages", "test_flag", "INTEGER NOT NULL DEFAULT 7")(store.db); err != nil {
+			t.Fatalf("addColumn run %d: %v", i+1, err)
+		}
+	}
+	var flag int
+	var body string
+	if err := store.db.QueryRow(`SELECT test_flag, body FROM messages`).Scan(&flag, &body); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if flag != 7 || body != "hi" {
+		t.Errorf("after addColumn: flag = %d, body = %q; want the default and the row intact", flag, body)
+	}
+}
+
+func TestReconcileChatPreviews(t *testing.T) {
+	store := newTestStore(t)
+	alice, bob, empty := "10000000001@s.whatsapp.net", "10000000002@s.whatsapp.net", "10000000003@s.whatsapp.net"
+	stale, staleTs := "stale", int64(500)
+	store.UpsertChat(alice, "Alice", false, &stale, &staleTs)
+	store.UpsertMessage("false_10000000001@c.us_A1", alice, alice, "", false, "older", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_A2", alice, alice, "", false, strings.Repeat("x", 150), 200, false, nil, nil)
+	bobBody, bobTs := "hi bob", int64(300)
+	store.UpsertChat(bob, "Bob", false, &bobBody, &bobTs)
+	store.UpsertMessage("false_10000000002@c.us_B1", bob, bob, "", false, "hi bob", 300, false, nil, nil)
+	store.UpsertChat(empty, "Nobody", false, &stale, &staleTs)
+
+	n, err := store.ReconcileChatPreviews("")
+	if err != nil {
+		t.Fatalf("ReconcileChatPreviews: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("updated = %d, want 1 (only alice drifted)", n)
+	}
+
+	chats, _ := store.GetChats()
+	got := map[string]Chat{}
+	for _, c := range chats {
+		got[c.ID] = c
+	}
+	a := got["10000000001@c.us"]
+	if a.LastMessage == nil || *a.LastMessage != strings.Repeat("x", 100)+"..." || a.LastMessageTimestamp == nil || *a.LastMessageTimestamp != 200 {
+		t.Errorf("alice = %+v", a)
+	}
+	if e := got["10000000003@c.us"]; e.LastMessage == nil || *e.LastMessage != "stale" {
+		t.Errorf("chat without messages changed: %+v", e)
+	}
+
+	// A single chat, already in sync
+	if n, err := store.ReconcileChatPreviews(bob); err != nil || n != 0 {
+		t.Errorf("ReconcileChatPreviews(bob) = %d, %v, want 0", n, err)
+	}
+}
+
+func TestChatFilter_ListsAgree(t *testing.T) {
+	store := newTestStore(t)
+	jids := []string{
+		"10000000001@s.whatsapp.net",
+		"120363000000000001@g.us",
+		"200000000000001@lid",
+		"1700000000@broadcast",
+		"status@broadcast",
+	}
+	for _, jid := range jids {
+		store.UpsertChat(jid, "", strings.HasSuffix(jid, "@g.us"), nil, nil)
+		store.RecordChatSync(jid, false)
+	}
+
+	tests := []struct {
+		name   string
+		filter chatFilter
+		want   []string
+	}{
+		{"default", chatFilter{}, []string{"10000000001@c.us", "120363000000000001@g.us"}},
+		{"lid", chatFilter{IncludeLID: true}, []string{"10000000001@c.us", "120363000000000001@g.us", "200000000000001@lid"}},
+		{"broadcast", chatFilter{IncludeBroadcast: true}, []string{"10000000001@c.us", "120363000000000001@g.us", "1700000000@broadcast"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.chatFilter = tt.filter
+			lists := map[string][]string{}
+
+			contacts, err := store.GetContacts()
+			if err != nil {
+				t.Fatalf("GetContacts: %v", err)
+			}
+			for _, c := range contacts {
+				lists["contacts"] = append(lists["contacts"], c.ID)
+			}
+			chats, err := store.GetChats()
+			if err != nil {
+				t.Fatalf("GetChats: %v", err)
+			}
+			for _, c := range chats {
+				lists["chats"] = append(lists["chats"], c.ID)
+			}
+			chatJIDs, err := store.GetAllChatJIDs()
+			if err != nil {
+				t.Fatalf("GetAllChatJIDs: %v", err)
+			}
+			for _, jid := range chatJIDs {
+				lists["chat jids"] = append(lists["chat jids"], toAPIJIDString(jid))
+			}
+			states, err := store.GetAllChatSyncStates()
+			if err != nil {
+				t.Fatalf("GetAllChatSyncStates: %v", err)
+			}
+			for _, st := range states {
+				lists["sync states"] = append(lists["sync states"], st.ChatID)
+			}
+
+			for name, got := range lists {
+				sort.Strings(got)
+				if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("%s = %v, want %v", name, got, tt.want)
+				}
+			}
+			if len(lists) != 4 {
+				t.Errorf("only %d of 4 lists returned anything", len(lists))
+			}
+		})
+	}
+}
+
+func TestBroadcastLists(t *testing.T) {
+	store := newTestStore(t)
+	list := "1700000000@broadcast"
+	store.UpsertChat(list, "Family", false, nil, nil)
+	store.UpsertChat("status@broadcast", "", false, nil, nil)
+	store.UpsertChat("10000000001@s.whatsapp.net", "Alice", false, nil, nil)
+
+	store.SetBroadcastListMembers(list, []string{"10000000001@s.whatsapp.net", "10000000009@s.whatsapp.net"})
+	// Members are replaced, not merged
+	if err := store.SetBroadcastListMembers(list, []string{"10000000002@s.whatsapp.net", "10000000001@s.whatsapp.net"}); err != nil {
+		t.Fatalf("SetBroadcastListMembers: %v", err)
+	}
+	// A list known only from its members
+	store.SetBroadcastListMembers("1700000001@broadcast", []string{"10000000003@s.whatsapp.net"})
+
+	members, err := store.GetBroadcastListMembers(list)
+	if err != nil {
+		t.Fatalf("GetBroadcastListMembers: %v", err)
+	}
+	if strings.Join(members, ",") != "10000000001@s.whatsapp.net,10000000002@s.whatsapp.net" {
+		t.Errorf("members = %v", members)
+	}
+
+	lists, err := store.GetBroadcastLists()
+	if err != nil {
+		t.Fatalf("GetBroadcastLists: %v", err)
+	}
+	if len(lists) != 2 {
+		t.Fatalf("lists = %+v, want 2", lists)
+	}
+	if lists[0].ID != "1700000001@broadcast" || len(lists[0].Members) != 1 {
+		t.Errorf("unnamed list = %+v", lists[0])
+	}
+	if lists[1].Name != "Family" || strings.Join(lists[1].Members, ",") != "10000000001@c.us,10000000002@c.us" {
+		t.Errorf("named list = %+v", lists[1])
+	}
+}
+
+func TestGetMessageByRawID(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	store.UpsertMessage("false_10000000001@c.us_ABC", chatJID, chatJID, "Alice", false, "hi", 100, false, nil, nil)
+	store.UpsertMessage("true_10000000001@c.us_DEF", chatJID, "me@s.whatsapp.net", "", true, "hello", 200, false, nil, nil)
+	store.UpsertMessage("false_10000000002@c.us_GHI", "10000000002@s.whatsapp.net", "10000000002@s.whatsapp.net", "", false, "other chat", 300, false, nil, nil)
+
+	for _, rawID := range []string{"ABC", "DEF"} {
+		msg, err := store.GetMessageByRawID(chatJID, rawID)
+		if err != nil {
+			t.Fatalf("GetMessageByRawID(%s): %v", rawID, err)
+		}
+		if !strings.HasSuffix(msg.ID, "_"+rawID) {
+			t.Errorf("GetMessageByRawID(%s) = %s", rawID, msg.ID)
+		}
+	}
+
+	// Another chat's message, and an ID that only matches with LIKE wildcards
+	for _, rawID := range []string{"GHI", "%"} {
+		if _, err := store.GetMessageByRawID(chatJID, rawID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetMessageByRawID(%s) error = %v, want sql.ErrNoRows", rawID, err)
+		}
+	}
+}
+
+func TestSetMessageSource(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	store.UpsertMessage("true_10000000001@c.us_A", chatJID, "me@s.whatsapp.net", "", true, "sent", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_B", chatJID, chatJID, "Alice", false, "received", 200, false, nil, nil)
+
+	if err := store.SetMessageSource("true_10000000001@c.us_A", MessageSourcePhone); err != nil {
+		t.Fatalf("SetMessageSource: %v", err)
+	}
+	// A later upsert (e.g. the echo of a sent message) must not clear it
+	store.UpsertMessage("true_10000000001@c.us_A", chatJID, "me@s.whatsapp.net", "", true, "sent", 100, false, nil, nil)
+
+	msgs, err := store.GetMessages(chatJID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	got := map[string]string{}
+	for _, m := range msgs {
+		got[m.ID] = m.Source
+	}
+	if got["true_10000000001@c.us_A"] != MessageSourcePhone || got["false_10000000001@c.us_B"] != "" {
+		t.Errorf("sources = %v", got)
+	}
+}
+
+func TestChatMute(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	store.UpsertChat(chatJID, "Alice", false, nil, nil)
+
+	muted := func(now int64) bool {
+		t.Helper()
+		m, err := store.IsChatMuted(chatJID, now)
+		if err != nil {
+			t.Fatalf("IsChatMuted: %v", err)
+		}
+		return m
+	}
+	if muted(100) {
+		t.Error("new chat is muted")
+	}
+	store.SetChatMutedUntil(chatJID, MutedForever)
+	if !muted(100) {
+		t.Error("chat muted forever is not muted")
+	}
+
+	// An expired mute reads as unmuted, and is cleared once looked at
+	until := time.Now().Unix() - 10
+	store.SetChatMutedUntil(chatJID, until)
+	if chats, _ := store.GetChats(); chats[0].MutedUntil != 0 {
+		t.Errorf("expired mute listed as mutedUntil = %d", chats[0].MutedUntil)
+	}
+	if !muted(until - 1) {
+		t.Error("chat not muted before its mute ends")
+	}
+	if muted(until) {
+		t.Error("chat still muted once its mute ended")
+	}
+	var stored int64
+	store.db.QueryRow(`SELECT muted_until FROM chats WHERE jid = ?`, chatJID).Scan(&stored)
+	if stored != 0 {
+		t.Errorf("muted_until = %d after expiry, want cleared", stored)
+	}
+
+	if m, err := store.IsChatMuted("10000000002@s.whatsapp.net", 100); m || err != nil {
+		t.Errorf("unknown chat: muted = %v, err = %v", m, err)
+	}
+}
+
+func TestChatSyncState(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+
+	if _, err := store.GetChatSyncState(chatJID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("unsynced chat: err = %v, want sql.ErrNoRows", err)
+	}
+
+	store.UpsertMessage("false_10000000001@c.us_A", chatJID, chatJID, "", false, "old", 100, false, nil, nil)
+	store.UpsertMessage("false_10000000001@c.us_B", chatJID, chatJID, "", false, "new", 300, false, nil, nil)
+	if err := store.RecordChatSync(chatJID, false); err != nil {
+		t.Fatalf("RecordChatSync: %v", err)
+	}
+	st, err := store.GetChatSyncState(chatJID)
+	if err != nil {
+		t.Fatalf("GetChatSyncState: %v", err)
+	}
+	if st.ChatID != "10000000001@c.us" || st.LastSyncAt == 0 || st.OldestSyncedTs == nil || *st.OldestSyncedTs != 100 || st.FullySynced {
+		t.Errorf("state = %+v", st)
+	}
+
+	// Fully synced sticks across later partial syncs until explicitly cleared
+	store.RecordChatSync(chatJID, true)
+	store.UpsertMessage("false_10000000001@c.us_C", chatJID, chatJID, "", false, "older", 50, false, nil, nil)
+	store.RecordChatSync(chatJID, false)
+	st, _ = store.GetChatSyncState(chatJID)
+	if !st.FullySynced || *st.OldestSyncedTs != 50 {
+		t.Errorf("after resync: state = %+v", st)
+	}
+	store.SetChatFullySynced(chatJID, false)
+	if st, _ = store.GetChatSyncState(chatJID); st.FullySynced {
+		t.Error("SetChatFullySynced(false) did not clear the flag")
+	}
+
+	all, err := store.GetAllChatSyncStates()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAllChatSyncStates = %v, %v", all, err)
+	}
+
+	store.UpsertChat(chatJID, "Alice", false, nil, nil)
+	store.DeleteChat(chatJID)
+	if _, err := store.GetChatSyncState(chatJID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("after DeleteChat: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMessageBatch(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+
+	batch, err := store.BeginMessageBatch()
+	if err != nil {
+		t.Fatalf("BeginMessageBatch: %v", err)
+	}
+	batch.UpsertMessage("false_10000000001@c.us_A", chatJID, chatJID, "Alice", false, "one", 100, false, nil, nil)
+	batch.UpsertMessage("false_10000000001@c.us_B", chatJID, chatJID, "Alice", false, "two", 200, false, nil, nil)
+	// Same conflict rules as UpsertMessage: an empty body keeps the old one
+	batch.UpsertMessage("false_10000000001@c.us_A", chatJID, chatJID, "", false, "", 100, false, nil, nil)
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	batch.Rollback() // no-op after commit
+
+	msgs, _ := store.GetMessages(chatJID, 10, 0)
+	if len(msgs) != 2 || msgs[1].Body != "one" {
+		t.Errorf("messages = %+v", msgs)
+	}
+
+	batch, _ = store.BeginMessageBatch()
+	batch.UpsertMessage("false_10000000001@c.us_C", chatJID, chatJID, "Alice", false, "three", 300, false, nil, nil)
+	batch.Rollback()
+	if n, _ := store.GetMessageCount(chatJID); n != 2 {
+		t.Errorf("count after rollback = %d, want 2", n)
+	}
+}
+
+// benchmarkHistoryImport imports 200-message conversations while another
+// goroutine polls message counts the way DeepSync does, reporting how long
+// each poll waited.
+func benchmarkHistoryImport(b *testing.B, batched bool) {
+	store := newTestStore(b)
+	const perConv = 200
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var polls int
+	var pollTime time.Duration
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			start := time.Now()
+			store.GetMessageCount("10000000001@s.whatsapp.net")
+			pollTime += time.Since(start)
+			polls++
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var upsert messageUpserter = store
+		var batch *MessageBatch
+		if batched {
+			batch, _ = store.BeginMessageBatch()
+			upsert = batch
+		}
+		for j := 0; j < perConv; j++ {
+			id := fmt.Sprintf("false_10000000001@c.us_%d_%d", i, j)
+			upsert.UpsertMessage(id, "10000000001@s.whatsapp.net", "10000000001@s.whatsapp.net", "Alice", false, "hello", int64(j), false, nil, nil)
+		}
+		if batch != nil {
+			batch.Commit()
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	<-done
+	if polls > 0 {
+		b.ReportMetric(float64(pollTime.Microseconds())/float64(polls), "µs/poll")
+	}
+}
+
+func BenchmarkHistoryImport_PerMessage(b *testing.B) { benchmarkHistoryImport(b, false) }
+func BenchmarkHistoryImport_Batched(b *testing.B)    { benchmarkHistoryImport(b, true) }
+
+func TestGetMessages_ClampsHugeLimit(t *testing.T) {
+	store := newTestStore(t)
+	chatJID := "10000000001@s.whatsapp.net"
+	batch, _ := store.BeginMessageBatch()
+	for i := 0; i < maxMessagesPerQuery+5; i++ {
+		batch.UpsertMessage(fmt.Sprintf("false_10000000001@c.us_%d", i), chatJID, chatJID, "", false, "m", int64(i), false, nil, nil)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	msgs, err := store.GetMessages(chatJID, 1_000_000, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != maxMessagesPerQuery {
+		t.Errorf("got %d messages, want %d", len(msgs), maxMessagesPerQuery)
+	}
+}
+
+func TestSearchOptionsFilterSQL(t *testing.T) {
+	cond, args := SearchOptions{Query: "hi", Limit: 10}.filterSQL()
+	if cond != "" || len(args) != 0 {
+		t.Errorf("no filters = %q %v, want none", cond, args)
+	}
+	cond, args = SearchOptions{Query: "hi", After: 100, Before: 200, ChatJID: "120363000000000001@g.us", Sender: "10000000001@s.whatsapp.net"}.filterSQL()
+	if cond != " AND m.timestamp >= ? AND m.timestamp <= ? AND m.chat_jid = ? AND m.sender_jid IN ("+senderAliasesSQL+")" ||
+		len(args) != 7 || args[0] != int64(100) || args[1] != int64(200) ||
+		args[2] != "120363000000000001@g.us" || args[3] != "10000000001@s.whatsapp.net" || args[6] != "10000000001@s.whatsapp.net" {
+		t.Errorf("all filters = %q %v", cond, args)
+	}
+}
+
+func TestSearchOptionsFilterSQL_SenderByNumberOrLID(t *testing.T) {
+	store := newTestStore(t)
+	group := "120363000000000001@g.us"
+	phone, lid := "10000000001@s.whatsapp.net", "200000000000001@lid"
+	store.SetGroupInfo(group, "Team", "", []GroupParticipant{{ID: lid, LID: lid, Phone: phone}})
+	store.UpsertMessage("false_120363000000000001@g.us_OLD", group, phone, "", false, "lunch plans", 100, false, nil, nil)
+	store.UpsertMessage("false_120363000000000001@g.us_NEW", group, lid, "", false, "lunch today", 200, false, nil, nil)
+	store.UpsertMessage("false_120363000000000001@g.us_BOB", group, "10000000002@s.whatsapp.net", "", false, "lunch?", 300, false, nil, nil)
+
+	for _, sender := range []string{phone, lid} {
+		cond, args := SearchOptions{Sender: sender}.filterSQL()
+		var n int
+		if err := store.db.QueryRow(`SELECT COUNT(*) FROM messages m WHERE 1 = 1`+cond, args...).Scan(&n); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("sender %s: %d messages, want both of theirs", sender, n)
+		}
+	}
+}
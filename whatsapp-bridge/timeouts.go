@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Per-route timeouts. Each route gets the one that fits the work it does, in
+// place of a single server-wide WriteTimeout: one short enough for quick
+// calls would cut off streams and large transfers.
+const (
+	// timeoutQuick covers routes that only read or write the local database.
+	timeoutQuick = 30 * time.Second
+	// timeoutWhatsApp covers routes that wait on WhatsApp, leaving room for
+	// the longest timeoutMs a caller may ask for.
+	timeoutWhatsApp = maxRequestTimeout + 15*time.Second
+	// timeoutLong covers media uploads and downloads, full message pages on
+	// slow links, exports and bulk sync requests.
+	timeoutLong = 10 * time.Minute
+	// timeoutNone leaves streams such as GET /events open indefinitely.
+	timeoutNone time.Duration = 0
+)
+
+// withTimeout gives h d to read the request body and write its response,
+// and cancels the request context once d has passed so the handler stops
+// working on a response that can no longer be sent. d of timeoutNone clears
+// the deadlines instead.
+func withTimeout(d time.Duration, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		var deadline time.Time
+		if d > 0 {
+			deadline = time.Now().Add(d)
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		// Recorders in tests support neither; the server's connections do
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline)
+		h(w, r)
+	}
+}
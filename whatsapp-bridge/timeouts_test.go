@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	canceled := make(chan bool, 1)
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(300 * time.Millisecond):
+			canceled <- false
+		}
+		w.Write([]byte("late"))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /short", withTimeout(50*time.Millisecond, slow))
+	mux.HandleFunc("GET /none", withTimeout(timeoutNone, slow))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Past its deadline the handler's context is canceled and its response
+	// can no longer be written
+	resp, err := http.Get(ts.URL + "/short")
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) == "late" {
+			t.Error("response written after the deadline")
+		}
+	}
+	if !<-canceled {
+		t.Error("context not canceled at the deadline")
+	}
+
+	resp, err = http.Get(ts.URL + "/none")
+	if err != nil {
+		t.Fatalf("no timeout: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "late" || <-canceled {
+		t.Errorf("no timeout: body = %q", body)
+	}
+}
@@ -39,6 +39,7 @@ body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;b
 .msg.outgoing{align-self:flex-end;background:#1a3a2a;border-bottom-right-radius:2px}
 .msg .sender{font-size:11px;color:#25D366;font-weight:600;margin-bottom:2px}
 .msg .time{font-size:10px;color:#555;margin-top:3px;text-align:right}
+.msg .fwd{font-size:11px;color:#888;font-style:italic;margin-bottom:2px}
 .msg .media-tag{font-size:11px;color:#999;font-style:italic}
 .empty{flex:1;display:flex;align-items:center;justify-content:center;color:#444;font-size:15px}
 .modal-bg{position:fixed;top:0;left:0;width:100%;height:100%;background:rgba(0,0,0,.7);display:none;align-items:center;justify-content:center;z-index:100}
@@ -68,7 +69,10 @@ body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;b
   <div class="main">
     <div class="main-header" id="mainHeader" style="display:none">
       <div><h2 id="chatTitle"></h2><span id="chatMsgCount"></span></div>
-      <button class="btn-delete" id="btnDelete" onclick="showDeleteModal()">Delete Chat</button>
+      <div>
+        <button class="btn-delete" id="btnLeave" onclick="leaveGroup()" style="display:none">Leave Group</button>
+        <button class="btn-delete" id="btnDelete" onclick="showDeleteModal()">Delete Chat</button>
+      </div>
     </div>
     <div class="messages" id="messages">
       <div class="empty">Select a chat to view messages</div>
@@ -78,7 +82,7 @@ body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;b
 <div class="modal-bg" id="modalBg">
   <div class="modal">
     <h3>Delete Chat</h3>
-    <p id="modalText">Are you sure? This will permanently delete this chat and all its messages.</p>
+    <p id="modalText">Move this chat and its messages to the trash? It can be restored until it is purged.</p>
     <div class="modal-btns">
       <button class="btn-cancel" onclick="hideDeleteModal()">Cancel</button>
       <button class="btn-confirm" onclick="confirmDelete()">Delete</button>
@@ -87,6 +91,8 @@ body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;b
 </div>
 <script>
 const API_KEY = "{{.APIKey}}";
+// Display zone from WAPP_TIMEZONE; undefined uses the browser's own
+const TZ = "{{.TimeZone}}" || undefined;
 const H = {"X-API-Key": API_KEY, "Content-Type": "application/json"};
 let chats = [], activeChat = null;
 
@@ -95,22 +101,26 @@ async function api(path, opts = {}) {
   return r.json();
 }
 
+// dayKey is d's calendar day in the display zone, as YYYY-MM-DD
+function dayKey(d) { return d.toLocaleDateString("en-CA", {timeZone: TZ}); }
+
+function timeStr(d) { return d.toLocaleTimeString([], {hour:"2-digit", minute:"2-digit", timeZone: TZ}); }
+
 function relTime(ts) {
   if (!ts) return "";
   const d = new Date(ts * 1000), now = new Date();
   const diff = (now - d) / 1000;
-  if (diff < 86400 && d.getDate() === now.getDate()) return d.toLocaleTimeString([], {hour:"2-digit", minute:"2-digit"});
+  if (diff < 86400 && dayKey(d) === dayKey(now)) return timeStr(d);
   if (diff < 172800) return "Yesterday";
-  if (diff < 604800) return d.toLocaleDateString([], {weekday:"short"});
-  return d.toLocaleDateString([], {month:"short", day:"numeric"});
+  if (diff < 604800) return d.toLocaleDateString([], {weekday:"short", timeZone: TZ});
+  return d.toLocaleDateString([], {month:"short", day:"numeric", timeZone: TZ});
 }
 
 function dateStr(ts) {
   const d = new Date(ts * 1000), now = new Date();
-  if (d.toDateString() === now.toDateString()) return "Today";
-  const y = new Date(now); y.setDate(y.getDate()-1);
-  if (d.toDateString() === y.toDateString()) return "Yesterday";
-  return d.toLocaleDateString([], {weekday:"long", month:"long", day:"numeric", year:"numeric"});
+  if (dayKey(d) === dayKey(now)) return "Today";
+  if (dayKey(d) === dayKey(new Date(now - 86400000))) return "Yesterday";
+  return d.toLocaleDateString([], {weekday:"long", month:"long", day:"numeric", year:"numeric", timeZone: TZ});
 }
 
 function renderChats(filter = "") {
@@ -136,23 +146,35 @@ async function loadChat(chatId) {
   renderChats(document.getElementById("search").value);
   document.getElementById("mainHeader").style.display = "flex";
   document.getElementById("chatTitle").textContent = activeChat.name;
-  document.getElementById("chatMsgCount").textContent = activeChat.messageCount + " messages";
+  document.getElementById("chatMsgCount").textContent = activeChat.messageCount + " messages" + (activeChat.leftAt ? " · left" : "");
+  document.getElementById("btnLeave").style.display = activeChat.isGroup && !activeChat.leftAt ? "" : "none";
   const el = document.getElementById("messages");
   el.innerHTML = '<div class="empty">Loading...</div>';
-  const data = await api("/chats/"+encodeURIComponent(chatId)+"/messages?limit=5000");
-  const msgs = (data.messages || []).slice().sort((a,b) => a.timestamp - b.timestamp);
+  // The API serves at most 1000 messages per request; page back with before=
+  // (inclusive, so boundary messages repeat and are de-duplicated by id).
+  const seen = new Map();
+  let before = 0;
+  for (let page = 0; page < 5; page++) {
+    const data = await api("/chats/"+encodeURIComponent(chatId)+"/messages?limit=1000"+(before ? "&before="+before : ""));
+    const batch = data.messages || [];
+    batch.forEach(m => seen.set(m.id, m));
+    if (batch.length < 1000) break;
+    before = batch[batch.length-1].timestamp;
+  }
+  const msgs = [...seen.values()].sort((a,b) => a.timestamp - b.timestamp);
   if (!msgs.length) { el.innerHTML = '<div class="empty">No messages</div>'; return; }
   let html = "", lastDate = "";
   msgs.forEach(m => {
     const d = dateStr(m.timestamp);
     if (d !== lastDate) { html += '<div class="date-sep">'+d+'</div>'; lastDate = d; }
     const cls = m.fromMe ? "outgoing" : "incoming";
-    const t = new Date(m.timestamp*1000).toLocaleTimeString([],{hour:"2-digit",minute:"2-digit"});
+    const t = (m.editedAt ? "edited " : "") + timeStr(new Date(m.timestamp*1000));
     let body = m.body ? esc(m.body) : "";
     if (m.hasMedia && !body) body = '<span class="media-tag">['+esc(m.mediaType||"media")+']</span>';
     else if (m.hasMedia) body += ' <span class="media-tag">['+esc(m.mediaType||"media")+']</span>';
     const sender = (!m.fromMe && m.senderName) ? '<div class="sender">'+esc(m.senderName)+'</div>' : "";
-    html += '<div class="msg '+cls+'">'+sender+body+'<div class="time">'+t+'</div></div>';
+    const fwd = m.forwarded ? '<div class="fwd">'+(m.forwardingScore >= 5 ? "Forwarded many times" : "Forwarded")+'</div>' : "";
+    html += '<div class="msg '+cls+'">'+sender+fwd+body+'<div class="time">'+t+'</div></div>';
   });
   el.innerHTML = html;
   el.scrollTop = el.scrollHeight;
@@ -164,12 +186,20 @@ function hideDeleteModal() { document.getElementById("modalBg").classList.remove
 async function confirmDelete() {
   if (!activeChat) return;
   hideDeleteModal();
-  await api("/chats/"+encodeURIComponent(activeChat.id), {method:"DELETE"});
+  await api("/chats/"+encodeURIComponent(activeChat.id)+"?trash=true", {method:"DELETE"});
   chats = chats.filter(c => c.id !== activeChat.id);
   activeChat = null;
   renderChats(document.getElementById("search").value);
   document.getElementById("mainHeader").style.display = "none";
-  document.getElementById("messages").innerHTML = '<div class="empty">Chat deleted</div>';
+  document.getElementById("messages").innerHTML = '<div class="empty">Chat moved to trash</div>';
+}
+
+async function leaveGroup() {
+  if (!activeChat || !confirm("Leave " + activeChat.name + "? Its messages are kept here.")) return;
+  const data = await api("/groups/"+encodeURIComponent(activeChat.id)+"/leave", {method:"POST"});
+  if (!data.success) { alert("Could not leave the group: " + (data.error || "unknown error")); return; }
+  activeChat.leftAt = Math.floor(Date.now() / 1000);
+  loadChat(activeChat.id);
 }
 
 document.getElementById("search").addEventListener("input", e => renderChats(e.target.value));
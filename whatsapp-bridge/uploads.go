@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Media upload concurrency. Each media send holds its decoded file in memory
+// while it uploads, so only a few run at once; the rest wait their turn, up
+// to uploadQueuePerSlot per slot, and beyond that are turned away with a 503.
+const (
+	defaultMaxConcurrentUploads = 2
+	uploadQueuePerSlot          = 4
+	uploadRetryAfter            = 5 * time.Second
+)
+
+var errUploadQueueFull = errors.New("upload queue full")
+
+// uploadGate bounds concurrent media uploads. A nil *uploadGate lets every
+// upload through.
+type uploadGate struct {
+	slots  chan struct{} // a token per running upload
+	queued chan struct{} // a token per upload running or waiting
+}
+
+// loadUploadGate returns a gate sized by WAPP_MAX_CONCURRENT_UPLOADS, or nil
+// if it is 0.
+func loadUploadGate() *uploadGate {
+	n := envInt(envMaxConcurrentUploads, defaultMaxConcurrentUploads)
+	if n == 0 {
+		log.Printf("Media upload concurrency unlimited (%s=0)", envMaxConcurrentUploads)
+		return nil
+	}
+	return newUploadGate(n)
+}
+
+func newUploadGate(concurrent int) *uploadGate {
+	return &uploadGate{
+		slots:  make(chan struct{}, concurrent),
+		queued: make(chan struct{}, concurrent*(1+uploadQueuePerSlot)),
+	}
+}
+
+// Acquire waits for an upload slot and returns the func that frees it. It
+// fails at once with errUploadQueueFull if too many uploads are already
+// waiting, or with ctx's error if ctx ends first.
+func (g *uploadGate) Acquire(ctx context.Context) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	select {
+	case g.queued <- struct{}{}:
+	default:
+		return nil, errUploadQueueFull
+	}
+	select {
+	case g.slots <- struct{}{}:
+		return func() {
+			<-g.slots
+			<-g.queued
+		}, nil
+	case <-ctx.Done():
+		<-g.queued
+		return nil, ctx.Err()
+	}
+}
+
+// acquireUpload takes an upload slot for a media send, writing a 503 with
+// Retry-After if the queue is full, or an error if the request ends while it
+// waits. Callers defer the returned release.
+func (s *Server) acquireUpload(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	release, err := s.uploads.Acquire(r.Context())
+	switch {
+	case err == nil:
+		return release, true
+	case errors.Is(err, errUploadQueueFull):
+		secs := int(math.Ceil(uploadRetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUploadsBusy,
+			fmt.Sprintf("too many media uploads in progress; retry in %ds", secs))
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, ErrCodeTimeout, "timed out waiting for an upload slot")
+	default:
+		writeError(w, statusClientClosedRequest, ErrCodeClientClosed, "client closed request while waiting for an upload slot")
+	}
+	return nil, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadGate(t *testing.T) {
+	g := newUploadGate(1)
+	release, err := g.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rest queue, up to uploadQueuePerSlot per slot
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error, uploadQueuePerSlot)
+	for i := 0; i < uploadQueuePerSlot; i++ {
+		go func() {
+			_, err := g.Acquire(ctx)
+			waiting <- err
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(g.queued) < 1+uploadQueuePerSlot && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.Acquire(context.Background()); !errors.Is(err, errUploadQueueFull) {
+		t.Errorf("beyond the queue: err = %v, want errUploadQueueFull", err)
+	}
+
+	// Giving up frees the queue places
+	cancel()
+	for i := 0; i < uploadQueuePerSlot; i++ {
+		if err := <-waiting; !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled waiter: err = %v", err)
+		}
+	}
+	release()
+	if len(g.queued) != 0 || len(g.slots) != 0 {
+		t.Errorf("gate not empty: %d queued, %d running", len(g.queued), len(g.slots))
+	}
+	if release, err := g.Acquire(context.Background()); err != nil {
+		t.Errorf("after release: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestAcquireUploadFull(t *testing.T) {
+	srv, fake := newTestServer(t)
+	srv.uploads = newUploadGate(1)
+	// Fill the slot and the queue
+	for i := 0; i < 1+uploadQueuePerSlot; i++ {
+		srv.uploads.queued <- struct{}{}
+	}
+
+	body := `{"chatId":"10000000001@c.us","base64":"aGk="}`
+	rec := serve(t, "POST /send-image", srv.handleSendImage, httptest.NewRequest("POST", "/send-image", strings.NewReader(body)))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if len(fake.sent) != 0 {
+		t.Error("send went through with the queue full")
+	}
+}
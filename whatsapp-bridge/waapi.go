@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// waAPI is the subset of the whatsmeow client that the HTTP handlers call.
+// The live implementation wraps *whatsmeow.Client; tests substitute a fake so
+// handler validation and response shapes can be checked without a connection.
+type waAPI interface {
+	SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	IsOnWhatsApp(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error)
+	MarkRead(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error
+	DownloadAny(ctx context.Context, msg *waE2E.Message) ([]byte, error)
+	GetUserInfo(ctx context.Context, jids []types.JID) (map[types.JID]types.UserInfo, error)
+	GetGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error)
+	GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error)
+	GetBlocklist(ctx context.Context) (*types.Blocklist, error)
+	CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error)
+	LeaveGroup(ctx context.Context, jid types.JID) error
+	UpdateGroupParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error)
+	SendAppState(ctx context.Context, patch appstate.PatchInfo) error
+
+	// BuildEdit wraps newContent as an edit of our message id in chat.
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message
+
+	// BuildRevoke builds a delete-for-everyone of message id, sent by
+	// sender (empty for our own messages), in chat.
+	BuildRevoke(chat, sender types.JID, id types.MessageID) *waE2E.Message
+
+	// GetContact looks jid up in whatsmeow's contact store, without a
+	// network round trip.
+	GetContact(ctx context.Context, jid types.JID) (types.ContactInfo, error)
+
+	// OwnJID returns the paired device's JID, or nil before pairing.
+	OwnJID() *types.JID
+}
+
+// liveWAAPI adapts *whatsmeow.Client to waAPI.
+type liveWAAPI struct {
+	*whatsmeow.Client
+}
+
+func (c liveWAAPI) OwnJID() *types.JID {
+	return c.Store.ID
+}
+
+func (c liveWAAPI) GetContact(ctx context.Context, jid types.JID) (types.ContactInfo, error) {
+	return c.Store.Contacts.GetContact(ctx, jid)
+}
+
+// ownJIDString returns the paired device's JID string, or "" before pairing.
+func ownJIDString(api waAPI) string {
+	if id := api.OwnJID(); id != nil {
+		return id.String()
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Webhook delivery. Each message is POSTed up to webhookAttempts times,
+// waiting webhookBackoff, then twice that, between attempts. Deliveries run
+// one at a time on a background worker; when webhookQueueSize are waiting,
+// further messages are dropped rather than holding up event handling.
+const (
+	webhookAttempts  = 3
+	webhookBackoff   = time.Second
+	webhookTimeout   = 10 * time.Second
+	webhookQueueSize = 256
+)
+
+// WebhookPayload is the JSON body POSTed to the webhook for each new
+// incoming message.
+type WebhookPayload struct {
+	MessageID  string  `json:"messageId"`
+	ChatID     string  `json:"chatId"`
+	Sender     string  `json:"sender"`
+	SenderName string  `json:"senderName,omitempty"`
+	Body       string  `json:"body"`
+	Timestamp  int64   `json:"timestamp"`
+	HasMedia   bool    `json:"hasMedia"`
+	MediaType  *string `json:"mediaType,omitempty"`
+}
+
+// Webhook POSTs incoming messages to a configured URL. A nil *Webhook
+// delivers nothing.
+type Webhook struct {
+	url     string
+	client  *http.Client
+	backoff time.Duration
+	queue   chan WebhookPayload
+}
+
+// loadWebhookURL returns the webhook URL from WAPP_WEBHOOK_URL or, if that is
+// unset, from ~/.whatsapp-raycast/webhook-url. It returns "" when neither is
+// set or the URL is not an absolute http(s) URL.
+func loadWebhookURL() string {
+	raw := envString(envWebhookURL, "")
+	source := envWebhookURL
+	if raw == "" {
+		home, _ := os.UserHomeDir()
+		source = filepath.Join(home, ".whatsapp-raycast", "webhook-url")
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return ""
+		}
+		raw = strings.TrimSpace(string(data))
+		if raw == "" {
+			return ""
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Printf("Invalid webhook URL %q in %s, webhook disabled", raw, source)
+		return ""
+	}
+	return raw
+}
+
+// newWebhook starts a webhook delivering to rawURL, or returns nil if rawURL
+// is empty.
+func newWebhook(rawURL string) *Webhook {
+	if rawURL == "" {
+		return nil
+	}
+	w := &Webhook{
+		url:     rawURL,
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: webhookBackoff,
+		queue:   make(chan WebhookPayload, webhookQueueSize),
+	}
+	go w.run()
+	log.Printf("Delivering incoming messages to webhook %s", rawURL)
+	return w
+}
+
+// Deliver queues p for delivery without blocking.
+func (w *Webhook) Deliver(p WebhookPayload) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.queue <- p:
+	default:
+		log.Printf("Webhook queue full, dropping message %s", p.MessageID)
+	}
+}
+
+func (w *Webhook) run() {
+	for p := range w.queue {
+		if err := w.send(p); err != nil {
+			log.Printf("Webhook delivery of message %s failed: %v", p.MessageID, err)
+		}
+	}
+}
+
+// send POSTs p, retrying with exponential backoff, and returns the last
+// attempt's error.
+func (w *Webhook) send(p WebhookPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	wait := w.backoff
+	for attempt := 1; ; attempt++ {
+		err = w.post(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		log.Printf("Webhook attempt %d for message %s failed, retrying in %s: %v", attempt, p.MessageID, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
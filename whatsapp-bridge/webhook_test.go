@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadWebhookURL(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(envWebhookURL, "")
+	if got := loadWebhookURL(); got != "" {
+		t.Errorf("unconfigured = %q, want none", got)
+	}
+
+	os.MkdirAll(filepath.Join(home, ".whatsapp-raycast"), 0700)
+	os.WriteFile(filepath.Join(home, ".whatsapp-raycast", "webhook-url"), []byte("https://example.com/from-file\n"), 0600)
+	if got := loadWebhookURL(); got != "https://example.com/from-file" {
+		t.Errorf("from file = %q", got)
+	}
+
+	t.Setenv(envWebhookURL, "http://localhost:9000/hook")
+	if got := loadWebhookURL(); got != "http://localhost:9000/hook" {
+		t.Errorf("from env = %q, want it to win over the file", got)
+	}
+
+	t.Setenv(envWebhookURL, "ftp://example.com")
+	if got := loadWebhookURL(); got != "" {
+		t.Errorf("invalid scheme = %q, want none", got)
+	}
+}
+
+func TestWebhook_RetriesThenDelivers(t *testing.T) {
+	var calls atomic.Int32
+	got := make(chan WebhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < webhookAttempts {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var p WebhookPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		got <- p
+	}))
+	defer srv.Close()
+
+	w := newWebhook(srv.URL)
+	w.backoff = time.Millisecond
+	w.Deliver(WebhookPayload{MessageID: "false_10000000001@c.us_M1", ChatID: "10000000001@c.us", Body: "hi", Timestamp: 100})
+
+	select {
+	case p := <-got:
+		if p.MessageID != "false_10000000001@c.us_M1" || p.Body != "hi" || p.Timestamp != 100 {
+			t.Errorf("payload = %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if n := calls.Load(); n != webhookAttempts {
+		t.Errorf("attempts = %d, want %d", n, webhookAttempts)
+	}
+
+	// A nil webhook is a no-op
+	var none *Webhook
+	none.Deliver(WebhookPayload{})
+}